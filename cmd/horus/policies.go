@@ -0,0 +1,154 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/policytest"
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+const policyFileExt = ".lua"
+
+// loadPolicies loads the rules from a policy file, from a bundle when the
+// directory has a bundle manifest, or from all the policy files found in a
+// directory tree otherwise.
+// Each rule is named after the path of its file relative to the directory,
+// without extension. The test files of the policies are ignored.
+func loadPolicies(fs afero.Fs, root string) (*warden.Bundle, error) {
+	fi, err := fs.Stat(root)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to access policy path: %w", err)
+	}
+
+	if !fi.IsDir() {
+		rule, err := loadPolicy(fs, root, strings.TrimSuffix(filepath.Base(root), policyFileExt))
+		if err != nil {
+			return nil, err
+		}
+
+		return &warden.Bundle{Rules: []warden.Rule{rule}}, nil
+	}
+
+	if _, err := fs.Stat(filepath.Join(root, warden.BundleManifestName)); err == nil {
+		return warden.LoadBundle(fs, root) //nolint:wrapcheck // this error actually comes from one of our own packages.
+	}
+
+	var rules []warden.Rule
+
+	err = afero.Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(path) != policyFileExt || policytest.IsTestFile(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return xerrors.Errorf("failed to compute the name of policy '%s': %w", path, err)
+		}
+
+		rule, err := loadPolicy(fs, path, filepath.ToSlash(strings.TrimSuffix(rel, policyFileExt)))
+		if err != nil {
+			return err
+		}
+
+		rules = append(rules, rule)
+
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load policies from '%s': %w", root, err)
+	}
+
+	if len(rules) == 0 {
+		return nil, xerrors.Errorf("no policy found in '%s'", root)
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+
+	return &warden.Bundle{Rules: rules}, nil
+}
+
+func loadPolicy(fs afero.Fs, path string, name string) (warden.Rule, error) {
+	script, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return warden.Rule{}, xerrors.Errorf("failed to read policy '%s': %w", path, err)
+	}
+
+	return warden.Rule{
+		Name:   name,
+		Script: string(script),
+	}, nil
+}
+
+// loadParams loads the values of the rule parameters from a YAML or JSON
+// file, overriding the default values of the bundle.
+func loadParams(fs afero.Fs, path string, bundle *warden.Bundle) error {
+	file, err := fs.Open(path)
+	if err != nil {
+		return xerrors.Errorf("failed to open parameters: %w", err)
+	}
+
+	defer file.Close()
+
+	params, err := warden.LoadParams(file)
+	if err != nil {
+		return err //nolint:wrapcheck // this error actually comes from one of our own packages.
+	}
+
+	if bundle.Params == nil {
+		bundle.Params = make(map[string]interface{}, len(params))
+	}
+
+	for k, v := range params {
+		bundle.Params[k] = v
+	}
+
+	return nil
+}
+
+// setParams sets the rule parameters of the form name=value, converted to
+// their declared type, overriding the values of the bundle.
+func setParams(bundle *warden.Bundle, params []string) error {
+	for _, p := range params {
+		parts := strings.SplitN(p, "=", keyValueParts)
+		if len(parts) != keyValueParts || parts[0] == "" {
+			return xerrors.Errorf("invalid parameter '%s' (expected name=value)", p)
+		}
+
+		value, err := warden.ParseParam(bundle.Rules, parts[0], parts[1])
+		if err != nil {
+			return xerrors.Errorf("invalid parameter '%s': %w", p, err)
+		}
+
+		if bundle.Params == nil {
+			bundle.Params = make(map[string]interface{}, len(params))
+		}
+
+		bundle.Params[parts[0]] = value
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+// Exit codes of the horus command.
+const (
+	exitCodePass        = 0 // every rule passed
+	exitCodeViolations  = 1 // at least one rule reported a violation
+	exitCodeEngineError = 2 // the validation could not be performed
+)
+
+// exitError is an error carrying the exit code of the command.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// run executes the command line and returns the exit code.
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	cmd := newRootCommand(afero.NewOsFs(), stderr)
+	cmd.SetArgs(args)
+	cmd.SetOutput(stdout)
+
+	err := cmd.Execute()
+	if err == nil {
+		return exitCodePass
+	}
+
+	var exitErr *exitError
+	if xerrors.As(err, &exitErr) {
+		// The report is written to stdout, possibly in a format meant for
+		// a machine: the reason of the exit code is always printed as well.
+		_, _ = fmt.Fprintf(stderr, "Error: %v\n", exitErr.err)
+
+		return exitErr.code
+	}
+
+	// Errors that are not exitErrors come from cobra itself, i.e. invalid
+	// command lines.
+	_, _ = fmt.Fprintf(stderr, "Error: %v\n", err)
+
+	return exitCodeEngineError
+}
+
+func newRootCommand(fs afero.Fs, stderr io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "horus",
+		Short:         "Automated Terraform validation",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	cmd.AddCommand(newValidateCommand(fs, stderr))
+	cmd.AddCommand(newTestCommand(fs))
+
+	return cmd
+}
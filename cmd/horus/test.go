@@ -0,0 +1,140 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/policytest"
+)
+
+type testFlags struct {
+	policy          string
+	tests           string
+	params          []string
+	paramsFile      string
+	providerSchemas string
+}
+
+// newTestCommand returns the test command.
+func newTestCommand(fs afero.Fs) *cobra.Command {
+	flags := &testFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "Run the unit tests of a set of policies",
+		Long: `Run the unit tests of a set of policies.
+
+Every *_test.lua file found in the test path is run. The test cases validate
+fixtures with the policies and check the findings:
+
+    test("public buckets are rejected", function()
+        validate_plan("fixtures/public.json")
+        expect_violation("s3/public", "aws_s3_bucket.logs")
+    end)
+
+The fixtures are loaded with validate_plan, validate_state or validate_config,
+relative to the directory of the test file, and the findings are checked with
+expect_violation(rule[, address]), expect_no_violation(rule[, address]) and
+expect_error(rule). The line coverage of the policies is reported as well.
+
+The parameters of the rules are set with --params-file and --param, as with
+the validate command.
+
+The command exits with 0 when all the tests pass, 1 when at least one test
+failed, and 2 when the tests could not be run.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runTest(cmd, fs, flags)
+		},
+	}
+
+	cmd.Flags().StringVar(&flags.policy, "policy", "", "path of a policy file or of a directory of policies")
+	cmd.Flags().StringVar(&flags.tests, "tests", "", "path of a test file or of a directory of tests (defaults to the policy path)")
+	cmd.Flags().StringArrayVar(&flags.params, "param", nil, "rule parameter, as name=value (can be repeated)")
+	cmd.Flags().StringVar(&flags.paramsFile, "params-file", "", "path of a YAML or JSON file of typed rule parameters")
+	cmd.Flags().StringVar(&flags.providerSchemas, "provider-schemas", "", "path of the output of 'terraform providers schema -json'")
+
+	return cmd
+}
+
+func runTest(cmd *cobra.Command, fs afero.Fs, flags *testFlags) error {
+	engineError := func(err error) error {
+		return &exitError{code: exitCodeEngineError, err: err}
+	}
+
+	if flags.policy == "" {
+		return engineError(xerrors.New("missing required flag --policy"))
+	}
+
+	bundle, err := loadPolicies(fs, flags.policy)
+	if err != nil {
+		return engineError(err)
+	}
+
+	if flags.paramsFile != "" {
+		if err := loadParams(fs, flags.paramsFile, bundle); err != nil {
+			return engineError(err)
+		}
+	}
+
+	if err := setParams(bundle, flags.params); err != nil {
+		return engineError(err)
+	}
+
+	testPath := flags.tests
+	if testPath == "" {
+		testPath = flags.policy
+	}
+
+	files, err := policytest.Discover(fs, testPath)
+	if err != nil {
+		return engineError(err)
+	}
+
+	if len(files) == 0 {
+		return engineError(xerrors.Errorf("no test found in '%s'", testPath))
+	}
+
+	opts := bundle.Options()
+
+	if flags.providerSchemas != "" {
+		if opts.ProviderSchemas, err = loadProviderSchemas(fs, flags.providerSchemas); err != nil {
+			return engineError(err)
+		}
+	}
+
+	results, err := policytest.Run(context.Background(), fs, opts, files)
+	if results == nil {
+		return engineError(err)
+	}
+
+	if err := policytest.Write(cmd.OutOrStdout(), results); err != nil {
+		return engineError(err)
+	}
+
+	switch {
+	case err != nil:
+		return engineError(err)
+	case !results.Passed():
+		return &exitError{code: exitCodeViolations, err: xerrors.Errorf("%d test(s) failed", results.Failed())}
+	default:
+		return nil
+	}
+}
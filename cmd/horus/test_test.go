@@ -0,0 +1,118 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTest(t *testing.T) {
+	planFile, err := filepath.Abs(testPlanFile)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		policies   map[string]string
+		args       []string
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+	}{
+		{
+			name: "pass",
+			policies: map[string]string{
+				"bundle.yaml": instanceTypeManifest,
+				"types.lua":   instanceTypePolicy,
+				"types_test.lua": `
+test("allowed type", function()
+	validate_plan("` + planFile + `")
+	expect_no_violation("types")
+end)
+`,
+			},
+			args:     []string{"--param", "instance_type=t2.micro"},
+			wantCode: exitCodePass,
+			wantStdout: []string{
+				"PASS  ",
+				"types_test.lua: allowed type",
+				"types                           83.3% (5/6 lines) missed: 7",
+				"1 tests: 1 passed, 0 failed",
+			},
+		},
+		{
+			name: "failure",
+			policies: map[string]string{
+				"bundle.yaml": instanceTypeManifest,
+				"types.lua":   instanceTypePolicy,
+				"types_test.lua": `
+test("allowed type", function()
+	validate_plan("` + planFile + `")
+	expect_no_violation("types", "aws_instance.simple_resource")
+end)
+`,
+			},
+			args:     []string{"--param", "instance_type=t3.micro"},
+			wantCode: exitCodeViolations,
+			wantStdout: []string{
+				"FAIL  ",
+				"types_test.lua:4: expected no violation of rule 'types' for aws_instance.simple_resource",
+				"1 tests: 0 passed, 1 failed",
+			},
+			wantStderr: "Error: 1 test(s) failed",
+		},
+		{
+			name:       "no test",
+			policies:   map[string]string{"bundle.yaml": instanceTypeManifest, "types.lua": instanceTypePolicy},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "no test found",
+		},
+		{
+			name:       "invalid policy",
+			policies:   map[string]string{"types.lua": "not lua", "types_test.lua": ""},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "failed to load the rules",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestPolicies(t, tt.policies)
+
+			args := append([]string{"test", "--policy", dir}, tt.args...)
+
+			var stdout, stderr bytes.Buffer
+
+			code := run(args, &stdout, &stderr)
+			assert.Equal(t, tt.wantCode, code, "stdout: %s\nstderr: %s", stdout.String(), stderr.String())
+
+			for _, s := range tt.wantStdout {
+				assert.Contains(t, stdout.String(), s)
+			}
+
+			if tt.wantStderr != "" {
+				assert.Contains(t, stderr.String(), tt.wantStderr)
+			} else {
+				assert.Empty(t, stderr.String())
+			}
+		})
+	}
+}
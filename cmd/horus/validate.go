@@ -0,0 +1,307 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/report"
+	"github.com/hexbee-net/horus/pkg/warden"
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+type validateFlags struct {
+	plan            string
+	state           string
+	config          string
+	policy          string
+	params          []string
+	paramsFile      string
+	format          string
+	providerSchemas string
+	waivers         string
+	enforcements    []string
+	overrides       []string
+}
+
+// newValidateCommand returns the validate command. The warnings of the
+// validation are written to stderr.
+func newValidateCommand(fs afero.Fs, stderr io.Writer) *cobra.Command {
+	flags := &validateFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate a Terraform plan, state or configuration against a set of policies",
+		Long: `Validate a Terraform plan, state or configuration against a set of policies.
+
+Every .lua file found in the policy path is run as a rule.
+
+When the policy path has a bundle.yaml manifest, it is loaded as a bundle:
+the .lua files of its lib directory are library modules, e.g. 'lib/tags.lua'
+is loaded with require('lib.tags'), and the manifest declares the rules, with
+their metadata and their typed parameters.
+
+The rules read the values of their parameters from the read-only 'params'
+global. The values of the --params-file file override the defaults of the
+manifest, and are overridden in turn by the --param flags, whose values are
+converted to the declared type of the parameter, e.g. --param max=10 or
+--param 'regions=[eu-west-1, eu-central-1]'.
+
+The findings matching one of the waivers of the --waivers file are reported
+separately, and do not make the validation fail.
+
+The rules are hard-mandatory unless their enforcement level is set with
+--enforcement. Advisory rules only warn, soft-mandatory rules can be
+overridden with --override and a reason, and hard-mandatory rules can never
+be overridden.
+
+The command exits with 0 when all the mandatory rules pass or are
+overridden, 1 when at least one of them reported a violation or one of the
+waivers expired, and 2 when the validation could not be performed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runValidate(cmd, fs, stderr, flags)
+		},
+	}
+
+	cmd.Flags().StringVar(&flags.plan, "plan", "", "path of the plan file to validate, binary or from 'terraform show -json'")
+	cmd.Flags().StringVar(&flags.state, "state", "", "path of the state file to validate, instead of a plan")
+	cmd.Flags().StringVar(&flags.config, "config", "", "path of a configuration directory to validate, instead of a plan")
+	cmd.Flags().StringVar(&flags.policy, "policy", "", "path of a policy file or of a directory of policies")
+	cmd.Flags().StringArrayVar(&flags.params, "param", nil, "rule parameter, as name=value (can be repeated)")
+	cmd.Flags().StringVar(&flags.paramsFile, "params-file", "", "path of a YAML or JSON file of typed rule parameters")
+	cmd.Flags().StringVar(&flags.format, "format", string(report.FormatText), "output format ("+formatNames()+")")
+	cmd.Flags().StringVar(&flags.providerSchemas, "provider-schemas", "", "path of the output of 'terraform providers schema -json'")
+	cmd.Flags().StringVar(&flags.waivers, "waivers", "", "path of a YAML file of waivers")
+	cmd.Flags().StringArrayVar(&flags.enforcements, "enforcement", nil,
+		"enforcement level of a rule, as rule=advisory|soft-mandatory|hard-mandatory (can be repeated)")
+	cmd.Flags().StringArrayVar(&flags.overrides, "override", nil, "override of a soft-mandatory rule, as rule=reason (can be repeated)")
+
+	return cmd
+}
+
+func runValidate(cmd *cobra.Command, fs afero.Fs, stderr io.Writer, flags *validateFlags) error {
+	engineError := func(err error) error {
+		return &exitError{code: exitCodeEngineError, err: err}
+	}
+
+	switch inputs := countNonEmpty(flags.plan, flags.state, flags.config); {
+	case inputs == 0:
+		return engineError(xerrors.New("missing required flag --plan, --state or --config"))
+	case inputs > 1:
+		return engineError(xerrors.New("flags --plan, --state and --config are mutually exclusive"))
+	}
+
+	if flags.policy == "" {
+		return engineError(xerrors.New("missing required flag --policy"))
+	}
+
+	format, err := report.ParseFormat(flags.format)
+	if err != nil {
+		return engineError(err)
+	}
+
+	bundle, err := loadPolicies(fs, flags.policy)
+	if err != nil {
+		return engineError(err)
+	}
+
+	if flags.paramsFile != "" {
+		if err := loadParams(fs, flags.paramsFile, bundle); err != nil {
+			return engineError(err)
+		}
+	}
+
+	if err := setParams(bundle, flags.params); err != nil {
+		return engineError(err)
+	}
+
+	if err := setEnforcements(bundle.Rules, flags.enforcements); err != nil {
+		return engineError(err)
+	}
+
+	overrides, err := parseOverrides(flags.overrides)
+	if err != nil {
+		return engineError(err)
+	}
+
+	opts := bundle.Options()
+	opts.Overrides = overrides
+
+	if flags.providerSchemas != "" {
+		if opts.ProviderSchemas, err = loadProviderSchemas(fs, flags.providerSchemas); err != nil {
+			return engineError(err)
+		}
+	}
+
+	if flags.waivers != "" {
+		if opts.Waivers, err = loadWaivers(fs, flags.waivers); err != nil {
+			return engineError(err)
+		}
+	}
+
+	w, err := warden.New(opts)
+	if err != nil {
+		return engineError(xerrors.Errorf("failed to initialize the validation: %w", err))
+	}
+
+	defer w.Close()
+
+	results, validationErr := validateFile(fs, w, flags)
+	if results == nil {
+		return engineError(validationErr)
+	}
+
+	for _, warning := range results.Warnings {
+		_, _ = fmt.Fprintf(stderr, "warning: %s\n", warning)
+	}
+
+	if err := report.Write(cmd.OutOrStdout(), format, results); err != nil {
+		return engineError(err)
+	}
+
+	switch {
+	case validationErr == nil:
+		return nil
+	case xerrors.Is(validationErr, warden.ErrValidationFailed), xerrors.Is(validationErr, warden.ErrWaiverExpired):
+		return &exitError{code: exitCodeViolations, err: validationErr}
+	default:
+		return engineError(validationErr)
+	}
+}
+
+// validateFile validates either the plan file, the state file or the
+// configuration directory of the flags.
+func validateFile(fs afero.Fs, w *warden.Warden, flags *validateFlags) (*warden.Report, error) {
+	if flags.config != "" {
+		return w.ValidateConfig(context.Background(), flags.config) //nolint:wrapcheck // this error actually comes from one of our own packages.
+	}
+
+	path, validate := flags.plan, w.ValidatePlan
+	if flags.state != "" {
+		path, validate = flags.state, w.ValidateState
+	}
+
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %s: %w", path, err)
+	}
+
+	defer file.Close()
+
+	return validate(context.Background(), file)
+}
+
+// countNonEmpty returns the number of non-empty strings.
+func countNonEmpty(values ...string) int {
+	n := 0
+
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+
+	return n
+}
+
+func formatNames() string {
+	formats := report.Formats()
+
+	names := make([]string, 0, len(formats))
+	for _, f := range formats {
+		names = append(names, string(f))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+const keyValueParts = 2
+
+// setEnforcements sets the enforcement levels of the form rule=level to the
+// rules.
+func setEnforcements(rules []warden.Rule, enforcements []string) error {
+	for _, e := range enforcements {
+		parts := strings.SplitN(e, "=", keyValueParts)
+		if len(parts) != keyValueParts || parts[0] == "" {
+			return xerrors.Errorf("invalid enforcement '%s' (expected rule=level)", e)
+		}
+
+		level, err := warden.ParseEnforcement(parts[1])
+		if err != nil {
+			return err //nolint:wrapcheck // this error actually comes from one of our own packages.
+		}
+
+		found := false
+
+		for i := range rules {
+			if rules[i].Name == parts[0] {
+				rules[i].Enforcement = level
+				found = true
+			}
+		}
+
+		if !found {
+			return xerrors.Errorf("invalid enforcement '%s': unknown rule '%s'", e, parts[0])
+		}
+	}
+
+	return nil
+}
+
+// parseOverrides parses overrides of the form rule=reason.
+func parseOverrides(overrides []string) ([]warden.Override, error) {
+	ret := make([]warden.Override, 0, len(overrides))
+
+	for _, o := range overrides {
+		parts := strings.SplitN(o, "=", keyValueParts)
+		if len(parts) != keyValueParts || parts[0] == "" || parts[1] == "" {
+			return nil, xerrors.Errorf("invalid override '%s' (expected rule=reason)", o)
+		}
+
+		ret = append(ret, warden.Override{RuleID: parts[0], Reason: parts[1]})
+	}
+
+	return ret, nil
+}
+
+func loadProviderSchemas(fs afero.Fs, path string) (*terraform.ProviderSchemas, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open provider schemas: %w", err)
+	}
+
+	defer file.Close()
+
+	return terraform.LoadProviderSchemas(file) //nolint:wrapcheck // this error actually comes from one of our own packages.
+}
+
+func loadWaivers(fs afero.Fs, path string) ([]warden.Waiver, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open waivers: %w", err)
+	}
+
+	defer file.Close()
+
+	return warden.LoadWaivers(file) //nolint:wrapcheck // this error actually comes from one of our own packages.
+}
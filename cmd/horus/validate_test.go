@@ -0,0 +1,552 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPlanFile = "../../testData/tf-planfile"
+
+func writeTestPolicies(t *testing.T, policies map[string]string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "horus-policies")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	for name, script := range policies {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(script), 0o600))
+	}
+
+	return dir
+}
+
+const instanceTypeManifest = `
+name: compute
+version: 1.0.0
+rules:
+  - name: types
+    params:
+      - name: instance_type
+`
+
+const instanceTypePolicy = `
+local tf = require "tf"
+
+local issues = {}
+for _, r in ipairs(tf.plan:findResource("aws_instance")) do
+	if r:change().after.instance_type ~= params.instance_type then
+		table.insert(issues, { resource = r, message = "unexpected instance type", path = "instance_type" })
+	end
+end
+return issues
+`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		policies   map[string]string
+		args       []string
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+	}{
+		{
+			name:       "pass",
+			policies:   map[string]string{"bundle.yaml": instanceTypeManifest, "types.lua": instanceTypePolicy},
+			args:       []string{"--param", "instance_type=t2.micro"},
+			wantCode:   exitCodePass,
+			wantStdout: []string{"PASS  types", "1 rules: 1 passed, 0 failed, 0 errored"},
+		},
+		{
+			name:     "violations",
+			policies: map[string]string{"bundle.yaml": instanceTypeManifest, "types.lua": instanceTypePolicy},
+			args:     []string{"--param", "instance_type=t3.micro"},
+			wantCode: exitCodeViolations,
+			wantStdout: []string{
+				"FAIL  types",
+				"[error] aws_instance.simple_resource.instance_type: unexpected instance type (root.tf:18)",
+			},
+			wantStderr: "Error: validation failed",
+		},
+		{
+			name: "nested policies",
+			policies: map[string]string{
+				"a.lua":     "return true",
+				"sub/b.lua": "return 'always'",
+				"README.md": "not a policy",
+			},
+			wantCode:   exitCodeViolations,
+			wantStdout: []string{"PASS  a", "FAIL  sub/b", "[error] always"},
+			wantStderr: "Error: validation failed",
+		},
+		{
+			name:       "rule error",
+			policies:   map[string]string{"broken.lua": "error('boom')"},
+			wantCode:   exitCodeEngineError,
+			wantStdout: []string{"ERROR broken"},
+			wantStderr: "Error: ",
+		},
+		{
+			name:       "invalid param",
+			policies:   map[string]string{"a.lua": "return true"},
+			args:       []string{"--param", "novalue"},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "invalid parameter",
+		},
+		{
+			name:       "unknown param",
+			policies:   map[string]string{"a.lua": "return true"},
+			args:       []string{"--param", "owner=team"},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "invalid parameter 'owner=team': unknown parameter 'owner'",
+		},
+		{
+			name:       "unknown format",
+			policies:   map[string]string{"a.lua": "return true"},
+			args:       []string{"--format", "xml"},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "unknown report format",
+		},
+		{
+			name:       "no policy",
+			policies:   map[string]string{},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "no policy",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestPolicies(t, tt.policies)
+
+			args := append([]string{"validate", "--plan", testPlanFile, "--policy", dir}, tt.args...)
+
+			var stdout, stderr bytes.Buffer
+
+			code := run(args, &stdout, &stderr)
+			assert.Equal(t, tt.wantCode, code, "stdout: %s\nstderr: %s", stdout.String(), stderr.String())
+
+			for _, s := range tt.wantStdout {
+				assert.Contains(t, stdout.String(), s)
+			}
+
+			if tt.wantStderr != "" {
+				assert.Contains(t, stderr.String(), tt.wantStderr)
+			} else {
+				assert.Empty(t, stderr.String())
+			}
+		})
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	dir := writeTestPolicies(t, map[string]string{"bundle.yaml": instanceTypeManifest, "types.lua": instanceTypePolicy})
+
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{
+		"validate",
+		"--plan", testPlanFile,
+		"--policy", dir,
+		"--param", "instance_type=t3.micro",
+		"--format", "json",
+	}, &stdout, &stderr)
+	require.Equal(t, exitCodeViolations, code, stderr.String())
+
+	var doc struct {
+		Results []struct {
+			Rule     string `json:"rule"`
+			Status   string `json:"status"`
+			Findings []struct {
+				RuleID        string `json:"ruleId"`
+				Severity      string `json:"severity"`
+				AttributePath string `json:"attributePath"`
+				Location      struct {
+					File      string `json:"file"`
+					StartLine int    `json:"startLine"`
+				} `json:"location"`
+			} `json:"findings"`
+		} `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &doc))
+	require.Len(t, doc.Results, 1)
+
+	res := doc.Results[0]
+	assert.Equal(t, "types", res.Rule)
+	assert.Equal(t, "fail", res.Status)
+	require.Len(t, res.Findings, 4)
+
+	for _, f := range res.Findings {
+		assert.Equal(t, "types", f.RuleID)
+		assert.Equal(t, "error", f.Severity)
+		assert.Equal(t, "instance_type", f.AttributePath)
+		assert.Equal(t, "root.tf", f.Location.File)
+		assert.NotZero(t, f.Location.StartLine)
+	}
+}
+
+func TestValidateState(t *testing.T) {
+	dir := writeTestPolicies(t, map[string]string{"tainted.lua": `
+local tf = require "tf"
+
+local issues = {}
+for _, r in ipairs(tf.state:findResource("aws_instance")) do
+	if r:status() == "tainted" then
+		table.insert(issues, { resource = r, message = "tainted instance" })
+	end
+end
+return issues
+`})
+
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"validate", "--state", "../../testData/tf-state.tfstate", "--policy", dir}, &stdout, &stderr)
+	assert.Equal(t, exitCodeViolations, code, "stderr: %s", stderr.String())
+	assert.Contains(t, stdout.String(), "[error] aws_instance.multiple_resource[0]: tainted instance")
+
+	stdout.Reset()
+	stderr.Reset()
+
+	code = run([]string{"validate", "--state", "../../testData/tf-state.tfstate", "--plan", testPlanFile, "--policy", dir}, &stdout, &stderr)
+	assert.Equal(t, exitCodeEngineError, code)
+	assert.Contains(t, stderr.String(), "mutually exclusive")
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := writeTestPolicies(t, map[string]string{"pinned.lua": `
+local tf = require "tf"
+
+local issues = {}
+for name, call in pairs(tf.config:moduleCalls()) do
+	if not call.version and not call.source:find("^%.") then
+		table.insert(issues, "unpinned module " .. name)
+	end
+end
+return issues
+`})
+
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"validate", "--config", "../../testData/config", "--policy", dir}, &stdout, &stderr)
+	assert.Equal(t, exitCodeViolations, code, "stderr: %s", stderr.String())
+	assert.Contains(t, stdout.String(), "[error] unpinned module dns")
+	assert.Contains(t, stderr.String(), "warning: Module not installed")
+
+	stdout.Reset()
+	stderr.Reset()
+
+	code = run([]string{"validate", "--config", "../../testData/config", "--plan", testPlanFile, "--policy", dir}, &stdout, &stderr)
+	assert.Equal(t, exitCodeEngineError, code)
+	assert.Contains(t, stderr.String(), "mutually exclusive")
+}
+
+func TestValidateWaivers(t *testing.T) {
+	const waiver = `
+waivers:
+  - rule: types
+    address: aws_instance.*
+    justification: Sized for the load test.
+    owner: perf-team
+    expires: %s
+`
+
+	tests := []struct {
+		name       string
+		waivers    string
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+	}{
+		{
+			name:     "active",
+			waivers:  fmt.Sprintf(waiver, "2999-12-31"),
+			wantCode: exitCodePass,
+			wantStdout: []string{
+				"PASS  types",
+				"[waived] aws_instance.simple_resource.instance_type: unexpected instance type (perf-team, until 2999-12-31: Sized for the load test.)",
+				"4 findings waived",
+			},
+		},
+		{
+			name:     "expired",
+			waivers:  fmt.Sprintf(waiver, "2000-01-01"),
+			wantCode: exitCodeViolations,
+			wantStdout: []string{
+				"FAIL  types",
+				"EXPIRED waiver of rule 'types' for 'aws_instance.*' (owner: perf-team, expires: 2000-01-01)",
+			},
+			wantStderr: "Error: validation failed",
+		},
+		{
+			name:       "invalid",
+			waivers:    "waivers:\n  - rule: types\n",
+			wantCode:   exitCodeEngineError,
+			wantStderr: "invalid waiver #1",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestPolicies(t, map[string]string{
+				"bundle.yaml":  instanceTypeManifest,
+				"types.lua":    instanceTypePolicy,
+				"waivers.yaml": tt.waivers,
+			})
+
+			var stdout, stderr bytes.Buffer
+
+			code := run([]string{
+				"validate",
+				"--plan", testPlanFile,
+				"--policy", dir,
+				"--param", "instance_type=t3.micro",
+				"--waivers", filepath.Join(dir, "waivers.yaml"),
+			}, &stdout, &stderr)
+			assert.Equal(t, tt.wantCode, code, "stdout: %s\nstderr: %s", stdout.String(), stderr.String())
+
+			for _, s := range tt.wantStdout {
+				assert.Contains(t, stdout.String(), s)
+			}
+
+			assert.Contains(t, stderr.String(), tt.wantStderr)
+		})
+	}
+}
+
+func TestValidateEnforcement(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+	}{
+		{
+			name:     "hard-mandatory by default",
+			wantCode: exitCodeViolations,
+			wantStdout: []string{
+				"FAIL  types",
+			},
+		},
+		{
+			name:     "advisory",
+			args:     []string{"--enforcement", "types=advisory"},
+			wantCode: exitCodePass,
+			wantStdout: []string{
+				"WARN  types",
+				"1 advisory rules warned",
+			},
+		},
+		{
+			name:     "soft-mandatory",
+			args:     []string{"--enforcement", "types=soft-mandatory"},
+			wantCode: exitCodeViolations,
+			wantStdout: []string{
+				"FAIL  types",
+			},
+		},
+		{
+			name:     "soft-mandatory overridden",
+			args:     []string{"--enforcement", "types=soft-mandatory", "--override", "types=load test, see CHG-1234"},
+			wantCode: exitCodePass,
+			wantStdout: []string{
+				"OVERRIDDEN types",
+				"overridden: load test, see CHG-1234",
+				"1 rules overridden",
+			},
+		},
+		{
+			name:       "hard-mandatory overridden",
+			args:       []string{"--override", "types=load test"},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "rule 'types' is hard-mandatory and cannot be overridden",
+		},
+		{
+			name:       "missing reason",
+			args:       []string{"--enforcement", "types=soft-mandatory", "--override", "types"},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "invalid override 'types' (expected rule=reason)",
+		},
+		{
+			name:       "invalid level",
+			args:       []string{"--enforcement", "types=mandatory"},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "invalid enforcement level 'mandatory'",
+		},
+		{
+			name:       "unknown rule",
+			args:       []string{"--enforcement", "sizes=advisory"},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "unknown rule 'sizes'",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestPolicies(t, map[string]string{"bundle.yaml": instanceTypeManifest, "types.lua": instanceTypePolicy})
+
+			var stdout, stderr bytes.Buffer
+
+			code := run(append([]string{
+				"validate",
+				"--plan", testPlanFile,
+				"--policy", dir,
+				"--param", "instance_type=t3.micro",
+			}, tt.args...), &stdout, &stderr)
+			assert.Equal(t, tt.wantCode, code, "stdout: %s\nstderr: %s", stdout.String(), stderr.String())
+
+			for _, s := range tt.wantStdout {
+				assert.Contains(t, stdout.String(), s)
+			}
+
+			assert.Contains(t, stderr.String(), tt.wantStderr)
+		})
+	}
+}
+
+func TestValidateBundle(t *testing.T) {
+	policies := map[string]string{
+		"bundle.yaml": `
+name: compute
+version: 1.0.0
+rules:
+  - name: types
+    enforcement: soft-mandatory
+    params:
+      - name: instance_types
+        type: list(string)
+        default: [t3.micro]
+`,
+		"types.lua": `
+local tf = require "tf"
+local allowed = require "lib.allowed"
+
+local issues = {}
+for _, r in ipairs(tf.plan:findResource("aws_instance")) do
+	if not allowed(params.instance_types, r:change().after.instance_type) then
+		table.insert(issues, { resource = r, message = "unexpected instance type", path = "instance_type" })
+	end
+end
+return issues
+`,
+		"lib/allowed.lua": `
+return function(list, value)
+	for _, v in ipairs(list) do
+		if v == value then
+			return true
+		end
+	end
+	return false
+end
+`,
+		"params.yaml":  `instance_types: [t3.micro, t2.micro]`,
+		"invalid.json": `{"instance_types": "t2.micro"}`,
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "default params",
+			wantCode:   exitCodeViolations,
+			wantStdout: "FAIL  types",
+		},
+		{
+			name:       "params file",
+			args:       []string{"--params-file", "params.yaml"},
+			wantCode:   exitCodePass,
+			wantStdout: "PASS  types",
+		},
+		{
+			name:       "param",
+			args:       []string{"--param", "instance_types=[t3.micro, t2.micro]"},
+			wantCode:   exitCodePass,
+			wantStdout: "PASS  types",
+		},
+		{
+			name:       "param overriding the params file",
+			args:       []string{"--params-file", "params.yaml", "--param", "instance_types=[t3.micro]"},
+			wantCode:   exitCodeViolations,
+			wantStdout: "FAIL  types",
+		},
+		{
+			name:       "invalid param",
+			args:       []string{"--param", "instance_types=t2.micro"},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "invalid parameter 'instance_types=t2.micro': invalid value for parameter 'instance_types': expected a list, got string t2.micro",
+		},
+		{
+			name:       "override",
+			args:       []string{"--override", "types=migration in progress"},
+			wantCode:   exitCodePass,
+			wantStdout: "OVERRIDDEN types",
+		},
+		{
+			name:       "invalid params file",
+			args:       []string{"--params-file", "invalid.json"},
+			wantCode:   exitCodeEngineError,
+			wantStderr: "invalid value for parameter 'instance_types' of rule 'types': expected a list, got string t2.micro",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestPolicies(t, policies)
+
+			for i, arg := range tt.args {
+				if arg == "--params-file" {
+					tt.args[i+1] = filepath.Join(dir, tt.args[i+1])
+				}
+			}
+
+			var stdout, stderr bytes.Buffer
+
+			code := run(append([]string{
+				"validate",
+				"--plan", testPlanFile,
+				"--policy", dir,
+			}, tt.args...), &stdout, &stderr)
+			assert.Equal(t, tt.wantCode, code, "stdout: %s\nstderr: %s", stdout.String(), stderr.String())
+			assert.Contains(t, stdout.String(), tt.wantStdout)
+			assert.Contains(t, stderr.String(), tt.wantStderr)
+		})
+	}
+}
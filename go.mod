@@ -5,19 +5,22 @@ go 1.16
 require (
 	github.com/apex/log v1.9.0
 	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/hashicorp/go-version v1.2.1
+	github.com/hashicorp/hcl/v2 v2.10.1
 	github.com/hexbee-net/horus/pkg/terraform v1.0.3
 	github.com/imdario/mergo v0.3.12
-	github.com/spf13/afero v1.2.2 // indirect
+	github.com/spf13/afero v1.2.2
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/testify v1.7.0
 	github.com/vadv/gopher-lua-libs v0.1.2
 	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9
+	github.com/zclconf/go-cty v1.9.0
 	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4 // indirect
 	golang.org/x/oauth2 v0.0.0-20210402161424-2e8d93401602 // indirect
 	golang.org/x/sys v0.0.0-20210510120138-977fb7262007 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
-	layeh.com/gopher-luar v1.0.10
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -0,0 +1,169 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policytest runs the unit tests of Lua policies.
+//
+// A test file is a Lua script whose name ends with '_test.lua'. It declares
+// test cases with the 'test' function; each of them validates fixtures with
+// the rules under test, and checks the findings:
+//
+//	test("public buckets are rejected", function()
+//	    validate_plan("fixtures/public.json")
+//	    expect_violation("s3/public", "aws_s3_bucket.logs")
+//	end)
+//
+// The paths of the fixtures are relative to the directory of the test file.
+//
+// The plans can also be built by the test cases, with the 'plan_builder'
+// function, the values only known after apply being set with 'unknown', the
+// same sentinel as 'tf.unknown':
+//
+//	validate_plan(plan_builder()
+//	    :resource("aws_s3_bucket.logs", "create", {after = {id = unknown, acl = "public-read"}}))
+package policytest
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+)
+
+// TestFileSuffix is the suffix of the names of the test files.
+const TestFileSuffix = warden.TestFileSuffix
+
+// IsTestFile reports whether path is the path of a test file.
+func IsTestFile(path string) bool {
+	return strings.HasSuffix(path, TestFileSuffix)
+}
+
+// Result is the result of a test case.
+type Result struct {
+	// File is the path of the test file.
+	File string
+	// Name is the name of the test case. It is empty when the test file
+	// itself could not be run.
+	Name string
+	// Failures are the messages of the failed expectations, and of the
+	// error which aborted the test case, if any.
+	Failures []string
+}
+
+// Passed reports whether the test case passed.
+func (r *Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// Report holds the results of a test run.
+type Report struct {
+	Results []Result
+	// Coverage is the line coverage of the rules and user modules.
+	Coverage []wlua.FileCoverage
+}
+
+// Failed returns the number of test cases which failed.
+func (r *Report) Failed() int {
+	n := 0
+
+	for i := range r.Results {
+		if !r.Results[i].Passed() {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Passed reports whether all the test cases passed.
+func (r *Report) Passed() bool {
+	return r.Failed() == 0
+}
+
+// Discover returns the paths of the test files found in root, which is
+// either a test file or a directory tree, in lexical order.
+func Discover(fs afero.Fs, root string) ([]string, error) {
+	fi, err := fs.Stat(root)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to access test path: %w", err)
+	}
+
+	if !fi.IsDir() {
+		return []string{root}, nil
+	}
+
+	var files []string
+
+	err = afero.Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && IsTestFile(path) {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to discover tests in '%s': %w", root, err)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// Run runs the test cases of the test files against the rules configured in
+// the options, and measures the coverage of their scripts.
+//
+// The failures of the test cases are reported in the results; an error is
+// only returned when the rules cannot be loaded, or when the context is
+// done, along with the partial report.
+func Run(ctx context.Context, fs afero.Fs, opts *warden.Options, files []string) (*Report, error) {
+	o := warden.Options{}
+	if opts != nil {
+		o = *opts
+	}
+
+	o.Coverage = wlua.NewCoverage()
+
+	w, err := warden.New(&o)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load the rules: %w", err)
+	}
+
+	defer w.Close()
+
+	report := &Report{}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			report.Coverage = o.Coverage.Files()
+
+			return report, xerrors.Errorf("tests interrupted: %w", err)
+		}
+
+		report.Results = append(report.Results, runFile(ctx, fs, w, file)...)
+	}
+
+	report.Coverage = o.Coverage.Files()
+
+	return report, nil
+}
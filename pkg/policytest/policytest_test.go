@@ -0,0 +1,230 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policytest
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+const testPlanFile = "../../testData/tf-plan.json"
+
+const testTypesRule = `local tf = require "tf"
+
+local issues = {}
+for _, r in ipairs(tf.plan:findResource("aws_instance")) do
+	if r:change().after.instance_type ~= "t2.micro" then
+		table.insert(issues, { resource = r, message = "unexpected instance type" })
+	end
+end
+return issues
+`
+
+func newTestFs(t *testing.T, files map[string]string) afero.Fs {
+	t.Helper()
+
+	plan, err := ioutil.ReadFile(testPlanFile)
+	require.NoError(t, err)
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "policies/fixtures/plan.json", plan, 0o600))
+
+	for name, content := range files {
+		require.NoError(t, afero.WriteFile(fs, name, []byte(content), 0o600))
+	}
+
+	return fs
+}
+
+func TestDiscover(t *testing.T) {
+	fs := newTestFs(t, map[string]string{
+		"policies/types.lua":         testTypesRule,
+		"policies/types_test.lua":    "",
+		"policies/sub/tags_test.lua": "",
+		"policies/sub/tags.lua":      "",
+	})
+
+	files, err := Discover(fs, "policies")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"policies/sub/tags_test.lua", "policies/types_test.lua"}, files)
+
+	files, err = Discover(fs, "policies/types_test.lua")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"policies/types_test.lua"}, files)
+
+	_, err = Discover(fs, "missing")
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	fs := newTestFs(t, map[string]string{
+		"policies/types_test.lua": `
+test("passing plan", function()
+	local report = validate_plan("fixtures/plan.json")
+	assert(#report.findings == 0)
+	expect_no_violation("types")
+	expect_no_violation("types", "aws_instance.simple_resource")
+end)
+
+test("failed expectations", function()
+	validate_plan("fixtures/plan.json")
+	expect_violation("types", "aws_instance.simple_resource")
+	expect_violation("typos")
+	expect_error("types")
+end)
+
+test("expectation without fixture", function()
+	expect_violation("types")
+end)
+
+test("missing fixture", function()
+	validate_plan("fixtures/missing.json")
+end)
+
+test("rule error", function()
+	validate_plan("fixtures/plan.json")
+	expect_error("broken")
+	expect_no_violation("broken")
+end)
+`,
+		"policies/broken_test.lua": `test("never declared"`,
+	})
+
+	opts := &warden.Options{Rules: []warden.Rule{
+		{Name: "types", Script: testTypesRule},
+		{Name: "broken", Script: "error('boom')"},
+	}}
+
+	files, err := Discover(fs, "policies")
+	require.NoError(t, err)
+
+	report, err := Run(context.Background(), fs, opts, files)
+	require.NoError(t, err)
+	require.Len(t, report.Results, 6)
+
+	assert.Equal(t, "policies/broken_test.lua", report.Results[0].File)
+	assert.Empty(t, report.Results[0].Name)
+	assert.Len(t, report.Results[0].Failures, 1)
+
+	results := map[string][]string{}
+	for _, res := range report.Results[1:] {
+		results[res.Name] = res.Failures
+	}
+
+	assert.Empty(t, results["passing plan"])
+	assert.Equal(t, []string{
+		"policies/types_test.lua:11: expected a violation of rule 'types' for aws_instance.simple_resource, got none",
+		"policies/types_test.lua:12: unknown rule 'typos'",
+		"policies/types_test.lua:13: expected rule 'types' to fail with an error, got status pass",
+	}, results["failed expectations"])
+	assert.Equal(t, []string{
+		"policies/types_test.lua:17: 'expect_violation' called before validating a fixture",
+	}, results["expectation without fixture"])
+	require.Len(t, results["missing fixture"], 1)
+	assert.Contains(t, results["missing fixture"][0], "failed to open fixture policies/fixtures/missing.json")
+	require.Len(t, results["rule error"], 1)
+	assert.Contains(t, results["rule error"][0], "policies/types_test.lua:27: rule 'broken' failed with an error")
+
+	assert.False(t, report.Passed())
+	assert.Equal(t, 5, report.Failed())
+
+	require.Len(t, report.Coverage, 2)
+	assert.Equal(t, "broken", report.Coverage[0].Name)
+	assert.Equal(t, 100.0, report.Coverage[0].Percent())
+
+	types := report.Coverage[1]
+	assert.Equal(t, "types", types.Name)
+	assert.Equal(t, []int{1, 3, 4, 5, 6, 9}, types.Lines)
+	assert.Equal(t, []int{6}, types.Missed())
+	assert.Equal(t, 5, types.Covered())
+}
+
+func TestRun_PlanBuilder(t *testing.T) {
+	fs := newTestFs(t, map[string]string{
+		"policies/types_test.lua": `
+local function plan(instance_type)
+	return plan_builder()
+		:config("main.tf", 'resource "aws_instance" "web" {}')
+		:resource("aws_instance.web", "create", {after = {id = unknown, instance_type = instance_type}})
+end
+
+test("built plans", function()
+	validate_plan(plan("t2.micro"))
+	expect_no_violation("types")
+
+	local report = validate_plan(plan("t3.large"))
+	expect_violation("types", "aws_instance.web")
+	assert(report.findings[1].message == "unexpected instance type")
+end)
+
+test("invalid plan", function()
+	validate_plan(plan_builder():resource("aws_instance", "create"))
+end)
+`,
+	})
+
+	opts := &warden.Options{Rules: []warden.Rule{{Name: "types", Script: testTypesRule}}}
+
+	report, err := Run(context.Background(), fs, opts, []string{"policies/types_test.lua"})
+	require.NoError(t, err)
+	require.Len(t, report.Results, 2)
+
+	assert.Empty(t, report.Results[0].Failures)
+	require.Len(t, report.Results[1].Failures, 1)
+	assert.Contains(t, report.Results[1].Failures[0], "failed to build plan")
+}
+
+func TestRun_Sandbox(t *testing.T) {
+	fs := newTestFs(t, map[string]string{
+		"policies/sandbox_test.lua": `
+test("sandbox", function()
+	assert(os == nil, "os is available")
+	assert(io == nil, "io is available")
+	assert(string.format("%d", 1) == "1")
+end)
+`,
+	})
+
+	report, err := Run(context.Background(), fs, &warden.Options{}, []string{"policies/sandbox_test.lua"})
+	require.NoError(t, err)
+	require.Len(t, report.Results, 1)
+	assert.Empty(t, report.Results[0].Failures)
+}
+
+func TestWrite(t *testing.T) {
+	report := &Report{
+		Results: []Result{
+			{File: "a_test.lua", Name: "ok"},
+			{File: "a_test.lua", Name: "ko", Failures: []string{"a_test.lua:3: expected a violation of rule 'a', got none"}},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, report))
+	assert.Equal(t, `PASS  a_test.lua: ok
+FAIL  a_test.lua: ko
+      a_test.lua:3: expected a violation of rule 'a', got none
+
+2 tests: 1 passed, 1 failed
+`, buf.String())
+}
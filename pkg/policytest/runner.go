@@ -0,0 +1,401 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policytest
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+const (
+	luaFunctionTest              = "test"
+	luaFunctionValidatePlan      = "validate_plan"
+	luaFunctionValidateState     = "validate_state"
+	luaFunctionValidateConfig    = "validate_config"
+	luaFunctionExpectViolation   = "expect_violation"
+	luaFunctionExpectNoViolation = "expect_no_violation"
+	luaFunctionExpectError       = "expect_error"
+	luaFunctionPlanBuilder       = "plan_builder"
+	luaGlobalUnknown             = "unknown"
+)
+
+// testCase is a test case declared by a test file.
+type testCase struct {
+	name string
+	fn   *lua.LFunction
+}
+
+// fileRun is the execution of a test file.
+type fileRun struct {
+	ctx  context.Context
+	fs   afero.Fs
+	w    *warden.Warden
+	file string
+
+	cases []testCase
+
+	// report is the report of the last fixture validated by the running
+	// test case.
+	report *warden.Report
+	// failures are the failures of the running test case.
+	failures []string
+}
+
+// runFile runs the test cases of a test file.
+func runFile(ctx context.Context, fs afero.Fs, w *warden.Warden, file string) []Result {
+	run := &fileRun{ctx: ctx, fs: fs, w: w, file: file}
+
+	fileFailure := func(err error) []Result {
+		return []Result{{File: file, Failures: []string{err.Error()}}}
+	}
+
+	script, err := afero.ReadFile(fs, file)
+	if err != nil {
+		return fileFailure(xerrors.Errorf("failed to read test file: %w", err))
+	}
+
+	proto, err := wlua.Compile(file, string(script))
+	if err != nil {
+		return fileFailure(err)
+	}
+
+	// The test files get the same sandbox as the rules: no access to the
+	// file system or to the environment.
+	ls := wlua.NewState(lua.Options{SkipOpenLibs: true})
+	defer ls.Close()
+
+	ls.OpenModules(wlua.StandardLibs())
+	ls.PreloadModules(warden.DefaultPreloadModules())
+	run.register(ls.LState)
+
+	ls.SetContext(ctx)
+	defer ls.RemoveContext()
+
+	if err := ls.CallByParam(lua.P{Fn: ls.NewFunctionFromProto(proto), Protect: true}); err != nil {
+		return fileFailure(xerrors.Errorf("failed to run test file: %w", err))
+	}
+
+	results := make([]Result, 0, len(run.cases))
+
+	for _, tc := range run.cases {
+		run.report, run.failures = nil, nil
+
+		if err := ls.CallByParam(lua.P{Fn: tc.fn, Protect: true}); err != nil {
+			run.failures = append(run.failures, err.Error())
+		}
+
+		results = append(results, Result{File: file, Name: tc.name, Failures: run.failures})
+	}
+
+	return results
+}
+
+// register defines the functions of the test API in the Lua state.
+func (r *fileRun) register(ls *lua.LState) {
+	functions := map[string]lua.LGFunction{
+		luaFunctionTest:              r.test,
+		luaFunctionValidatePlan:      r.validatePlan,
+		luaFunctionValidateState:     r.validateState,
+		luaFunctionValidateConfig:    r.validateConfig,
+		luaFunctionExpectViolation:   r.expectViolation,
+		luaFunctionExpectNoViolation: r.expectNoViolation,
+		luaFunctionExpectError:       r.expectError,
+		luaFunctionPlanBuilder:       planBuilder,
+	}
+
+	for name, fn := range functions {
+		ls.SetGlobal(name, ls.NewFunction(fn))
+	}
+
+	terraform.RegisterPlanBuilderType(ls)
+	ls.SetGlobal(luaGlobalUnknown, wlua.LUnknown(ls))
+}
+
+// fixturePath returns the path of a fixture, relative to the directory of
+// the test file unless absolute.
+func (r *fileRun) fixturePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(r.file), path)
+}
+
+// fail records a failed expectation, located at the caller of the
+// expectation function.
+func (r *fileRun) fail(ls *lua.LState, format string, args ...interface{}) {
+	r.failures = append(r.failures, ls.Where(1)+" "+fmt.Sprintf(format, args...))
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+// test declares a test case, with its name and function.
+func (r *fileRun) test(ls *lua.LState) int {
+	name := ls.CheckString(1)
+	fn := ls.CheckFunction(2) //nolint:gomnd // second argument
+
+	r.cases = append(r.cases, testCase{name: name, fn: fn})
+
+	return 0
+}
+
+// validatePlan validates a plan file, binary or JSON, or a plan built with
+// 'plan_builder', with the rules, and returns the report.
+func (r *fileRun) validatePlan(ls *lua.LState) int {
+	b, ok := terraform.AsPlanBuilder(ls.Get(1))
+	if !ok {
+		return r.validateFile(ls, r.w.ValidatePlan)
+	}
+
+	planFile, err := b.Build()
+	if err != nil {
+		ls.RaiseError("failed to build plan: %v", err)
+
+		return 0
+	}
+
+	report, err := r.w.ValidatePlanFile(r.ctx, planFile)
+
+	return r.pushReport(ls, "built plan", report, err)
+}
+
+// planBuilder returns a new builder of synthetic plans, validated by
+// passing it to 'validate_plan'. The values that will only be known after
+// apply are set with the 'unknown' global.
+func planBuilder(ls *lua.LState) int {
+	ls.Push(terraform.LPlanBuilder(ls, terraform.NewPlanBuilder()))
+
+	return 1
+}
+
+// validateState validates a state file with the rules, and returns the
+// report.
+func (r *fileRun) validateState(ls *lua.LState) int {
+	return r.validateFile(ls, r.w.ValidateState)
+}
+
+func (r *fileRun) validateFile(ls *lua.LState, validate func(context.Context, afero.File) (*warden.Report, error)) int {
+	path := r.fixturePath(ls.CheckString(1))
+
+	file, err := r.fs.Open(path)
+	if err != nil {
+		ls.RaiseError("failed to open fixture %s: %v", path, err)
+
+		return 0
+	}
+
+	defer file.Close()
+
+	report, err := validate(r.ctx, file)
+
+	return r.pushReport(ls, path, report, err)
+}
+
+// validateConfig validates a configuration directory with the rules, and
+// returns the report.
+func (r *fileRun) validateConfig(ls *lua.LState) int {
+	path := r.fixturePath(ls.CheckString(1))
+
+	report, err := r.w.ValidateConfig(r.ctx, path)
+
+	return r.pushReport(ls, path, report, err)
+}
+
+// pushReport records the report of a validation and pushes it as a table
+// with the 'findings' and 'errors' fields. The findings are tables with the
+// 'rule', 'severity', 'address', 'path' and 'message' fields, and the errors
+// are indexed by rule.
+// The validation failures are part of the report; the other errors are
+// raised.
+func (r *fileRun) pushReport(ls *lua.LState, path string, report *warden.Report, err error) int {
+	if report == nil {
+		ls.RaiseError("failed to validate fixture %s: %v", path, err)
+
+		return 0
+	}
+
+	r.report = report
+
+	findings := ls.NewTable()
+
+	for _, f := range report.Findings() {
+		entry := ls.NewTable()
+		entry.RawSetString("rule", lua.LString(f.RuleID))
+		entry.RawSetString("severity", lua.LString(f.Severity))
+		entry.RawSetString("address", lua.LString(f.Address))
+		entry.RawSetString("path", lua.LString(f.AttributePath))
+		entry.RawSetString("message", lua.LString(f.Message))
+		findings.Append(entry)
+	}
+
+	errors := ls.NewTable()
+
+	for _, res := range report.Results {
+		if res.Err != nil {
+			errors.RawSetString(res.Rule.Name, lua.LString(res.Err.Error()))
+		}
+	}
+
+	ret := ls.NewTable()
+	ret.RawSetString("findings", findings)
+	ret.RawSetString("errors", errors)
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// expectViolation checks that the rule reported a finding, for the given
+// address if any, on the last fixture validated. It returns whether the
+// expectation is met.
+func (r *fileRun) expectViolation(ls *lua.LState) int {
+	rule, address := ls.CheckString(1), ls.OptString(2, "") //nolint:gomnd // second argument
+
+	res, ok := r.ruleResult(ls, luaFunctionExpectViolation, rule)
+	if !ok {
+		ls.Push(lua.LFalse)
+
+		return 1
+	}
+
+	if findings := matchingFindings(res, address); len(findings) == 0 {
+		r.fail(ls, "expected a violation of rule '%s'%s, got none", rule, forAddress(address))
+		ls.Push(lua.LFalse)
+
+		return 1
+	}
+
+	ls.Push(lua.LTrue)
+
+	return 1
+}
+
+// expectNoViolation checks that the rule reported no finding, for the given
+// address if any, on the last fixture validated. It returns whether the
+// expectation is met.
+func (r *fileRun) expectNoViolation(ls *lua.LState) int {
+	rule, address := ls.CheckString(1), ls.OptString(2, "") //nolint:gomnd // second argument
+
+	res, ok := r.ruleResult(ls, luaFunctionExpectNoViolation, rule)
+	if !ok {
+		ls.Push(lua.LFalse)
+
+		return 1
+	}
+
+	if findings := matchingFindings(res, address); len(findings) > 0 {
+		r.fail(ls, "expected no violation of rule '%s'%s, got %q", rule, forAddress(address), findings[0].String())
+		ls.Push(lua.LFalse)
+
+		return 1
+	}
+
+	ls.Push(lua.LTrue)
+
+	return 1
+}
+
+// expectError checks that the rule could not be executed on the last
+// fixture validated. It returns whether the expectation is met.
+func (r *fileRun) expectError(ls *lua.LState) int {
+	rule := ls.CheckString(1)
+
+	res := r.findResult(ls, luaFunctionExpectError, rule)
+	if res == nil {
+		ls.Push(lua.LFalse)
+
+		return 1
+	}
+
+	if res.Err == nil {
+		r.fail(ls, "expected rule '%s' to fail with an error, got status %s", rule, res.Status)
+		ls.Push(lua.LFalse)
+
+		return 1
+	}
+
+	ls.Push(lua.LTrue)
+
+	return 1
+}
+
+// ruleResult returns the result of a rule for the last fixture validated,
+// and whether its findings can be checked, i.e. it ran without error.
+func (r *fileRun) ruleResult(ls *lua.LState, funcName string, rule string) (*warden.RuleResult, bool) {
+	res := r.findResult(ls, funcName, rule)
+	if res == nil {
+		return nil, false
+	}
+
+	if res.Err != nil {
+		r.fail(ls, "rule '%s' failed with an error: %v", rule, res.Err)
+
+		return nil, false
+	}
+
+	return res, true
+}
+
+// findResult returns the result of a rule for the last fixture validated,
+// or records a failure and returns nil if there is none.
+func (r *fileRun) findResult(ls *lua.LState, funcName string, rule string) *warden.RuleResult {
+	if r.report == nil {
+		r.fail(ls, "'%s' called before validating a fixture", funcName)
+
+		return nil
+	}
+
+	for i := range r.report.Results {
+		if r.report.Results[i].Rule.Name == rule {
+			return &r.report.Results[i]
+		}
+	}
+
+	r.fail(ls, "unknown rule '%s'", rule)
+
+	return nil
+}
+
+// matchingFindings returns the findings of the result for the address, or
+// all of them if the address is empty.
+func matchingFindings(res *warden.RuleResult, address string) []warden.Finding {
+	var ret []warden.Finding
+
+	for _, f := range res.Findings {
+		if address == "" || f.Address == address {
+			ret = append(ret, f)
+		}
+	}
+
+	return ret
+}
+
+func forAddress(address string) string {
+	if address == "" {
+		return ""
+	}
+
+	return " for " + address
+}
@@ -0,0 +1,93 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policytest
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Write writes the results of the test cases and the coverage of the
+// scripts to w, as text.
+func Write(w io.Writer, report *Report) error {
+	if err := writeText(w, report); err != nil {
+		return xerrors.Errorf("failed to write the test report: %w", err)
+	}
+
+	return nil
+}
+
+func writeText(w io.Writer, report *Report) error {
+	for i := range report.Results {
+		res := &report.Results[i]
+
+		label := "PASS"
+		if !res.Passed() {
+			label = "FAIL"
+		}
+
+		name := res.File
+		if res.Name != "" {
+			name += ": " + res.Name
+		}
+
+		if _, err := fmt.Fprintf(w, "%-5s %s\n", label, name); err != nil {
+			return err //nolint:wrapcheck // the error is wrapped by Write.
+		}
+
+		for _, f := range res.Failures {
+			if _, err := fmt.Fprintf(w, "      %s\n", f); err != nil {
+				return err //nolint:wrapcheck // the error is wrapped by Write.
+			}
+		}
+	}
+
+	if len(report.Coverage) > 0 {
+		if _, err := fmt.Fprintln(w, "\ncoverage:"); err != nil {
+			return err //nolint:wrapcheck // the error is wrapped by Write.
+		}
+	}
+
+	for i := range report.Coverage {
+		fc := &report.Coverage[i]
+
+		line := fmt.Sprintf("  %-30s %5.1f%% (%d/%d lines)", fc.Name, fc.Percent(), fc.Covered(), len(fc.Lines))
+		if missed := fc.Missed(); len(missed) > 0 {
+			line += " missed: " + joinInts(missed)
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err //nolint:wrapcheck // the error is wrapped by Write.
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d tests: %d passed, %d failed\n",
+		len(report.Results), len(report.Results)-report.Failed(), report.Failed())
+
+	return err //nolint:wrapcheck // the error is wrapped by Write.
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, strconv.Itoa(v))
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,131 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+// GitHub Actions workflow commands creating annotations.
+const (
+	githubError   = "error"
+	githubWarning = "warning"
+	githubNotice  = "notice"
+)
+
+//nolint:gochecknoglobals // read-only replacers.
+var (
+	githubDataEscaper = strings.NewReplacer(
+		"%", "%25",
+		"\r", "%0D",
+		"\n", "%0A",
+	)
+	githubPropertyEscaper = strings.NewReplacer(
+		"%", "%25",
+		"\r", "%0D",
+		"\n", "%0A",
+		":", "%3A",
+		",", "%2C",
+	)
+)
+
+// githubProperty is a property of a workflow command.
+type githubProperty struct {
+	key   string
+	value string
+}
+
+func writeGitHub(w io.Writer, report *warden.Report) error {
+	for i := range report.Results {
+		res := &report.Results[i]
+
+		if res.Err != nil {
+			if err := writeGitHubCommand(w, githubError, []githubProperty{{"title", res.Rule.Name}}, res.Err.Error()); err != nil {
+				return err
+			}
+		}
+
+		for _, f := range res.Findings {
+			if err := writeGitHubCommand(w, githubCommand(findingSeverity(res, f)), githubProperties(f), f.String()); err != nil {
+				return err
+			}
+		}
+
+		for _, f := range res.Waived {
+			if err := writeGitHubCommand(w, githubNotice, githubProperties(f.Finding), "waived: "+waivedDescription(f)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, waiver := range report.ExpiredWaivers {
+		if err := writeGitHubCommand(w, githubError, []githubProperty{{"title", waiver.RuleID}}, "expired "+waiver.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func githubCommand(severity warden.Severity) string {
+	switch severity {
+	case warden.SeverityError:
+		return githubError
+	case warden.SeverityWarning:
+		return githubWarning
+	default:
+		return githubNotice
+	}
+}
+
+// githubProperties returns the properties of the annotation of a finding.
+func githubProperties(f warden.Finding) []githubProperty {
+	var props []githubProperty
+
+	if f.Range != nil {
+		props = append(props,
+			githubProperty{"file", sourceFile(f.Range)},
+			githubProperty{"line", strconv.Itoa(f.Range.Start.Line)},
+			githubProperty{"endLine", strconv.Itoa(f.Range.End.Line)},
+		)
+
+		// Columns are only meaningful for annotations on a single line.
+		if f.Range.Start.Line == f.Range.End.Line {
+			props = append(props,
+				githubProperty{"col", strconv.Itoa(f.Range.Start.Column)},
+				githubProperty{"endColumn", strconv.Itoa(f.Range.End.Column)},
+			)
+		}
+	}
+
+	return append(props, githubProperty{"title", f.RuleID})
+}
+
+func writeGitHubCommand(w io.Writer, command string, props []githubProperty, message string) error {
+	escaped := make([]string, 0, len(props))
+
+	for _, p := range props {
+		escaped = append(escaped, p.key+"="+githubPropertyEscaper.Replace(p.value))
+	}
+
+	_, err := fmt.Fprintf(w, "::%s %s::%s\n", command, strings.Join(escaped, ","), githubDataEscaper.Replace(message))
+
+	return err //nolint:wrapcheck // the error is wrapped by Write.
+}
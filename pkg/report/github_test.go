@@ -0,0 +1,66 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+func TestWrite_GitHub(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatGitHub, testReport()))
+
+	assert.Equal(t, ""+
+		"::error file=root.tf,line=18,endLine=18,col=1,endColumn=32,title=instance-type::"+
+		"aws_instance.simple_resource.instance_type: unexpected instance type\n"+
+		"::warning title=instance-type::100%25 of the instances, are: checked\n"+
+		"::notice file=modules/net/main.tf,line=3,endLine=3,col=1,endColumn=32,title=tags::"+
+		"module.net.aws_vpc.main: missing Owner tag\n"+
+		"::error title=broken::boom\n",
+		buf.String())
+}
+
+func TestWrite_GitHubEscaping(t *testing.T) {
+	report := &warden.Report{
+		Results: []warden.RuleResult{{
+			Rule:   warden.Rule{Name: "multi"},
+			Status: warden.RuleStatusFail,
+			Findings: []warden.Finding{{
+				RuleID:   "ns:rule,1",
+				Severity: warden.SeverityError,
+				Message:  "first line\nsecond line",
+				Range: &hcl.Range{
+					Filename: "a,b.tf",
+					Start:    hcl.Pos{Line: 2, Column: 3},
+					End:      hcl.Pos{Line: 4, Column: 1},
+				},
+			}},
+		}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatGitHub, report))
+
+	assert.Equal(t,
+		"::error file=a%2Cb.tf,line=2,endLine=4,title=ns%3Arule%2C1::first line%0Asecond line\n",
+		buf.String())
+}
@@ -0,0 +1,146 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+type jsonReport struct {
+	Results        []jsonRuleResult `json:"results"`
+	ExpiredWaivers []jsonWaiver     `json:"expiredWaivers,omitempty"`
+}
+
+type jsonRuleResult struct {
+	Rule        string              `json:"rule"`
+	Description string              `json:"description,omitempty"`
+	Status      string              `json:"status"`
+	Enforcement string              `json:"enforcement,omitempty"`
+	Override    string              `json:"overrideReason,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	Findings    []jsonFinding       `json:"findings"`
+	Waived      []jsonWaivedFinding `json:"waived,omitempty"`
+}
+
+type jsonFinding struct {
+	RuleID        string     `json:"ruleId"`
+	Severity      string     `json:"severity"`
+	Address       string     `json:"address,omitempty"`
+	Message       string     `json:"message"`
+	AttributePath string     `json:"attributePath,omitempty"`
+	Location      *jsonRange `json:"location,omitempty"`
+}
+
+type jsonWaivedFinding struct {
+	jsonFinding
+	Waiver jsonWaiver `json:"waiver"`
+}
+
+type jsonWaiver struct {
+	RuleID        string `json:"ruleId"`
+	Address       string `json:"address"`
+	Justification string `json:"justification"`
+	Owner         string `json:"owner"`
+	Expires       string `json:"expires"`
+}
+
+type jsonRange struct {
+	File        string `json:"file"`
+	StartLine   int    `json:"startLine"`
+	StartColumn int    `json:"startColumn"`
+	EndLine     int    `json:"endLine"`
+	EndColumn   int    `json:"endColumn"`
+}
+
+func writeJSON(w io.Writer, report *warden.Report) error {
+	doc := jsonReport{
+		Results: make([]jsonRuleResult, 0, len(report.Results)),
+	}
+
+	for _, res := range report.Results {
+		r := jsonRuleResult{
+			Rule:        res.Rule.Name,
+			Description: res.Rule.Description,
+			Status:      string(res.Status),
+			Enforcement: string(res.Rule.Enforcement),
+			Findings:    make([]jsonFinding, 0, len(res.Findings)),
+		}
+
+		if res.Err != nil {
+			r.Error = res.Err.Error()
+		}
+
+		if res.Override != nil {
+			r.Override = res.Override.Reason
+		}
+
+		for _, f := range res.Findings {
+			r.Findings = append(r.Findings, newJSONFinding(f))
+		}
+
+		for _, f := range res.Waived {
+			r.Waived = append(r.Waived, jsonWaivedFinding{
+				jsonFinding: newJSONFinding(f.Finding),
+				Waiver:      newJSONWaiver(f.Waiver),
+			})
+		}
+
+		doc.Results = append(doc.Results, r)
+	}
+
+	for _, waiver := range report.ExpiredWaivers {
+		doc.ExpiredWaivers = append(doc.ExpiredWaivers, newJSONWaiver(waiver))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc) //nolint:wrapcheck // the error is wrapped by Write.
+}
+
+func newJSONFinding(f warden.Finding) jsonFinding {
+	jf := jsonFinding{
+		RuleID:        f.RuleID,
+		Severity:      string(f.Severity),
+		Address:       f.Address,
+		Message:       f.Message,
+		AttributePath: f.AttributePath,
+	}
+
+	if f.Range != nil {
+		jf.Location = &jsonRange{
+			File:        sourceFile(f.Range),
+			StartLine:   f.Range.Start.Line,
+			StartColumn: f.Range.Start.Column,
+			EndLine:     f.Range.End.Line,
+			EndColumn:   f.Range.End.Column,
+		}
+	}
+
+	return jf
+}
+
+func newJSONWaiver(w warden.Waiver) jsonWaiver {
+	return jsonWaiver{
+		RuleID:        w.RuleID,
+		Address:       w.Address,
+		Justification: w.Justification,
+		Owner:         w.Owner,
+		Expires:       w.Expires.Format(warden.WaiverDateLayout),
+	}
+}
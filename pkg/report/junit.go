@@ -0,0 +1,182 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+const (
+	junitSuiteName    = "horus"
+	junitWaiversClass = junitSuiteName + ".waivers"
+	junitWaivedType   = "waived"
+	junitExpiredType  = "expired"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr,omitempty"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr,omitempty"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func writeJUnit(w io.Writer, report *warden.Report) error {
+	suite := junitTestSuite{
+		Name:      junitSuiteName,
+		Tests:     len(report.Results),
+		TestCases: make([]junitTestCase, 0, len(report.Results)),
+	}
+
+	for _, res := range report.Results {
+		tc := junitTestCase{
+			Name:      res.Rule.Name,
+			ClassName: junitSuiteName,
+		}
+
+		switch res.Status {
+		case warden.RuleStatusFail:
+			suite.Failures++
+			tc.Failure = &junitMessage{
+				Message: fmt.Sprintf("%d finding(s)", len(res.Findings)),
+				Type:    string(res.Status),
+				Text:    junitFindings(res.Findings),
+			}
+		case warden.RuleStatusError:
+			suite.Errors++
+			tc.Error = &junitMessage{
+				Message: res.Err.Error(),
+				Type:    string(res.Status),
+			}
+		case warden.RuleStatusPass, warden.RuleStatusWarn:
+			// Rules passing with warnings or informational findings still
+			// report them, as do the advisory rules.
+			tc.SystemOut = junitFindings(res.Findings)
+		case warden.RuleStatusOverridden:
+			tc.SystemOut = fmt.Sprintf("overridden: %s\n%s", res.Override.Reason, junitFindings(res.Findings))
+		}
+
+		if len(res.Waived) > 0 {
+			// A rule passing thanks to its waivers is skipped rather than
+			// passed; the waivers of the other rules are only reported.
+			if res.Status == warden.RuleStatusPass {
+				suite.Skipped++
+				tc.Skipped = &junitMessage{
+					Message: fmt.Sprintf("%d finding(s) waived", len(res.Waived)),
+					Type:    junitWaivedType,
+					Text:    junitWaived(res.Waived),
+				}
+			} else {
+				tc.SystemOut += junitWaived(res.Waived)
+			}
+		}
+
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	// Each expired waiver fails a test case of its own, as it fails the
+	// validation even when the rule it applied to passes.
+	for _, waiver := range report.ExpiredWaivers {
+		suite.Tests++
+		suite.Failures++
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			Name:      fmt.Sprintf("%s %s", waiver.RuleID, waiver.Address),
+			ClassName: junitWaiversClass,
+			Failure: &junitMessage{
+				Message: "expired " + waiver.String(),
+				Type:    junitExpiredType,
+			},
+		})
+	}
+
+	doc := junitTestSuites{
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Skipped:  suite.Skipped,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err //nolint:wrapcheck // the error is wrapped by Write.
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(doc); err != nil {
+		return err //nolint:wrapcheck // the error is wrapped by Write.
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err //nolint:wrapcheck // the error is wrapped by Write.
+}
+
+func junitFindings(findings []warden.Finding) string {
+	var sb strings.Builder
+
+	for _, f := range findings {
+		fmt.Fprintf(&sb, "[%s] %s", f.Severity, f.String())
+
+		if f.Range != nil {
+			fmt.Fprintf(&sb, " (%s:%d)", sourceFile(f.Range), f.Range.Start.Line)
+		}
+
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
+
+func junitWaived(waived []warden.WaivedFinding) string {
+	var sb strings.Builder
+
+	for _, f := range waived {
+		fmt.Fprintf(&sb, "[waived] %s\n", waivedDescription(f))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,65 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite_JUnit(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatJUnit, testReport()))
+
+	assert.True(t, strings.HasPrefix(buf.String(), xml.Header))
+
+	var doc junitTestSuites
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+
+	assert.Equal(t, 4, doc.Tests)
+	assert.Equal(t, 1, doc.Failures)
+	assert.Equal(t, 1, doc.Errors)
+	require.Len(t, doc.Suites, 1)
+
+	cases := doc.Suites[0].TestCases
+	require.Len(t, cases, 4)
+
+	assert.Equal(t, junitTestCase{Name: "passing", ClassName: "horus"}, cases[0])
+	assert.Equal(t, junitTestCase{
+		Name:      "instance-type",
+		ClassName: "horus",
+		Failure: &junitMessage{
+			Message: "2 finding(s)",
+			Type:    "fail",
+			Text: "[error] aws_instance.simple_resource.instance_type: unexpected instance type (root.tf:18)\n" +
+				"[warning] 100% of the instances, are: checked\n",
+		},
+	}, cases[1])
+	assert.Equal(t, junitTestCase{
+		Name:      "tags",
+		ClassName: "horus",
+		SystemOut: "[info] module.net.aws_vpc.main: missing Owner tag (modules/net/main.tf:3)\n",
+	}, cases[2])
+	assert.Equal(t, junitTestCase{
+		Name:      "broken",
+		ClassName: "horus",
+		Error:     &junitMessage{Message: "boom", Type: "error"},
+	}, cases[3])
+}
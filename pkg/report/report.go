@@ -0,0 +1,127 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package report renders the results of a validation in the formats
+// consumed by humans and CI systems.
+package report
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+// Format is an output format of a report.
+type Format string
+
+const (
+	// FormatText is a human readable summary of the results.
+	FormatText Format = "text"
+	// FormatJSON is a JSON document listing the results of every rule.
+	FormatJSON Format = "json"
+	// FormatSARIF is a SARIF 2.1.0 log, as consumed by code scanning tools.
+	FormatSARIF Format = "sarif"
+	// FormatJUnit is a JUnit XML document with one test case per rule and per
+	// expired waiver.
+	FormatJUnit Format = "junit"
+	// FormatGitHub is a list of GitHub Actions workflow commands annotating
+	// the configuration files.
+	FormatGitHub Format = "github"
+)
+
+// ErrUnknownFormat is returned when a report is requested in an unsupported
+// format.
+var ErrUnknownFormat = xerrors.New("unknown report format")
+
+// writerFunc renders a report to a writer.
+type writerFunc func(w io.Writer, report *warden.Report) error
+
+func writers() map[Format]writerFunc {
+	return map[Format]writerFunc{
+		FormatText:   writeText,
+		FormatJSON:   writeJSON,
+		FormatSARIF:  writeSARIF,
+		FormatJUnit:  writeJUnit,
+		FormatGitHub: writeGitHub,
+	}
+}
+
+// Formats returns the supported report formats, sorted by name.
+func Formats() []Format {
+	ws := writers()
+
+	formats := make([]Format, 0, len(ws))
+	for f := range ws {
+		formats = append(formats, f)
+	}
+
+	sort.Slice(formats, func(i, j int) bool { return formats[i] < formats[j] })
+
+	return formats
+}
+
+// ParseFormat returns the report format with the given name.
+func ParseFormat(s string) (Format, error) {
+	format := Format(strings.ToLower(s))
+	if _, ok := writers()[format]; !ok {
+		return "", xerrors.Errorf("%w '%s'", ErrUnknownFormat, s)
+	}
+
+	return format, nil
+}
+
+// Write renders the report in the given format.
+func Write(w io.Writer, format Format, report *warden.Report) error {
+	write, ok := writers()[format]
+	if !ok {
+		return xerrors.Errorf("%w '%s'", ErrUnknownFormat, format)
+	}
+
+	if err := write(w, report); err != nil {
+		return xerrors.Errorf("failed to write %s report: %w", format, err)
+	}
+
+	return nil
+}
+
+// sourceFile returns the path of the file of a source range, using forward
+// slashes as expected by URIs and CI annotations.
+func sourceFile(rng *hcl.Range) string {
+	return filepath.ToSlash(filepath.Clean(rng.Filename))
+}
+
+// findingSeverity returns the severity a finding of a rule is reported
+// with: the errors of the rules that only warn, because they are advisory or
+// overridden, are reported as warnings.
+func findingSeverity(res *warden.RuleResult, f warden.Finding) warden.Severity {
+	if f.Severity == warden.SeverityError &&
+		(res.Status == warden.RuleStatusWarn || res.Status == warden.RuleStatusOverridden) {
+		return warden.SeverityWarning
+	}
+
+	return f.Severity
+}
+
+// waivedDescription describes a waived finding along with its waiver.
+func waivedDescription(f warden.WaivedFinding) string {
+	return fmt.Sprintf("%s (%s, until %s: %s)",
+		f.String(), f.Waiver.Owner, f.Waiver.Expires.Format(warden.WaiverDateLayout), f.Waiver.Justification)
+}
@@ -0,0 +1,411 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+func testRange(file string, line int) *hcl.Range {
+	return &hcl.Range{
+		Filename: file,
+		Start:    hcl.Pos{Line: line, Column: 1},
+		End:      hcl.Pos{Line: line, Column: 32},
+	}
+}
+
+func testReport() *warden.Report {
+	return &warden.Report{
+		Results: []warden.RuleResult{
+			{
+				Rule:   warden.Rule{Name: "passing", Severity: warden.SeverityError},
+				Status: warden.RuleStatusPass,
+			},
+			{
+				Rule: warden.Rule{
+					Name:        "instance-type",
+					Description: "Instances must use approved types",
+					Severity:    warden.SeverityError,
+					Tags:        []string{"cost"},
+				},
+				Status: warden.RuleStatusFail,
+				Findings: []warden.Finding{
+					{
+						RuleID:        "instance-type",
+						Severity:      warden.SeverityError,
+						Address:       "aws_instance.simple_resource",
+						Message:       "unexpected instance type",
+						AttributePath: "instance_type",
+						Range:         testRange("root.tf", 18),
+					},
+					{
+						RuleID:   "instance-type",
+						Severity: warden.SeverityWarning,
+						Message:  "100% of the instances, are: checked",
+					},
+				},
+			},
+			{
+				Rule:   warden.Rule{Name: "tags", Severity: warden.SeverityWarning},
+				Status: warden.RuleStatusPass,
+				Findings: []warden.Finding{
+					{
+						RuleID:   "tags",
+						Severity: warden.SeverityInfo,
+						Address:  "module.net.aws_vpc.main",
+						Message:  "missing Owner tag",
+						Range:    testRange("modules/net/main.tf", 3),
+					},
+				},
+			},
+			{
+				Rule:   warden.Rule{Name: "broken", Severity: warden.SeverityError},
+				Status: warden.RuleStatusError,
+				Err:    xerrors.New("boom"),
+			},
+		},
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range Formats() {
+		got, err := ParseFormat(string(f))
+		require.NoError(t, err)
+		assert.Equal(t, f, got)
+	}
+
+	got, err := ParseFormat("SARIF")
+	require.NoError(t, err)
+	assert.Equal(t, FormatSARIF, got)
+
+	_, err = ParseFormat("xml")
+	assert.True(t, xerrors.Is(err, ErrUnknownFormat))
+}
+
+func TestFormats(t *testing.T) {
+	assert.Equal(t, []Format{FormatGitHub, FormatJSON, FormatJUnit, FormatSARIF, FormatText}, Formats())
+}
+
+func TestWrite_UnknownFormat(t *testing.T) {
+	err := Write(&bytes.Buffer{}, "xml", testReport())
+	assert.True(t, xerrors.Is(err, ErrUnknownFormat))
+}
+
+func TestWrite_Text(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatText, testReport()))
+
+	assert.Equal(t, `PASS  passing
+FAIL  instance-type
+      [error] aws_instance.simple_resource.instance_type: unexpected instance type (root.tf:18)
+      [warning] 100% of the instances, are: checked
+PASS  tags
+      [info] module.net.aws_vpc.main: missing Owner tag (modules/net/main.tf:3)
+ERROR broken
+      boom
+
+4 rules: 2 passed, 1 failed, 1 errored
+`, buf.String())
+}
+
+func TestWrite_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatJSON, testReport()))
+
+	var doc jsonReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	require.Len(t, doc.Results, 4)
+
+	assert.Equal(t, jsonRuleResult{Rule: "passing", Status: "pass", Findings: []jsonFinding{}}, doc.Results[0])
+	assert.Equal(t, jsonRuleResult{Rule: "broken", Status: "error", Error: "boom", Findings: []jsonFinding{}}, doc.Results[3])
+
+	res := doc.Results[1]
+	assert.Equal(t, "instance-type", res.Rule)
+	assert.Equal(t, "Instances must use approved types", res.Description)
+	assert.Equal(t, "fail", res.Status)
+	assert.Equal(t, []jsonFinding{
+		{
+			RuleID:        "instance-type",
+			Severity:      "error",
+			Address:       "aws_instance.simple_resource",
+			Message:       "unexpected instance type",
+			AttributePath: "instance_type",
+			Location:      &jsonRange{File: "root.tf", StartLine: 18, StartColumn: 1, EndLine: 18, EndColumn: 32},
+		},
+		{
+			RuleID:   "instance-type",
+			Severity: "warning",
+			Message:  "100% of the instances, are: checked",
+		},
+	}, res.Findings)
+}
+
+func testWaiversReport() *warden.Report {
+	waiver := warden.Waiver{
+		RuleID:        "tags",
+		Address:       "module.legacy.*",
+		Justification: "Decommissioned in Q3.",
+		Owner:         "ops",
+		Expires:       time.Date(2021, 9, 30, 0, 0, 0, 0, time.UTC),
+	}
+
+	expired := waiver
+	expired.Address = "aws_instance.old"
+	expired.Expires = time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	return &warden.Report{
+		Results: []warden.RuleResult{
+			{
+				Rule:   warden.Rule{Name: "tags", Severity: warden.SeverityError},
+				Status: warden.RuleStatusPass,
+				Waived: []warden.WaivedFinding{
+					{
+						Finding: warden.Finding{
+							RuleID:   "tags",
+							Severity: warden.SeverityError,
+							Address:  "module.legacy.aws_vpc.main",
+							Message:  "missing Owner tag",
+						},
+						Waiver: waiver,
+					},
+				},
+			},
+		},
+		ExpiredWaivers: []warden.Waiver{expired},
+	}
+}
+
+func TestWrite_Waivers(t *testing.T) {
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatText, testWaiversReport()))
+
+		assert.Equal(t, `PASS  tags
+      [waived] module.legacy.aws_vpc.main: missing Owner tag (ops, until 2021-09-30: Decommissioned in Q3.)
+EXPIRED waiver of rule 'tags' for 'aws_instance.old' (owner: ops, expires: 2021-01-31)
+
+1 rules: 1 passed, 0 failed, 0 errored
+1 findings waived
+`, buf.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatJSON, testWaiversReport()))
+
+		var doc jsonReport
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+		require.Len(t, doc.Results, 1)
+
+		assert.Empty(t, doc.Results[0].Findings)
+		assert.Equal(t, []jsonWaivedFinding{{
+			jsonFinding: jsonFinding{
+				RuleID:   "tags",
+				Severity: "error",
+				Address:  "module.legacy.aws_vpc.main",
+				Message:  "missing Owner tag",
+			},
+			Waiver: jsonWaiver{
+				RuleID:        "tags",
+				Address:       "module.legacy.*",
+				Justification: "Decommissioned in Q3.",
+				Owner:         "ops",
+				Expires:       "2021-09-30",
+			},
+		}}, doc.Results[0].Waived)
+		assert.Equal(t, []jsonWaiver{{
+			RuleID:        "tags",
+			Address:       "aws_instance.old",
+			Justification: "Decommissioned in Q3.",
+			Owner:         "ops",
+			Expires:       "2021-01-31",
+		}}, doc.ExpiredWaivers)
+	})
+
+	t.Run("sarif", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatSARIF, testWaiversReport()))
+
+		var log sarifLog
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+		require.Len(t, log.Runs, 1)
+
+		run := log.Runs[0]
+		require.Len(t, run.Results, 1)
+		assert.Equal(t, []sarifSuppression{{
+			Kind:          "external",
+			Status:        "accepted",
+			Justification: "Decommissioned in Q3.",
+		}}, run.Results[0].Suppressions)
+
+		require.Len(t, run.Invocations[0].ToolExecutionNotifications, 1)
+		assert.Equal(t, "error", run.Invocations[0].ToolExecutionNotifications[0].Level)
+		assert.Contains(t, run.Invocations[0].ToolExecutionNotifications[0].Message.Text, "expired waiver of rule 'tags'")
+	})
+
+	t.Run("junit", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatJUnit, testWaiversReport()))
+
+		var doc junitTestSuites
+		require.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+
+		assert.Equal(t, 2, doc.Tests)
+		assert.Equal(t, 1, doc.Failures)
+		assert.Equal(t, 1, doc.Skipped)
+		require.Len(t, doc.Suites, 1)
+		assert.Equal(t, []junitTestCase{
+			{
+				Name:      "tags",
+				ClassName: "horus",
+				Skipped: &junitMessage{
+					Message: "1 finding(s) waived",
+					Type:    "waived",
+					Text:    "[waived] module.legacy.aws_vpc.main: missing Owner tag (ops, until 2021-09-30: Decommissioned in Q3.)\n",
+				},
+			},
+			{
+				Name:      "tags aws_instance.old",
+				ClassName: "horus.waivers",
+				Failure: &junitMessage{
+					Message: "expired waiver of rule 'tags' for 'aws_instance.old' (owner: ops, expires: 2021-01-31)",
+					Type:    "expired",
+				},
+			},
+		}, doc.Suites[0].TestCases)
+	})
+
+	t.Run("github", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatGitHub, testWaiversReport()))
+
+		assert.Equal(t, ""+
+			"::notice title=tags::waived: module.legacy.aws_vpc.main: missing Owner tag "+
+			"(ops, until 2021-09-30: Decommissioned in Q3.)\n"+
+			"::error title=tags::expired waiver of rule 'tags' for 'aws_instance.old' (owner: ops, expires: 2021-01-31)\n",
+			buf.String())
+	})
+}
+
+func testEnforcementReport() *warden.Report {
+	finding := warden.Finding{
+		RuleID:   "encryption",
+		Severity: warden.SeverityError,
+		Address:  "aws_s3_bucket.logs",
+		Message:  "bucket is not encrypted",
+	}
+
+	advisory := finding
+	advisory.RuleID = "naming"
+	advisory.Message = "bucket name has no prefix"
+
+	return &warden.Report{
+		Results: []warden.RuleResult{
+			{
+				Rule:     warden.Rule{Name: "naming", Severity: warden.SeverityError, Enforcement: warden.EnforcementAdvisory},
+				Status:   warden.RuleStatusWarn,
+				Findings: []warden.Finding{advisory},
+			},
+			{
+				Rule:     warden.Rule{Name: "encryption", Severity: warden.SeverityError, Enforcement: warden.EnforcementSoftMandatory},
+				Status:   warden.RuleStatusOverridden,
+				Findings: []warden.Finding{finding},
+				Override: &warden.Override{RuleID: "encryption", Reason: "CHG-1234"},
+			},
+		},
+	}
+}
+
+func TestWrite_Enforcement(t *testing.T) {
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatText, testEnforcementReport()))
+
+		assert.Equal(t, `WARN  naming
+      [error] aws_s3_bucket.logs: bucket name has no prefix
+OVERRIDDEN encryption
+      overridden: CHG-1234
+      [error] aws_s3_bucket.logs: bucket is not encrypted
+
+2 rules: 0 passed, 0 failed, 0 errored
+1 advisory rules warned
+1 rules overridden
+`, buf.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatJSON, testEnforcementReport()))
+
+		var doc jsonReport
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+		require.Len(t, doc.Results, 2)
+
+		assert.Equal(t, "warn", doc.Results[0].Status)
+		assert.Equal(t, "advisory", doc.Results[0].Enforcement)
+		assert.Empty(t, doc.Results[0].Override)
+		assert.Equal(t, "overridden", doc.Results[1].Status)
+		assert.Equal(t, "soft-mandatory", doc.Results[1].Enforcement)
+		assert.Equal(t, "CHG-1234", doc.Results[1].Override)
+	})
+
+	t.Run("sarif", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatSARIF, testEnforcementReport()))
+
+		var log sarifLog
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+		require.Len(t, log.Runs, 1)
+
+		results := log.Runs[0].Results
+		require.Len(t, results, 2)
+		assert.Equal(t, "warning", results[0].Level)
+		assert.Empty(t, results[0].Suppressions)
+		assert.Equal(t, "warning", results[1].Level)
+		assert.Equal(t, []sarifSuppression{{
+			Kind:          "external",
+			Status:        "accepted",
+			Justification: "CHG-1234",
+		}}, results[1].Suppressions)
+	})
+
+	t.Run("github", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatGitHub, testEnforcementReport()))
+
+		assert.Equal(t, `::warning title=naming::aws_s3_bucket.logs: bucket name has no prefix
+::warning title=encryption::aws_s3_bucket.logs: bucket is not encrypted
+`, buf.String())
+	})
+
+	t.Run("junit", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Write(&buf, FormatJUnit, testEnforcementReport()))
+
+		assert.Contains(t, buf.String(), `<testsuites tests="2" failures="0" errors="0">`)
+		assert.Contains(t, buf.String(), "overridden: CHG-1234")
+	})
+}
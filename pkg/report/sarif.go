@@ -0,0 +1,272 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+const (
+	sarifVersion   = "2.1.0"
+	sarifSchema    = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifToolName  = "horus"
+	sarifToolURI   = "https://github.com/hexbee-net/horus"
+	sarifLevelNote = "note"
+
+	sarifSuppressionExternal = "external"
+	sarifSuppressionAccepted = "accepted"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string              `json:"id"`
+	ShortDescription     *sarifMessage       `json:"shortDescription,omitempty"`
+	DefaultConfiguration sarifReportingLevel `json:"defaultConfiguration"`
+	Properties           *sarifRuleProps     `json:"properties,omitempty"`
+}
+
+type sarifReportingLevel struct {
+	Level string `json:"level"`
+}
+
+type sarifRuleProps struct {
+	Tags []string `json:"tags"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful        bool                `json:"executionSuccessful"`
+	ToolExecutionNotifications []sarifNotification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type sarifNotification struct {
+	Level      string              `json:"level"`
+	Message    sarifMessage        `json:"message"`
+	Descriptor *sarifDescriptorRef `json:"associatedRule,omitempty"`
+}
+
+type sarifDescriptorRef struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+}
+
+type sarifResult struct {
+	RuleID       string             `json:"ruleId"`
+	RuleIndex    *int               `json:"ruleIndex,omitempty"`
+	Level        string             `json:"level"`
+	Message      sarifMessage       `json:"message"`
+	Locations    []sarifLocation    `json:"locations,omitempty"`
+	Suppressions []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+// sarifSuppression records that a result was waived, or that its rule was
+// overridden.
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Status        string `json:"status"`
+	Justification string `json:"justification"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+func writeSARIF(w io.Writer, report *warden.Report) error {
+	run := sarifRun{
+		Tool: sarifTool{
+			Driver: sarifDriver{
+				Name:           sarifToolName,
+				InformationURI: sarifToolURI,
+				Rules:          make([]sarifRule, 0, len(report.Results)),
+			},
+		},
+		Invocations: []sarifInvocation{{ExecutionSuccessful: !report.Errored()}},
+		Results:     []sarifResult{},
+	}
+
+	ruleIndexes := make(map[string]int, len(report.Results))
+
+	for i, res := range report.Results {
+		ruleIndexes[res.Rule.Name] = i
+
+		rule := sarifRule{
+			ID:                   res.Rule.Name,
+			DefaultConfiguration: sarifReportingLevel{Level: sarifLevel(res.Rule.Severity)},
+		}
+
+		if res.Rule.Description != "" {
+			rule.ShortDescription = &sarifMessage{Text: res.Rule.Description}
+		}
+
+		if len(res.Rule.Tags) > 0 {
+			rule.Properties = &sarifRuleProps{Tags: res.Rule.Tags}
+		}
+
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+
+		if res.Err != nil {
+			run.Invocations[0].ToolExecutionNotifications = append(run.Invocations[0].ToolExecutionNotifications, sarifNotification{
+				Level:      string(warden.SeverityError),
+				Message:    sarifMessage{Text: res.Err.Error()},
+				Descriptor: &sarifDescriptorRef{ID: res.Rule.Name, Index: i},
+			})
+		}
+	}
+
+	for i := range report.Results {
+		res := &report.Results[i]
+
+		for _, f := range res.Findings {
+			result := sarifFinding(f, ruleIndexes)
+			result.Level = sarifLevel(findingSeverity(res, f))
+
+			if res.Override != nil {
+				result.Suppressions = []sarifSuppression{{
+					Kind:          sarifSuppressionExternal,
+					Status:        sarifSuppressionAccepted,
+					Justification: res.Override.Reason,
+				}}
+			}
+
+			run.Results = append(run.Results, result)
+		}
+
+		for _, f := range res.Waived {
+			result := sarifFinding(f.Finding, ruleIndexes)
+			result.Suppressions = []sarifSuppression{{
+				Kind:          sarifSuppressionExternal,
+				Status:        sarifSuppressionAccepted,
+				Justification: f.Waiver.Justification,
+			}}
+
+			run.Results = append(run.Results, result)
+		}
+	}
+
+	for _, waiver := range report.ExpiredWaivers {
+		run.Invocations[0].ToolExecutionNotifications = append(run.Invocations[0].ToolExecutionNotifications, sarifNotification{
+			Level:   string(warden.SeverityError),
+			Message: sarifMessage{Text: "expired " + waiver.String()},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(sarifLog{ //nolint:wrapcheck // the error is wrapped by Write.
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs:    []sarifRun{run},
+	})
+}
+
+func sarifFinding(f warden.Finding, ruleIndexes map[string]int) sarifResult {
+	result := sarifResult{
+		RuleID:  f.RuleID,
+		Level:   sarifLevel(f.Severity),
+		Message: sarifMessage{Text: f.String()},
+	}
+
+	// Findings can override their rule ID, in which case they do not refer
+	// to a rule descriptor of the run.
+	if idx, ok := ruleIndexes[f.RuleID]; ok {
+		result.RuleIndex = &idx
+	}
+
+	var loc sarifLocation
+
+	if f.Range != nil {
+		loc.PhysicalLocation = &sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: sourceFile(f.Range)},
+			Region: sarifRegion{
+				StartLine:   f.Range.Start.Line,
+				StartColumn: f.Range.Start.Column,
+				EndLine:     f.Range.End.Line,
+				EndColumn:   f.Range.End.Column,
+			},
+		}
+	}
+
+	if f.Address != "" {
+		loc.LogicalLocations = []sarifLogicalLocation{{
+			FullyQualifiedName: f.Address,
+			Kind:               "resource",
+		}}
+	}
+
+	if loc.PhysicalLocation != nil || loc.LogicalLocations != nil {
+		result.Locations = []sarifLocation{loc}
+	}
+
+	return result
+}
+
+func sarifLevel(severity warden.Severity) string {
+	switch severity {
+	case warden.SeverityError, warden.SeverityWarning:
+		return string(severity)
+	default:
+		return sarifLevelNote
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite_SARIF(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatSARIF, testReport()))
+
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+
+	assert.Equal(t, sarifVersion, log.Version)
+	assert.Equal(t, sarifSchema, log.Schema)
+	require.Len(t, log.Runs, 1)
+
+	run := log.Runs[0]
+	assert.Equal(t, "horus", run.Tool.Driver.Name)
+
+	require.Len(t, run.Tool.Driver.Rules, 4)
+	assert.Equal(t, sarifRule{
+		ID:                   "instance-type",
+		ShortDescription:     &sarifMessage{Text: "Instances must use approved types"},
+		DefaultConfiguration: sarifReportingLevel{Level: "error"},
+		Properties:           &sarifRuleProps{Tags: []string{"cost"}},
+	}, run.Tool.Driver.Rules[1])
+	assert.Equal(t, "warning", run.Tool.Driver.Rules[2].DefaultConfiguration.Level)
+
+	require.Len(t, run.Invocations, 1)
+	assert.False(t, run.Invocations[0].ExecutionSuccessful)
+	assert.Equal(t, []sarifNotification{{
+		Level:      "error",
+		Message:    sarifMessage{Text: "boom"},
+		Descriptor: &sarifDescriptorRef{ID: "broken", Index: 3},
+	}}, run.Invocations[0].ToolExecutionNotifications)
+
+	require.Len(t, run.Results, 3)
+
+	one, two := 1, 2
+
+	assert.Equal(t, sarifResult{
+		RuleID:    "instance-type",
+		RuleIndex: &one,
+		Level:     "error",
+		Message:   sarifMessage{Text: "aws_instance.simple_resource.instance_type: unexpected instance type"},
+		Locations: []sarifLocation{{
+			PhysicalLocation: &sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: "root.tf"},
+				Region:           sarifRegion{StartLine: 18, StartColumn: 1, EndLine: 18, EndColumn: 32},
+			},
+			LogicalLocations: []sarifLogicalLocation{{
+				FullyQualifiedName: "aws_instance.simple_resource",
+				Kind:               "resource",
+			}},
+		}},
+	}, run.Results[0])
+	assert.Equal(t, sarifResult{
+		RuleID:    "instance-type",
+		RuleIndex: &one,
+		Level:     "warning",
+		Message:   sarifMessage{Text: "100% of the instances, are: checked"},
+	}, run.Results[1])
+	assert.Equal(t, "note", run.Results[2].Level)
+	assert.Equal(t, &two, run.Results[2].RuleIndex)
+	assert.Equal(t, "modules/net/main.tf", run.Results[2].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+}
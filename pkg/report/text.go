@@ -0,0 +1,108 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hexbee-net/horus/pkg/warden"
+)
+
+func writeText(w io.Writer, report *warden.Report) error {
+	counts := map[warden.RuleStatus]int{}
+
+	for _, res := range report.Results {
+		counts[res.Status]++
+
+		if _, err := fmt.Fprintf(w, "%-5s %s\n", statusLabel(res.Status), res.Rule.Name); err != nil {
+			return err //nolint:wrapcheck // the error is wrapped by Write.
+		}
+
+		if res.Err != nil {
+			if _, err := fmt.Fprintf(w, "      %v\n", res.Err); err != nil {
+				return err //nolint:wrapcheck // the error is wrapped by Write.
+			}
+		}
+
+		if res.Override != nil {
+			if _, err := fmt.Fprintf(w, "      overridden: %s\n", res.Override.Reason); err != nil {
+				return err //nolint:wrapcheck // the error is wrapped by Write.
+			}
+		}
+
+		for _, f := range res.Findings {
+			line := fmt.Sprintf("      [%s] %s", f.Severity, f.String())
+			if f.Range != nil {
+				line += fmt.Sprintf(" (%s:%d)", sourceFile(f.Range), f.Range.Start.Line)
+			}
+
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err //nolint:wrapcheck // the error is wrapped by Write.
+			}
+		}
+
+		for _, f := range res.Waived {
+			if _, err := fmt.Fprintf(w, "      [waived] %s\n", waivedDescription(f)); err != nil {
+				return err //nolint:wrapcheck // the error is wrapped by Write.
+			}
+		}
+	}
+
+	for _, waiver := range report.ExpiredWaivers {
+		if _, err := fmt.Fprintf(w, "EXPIRED %s\n", waiver.String()); err != nil {
+			return err //nolint:wrapcheck // the error is wrapped by Write.
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d rules: %d passed, %d failed, %d errored\n",
+		len(report.Results), counts[warden.RuleStatusPass], counts[warden.RuleStatusFail], counts[warden.RuleStatusError])
+	if err != nil {
+		return err //nolint:wrapcheck // the error is wrapped by Write.
+	}
+
+	if warned := counts[warden.RuleStatusWarn]; warned > 0 {
+		if _, err := fmt.Fprintf(w, "%d advisory rules warned\n", warned); err != nil {
+			return err //nolint:wrapcheck // the error is wrapped by Write.
+		}
+	}
+
+	if overridden := counts[warden.RuleStatusOverridden]; overridden > 0 {
+		if _, err := fmt.Fprintf(w, "%d rules overridden\n", overridden); err != nil {
+			return err //nolint:wrapcheck // the error is wrapped by Write.
+		}
+	}
+
+	if waived := len(report.Waived()); waived > 0 {
+		_, err = fmt.Fprintf(w, "%d findings waived\n", waived)
+	}
+
+	return err //nolint:wrapcheck // the error is wrapped by Write.
+}
+
+func statusLabel(status warden.RuleStatus) string {
+	switch status {
+	case warden.RuleStatusPass:
+		return "PASS"
+	case warden.RuleStatusFail:
+		return "FAIL"
+	case warden.RuleStatusWarn:
+		return "WARN"
+	case warden.RuleStatusOverridden:
+		return "OVERRIDDEN"
+	default:
+		return "ERROR"
+	}
+}
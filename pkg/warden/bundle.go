@@ -0,0 +1,273 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+)
+
+const (
+	// BundleManifestName is the name of the manifest at the root of a
+	// bundle.
+	BundleManifestName = "bundle.yaml"
+	// BundleLibDir is the directory of the library modules of a bundle,
+	// relative to its root.
+	BundleLibDir = "lib"
+	// TestFileSuffix is the suffix of the names of the test files of the
+	// policies. They are run by the policytest package, never loaded as
+	// rules.
+	TestFileSuffix = "_test" + bundleScriptExt
+
+	bundleScriptExt = ".lua"
+)
+
+// Bundle is a set of rules and library modules loaded from a directory tree
+// with LoadBundle.
+type Bundle struct {
+	// Name identifies the bundle.
+	Name string
+	// Version is the version of the bundle.
+	Version string
+	// Rules are the rules of the bundle, sorted by name.
+	Rules []Rule
+	// Modules are the library modules the rules can require, sorted by
+	// name.
+	Modules []wlua.UserModule
+	// Params are the values of the parameters of the rules set by the
+	// manifest. They override the defaults declared by the rules.
+	Params map[string]interface{}
+}
+
+// Options returns the options running the rules of the bundle, which can be
+// merged with other options by New.
+// The parameters of the bundle are passed as Options.Params, so that the
+// values of the parameters set by the options merged after them override
+// those of the bundle, which override the defaults declared by the rules.
+func (b *Bundle) Options() *Options {
+	return &Options{
+		Rules:       b.Rules,
+		UserModules: b.Modules,
+		Params:      b.Params,
+	}
+}
+
+// bundleManifest is the content of the manifest of a bundle, e.g.:
+//
+//	name: aws-baseline
+//	version: 1.2.0
+//	rules:
+//	  - name: s3/encryption
+//	    description: S3 buckets must be encrypted.
+//	    enforcement: soft-mandatory
+//	    params:
+//	      - name: allowed_algorithms
+//	        type: list(string)
+//	        default: [aws:kms]
+//	params:
+//	  allowed_algorithms: [aws:kms, AES256]
+type bundleManifest struct {
+	Name    string                 `yaml:"name"`
+	Version string                 `yaml:"version"`
+	Rules   []bundleManifestRule   `yaml:"rules"`
+	Params  map[string]interface{} `yaml:"params"`
+}
+
+type bundleManifestRule struct {
+	Name        string                `yaml:"name"`
+	Description string                `yaml:"description"`
+	Severity    string                `yaml:"severity"`
+	Enforcement string                `yaml:"enforcement"`
+	Tags        []string              `yaml:"tags"`
+	Params      []bundleManifestParam `yaml:"params"`
+}
+
+type bundleManifestParam struct {
+	Name        string      `yaml:"name"`
+	Description string      `yaml:"description"`
+	Type        string      `yaml:"type"`
+	Default     interface{} `yaml:"default"`
+}
+
+// LoadBundle loads the bundle whose root directory is root.
+//
+// The manifest of the bundle, BundleManifestName, declares its name, its
+// version, the metadata of its rules and the default values of their
+// parameters. Every .lua file of the BundleLibDir directory is a library
+// module named after its path relative to the root, with dots as separators,
+// so that 'lib/aws/tags.lua' is loaded with require('lib.aws.tags'). Every
+// other .lua file is a rule, named after its path relative to the root,
+// without extension, e.g. 's3/encryption'. The rules declared in the
+// manifest must have a file, and the files of the rules must be declared in
+// the manifest. The test files of the policies are ignored.
+func LoadBundle(fs afero.Fs, root string) (*Bundle, error) {
+	manifest, err := loadBundleManifest(fs, filepath.Join(root, BundleManifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{
+		Name:    manifest.Name,
+		Version: manifest.Version,
+		Params:  manifest.Params,
+	}
+
+	scripts := map[string]string{}
+
+	err = afero.Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(path) != bundleScriptExt || strings.HasSuffix(path, TestFileSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return xerrors.Errorf("failed to compute the name of '%s': %w", path, err)
+		}
+
+		script, err := afero.ReadFile(fs, path)
+		if err != nil {
+			return xerrors.Errorf("failed to read '%s': %w", path, err)
+		}
+
+		name := filepath.ToSlash(strings.TrimSuffix(rel, bundleScriptExt))
+
+		if strings.HasPrefix(name, BundleLibDir+"/") {
+			bundle.Modules = append(bundle.Modules, wlua.UserModule{
+				Name:   strings.ReplaceAll(name, "/", "."),
+				Script: string(script),
+			})
+		} else {
+			scripts[name] = string(script)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load bundle '%s': %w", root, err)
+	}
+
+	declared := make(map[string]struct{}, len(manifest.Rules))
+
+	for _, r := range manifest.Rules {
+		script, ok := scripts[r.Name]
+		if !ok {
+			return nil, xerrors.Errorf("invalid bundle '%s': rule '%s' has no file '%s%s'", root, r.Name, r.Name, bundleScriptExt)
+		}
+
+		declared[r.Name] = struct{}{}
+
+		bundle.Rules = append(bundle.Rules, r.rule(script))
+	}
+
+	undeclared := make([]string, 0, len(scripts))
+
+	for name := range scripts {
+		if _, ok := declared[name]; !ok {
+			undeclared = append(undeclared, name)
+		}
+	}
+
+	if len(undeclared) > 0 {
+		sort.Strings(undeclared)
+
+		return nil, xerrors.Errorf("invalid bundle '%s': rule '%s' is not declared in %s (library modules belong in %s/)",
+			root, undeclared[0], BundleManifestName, BundleLibDir)
+	}
+
+	if len(bundle.Rules) == 0 {
+		return nil, xerrors.Errorf("invalid bundle '%s': no rule found", root)
+	}
+
+	sort.Slice(bundle.Rules, func(i, j int) bool { return bundle.Rules[i].Name < bundle.Rules[j].Name })
+	sort.Slice(bundle.Modules, func(i, j int) bool { return bundle.Modules[i].Name < bundle.Modules[j].Name })
+
+	return bundle, nil
+}
+
+func loadBundleManifest(fs afero.Fs, path string) (*bundleManifest, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open bundle manifest: %w", err)
+	}
+
+	defer file.Close()
+
+	var manifest bundleManifest
+
+	dec := yaml.NewDecoder(file)
+	dec.KnownFields(true)
+
+	if err := dec.Decode(&manifest); err != nil {
+		return nil, xerrors.Errorf("failed to decode bundle manifest '%s': %w", path, err)
+	}
+
+	switch {
+	case manifest.Name == "":
+		return nil, xerrors.Errorf("invalid bundle manifest '%s': missing name", path)
+	case manifest.Version == "":
+		return nil, xerrors.Errorf("invalid bundle manifest '%s': missing version", path)
+	}
+
+	names := make(map[string]struct{}, len(manifest.Rules))
+
+	for i, r := range manifest.Rules {
+		if r.Name == "" {
+			return nil, xerrors.Errorf("invalid bundle manifest '%s': rule #%d has no name", path, i+1)
+		}
+
+		if _, ok := names[r.Name]; ok {
+			return nil, xerrors.Errorf("invalid bundle manifest '%s': duplicate rule '%s'", path, r.Name)
+		}
+
+		names[r.Name] = struct{}{}
+	}
+
+	return &manifest, nil
+}
+
+// rule returns the rule declared in the manifest, with its script.
+func (r *bundleManifestRule) rule(script string) Rule {
+	params := make([]Param, 0, len(r.Params))
+	for _, p := range r.Params {
+		params = append(params, Param{
+			Name:        p.Name,
+			Description: p.Description,
+			Type:        ParamType(p.Type),
+			Default:     p.Default,
+		})
+	}
+
+	return Rule{
+		Name:        r.Name,
+		Description: r.Description,
+		Severity:    Severity(r.Severity),
+		Enforcement: Enforcement(r.Enforcement),
+		Tags:        r.Tags,
+		Params:      params,
+		Script:      script,
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+func testBundleFs(t *testing.T, files map[string]string) afero.Fs {
+	t.Helper()
+
+	fs := afero.NewMemMapFs()
+	for name, content := range files {
+		require.NoError(t, afero.WriteFile(fs, filepath.Join("bundle", name), []byte(content), 0o644))
+	}
+
+	return fs
+}
+
+const testBundleManifest = `
+name: aws-baseline
+version: 1.2.0
+rules:
+  - name: tags
+    description: Resources must be tagged.
+    severity: warning
+    enforcement: soft-mandatory
+    tags: [tagging]
+    params:
+      - name: required_tags
+        type: list(string)
+        default: [Owner]
+  - name: s3/public
+params:
+  required_tags: [Owner, CostCenter]
+`
+
+func TestLoadBundle(t *testing.T) {
+	fs := testBundleFs(t, map[string]string{
+		BundleManifestName: testBundleManifest,
+		"tags.lua":         `return require('lib.tags').check(params.required_tags)`,
+		"s3/public.lua":    `return require('lib.aws.s3').check()`,
+		"tags_test.lua":    `test("ignored", function() end)`,
+		"lib/tags.lua":     `return {check = function(tags) return {message = "missing " .. table.concat(tags, ", ")} end}`,
+		"lib/aws/s3.lua":   `return {check = function() return true end}`,
+		"README.md":        `ignored`,
+	})
+
+	bundle, err := LoadBundle(fs, "bundle")
+	require.NoError(t, err)
+
+	assert.Equal(t, "aws-baseline", bundle.Name)
+	assert.Equal(t, "1.2.0", bundle.Version)
+	assert.Equal(t, map[string]interface{}{"required_tags": []interface{}{"Owner", "CostCenter"}}, bundle.Params)
+
+	require.Len(t, bundle.Rules, 2)
+	assert.Equal(t, Rule{Name: "s3/public", Params: []Param{}, Script: `return require('lib.aws.s3').check()`}, bundle.Rules[0])
+	assert.Equal(t, Rule{
+		Name:        "tags",
+		Description: "Resources must be tagged.",
+		Severity:    SeverityWarning,
+		Enforcement: EnforcementSoftMandatory,
+		Tags:        []string{"tagging"},
+		Params: []Param{{
+			Name:    "required_tags",
+			Type:    ParamTypeStringList,
+			Default: []interface{}{"Owner"},
+		}},
+		Script: `return require('lib.tags').check(params.required_tags)`,
+	}, bundle.Rules[1])
+
+	assert.Equal(t, []wlua.UserModule{
+		{Name: "lib.aws.s3", Script: `return {check = function() return true end}`},
+		{Name: "lib.tags", Script: `return {check = function(tags) return {message = "missing " .. table.concat(tags, ", ")} end}`},
+	}, bundle.Modules)
+
+	w, err := New(bundle.Options())
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	planFile, err := terraform.NewPlanBuilder().Build()
+	require.NoError(t, err)
+
+	report, err := w.ValidatePlanFile(context.Background(), planFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"missing Owner, CostCenter"}, findingMessages(report))
+
+	// The parameters of the options merged after the bundle override those
+	// of the manifest.
+	w, err = New(bundle.Options(), &Options{Params: map[string]interface{}{"required_tags": []string{"Team"}}})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	report, err = w.ValidatePlanFile(context.Background(), planFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"missing Team"}, findingMessages(report))
+}
+
+func TestLoadBundle_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "missing manifest",
+			files:   map[string]string{"tags.lua": `return true`},
+			wantErr: "failed to open bundle manifest",
+		},
+		{
+			name: "missing version",
+			files: map[string]string{
+				BundleManifestName: `name: aws-baseline`,
+				"tags.lua":         `return true`,
+			},
+			wantErr: "missing version",
+		},
+		{
+			name: "unknown field",
+			files: map[string]string{
+				BundleManifestName: "name: aws-baseline\nversion: 1.0.0\nauthor: ops",
+				"tags.lua":         `return true`,
+			},
+			wantErr: "field author not found",
+		},
+		{
+			name: "rule without file",
+			files: map[string]string{
+				BundleManifestName: "name: aws-baseline\nversion: 1.0.0\nrules:\n  - name: tags",
+				"naming.lua":       `return true`,
+			},
+			wantErr: "rule 'tags' has no file 'tags.lua'",
+		},
+		{
+			name: "undeclared rule",
+			files: map[string]string{
+				BundleManifestName: "name: aws-baseline\nversion: 1.0.0\nrules:\n  - name: tags",
+				"tags.lua":         `return true`,
+				"s3/public.lua":    `return true`,
+			},
+			wantErr: "rule 's3/public' is not declared in bundle.yaml",
+		},
+		{
+			name: "duplicate rule",
+			files: map[string]string{
+				BundleManifestName: "name: aws-baseline\nversion: 1.0.0\nrules:\n  - name: tags\n  - name: tags",
+				"tags.lua":         `return true`,
+			},
+			wantErr: "duplicate rule 'tags'",
+		},
+		{
+			name: "no rule",
+			files: map[string]string{
+				BundleManifestName: "name: aws-baseline\nversion: 1.0.0",
+				"lib/tags.lua":     `return {}`,
+			},
+			wantErr: "no rule found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBundle(testBundleFs(t, tt.files), "bundle")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Enforcement indicates how the failure of a rule affects the outcome of
+// the validation.
+type Enforcement string
+
+const (
+	// EnforcementAdvisory rules only warn: their failure does not make the
+	// validation fail.
+	EnforcementAdvisory Enforcement = "advisory"
+	// EnforcementSoftMandatory rules make the validation fail, unless they
+	// are overridden.
+	EnforcementSoftMandatory Enforcement = "soft-mandatory"
+	// EnforcementHardMandatory rules make the validation fail, and can never
+	// be overridden.
+	EnforcementHardMandatory Enforcement = "hard-mandatory"
+)
+
+// ParseEnforcement returns the enforcement level with the given name.
+func ParseEnforcement(s string) (Enforcement, error) {
+	switch e := Enforcement(strings.ToLower(s)); e {
+	case EnforcementAdvisory, EnforcementSoftMandatory, EnforcementHardMandatory:
+		return e, nil
+	default:
+		return "", xerrors.Errorf("invalid enforcement level '%s' (expected '%s', '%s' or '%s')",
+			s, EnforcementAdvisory, EnforcementSoftMandatory, EnforcementHardMandatory)
+	}
+}
+
+// Override lets a soft-mandatory rule fail without failing the validation.
+// It is recorded in the result of the rule.
+type Override struct {
+	// RuleID is the name of the overridden rule.
+	RuleID string
+	// Reason explains why the rule is overridden.
+	Reason string
+}
+
+// overrides returns the overrides configured in the options, by rule name.
+// Only the soft-mandatory rules can be overridden.
+func (o *Options) overrides(rules []Rule) (map[string]Override, error) {
+	enforcements := make(map[string]Enforcement, len(rules))
+	for _, r := range rules {
+		enforcements[r.Name] = r.Enforcement
+	}
+
+	ret := make(map[string]Override, len(o.Overrides))
+
+	for _, override := range o.Overrides {
+		enforcement, ok := enforcements[override.RuleID]
+
+		switch {
+		case !ok:
+			return nil, xerrors.Errorf("unknown rule '%s'", override.RuleID)
+		case enforcement != EnforcementSoftMandatory:
+			return nil, xerrors.Errorf("rule '%s' is %s and cannot be overridden", override.RuleID, enforcement)
+		case override.Reason == "":
+			return nil, xerrors.Errorf("missing reason for the override of rule '%s'", override.RuleID)
+		}
+
+		ret[override.RuleID] = override
+	}
+
+	return ret, nil
+}
+
+// enforce computes the status of a failed rule from its enforcement level:
+// advisory rules only warn, and soft-mandatory rules are overridden by their
+// override, if any.
+func enforce(result *RuleResult, overrides map[string]Override) {
+	if result.Status != RuleStatusFail {
+		return
+	}
+
+	switch result.Rule.Enforcement {
+	case EnforcementAdvisory:
+		result.Status = RuleStatusWarn
+	case EnforcementSoftMandatory:
+		if override, ok := overrides[result.Rule.Name]; ok {
+			result.Status = RuleStatusOverridden
+			result.Override = &override
+		}
+	case EnforcementHardMandatory:
+		// hard-mandatory rules can never be overridden.
+	}
+}
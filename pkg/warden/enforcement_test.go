@@ -0,0 +1,199 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+func TestParseEnforcement(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Enforcement
+		wantErr bool
+	}{
+		{s: "advisory", want: EnforcementAdvisory},
+		{s: "Soft-Mandatory", want: EnforcementSoftMandatory},
+		{s: "hard-mandatory", want: EnforcementHardMandatory},
+		{s: "mandatory", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseEnforcement(tt.s)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNew_Overrides(t *testing.T) {
+	rules := []Rule{
+		{Name: "advisory", Enforcement: EnforcementAdvisory, Script: `return true`},
+		{Name: "soft", Enforcement: EnforcementSoftMandatory, Script: `return true`},
+		{Name: "hard", Script: `return true`},
+	}
+
+	tests := []struct {
+		name      string
+		overrides []Override
+		wantErr   string
+	}{
+		{
+			name:      "soft-mandatory",
+			overrides: []Override{{RuleID: "soft", Reason: "approved by security"}},
+		},
+		{
+			name:      "hard-mandatory",
+			overrides: []Override{{RuleID: "hard", Reason: "approved by security"}},
+			wantErr:   "rule 'hard' is hard-mandatory and cannot be overridden",
+		},
+		{
+			name:      "advisory",
+			overrides: []Override{{RuleID: "advisory", Reason: "approved by security"}},
+			wantErr:   "rule 'advisory' is advisory and cannot be overridden",
+		},
+		{
+			name:      "unknown rule",
+			overrides: []Override{{RuleID: "missing", Reason: "approved by security"}},
+			wantErr:   "unknown rule 'missing'",
+		},
+		{
+			name:      "missing reason",
+			overrides: []Override{{RuleID: "soft"}},
+			wantErr:   "missing reason for the override of rule 'soft'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New(&Options{Rules: rules, Overrides: tt.overrides})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, "invalid overrides: "+tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			w.Close()
+		})
+	}
+
+	_, err := New(&Options{Rules: []Rule{{Name: "r1", Enforcement: "mandatory", Script: `return true`}}})
+	assert.Error(t, err)
+}
+
+func TestWarden_Enforcement(t *testing.T) {
+	planFile, err := terraform.NewPlanBuilder().Build()
+	require.NoError(t, err)
+
+	const (
+		failing = `return {message = "not compliant"}`
+		warning = `return {severity = "warning", message = "almost compliant"}`
+	)
+
+	override := Override{RuleID: "soft", Reason: "approved by security"}
+
+	tests := []struct {
+		name       string
+		rules      []Rule
+		overrides  []Override
+		wantErr    error
+		wantStatus []RuleStatus
+	}{
+		{
+			name: "advisory",
+			rules: []Rule{
+				{Name: "advisory", Enforcement: EnforcementAdvisory, Script: failing},
+				{Name: "hard", Script: warning},
+			},
+			wantStatus: []RuleStatus{RuleStatusWarn, RuleStatusPass},
+		},
+		{
+			name: "soft-mandatory",
+			rules: []Rule{
+				{Name: "soft", Enforcement: EnforcementSoftMandatory, Script: failing},
+			},
+			wantErr:    ErrValidationFailed,
+			wantStatus: []RuleStatus{RuleStatusFail},
+		},
+		{
+			name: "soft-mandatory overridden",
+			rules: []Rule{
+				{Name: "advisory", Enforcement: EnforcementAdvisory, Script: failing},
+				{Name: "soft", Enforcement: EnforcementSoftMandatory, Script: failing},
+			},
+			overrides:  []Override{override},
+			wantStatus: []RuleStatus{RuleStatusWarn, RuleStatusOverridden},
+		},
+		{
+			name: "hard-mandatory",
+			rules: []Rule{
+				{Name: "soft", Enforcement: EnforcementSoftMandatory, Script: failing},
+				{Name: "hard", Enforcement: EnforcementHardMandatory, Script: failing},
+			},
+			overrides:  []Override{override},
+			wantErr:    ErrValidationFailed,
+			wantStatus: []RuleStatus{RuleStatusOverridden, RuleStatusFail},
+		},
+		{
+			name: "overridden rule passing",
+			rules: []Rule{
+				{Name: "soft", Enforcement: EnforcementSoftMandatory, Script: warning},
+			},
+			overrides:  []Override{override},
+			wantStatus: []RuleStatus{RuleStatusPass},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New(&Options{Rules: tt.rules, Overrides: tt.overrides})
+			require.NoError(t, err)
+
+			defer w.Close()
+
+			report, err := w.ValidatePlanFile(context.Background(), planFile)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			statuses := make([]RuleStatus, 0, len(report.Results))
+
+			for _, res := range report.Results {
+				statuses = append(statuses, res.Status)
+
+				if res.Status == RuleStatusOverridden {
+					assert.Equal(t, &override, res.Override)
+				} else {
+					assert.Nil(t, res.Override)
+				}
+			}
+
+			assert.Equal(t, tt.wantStatus, statuses)
+		})
+	}
+}
@@ -18,4 +18,7 @@ import "golang.org/x/xerrors"
 
 var (
 	ErrValidationFailed = xerrors.New("validation failed")
+	ErrRuleErrored      = xerrors.New("rule execution failed")
+	ErrLimitExceeded    = xerrors.New("execution limit exceeded")
+	ErrWaiverExpired    = xerrors.New("waiver expired")
 )
@@ -0,0 +1,228 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+// Severity indicates how serious a finding is.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// ParseSeverity returns the severity with the given name.
+func ParseSeverity(s string) (Severity, error) {
+	switch sev := Severity(strings.ToLower(s)); sev {
+	case SeverityError, SeverityWarning, SeverityInfo:
+		return sev, nil
+	default:
+		return "", xerrors.Errorf("invalid severity '%s' (expected '%s', '%s' or '%s')", s, SeverityError, SeverityWarning, SeverityInfo)
+	}
+}
+
+// Finding is an issue reported by a validation script.
+type Finding struct {
+	// RuleID identifies the rule that reported the finding. It defaults to
+	// the name of the rule.
+	RuleID string
+	// Severity indicates how serious the finding is. It defaults to the
+	// severity of the rule.
+	Severity Severity
+	// Address is the address of the resource the finding is about, if any.
+	Address string
+	// Message describes the finding.
+	Message string
+	// AttributePath optionally points to the offending attribute of the
+	// resource, e.g. 'tags.Name'.
+	AttributePath string
+	// Range is the source range of the declaration of the resource in the
+	// configuration, when it is known.
+	Range *hcl.Range
+}
+
+func (f Finding) String() string {
+	var sb strings.Builder
+
+	if f.Address != "" {
+		sb.WriteString(f.Address)
+
+		if f.AttributePath != "" {
+			sb.WriteString(".")
+			sb.WriteString(f.AttributePath)
+		}
+
+		sb.WriteString(": ")
+	}
+
+	sb.WriteString(f.Message)
+
+	return sb.String()
+}
+
+// hasErrors reports whether any of the findings has the error severity.
+func hasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// -----------------------------------------------------------------------------
+// Lua Results
+
+const (
+	findingFieldRule     = "rule"
+	findingFieldSeverity = "severity"
+	findingFieldAddress  = "address"
+	findingFieldResource = "resource"
+	findingFieldMessage  = "message"
+	findingFieldPath     = "path"
+)
+
+// checkResult converts the value returned by a validation script into
+// findings.
+//
+// A script can return nil or true when the validation succeeded, false, a
+// string or a finding table when it found one issue, or an array of strings
+// and finding tables when it found several issues. A finding table has the
+// 'message', 'rule', 'severity', 'address' (or 'resource'), and 'path' fields,
+// only 'message' being required.
+func checkResult(ret lua.LValue, locator resourceLocator) ([]Finding, error) {
+	if ret == lua.LNil || ret == lua.LTrue {
+		return nil, nil
+	}
+
+	if ret == lua.LFalse {
+		return []Finding{newFinding("validation failed")}, nil
+	}
+
+	// Check for single error
+	if str, ok := ret.(lua.LString); ok {
+		return []Finding{newFinding(str.String())}, nil
+	}
+
+	if tbl, ok := ret.(*lua.LTable); ok {
+		// Check for a single finding
+		if isFindingTable(tbl) {
+			f, err := findingFromTable(tbl, locator)
+			if err != nil {
+				return nil, err
+			}
+
+			return []Finding{f}, nil
+		}
+
+		// Check for multiple errors
+		findings := make([]Finding, 0, tbl.Len())
+
+		var err error
+
+		tbl.ForEach(func(_ lua.LValue, v lua.LValue) {
+			if err != nil {
+				return
+			}
+
+			if t, ok := v.(*lua.LTable); ok && isFindingTable(t) {
+				var f Finding
+				if f, err = findingFromTable(t, locator); err == nil {
+					findings = append(findings, f)
+				}
+
+				return
+			}
+
+			findings = append(findings, newFinding(v.String()))
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return findings, nil
+	}
+
+	// The returned value was neither Nil, a boolean, a string or
+	// an array of string.
+	// Still, something was returned so assume the validation failed and
+	// return whatever we got back.
+	return []Finding{newFinding(fmt.Sprintf("validation failed (%s)", ret.String()))}, nil
+}
+
+func newFinding(message string) Finding {
+	return Finding{Message: message}
+}
+
+func isFindingTable(tbl *lua.LTable) bool {
+	return tbl.RawGetString(findingFieldMessage) != lua.LNil
+}
+
+func findingFromTable(tbl *lua.LTable, locator resourceLocator) (Finding, error) {
+	f := newFinding(tbl.RawGetString(findingFieldMessage).String())
+
+	if v := tbl.RawGetString(findingFieldRule); v != lua.LNil {
+		f.RuleID = v.String()
+	}
+
+	if v := tbl.RawGetString(findingFieldSeverity); v != lua.LNil {
+		sev, err := ParseSeverity(v.String())
+		if err != nil {
+			return Finding{}, xerrors.Errorf("invalid finding '%s': %w", f.Message, err)
+		}
+
+		f.Severity = sev
+	}
+
+	if v := tbl.RawGetString(findingFieldAddress); v != lua.LNil {
+		f.Address = v.String()
+	}
+
+	if ud, ok := tbl.RawGetString(findingFieldResource).(*lua.LUserData); ok {
+		switch r := ud.Value.(type) {
+		case *terraform.ResourceChange:
+			f.Address = r.Address()
+		case *terraform.ResourceInstance:
+			f.Address = r.Address()
+		case *terraform.ConfigResource:
+			f.Address = r.Address()
+		case *terraform.ResourceDrift:
+			f.Address = r.Address()
+		}
+	}
+
+	if v := tbl.RawGetString(findingFieldPath); v != lua.LNil {
+		f.AttributePath = v.String()
+	}
+
+	if f.Address != "" && locator != nil {
+		f.Range = locator.ResourceRange(f.Address)
+	}
+
+	return f, nil
+}
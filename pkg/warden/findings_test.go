@@ -0,0 +1,135 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarden_ValidatePlan_Findings(t *testing.T) {
+	testFs := afero.NewReadOnlyFs(afero.NewOsFs())
+
+	tests := []struct {
+		name     string
+		script   string
+		findings []Finding
+		wantErr  error
+		invalid  bool
+	}{
+		{
+			name:   "single finding table",
+			script: `return { rule = "r1", severity = "warning", address = "null_resource.foo", message = "m1", path = "triggers.foo" }`,
+			findings: []Finding{{
+				RuleID:        "r1",
+				Severity:      SeverityWarning,
+				Address:       "null_resource.foo",
+				Message:       "m1",
+				AttributePath: "triggers.foo",
+			}},
+		},
+		{
+			name: "mixed strings and finding tables",
+			script: `
+local tf = require 'tf'
+local r = tf.plan:findResource("aws_instance", "simple_resource")[1]
+return {
+	"plain message",
+	{ resource = r, message = "from resource", rule = "r2" },
+	{ address = "aws_instance.multiple_resource[1]", message = "from address", severity = "INFO" },
+}
+`,
+			findings: []Finding{
+				{RuleID: DefaultRuleName, Severity: SeverityError, Message: "plain message"},
+				{RuleID: "r2", Severity: SeverityError, Address: "aws_instance.simple_resource", Message: "from resource"},
+				{RuleID: DefaultRuleName, Severity: SeverityInfo, Address: "aws_instance.multiple_resource[1]", Message: "from address"},
+			},
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name:    "invalid severity",
+			script:  `return { message = "m", severity = "fatal" }`,
+			invalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New(&Options{Script: tt.script})
+			require.NoError(t, err)
+
+			planFile, err := testFs.Open(getTestDataPath(t, "tf-planfile"))
+			require.NoError(t, err)
+
+			report, err := w.ValidatePlan(context.Background(), planFile)
+			_ = planFile.Close()
+
+			switch {
+			case tt.invalid:
+				assert.Error(t, err)
+				assert.NotErrorIs(t, err, ErrValidationFailed)
+
+				return
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			default:
+				assert.NoError(t, err)
+			}
+
+			findings := report.Findings()
+
+			// Source ranges are checked separately.
+			for i := range findings {
+				findings[i].Range = nil
+			}
+
+			assert.Equal(t, tt.findings, findings)
+		})
+	}
+}
+
+func TestWarden_ValidatePlan_FindingRange(t *testing.T) {
+	w, err := New(&Options{Script: `return {
+	{ address = "aws_instance.multiple_resource[2]", message = "with range" },
+	{ address = "aws_instance.missing", message = "without range" },
+}`})
+	require.NoError(t, err)
+
+	planFile, err := afero.NewReadOnlyFs(afero.NewOsFs()).Open(getTestDataPath(t, "tf-planfile"))
+	require.NoError(t, err)
+
+	report, err := w.ValidatePlan(context.Background(), planFile)
+	_ = planFile.Close()
+
+	assert.ErrorIs(t, err, ErrValidationFailed)
+
+	findings := report.Findings()
+	require.Len(t, findings, 2)
+
+	require.NotNil(t, findings[0].Range)
+	assert.Equal(t, "root.tf", findings[0].Range.Filename)
+	assert.Equal(t, 27, findings[0].Range.Start.Line)
+
+	assert.Nil(t, findings[1].Range)
+}
+
+func TestFinding_String(t *testing.T) {
+	assert.Equal(t, "message", Finding{Message: "message"}.String())
+	assert.Equal(t, "aws_instance.web: message", Finding{Address: "aws_instance.web", Message: "message"}.String())
+	assert.Equal(t, "aws_instance.web.tags.Name: message", Finding{Address: "aws_instance.web", AttributePath: "tags.Name", Message: "message"}.String())
+}
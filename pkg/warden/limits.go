@@ -0,0 +1,91 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// limitProbe records which limit of the Lua VM, if any, was reached when a
+// rule raised an error.
+//
+// The VM raises the overflows of the call stack and of the registry as plain
+// string errors, which a script can forge with error(). The probe instead
+// inspects the state at the time the error is raised, before it is unwound.
+type limitProbe struct {
+	callStack bool
+	registry  bool
+}
+
+// registryProbeFailed is the panic value of a failed registry probe.
+type registryProbeFailed struct{}
+
+// protect returns a function calling fn with the probe installed as the
+// panic handler of the state.
+//
+// The returned function must be called in protected mode, so that the state
+// is restored once the error is raised.
+func (p *limitProbe) protect(ls *lua.LState, fn *lua.LFunction) *lua.LFunction {
+	return ls.NewFunction(func(ls *lua.LState) int {
+		ls.Panic = p.panic
+		ls.Push(fn)
+		ls.Call(0, 1)
+
+		return 1
+	})
+}
+
+// panic records the state of the limits and raises the error on top of the
+// stack, like the default panic handler of a protected call.
+func (p *limitProbe) panic(ls *lua.LState) {
+	obj := ls.Get(-1)
+
+	p.registry = registryFull(ls)
+	if !p.registry {
+		p.callStack = callStackFull(ls)
+	}
+
+	panic(&lua.ApiError{Type: lua.ApiErrorRun, Object: obj})
+}
+
+// registryFull reports whether no value can be pushed on the stack.
+func registryFull(ls *lua.LState) (full bool) {
+	panicFn := ls.Panic
+	ls.Panic = func(*lua.LState) { panic(registryProbeFailed{}) }
+
+	defer func() {
+		ls.Panic = panicFn
+
+		if rcv := recover(); rcv != nil {
+			if _, ok := rcv.(registryProbeFailed); !ok {
+				panic(rcv)
+			}
+
+			full = true
+		}
+	}()
+
+	ls.Push(lua.LNil)
+	ls.Pop(1)
+
+	return false
+}
+
+// callStackFull reports whether no function can be called.
+func callStackFull(ls *lua.LState) bool {
+	noop := ls.NewFunction(func(*lua.LState) int { return 0 })
+
+	return ls.CallByParam(lua.P{Fn: noop, Protect: true}) != nil
+}
@@ -0,0 +1,152 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarden_ValidatePlan_Limits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		script  string
+		wantErr error
+	}{
+		{
+			name:    "rule timeout",
+			limits:  Limits{RuleTimeout: 50 * time.Millisecond},
+			script:  `while true do end`,
+			wantErr: ErrLimitExceeded,
+		},
+		{
+			name:    "call stack size",
+			limits:  Limits{CallStackSize: 32},
+			script:  `local function f(n) return 1 + f(n + 1) end return f(1)`,
+			wantErr: ErrLimitExceeded,
+		},
+		{
+			name:    "registry size",
+			limits:  Limits{RegistrySize: 256},
+			script:  `return select('#', unpack({}, 1, 10000))`,
+			wantErr: ErrLimitExceeded,
+		},
+		{
+			name:    "within limits",
+			limits:  Limits{CallStackSize: 64, RegistrySize: 1024, RuleTimeout: time.Second},
+			script:  `local function f(n) if n == 0 then return true end return f(n - 1) end return f(16)`,
+			wantErr: nil,
+		},
+		{
+			name:    "script error",
+			limits:  Limits{RuleTimeout: time.Second},
+			script:  `error("boom")`,
+			wantErr: ErrRuleErrored,
+		},
+		{
+			name:    "script error mimicking a stack overflow",
+			limits:  Limits{CallStackSize: 32},
+			script:  `error("stack overflow")`,
+			wantErr: ErrRuleErrored,
+		},
+		{
+			name:    "script error mimicking a registry overflow",
+			limits:  Limits{RegistrySize: 256},
+			script:  `error("registry overflow")`,
+			wantErr: ErrRuleErrored,
+		},
+		{
+			name:    "call stack overflow caught by the script",
+			limits:  Limits{CallStackSize: 32},
+			script:  `local function f(n) return 1 + f(n + 1) end local ok, err = pcall(f, 1) error(err)`,
+			wantErr: ErrRuleErrored,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New(&Options{
+				Limits: tt.limits,
+				Rules: []Rule{
+					{Name: "limited", Script: tt.script},
+					{Name: "other", Script: `return "still reported"`},
+				},
+			})
+			require.NoError(t, err)
+
+			defer w.Close()
+
+			planFile, err := afero.NewReadOnlyFs(afero.NewOsFs()).Open(getTestDataPath(t, "tf-planfile"))
+			require.NoError(t, err)
+
+			report, err := w.ValidatePlan(context.Background(), planFile)
+			_ = planFile.Close()
+
+			require.NotNil(t, report)
+			require.Len(t, report.Results, 2)
+			assert.Equal(t, []string{"still reported"}, findingMessages(report))
+
+			if tt.wantErr == nil {
+				assert.ErrorIs(t, err, ErrValidationFailed)
+				assert.Equal(t, RuleStatusPass, report.Results[0].Status)
+
+				return
+			}
+
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, RuleStatusError, report.Results[0].Status)
+
+			if tt.wantErr == ErrLimitExceeded {
+				assert.ErrorIs(t, report.Results[0].Err, ErrLimitExceeded)
+			} else {
+				assert.NotErrorIs(t, report.Results[0].Err, ErrLimitExceeded)
+			}
+		})
+	}
+}
+
+func TestWarden_ValidatePlan_ContextDeadline(t *testing.T) {
+	w, err := New(&Options{
+		Rules: []Rule{
+			{Name: "endless", Script: `while true do end`},
+			{Name: "never run", Script: `return true`},
+		},
+	})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	planFile, err := afero.NewReadOnlyFs(afero.NewOsFs()).Open(getTestDataPath(t, "tf-planfile"))
+	require.NoError(t, err)
+
+	defer planFile.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	report, err := w.ValidatePlan(ctx, planFile)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrLimitExceeded)
+	require.NotNil(t, report)
+	require.Len(t, report.Results, 1)
+	assert.Equal(t, RuleStatusError, report.Results[0].Status)
+	assert.ErrorIs(t, report.Results[0].Err, context.DeadlineExceeded)
+}
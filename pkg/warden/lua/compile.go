@@ -0,0 +1,82 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lua
+
+import (
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"golang.org/x/xerrors"
+)
+
+// CompiledModule is a UserModule whose script has been compiled.
+type CompiledModule struct {
+	Name  string
+	Proto *lua.FunctionProto
+}
+
+// Compile parses and compiles a Lua script.
+// The resulting prototype can be instantiated in any LState.
+func Compile(name string, script string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(script), name)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse '%s': %w", name, err)
+	}
+
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to compile '%s': %w", name, err)
+	}
+
+	return proto, nil
+}
+
+// CompileUserModules compiles the scripts of the specified user modules.
+func CompileUserModules(modules []UserModule) ([]CompiledModule, error) {
+	compiled := make([]CompiledModule, 0, len(modules))
+
+	for _, m := range modules {
+		proto, err := Compile(m.Name, m.Script)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to load user module '%s': %w", m.Name, err)
+		}
+
+		compiled = append(compiled, CompiledModule{Name: m.Name, Proto: proto})
+	}
+
+	return compiled, nil
+}
+
+// PreloadCompiledModules preloads the specified compiled user modules in the
+// current LState.
+func (ls *LState) PreloadCompiledModules(modules []CompiledModule) error {
+	if len(modules) == 0 {
+		return nil
+	}
+
+	luaEnv := ls.Get(lua.EnvironIndex)
+	preload := ls.GetField(ls.GetField(luaEnv, "package"), "preload")
+
+	if _, ok := preload.(*lua.LTable); !ok {
+		return xerrors.New("invalid value for package.preload.")
+	}
+
+	for _, module := range modules {
+		ls.SetField(preload, module.Name, ls.NewFunctionFromProto(module.Proto))
+	}
+
+	return nil
+}
@@ -0,0 +1,304 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lua
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+	"golang.org/x/xerrors"
+)
+
+// coverageFuncName is the name of the global function called by the
+// instrumented scripts to record the execution of their statements.
+const coverageFuncName = "__horus_coverage"
+
+// Coverage records which lines of a set of scripts are executed.
+//
+// Since the Lua VM has no debug hook, the scripts are instrumented instead:
+// Compile inserts before each statement a call recording its line, so only
+// the lines where a statement starts are considered.
+// A Coverage can be shared by concurrent states.
+type Coverage struct {
+	mu    sync.Mutex
+	files map[string]map[int]int
+}
+
+// FileCoverage is the line coverage of a script.
+type FileCoverage struct {
+	Name string
+	// Lines are the lines where a statement starts, in ascending order.
+	Lines []int
+	// Hits are the number of executions of each line.
+	Hits map[int]int
+}
+
+// NewCoverage creates an empty coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{files: map[string]map[int]int{}}
+}
+
+// Compile parses, instruments and compiles a Lua script, like the Compile
+// function. The lines of the script are recorded as not executed yet.
+func (c *Coverage) Compile(name string, script string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(script), name)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse '%s': %w", name, err)
+	}
+
+	lines := map[int]int{}
+	chunk = instrumentStmts(chunk, name, lines)
+
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to compile '%s': %w", name, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.files[name]; !ok {
+		c.files[name] = lines
+	}
+
+	return proto, nil
+}
+
+// CompileUserModules compiles and instruments the scripts of the specified
+// user modules.
+func (c *Coverage) CompileUserModules(modules []UserModule) ([]CompiledModule, error) {
+	compiled := make([]CompiledModule, 0, len(modules))
+
+	for _, m := range modules {
+		proto, err := c.Compile(m.Name, m.Script)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to load user module '%s': %w", m.Name, err)
+		}
+
+		compiled = append(compiled, CompiledModule{Name: m.Name, Proto: proto})
+	}
+
+	return compiled, nil
+}
+
+// Register defines the function recording the executions in the Lua state.
+// It must be called on every state running the compiled scripts.
+func (c *Coverage) Register(ls *lua.LState) {
+	ls.SetGlobal(coverageFuncName, ls.NewFunction(c.hit))
+}
+
+func (c *Coverage) hit(ls *lua.LState) int {
+	name := ls.CheckString(1)
+	line := ls.CheckInt(2) //nolint:gomnd // second argument
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if lines, ok := c.files[name]; ok {
+		lines[line]++
+	}
+
+	return 0
+}
+
+// Files returns the coverage of the compiled scripts, ordered by name.
+func (c *Coverage) Files() []FileCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ret := make([]FileCoverage, 0, len(c.files))
+
+	for name, hits := range c.files {
+		fc := FileCoverage{
+			Name:  name,
+			Lines: make([]int, 0, len(hits)),
+			Hits:  make(map[int]int, len(hits)),
+		}
+
+		for line, n := range hits {
+			fc.Lines = append(fc.Lines, line)
+			fc.Hits[line] = n
+		}
+
+		sort.Ints(fc.Lines)
+
+		ret = append(ret, fc)
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+
+	return ret
+}
+
+// Covered returns the number of lines executed at least once.
+func (f *FileCoverage) Covered() int {
+	n := 0
+
+	for _, line := range f.Lines {
+		if f.Hits[line] > 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Missed returns the lines never executed, in ascending order.
+func (f *FileCoverage) Missed() []int {
+	var ret []int
+
+	for _, line := range f.Lines {
+		if f.Hits[line] == 0 {
+			ret = append(ret, line)
+		}
+	}
+
+	return ret
+}
+
+// Percent returns the percentage of lines executed, or 100 if the script
+// has no statement.
+func (f *FileCoverage) Percent() float64 {
+	if len(f.Lines) == 0 {
+		return 100 //nolint:gomnd // percentage
+	}
+
+	return 100 * float64(f.Covered()) / float64(len(f.Lines)) //nolint:gomnd // percentage
+}
+
+// -----------------------------------------------------------------------------
+// Instrumentation
+
+// instrumentStmts returns the statements preceded by calls recording their
+// lines, which are added to lines. The nested blocks and functions are
+// instrumented as well.
+func instrumentStmts(stmts []ast.Stmt, name string, lines map[int]int) []ast.Stmt {
+	ret := make([]ast.Stmt, 0, 2*len(stmts))
+
+	for _, stmt := range stmts {
+		// A line is recorded by its first statement only, which is the
+		// outermost one when statements are nested on the same line.
+		if _, seen := lines[stmt.Line()]; !seen {
+			lines[stmt.Line()] = 0
+			ret = append(ret, coverageCall(name, stmt.Line()))
+		}
+
+		instrumentStmt(stmt, name, lines)
+
+		ret = append(ret, stmt)
+	}
+
+	return ret
+}
+
+func instrumentStmt(stmt ast.Stmt, name string, lines map[int]int) {
+	exprs := func(exprs ...ast.Expr) {
+		for _, e := range exprs {
+			instrumentExpr(e, name, lines)
+		}
+	}
+
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		exprs(s.Lhs...)
+		exprs(s.Rhs...)
+	case *ast.LocalAssignStmt:
+		exprs(s.Exprs...)
+	case *ast.FuncCallStmt:
+		exprs(s.Expr)
+	case *ast.DoBlockStmt:
+		s.Stmts = instrumentStmts(s.Stmts, name, lines)
+	case *ast.WhileStmt:
+		exprs(s.Condition)
+		s.Stmts = instrumentStmts(s.Stmts, name, lines)
+	case *ast.RepeatStmt:
+		exprs(s.Condition)
+		s.Stmts = instrumentStmts(s.Stmts, name, lines)
+	case *ast.IfStmt:
+		exprs(s.Condition)
+		s.Then = instrumentStmts(s.Then, name, lines)
+		s.Else = instrumentStmts(s.Else, name, lines)
+	case *ast.NumberForStmt:
+		exprs(s.Init, s.Limit, s.Step)
+		s.Stmts = instrumentStmts(s.Stmts, name, lines)
+	case *ast.GenericForStmt:
+		exprs(s.Exprs...)
+		s.Stmts = instrumentStmts(s.Stmts, name, lines)
+	case *ast.FuncDefStmt:
+		exprs(s.Func)
+	case *ast.ReturnStmt:
+		exprs(s.Exprs...)
+	}
+}
+
+// instrumentExpr instruments the bodies of the functions defined in the
+// expression.
+func instrumentExpr(expr ast.Expr, name string, lines map[int]int) {
+	exprs := func(exprs ...ast.Expr) {
+		for _, e := range exprs {
+			instrumentExpr(e, name, lines)
+		}
+	}
+
+	switch e := expr.(type) {
+	case *ast.FunctionExpr:
+		e.Stmts = instrumentStmts(e.Stmts, name, lines)
+	case *ast.AttrGetExpr:
+		exprs(e.Object, e.Key)
+	case *ast.TableExpr:
+		for _, f := range e.Fields {
+			exprs(f.Key, f.Value)
+		}
+	case *ast.FuncCallExpr:
+		exprs(e.Func, e.Receiver)
+		exprs(e.Args...)
+	case *ast.LogicalOpExpr:
+		exprs(e.Lhs, e.Rhs)
+	case *ast.RelationalOpExpr:
+		exprs(e.Lhs, e.Rhs)
+	case *ast.StringConcatOpExpr:
+		exprs(e.Lhs, e.Rhs)
+	case *ast.ArithmeticOpExpr:
+		exprs(e.Lhs, e.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		exprs(e.Expr)
+	case *ast.UnaryNotOpExpr:
+		exprs(e.Expr)
+	case *ast.UnaryLenOpExpr:
+		exprs(e.Expr)
+	}
+}
+
+// coverageCall returns the statement recording the execution of a line.
+func coverageCall(name string, line int) ast.Stmt {
+	fn := &ast.IdentExpr{Value: coverageFuncName}
+	file := &ast.StringExpr{Value: name}
+	num := &ast.NumberExpr{Value: strconv.Itoa(line)}
+
+	call := &ast.FuncCallExpr{Func: fn, Args: []ast.Expr{file, num}}
+	stmt := &ast.FuncCallStmt{Expr: call}
+
+	for _, n := range []ast.PositionHolder{fn, file, num, call, stmt} {
+		n.SetLine(line)
+		n.SetLastLine(line)
+	}
+
+	return stmt
+}
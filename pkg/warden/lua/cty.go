@@ -0,0 +1,487 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lua
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+)
+
+const (
+	luaUnknownTypeName   = "unknown"
+	luaBigNumberTypeName = "bignumber"
+
+	// unknownRegistryKey is the key of the unknown sentinel of a state in
+	// its registry.
+	unknownRegistryKey = "horus.unknown"
+
+	// bigNumberPrecision is the precision of the results of the operations
+	// on big numbers, the same as the numbers parsed by cty.
+	bigNumberPrecision = 512
+)
+
+// Bridge converts values between cty and Lua.
+//
+// Strings, numbers and bools are converted to their Lua equivalent, except
+// the numbers that cannot be represented exactly by a float64, which are
+// converted to big numbers. Lists, sets and tuples are converted to
+// sequences, in the order of their elements, and maps and objects to tables
+// indexed by their keys, so that empty collections are empty tables. Null
+// values are converted to nil, and the values that will only be known after
+// apply to the unknown sentinel.
+type Bridge struct {
+	// Marked converts the values with marks, e.g. to wrap sensitive values
+	// in an opaque type. When it is not set, the marks are dropped.
+	Marked func(ls *lua.LState, v cty.Value) lua.LValue
+	// UserData converts the userdata other than the unknown sentinel and
+	// the big numbers, e.g. the opaque values returned by Marked, and
+	// reports whether it supports them.
+	UserData func(ud *lua.LUserData) (cty.Value, bool)
+}
+
+// ToLua converts a cty value to its Lua equivalent.
+func (b *Bridge) ToLua(ls *lua.LState, v cty.Value) lua.LValue {
+	if v == cty.NilVal {
+		return lua.LNil
+	}
+
+	if v.IsMarked() {
+		if b.Marked != nil {
+			return b.Marked(ls, v)
+		}
+
+		v, _ = v.Unmark()
+	}
+
+	switch {
+	case !v.IsKnown():
+		return LUnknown(ls)
+	case v.IsNull():
+		return lua.LNil
+	}
+
+	ty := v.Type()
+
+	switch {
+	case ty == cty.String:
+		return lua.LString(v.AsString())
+
+	case ty == cty.Number:
+		return LNumber(ls, v.AsBigFloat())
+
+	case ty == cty.Bool:
+		return lua.LBool(v.True())
+
+	case ty.IsListType() || ty.IsSetType() || ty.IsTupleType():
+		tbl := ls.CreateTable(v.LengthInt(), 0)
+		i := 1
+
+		for it := v.ElementIterator(); it.Next(); i++ {
+			_, ev := it.Element()
+			tbl.RawSetInt(i, b.ToLua(ls, ev))
+		}
+
+		return tbl
+
+	case ty.IsMapType() || ty.IsObjectType():
+		tbl := ls.CreateTable(0, v.LengthInt())
+		for it := v.ElementIterator(); it.Next(); {
+			k, ev := it.Element()
+			tbl.RawSetString(k.AsString(), b.ToLua(ls, ev))
+		}
+
+		return tbl
+
+	default:
+		return lua.LNil
+	}
+}
+
+// ToCty converts a Lua value to its cty equivalent: nil is converted to a
+// null value, sequences to tuples, the other tables to objects, the unknown
+// sentinel to an unknown value, and big numbers to numbers. An empty table
+// is converted to an empty object. The holes of a sequence, e.g. {1, nil, 3},
+// are converted to null elements, as long as at least half of its indexes
+// are set.
+func (b *Bridge) ToCty(lv lua.LValue) (cty.Value, error) {
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return cty.NullVal(cty.DynamicPseudoType), nil
+
+	case lua.LBool:
+		return cty.BoolVal(bool(v)), nil
+
+	case lua.LNumber:
+		// cty numbers can be infinite, but not NaN.
+		if math.IsNaN(float64(v)) {
+			return cty.NilVal, xerrors.New("unsupported number NaN")
+		}
+
+		return cty.NumberFloatVal(float64(v)), nil
+
+	case lua.LString:
+		return cty.StringVal(string(v)), nil
+
+	case *lua.LTable:
+		return b.tableToCty(v)
+
+	case *lua.LUserData:
+		switch uv := v.Value.(type) {
+		case unknownValue:
+			return cty.DynamicVal, nil
+		case *big.Float:
+			return cty.NumberVal(uv), nil
+		}
+
+		if b.UserData != nil {
+			if cv, ok := b.UserData(v); ok {
+				return cv, nil
+			}
+		}
+	}
+
+	return cty.NilVal, xerrors.Errorf("unsupported value of type %s", lv.Type())
+}
+
+func (b *Bridge) tableToCty(tbl *lua.LTable) (cty.Value, error) {
+	var (
+		elems = map[int]cty.Value{}
+		attrs = map[string]cty.Value{}
+		n     int
+		err   error
+	)
+
+	tbl.ForEach(func(k lua.LValue, v lua.LValue) {
+		if err != nil {
+			return
+		}
+
+		val, verr := b.ToCty(v)
+		if verr != nil {
+			err = xerrors.Errorf("invalid value for key %s: %w", k.String(), verr)
+
+			return
+		}
+
+		switch key := k.(type) {
+		case lua.LNumber:
+			if i := int(key); float64(i) == float64(key) && i >= 1 {
+				elems[i] = val
+				if i > n {
+					n = i
+				}
+
+				return
+			}
+		case lua.LString:
+			attrs[string(key)] = val
+
+			return
+		}
+
+		err = xerrors.Errorf("unsupported table key %s (expected a string or a positive integer)", k.String())
+	})
+
+	switch {
+	case err != nil:
+		return cty.NilVal, err
+	case len(elems) > 0 && len(attrs) > 0:
+		return cty.NilVal, xerrors.New("tables cannot mix sequence and string keys")
+	case len(elems) > 0:
+		return sequenceToCty(elems, n)
+	default:
+		return cty.ObjectVal(attrs), nil
+	}
+}
+
+// sequenceToCty converts the elements of a sequence, by index, to a tuple of
+// length n. The missing elements are null, but at least half of them must be
+// set, so that a sparse table is not converted to a huge tuple.
+func sequenceToCty(elems map[int]cty.Value, n int) (cty.Value, error) {
+	if n > 2*len(elems) {
+		return cty.NilVal, xerrors.Errorf("sequence too sparse: %d of its %d indexes are set", len(elems), n)
+	}
+
+	tuple := make([]cty.Value, n)
+
+	for i := range tuple {
+		if v, ok := elems[i+1]; ok {
+			tuple[i] = v
+		} else {
+			tuple[i] = cty.NullVal(cty.DynamicPseudoType)
+		}
+	}
+
+	return cty.TupleVal(tuple), nil
+}
+
+// IsKnown reports whether a Lua value is wholly known, i.e. it is not the
+// unknown sentinel and does not contain it.
+func (b *Bridge) IsKnown(lv lua.LValue) (bool, error) {
+	v, err := b.ToCty(lv)
+	if err != nil {
+		return false, err
+	}
+
+	v, _ = v.UnmarkDeep()
+
+	return v.IsWhollyKnown(), nil
+}
+
+// IsNull reports whether a Lua value is null. The unknown sentinel is not
+// null, since its value is not known yet.
+func (b *Bridge) IsNull(lv lua.LValue) (bool, error) {
+	v, err := b.ToCty(lv)
+	if err != nil {
+		return false, err
+	}
+
+	v, _ = v.Unmark()
+
+	return v.IsNull(), nil
+}
+
+// -----------------------------------------------------------------------------
+// Lua Utilities
+
+// unknownValue is the value of the unknown sentinel.
+type unknownValue struct{}
+
+// LUnknown returns the sentinel of the values that will only be known after
+// apply. There is a single sentinel per state, so that values can be
+// compared to it.
+func LUnknown(ls *lua.LState) lua.LValue {
+	registry := ls.Get(lua.RegistryIndex)
+
+	if ud, ok := ls.GetField(registry, unknownRegistryKey).(*lua.LUserData); ok {
+		return ud
+	}
+
+	mt := ls.NewTypeMetatable(luaUnknownTypeName)
+	ls.SetField(mt, "__tostring", ls.NewFunction(unknownToString))
+
+	ud := ls.NewUserData()
+	ud.Value = unknownValue{}
+	ls.SetMetatable(ud, mt)
+	ls.SetField(registry, unknownRegistryKey, ud)
+
+	return ud
+}
+
+// IsLUnknown reports whether v is the unknown sentinel.
+func IsLUnknown(v lua.LValue) bool {
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return false
+	}
+
+	_, ok = ud.Value.(unknownValue)
+
+	return ok
+}
+
+// LNumber returns the Lua equivalent of a number: a plain number if a
+// float64 has the same shortest decimal representation, e.g. 0.1, or a big
+// number otherwise, e.g. 12345678901234567890.
+//
+// Big numbers keep the precision of the original value. They support the
+// arithmetic operators, with big numbers or plain numbers, and their results
+// are plain numbers when they can be represented exactly. Big numbers can be
+// compared to each other and converted to strings with tostring(). The Lua VM
+// does not compare values of different types though: comparing a big number
+// to a plain number raises an error, and == is always false. One of them must
+// be converted first, e.g. with LBigNumber.
+func LNumber(ls *lua.LState, f *big.Float) lua.LValue {
+	if v, _ := f.Float64(); strconv.FormatFloat(v, 'g', -1, 64) == f.Text('g', -1) {
+		return lua.LNumber(v)
+	}
+
+	return LBigNumber(ls, f)
+}
+
+// LBigNumber returns a number as a big number, even if it can be represented
+// exactly by a plain number, e.g. to compare it to other big numbers.
+func LBigNumber(ls *lua.LState, f *big.Float) lua.LValue {
+	mt := ls.NewTypeMetatable(luaBigNumberTypeName)
+	if mt.RawGetString("__tostring") == lua.LNil {
+		ls.SetFuncs(mt, map[string]lua.LGFunction{
+			"__tostring": bigNumberToString,
+			"__eq":       bigNumberEqual,
+			"__lt":       bigNumberLessThan,
+			"__le":       bigNumberLessOrEqual,
+			"__add":      bigNumberAdd,
+			"__sub":      bigNumberSub,
+			"__mul":      bigNumberMul,
+			"__div":      bigNumberDiv,
+			"__unm":      bigNumberUnm,
+		})
+	}
+
+	ud := ls.NewUserData()
+	ud.Value = f
+	ls.SetMetatable(ud, mt)
+
+	return ud
+}
+
+// ToBigFloat converts a number, a big number or a string representing a
+// number to a *big.Float, and reports whether the conversion succeeded. NaN
+// cannot be converted.
+func ToBigFloat(lv lua.LValue) (*big.Float, bool) {
+	switch v := lv.(type) {
+	case lua.LNumber:
+		if math.IsNaN(float64(v)) {
+			return nil, false
+		}
+
+		return big.NewFloat(float64(v)), true
+
+	case lua.LString:
+		f, _, err := big.ParseFloat(strings.TrimSpace(string(v)), 10, bigNumberPrecision, big.ToNearestEven)
+
+		return f, err == nil
+
+	case *lua.LUserData:
+		f, ok := v.Value.(*big.Float)
+
+		return f, ok
+	}
+
+	return nil, false
+}
+
+// CheckBigNumber checks whether the lua argument n is a *LUserData with
+// *big.Float and returns this *big.Float.
+func CheckBigNumber(ls *lua.LState, n int) (*big.Float, error) {
+	ud := ls.CheckUserData(n)
+	if v, ok := ud.Value.(*big.Float); ok {
+		return v, nil
+	}
+
+	ls.ArgError(n, "bignumber expected")
+
+	return nil, xerrors.New("not a bignumber variable")
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+func unknownToString(ls *lua.LState) int {
+	ls.Push(lua.LString("(known after apply)"))
+
+	return 1
+}
+
+func bigNumberToString(ls *lua.LState) int {
+	f, err := CheckBigNumber(ls, 1)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(f.Text('f', -1)))
+
+	return 1
+}
+
+// checkBigNumberOperand checks whether the lua argument n can be converted
+// to a *big.Float and returns this *big.Float.
+func checkBigNumberOperand(ls *lua.LState, n int) *big.Float {
+	f, ok := ToBigFloat(ls.CheckAny(n))
+	if !ok {
+		ls.ArgError(n, "number expected")
+	}
+
+	return f
+}
+
+// bigNumberCompare pushes the result of the comparison of two operands, at
+// least one of which is a big number.
+func bigNumberCompare(ls *lua.LState, result func(cmp int) bool) int {
+	a := checkBigNumberOperand(ls, 1)
+	b := checkBigNumberOperand(ls, 2) //nolint:gomnd // second operand
+
+	ls.Push(lua.LBool(result(a.Cmp(b))))
+
+	return 1
+}
+
+func bigNumberEqual(ls *lua.LState) int {
+	return bigNumberCompare(ls, func(cmp int) bool { return cmp == 0 })
+}
+
+func bigNumberLessThan(ls *lua.LState) int {
+	return bigNumberCompare(ls, func(cmp int) bool { return cmp < 0 })
+}
+
+func bigNumberLessOrEqual(ls *lua.LState) int {
+	return bigNumberCompare(ls, func(cmp int) bool { return cmp <= 0 })
+}
+
+// bigNumberArith pushes the result of an arithmetic operation on two
+// operands, at least one of which is a big number.
+func bigNumberArith(ls *lua.LState, op func(z, x, y *big.Float) *big.Float) int {
+	x := checkBigNumberOperand(ls, 1)
+	y := checkBigNumberOperand(ls, 2) //nolint:gomnd // second operand
+
+	ls.Push(bigNumberResult(ls, func(z *big.Float) *big.Float { return op(z, x, y) }))
+
+	return 1
+}
+
+// bigNumberResult returns the result of an operation on big numbers, or NaN
+// when the result is not a number, e.g. for 0/0 or inf-inf.
+func bigNumberResult(ls *lua.LState, op func(z *big.Float) *big.Float) (ret lua.LValue) {
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			if _, ok := rcv.(big.ErrNaN); !ok {
+				panic(rcv)
+			}
+
+			ret = lua.LNumber(math.NaN())
+		}
+	}()
+
+	return LNumber(ls, op(new(big.Float).SetPrec(bigNumberPrecision)))
+}
+
+func bigNumberAdd(ls *lua.LState) int {
+	return bigNumberArith(ls, (*big.Float).Add)
+}
+
+func bigNumberSub(ls *lua.LState) int {
+	return bigNumberArith(ls, (*big.Float).Sub)
+}
+
+func bigNumberMul(ls *lua.LState) int {
+	return bigNumberArith(ls, (*big.Float).Mul)
+}
+
+func bigNumberDiv(ls *lua.LState) int {
+	return bigNumberArith(ls, (*big.Float).Quo)
+}
+
+func bigNumberUnm(ls *lua.LState) int {
+	x := checkBigNumberOperand(ls, 1)
+
+	ls.Push(bigNumberResult(ls, func(z *big.Float) *big.Float { return z.Neg(x) }))
+
+	return 1
+}
@@ -0,0 +1,354 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lua
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestBridge_ToLua(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  cty.Value
+		script string
+		want   []lua.LValue
+	}{
+		{
+			name:   "null and unknown",
+			value:  cty.ObjectVal(map[string]cty.Value{"null": cty.NullVal(cty.String), "unknown": cty.UnknownVal(cty.String)}),
+			script: `return v.null, v.unknown == unknown, tostring(v.unknown)`,
+			want:   []lua.LValue{lua.LNil, lua.LTrue, lua.LString("(known after apply)")},
+		},
+		{
+			name: "empty collections",
+			value: cty.ObjectVal(map[string]cty.Value{
+				"list": cty.ListValEmpty(cty.String),
+				"set":  cty.SetValEmpty(cty.String),
+				"map":  cty.MapValEmpty(cty.String),
+				"obj":  cty.EmptyObjectVal,
+			}),
+			script: `return type(v.list), #v.list, next(v.set), next(v.map), next(v.obj)`,
+			want:   []lua.LValue{lua.LString("table"), lua.LNumber(0), lua.LNil, lua.LNil, lua.LNil},
+		},
+		{
+			name: "collections",
+			value: cty.ObjectVal(map[string]cty.Value{
+				"set":   cty.SetVal([]cty.Value{cty.StringVal("b"), cty.StringVal("a")}),
+				"tuple": cty.TupleVal([]cty.Value{cty.StringVal("x"), cty.True}),
+				"map":   cty.MapVal(map[string]cty.Value{"k": cty.StringVal("v")}),
+			}),
+			script: `return v.set[1], v.set[2], v.tuple[1], v.tuple[2], v.map.k`,
+			want:   []lua.LValue{lua.LString("a"), lua.LString("b"), lua.LString("x"), lua.LTrue, lua.LString("v")},
+		},
+		{
+			name: "numbers",
+			value: cty.TupleVal([]cty.Value{
+				cty.NumberFloatVal(0.1),
+				cty.MustParseNumberVal("42"),
+				cty.MustParseNumberVal("12345678901234567890"),
+				cty.MustParseNumberVal("12345678901234567891"),
+			}),
+			script: `return v[1], v[2], type(v[3]), tostring(v[3]), v[3] < v[4], v[3] == v[3]`,
+			want: []lua.LValue{
+				lua.LNumber(0.1), lua.LNumber(42), lua.LString("userdata"), lua.LString("12345678901234567890"), lua.LTrue, lua.LTrue,
+			},
+		},
+		{
+			name:   "marks",
+			value:  cty.StringVal("secret").Mark("sensitive"),
+			script: `return v`,
+			want:   []lua.LValue{lua.LString("secret")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ls := lua.NewState()
+			defer ls.Close()
+
+			b := &Bridge{}
+			ls.SetGlobal("v", b.ToLua(ls, tt.value))
+			ls.SetGlobal("unknown", LUnknown(ls))
+
+			require.NoError(t, ls.DoString(tt.script))
+
+			got := make([]lua.LValue, 0, ls.GetTop())
+			for i := 1; i <= ls.GetTop(); i++ {
+				got = append(got, ls.Get(i))
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBridge_ToCty(t *testing.T) {
+	bigNum, _, err := big.ParseFloat("12345678901234567890", 10, 512, big.ToNearestEven)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		script  string
+		want    cty.Value
+		wantErr string
+	}{
+		{
+			name:   "scalars",
+			script: `return {s = "a", n = 1.5, b = true}`,
+			want: cty.ObjectVal(map[string]cty.Value{
+				"s": cty.StringVal("a"),
+				"n": cty.NumberFloatVal(1.5),
+				"b": cty.True,
+			}),
+		},
+		{
+			name:   "sequence",
+			script: `return {"a", unknown}`,
+			want:   cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.DynamicVal}),
+		},
+		{
+			name:   "empty table",
+			script: `return {}`,
+			want:   cty.EmptyObjectVal,
+		},
+		{
+			name:   "big number",
+			script: `return bignum`,
+			want:   cty.NumberVal(bigNum),
+		},
+		{
+			name:   "sequence with holes",
+			script: `return {1, nil, 3}`,
+			want:   cty.TupleVal([]cty.Value{cty.NumberIntVal(1), cty.NullVal(cty.DynamicPseudoType), cty.NumberIntVal(3)}),
+		},
+		{
+			name:   "sparse keys",
+			script: `return {[1] = 1, [3] = 3}`,
+			want:   cty.TupleVal([]cty.Value{cty.NumberIntVal(1), cty.NullVal(cty.DynamicPseudoType), cty.NumberIntVal(3)}),
+		},
+		{
+			name:    "too sparse",
+			script:  `return {[1] = 1, [1000000] = 2}`,
+			wantErr: "sequence too sparse: 2 of its 1000000 indexes are set",
+		},
+		{
+			name:   "infinity",
+			script: `return {1/0, -1/0}`,
+			want:   cty.TupleVal([]cty.Value{cty.PositiveInfinity, cty.NegativeInfinity}),
+		},
+		{
+			name:    "nan",
+			script:  `return {n = 0/0}`,
+			wantErr: "unsupported number NaN",
+		},
+		{
+			name:    "fractional key",
+			script:  `return {[1.5] = 1}`,
+			wantErr: "unsupported table key 1.5 (expected a string or a positive integer)",
+		},
+		{
+			name:    "mixed keys",
+			script:  `return {"a", b = "b"}`,
+			wantErr: "tables cannot mix sequence and string keys",
+		},
+		{
+			name:    "function",
+			script:  `return print`,
+			wantErr: "unsupported value of type function",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ls := lua.NewState()
+			defer ls.Close()
+
+			b := &Bridge{}
+			ls.SetGlobal("unknown", LUnknown(ls))
+			ls.SetGlobal("bignum", LNumber(ls, bigNum))
+
+			require.NoError(t, ls.DoString(tt.script))
+
+			got, err := b.ToCty(ls.Get(-1))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.True(t, tt.want.RawEquals(got), "got %#v", got)
+		})
+	}
+}
+
+func TestLNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    []lua.LValue
+		wantErr string
+	}{
+		{
+			name:   "arithmetic",
+			script: `return tostring(big + 1), tostring(1 + big), tostring(big - big1), tostring(big * 2), tostring(-big)`,
+			want: []lua.LValue{
+				lua.LString("12345678901234567891"),
+				lua.LString("12345678901234567891"),
+				lua.LString("-1"),
+				lua.LString("24691357802469135780"),
+				lua.LString("-12345678901234567890"),
+			},
+		},
+		{
+			name:   "plain results",
+			script: `return big - big, (big + 10) - big, big / big, type(big / 0)`,
+			want:   []lua.LValue{lua.LNumber(0), lua.LNumber(10), lua.LNumber(1), lua.LString("number")},
+		},
+		{
+			name:   "comparisons",
+			script: `return big < big1, big1 <= big, big == tonumber("12345678901234567890"), big ~= big1`,
+			want:   []lua.LValue{lua.LTrue, lua.LFalse, lua.LFalse, lua.LTrue},
+		},
+		{
+			name: "mixed comparisons",
+			script: `
+local mt = getmetatable(big)
+return mt.__lt(big, 1e20), mt.__le(1e19, big), mt.__eq(big, 12345678901234567890)`,
+			want: []lua.LValue{lua.LTrue, lua.LTrue, lua.LFalse},
+		},
+		{
+			name:   "converted comparisons",
+			script: `return small == small, small < big, big <= small`,
+			want:   []lua.LValue{lua.LTrue, lua.LTrue, lua.LFalse},
+		},
+		{
+			name:    "plain number comparison",
+			script:  `return big < 1`,
+			wantErr: "attempt to compare userdata with number",
+		},
+		{
+			name:    "invalid operand",
+			script:  `return big + {}`,
+			wantErr: "number expected",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ls := lua.NewState()
+			defer ls.Close()
+
+			for name, v := range map[string]string{"big": "12345678901234567890", "big1": "12345678901234567891"} {
+				f, _, err := big.ParseFloat(v, 10, 512, big.ToNearestEven)
+				require.NoError(t, err)
+				ls.SetGlobal(name, LNumber(ls, f))
+			}
+
+			ls.SetGlobal("small", LBigNumber(ls, big.NewFloat(42)))
+
+			err := ls.DoString(tt.script)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			got := make([]lua.LValue, 0, ls.GetTop())
+			for i := 1; i <= ls.GetTop(); i++ {
+				got = append(got, ls.Get(i))
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestToBigFloat(t *testing.T) {
+	ls := lua.NewState()
+	defer ls.Close()
+
+	bigNum, _, err := big.ParseFloat("12345678901234567890", 10, 512, big.ToNearestEven)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		value  lua.LValue
+		want   string
+		wantOK bool
+	}{
+		{name: "number", value: lua.LNumber(1.5), want: "1.5", wantOK: true},
+		{name: "big number", value: LNumber(ls, bigNum), want: "12345678901234567890", wantOK: true},
+		{name: "string", value: lua.LString(" 12345678901234567891 "), want: "12345678901234567891", wantOK: true},
+		{name: "invalid string", value: lua.LString("ten")},
+		{name: "nan", value: lua.LNumber(math.NaN())},
+		{name: "bool", value: lua.LTrue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ToBigFloat(tt.value)
+			require.Equal(t, tt.wantOK, ok)
+
+			if ok {
+				assert.Equal(t, tt.want, got.Text('f', -1))
+			}
+		})
+	}
+}
+
+func TestBridge_IsKnownIsNull(t *testing.T) {
+	ls := lua.NewState()
+	defer ls.Close()
+
+	b := &Bridge{}
+
+	tests := []struct {
+		name      string
+		value     cty.Value
+		wantKnown bool
+		wantNull  bool
+	}{
+		{name: "known", value: cty.StringVal("a"), wantKnown: true},
+		{name: "empty", value: cty.StringVal(""), wantKnown: true},
+		{name: "null", value: cty.NullVal(cty.String), wantKnown: true, wantNull: true},
+		{name: "unknown", value: cty.UnknownVal(cty.String)},
+		{name: "nested unknown", value: cty.ListVal([]cty.Value{cty.StringVal("a"), cty.UnknownVal(cty.String)})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lv := b.ToLua(ls, tt.value)
+
+			known, err := b.IsKnown(lv)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantKnown, known)
+
+			null, err := b.IsNull(lv)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantNull, null)
+		})
+	}
+
+	assert.Same(t, LUnknown(ls), LUnknown(ls))
+	assert.True(t, IsLUnknown(LUnknown(ls)))
+	assert.False(t, IsLUnknown(lua.LNil))
+}
@@ -15,6 +15,8 @@
 package warden
 
 import (
+	"time"
+
 	luaCrypto "github.com/vadv/gopher-lua-libs/crypto"
 	luaHumanize "github.com/vadv/gopher-lua-libs/humanize"
 	luaInspect "github.com/vadv/gopher-lua-libs/inspect"
@@ -23,15 +25,78 @@ import (
 	luaTac "github.com/vadv/gopher-lua-libs/tac"
 	luaTime "github.com/vadv/gopher-lua-libs/time"
 	luaYaml "github.com/vadv/gopher-lua-libs/yaml"
+	lua "github.com/yuin/gopher-lua"
 
 	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
 )
 
 type Options struct {
 	Libs        []wlua.Module
 	Modules     []wlua.Module
 	UserModules []wlua.UserModule
-	Script      string
+
+	// Script is a validation script run as a rule named DefaultRuleName.
+	Script string
+	// Rules are the validation rules.
+	Rules []Rule
+	// Params are the values of the parameters declared by the rules, by
+	// name. They can be loaded from a YAML or JSON document with
+	// LoadParams.
+	Params map[string]interface{}
+
+	// ProviderSchemas are used to decode the values of the planned changes.
+	// They can be loaded from the output of 'terraform providers schema -json'
+	// with terraform.LoadProviderSchemas.
+	// Without them, values are decoded on a best-effort basis.
+	ProviderSchemas *terraform.ProviderSchemas
+
+	// Waivers are temporary exceptions to the rules. They can be loaded
+	// from a YAML document with LoadWaivers.
+	Waivers []Waiver
+
+	// Overrides let soft-mandatory rules fail without failing the
+	// validation.
+	Overrides []Override
+
+	// Limits restrict the resources the rules can use.
+	Limits Limits
+
+	// Coverage, if set, records the lines of the rules and user modules
+	// executed by the validations. The scripts are then instrumented, which
+	// slows them down.
+	Coverage *wlua.Coverage
+}
+
+// Limits restrict the resources the rules can use.
+// A zero value means that the default of the Lua VM applies, or that there
+// is no limit for RuleTimeout.
+type Limits struct {
+	// CallStackSize is the maximum depth of the call stack.
+	CallStackSize int
+	// RegistrySize is the maximum number of values on the Lua stack.
+	RegistrySize int
+	// RuleTimeout is the maximum execution time of each rule.
+	RuleTimeout time.Duration
+}
+
+// luaOptions returns the options of the Lua VM enforcing the limits.
+func (l *Limits) luaOptions() lua.Options {
+	opts := lua.Options{
+		SkipOpenLibs:  true,
+		CallStackSize: l.CallStackSize,
+	}
+
+	if l.RegistrySize > 0 {
+		opts.RegistrySize = lua.RegistrySize
+		if l.RegistrySize < opts.RegistrySize {
+			opts.RegistrySize = l.RegistrySize
+		}
+
+		opts.RegistryMaxSize = l.RegistrySize
+	}
+
+	return opts
 }
 
 func DefaultPreloadModules() []wlua.Module {
@@ -51,9 +116,16 @@ func DefaultPreloadModules() []wlua.Module {
 // instance with New.
 func DefaultOptions() *Options {
 	return &Options{
-		Libs:        wlua.StandardLibs(),
-		Modules:     DefaultPreloadModules(),
-		UserModules: nil,
-		Script:      "",
+		Libs:            wlua.StandardLibs(),
+		Modules:         DefaultPreloadModules(),
+		UserModules:     nil,
+		Script:          "",
+		Rules:           nil,
+		Params:          nil,
+		ProviderSchemas: nil,
+		Waivers:         nil,
+		Overrides:       nil,
+		Limits:          Limits{},
+		Coverage:        nil,
 	}
 }
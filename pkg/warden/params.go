@@ -0,0 +1,384 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// ParamsGlobalName is the name of the global table exposing the parameters
+// of a rule.
+const ParamsGlobalName = "params"
+
+// ParamType is the type of the value of a rule parameter.
+type ParamType string
+
+const (
+	ParamTypeString     ParamType = "string"
+	ParamTypeNumber     ParamType = "number"
+	ParamTypeBool       ParamType = "bool"
+	ParamTypeStringList ParamType = "list(string)"
+	ParamTypeNumberList ParamType = "list(number)"
+	ParamTypeStringMap  ParamType = "map(string)"
+)
+
+// ParseParamType returns the parameter type with the given name.
+func ParseParamType(s string) (ParamType, error) {
+	switch t := ParamType(strings.ToLower(strings.ReplaceAll(s, " ", ""))); t {
+	case ParamTypeString, ParamTypeNumber, ParamTypeBool, ParamTypeStringList, ParamTypeNumberList, ParamTypeStringMap:
+		return t, nil
+	default:
+		return "", xerrors.Errorf("invalid parameter type '%s' (expected '%s', '%s', '%s', '%s', '%s' or '%s')", s,
+			ParamTypeString, ParamTypeNumber, ParamTypeBool, ParamTypeStringList, ParamTypeNumberList, ParamTypeStringMap)
+	}
+}
+
+// Param is a parameter declared by a rule.
+type Param struct {
+	// Name identifies the parameter. Rules declaring a parameter with the
+	// same name share its value, and must declare the same type.
+	Name string
+	// Description explains what the parameter is for.
+	Description string
+	// Type is the type of the value of the parameter. It defaults to
+	// ParamTypeString.
+	Type ParamType
+	// Default is the value of the parameter when none is supplied. The
+	// parameter is required when it has no default.
+	Default interface{}
+}
+
+// LoadParams reads the values of the rule parameters from a YAML or JSON
+// document mapping the parameter names to their values, e.g.:
+//
+//	allowed_regions: [eu-west-1, eu-central-1]
+//	max_instances: 10
+func LoadParams(r io.Reader) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+
+	if err := yaml.NewDecoder(r).Decode(&params); err != nil && !xerrors.Is(err, io.EOF) {
+		return nil, xerrors.Errorf("failed to decode parameters: %w", err)
+	}
+
+	return params, nil
+}
+
+// ParseParam parses the textual representation of the value of a parameter
+// declared by one of the rules, e.g. as passed on the command line. The
+// values of the string parameters are taken as is, and those of the other
+// types are read as YAML, e.g. '10', 'true', '[a, b]' or '{a: b}'.
+func ParseParam(rules []Rule, name string, value string) (interface{}, error) {
+	for _, r := range rules {
+		for _, p := range r.Params {
+			if p.Name != name {
+				continue
+			}
+
+			t := ParamTypeString
+			if p.Type != "" {
+				var err error
+				if t, err = ParseParamType(string(p.Type)); err != nil {
+					return nil, xerrors.Errorf("invalid parameter '%s' of rule '%s': %w", name, r.Name, err)
+				}
+			}
+
+			v, err := t.parse(value)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid value for parameter '%s': %w", name, err)
+			}
+
+			return v, nil
+		}
+	}
+
+	return nil, xerrors.Errorf("unknown parameter '%s'", name)
+}
+
+// params returns the values of the parameters declared by the rules, by rule
+// name. The values are converted to their declared type, and the defaults
+// apply to the parameters with no value.
+// The parameters must all be declared by one of the rules, and the required
+// ones must have a value.
+func (o *Options) params(rules []Rule) (map[string]map[string]interface{}, error) {
+	types := map[string]ParamType{}
+	ret := make(map[string]map[string]interface{}, len(rules))
+
+	for _, r := range rules {
+		values := make(map[string]interface{}, len(r.Params))
+
+		for _, p := range r.Params {
+			if t, ok := types[p.Name]; ok && t != p.Type {
+				return nil, xerrors.Errorf("parameter '%s' of rule '%s' is a %s, but is a %s in another rule", p.Name, r.Name, p.Type, t)
+			}
+
+			types[p.Name] = p.Type
+
+			v, ok := o.Params[p.Name]
+			if !ok {
+				v = p.Default
+			}
+
+			if v == nil {
+				return nil, xerrors.Errorf("missing value for parameter '%s' of rule '%s'", p.Name, r.Name)
+			}
+
+			value, err := p.Type.convert(v)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid value for parameter '%s' of rule '%s': %w", p.Name, r.Name, err)
+			}
+
+			values[p.Name] = value
+		}
+
+		ret[r.Name] = values
+	}
+
+	names := make([]string, 0, len(o.Params))
+	for name := range o.Params {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := types[name]; !ok {
+			return nil, xerrors.Errorf("unknown parameter '%s'", name)
+		}
+	}
+
+	return ret, nil
+}
+
+// validateParams checks the declaration of the parameters of a rule, and
+// sets the default type.
+func validateParams(params []Param) error {
+	names := make(map[string]struct{}, len(params))
+
+	for i := range params {
+		p := &params[i]
+
+		if p.Name == "" {
+			return xerrors.Errorf("parameter #%d has no name", i+1)
+		}
+
+		if _, ok := names[p.Name]; ok {
+			return xerrors.Errorf("duplicate parameter '%s'", p.Name)
+		}
+
+		names[p.Name] = struct{}{}
+
+		if p.Type == "" {
+			p.Type = ParamTypeString
+		} else {
+			t, err := ParseParamType(string(p.Type))
+			if err != nil {
+				return xerrors.Errorf("invalid parameter '%s': %w", p.Name, err)
+			}
+
+			p.Type = t
+		}
+
+		if p.Default != nil {
+			if _, err := p.Type.convert(p.Default); err != nil {
+				return xerrors.Errorf("invalid default value for parameter '%s': %w", p.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parse parses the textual representation of a value of the type.
+func (t ParamType) parse(s string) (interface{}, error) {
+	if t == ParamTypeString {
+		return s, nil
+	}
+
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return nil, xerrors.Errorf("failed to parse '%s': %w", s, err)
+	}
+
+	return t.convert(v)
+}
+
+// convert converts a value to the Go representation of the type: string,
+// float64, bool, []string, []float64 or map[string]string.
+func (t ParamType) convert(v interface{}) (interface{}, error) {
+	switch t {
+	case ParamTypeString:
+		return convertString(v)
+	case ParamTypeNumber:
+		return convertNumber(v)
+	case ParamTypeBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, xerrors.Errorf("expected a bool, got %s", describeValue(v))
+		}
+
+		return b, nil
+	case ParamTypeStringList:
+		return convertList(v, ParamTypeString, []string{})
+	case ParamTypeNumberList:
+		return convertList(v, ParamTypeNumber, []float64{})
+	case ParamTypeStringMap:
+		return convertStringMap(v)
+	default:
+		return nil, xerrors.Errorf("unsupported parameter type '%s'", t)
+	}
+}
+
+func convertString(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.String {
+		return nil, xerrors.Errorf("expected a string, got %s", describeValue(v))
+	}
+
+	return rv.String(), nil
+}
+
+func convertNumber(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() { //nolint:exhaustive // the other kinds are not numbers.
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	default:
+		return nil, xerrors.Errorf("expected a number, got %s", describeValue(v))
+	}
+}
+
+// convertList converts the elements of a slice to the element type, and
+// appends them to list, a slice of the Go representation of the element
+// type.
+func convertList(v interface{}, elem ParamType, list interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, xerrors.Errorf("expected a list, got %s", describeValue(v))
+	}
+
+	ret := reflect.ValueOf(list)
+
+	for i := 0; i < rv.Len(); i++ {
+		e, err := elem.convert(rv.Index(i).Interface())
+		if err != nil {
+			return nil, xerrors.Errorf("invalid element #%d: %w", i+1, err)
+		}
+
+		ret = reflect.Append(ret, reflect.ValueOf(e))
+	}
+
+	return ret.Interface(), nil
+}
+
+func convertStringMap(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, xerrors.Errorf("expected a map, got %s", describeValue(v))
+	}
+
+	ret := make(map[string]string, rv.Len())
+
+	iter := rv.MapRange()
+	for iter.Next() {
+		e, err := convertString(iter.Value().Interface())
+		if err != nil {
+			return nil, xerrors.Errorf("invalid element '%s': %w", iter.Key().String(), err)
+		}
+
+		ret[iter.Key().String()] = e.(string) //nolint:forcetypeassert // convertString returns strings.
+	}
+
+	return ret, nil
+}
+
+// describeValue returns a description of a value for the error messages.
+func describeValue(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+
+	return fmt.Sprintf("%T %v", v, v)
+}
+
+// lParams returns the read-only table of the parameters of a rule. Setting
+// a field of the table raises an error. The lists and maps are copies, so
+// that a rule changing them does not affect the others.
+func lParams(ls *lua.LState, params map[string]interface{}) *lua.LTable {
+	values := ls.CreateTable(0, len(params))
+	for name, v := range params {
+		values.RawSetString(name, lParamValue(ls, v))
+	}
+
+	meta := ls.NewTable()
+	meta.RawSetString("__index", values)
+	meta.RawSetString("__newindex", ls.NewFunction(func(ls *lua.LState) int {
+		ls.RaiseError("cannot set parameter '%s': the parameters are read-only", ls.CheckAny(2).String())
+
+		return 0
+	}))
+	meta.RawSetString("__metatable", lua.LFalse)
+
+	proxy := ls.NewTable()
+	ls.SetMetatable(proxy, meta)
+
+	return proxy
+}
+
+func lParamValue(ls *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case string:
+		return lua.LString(v)
+	case float64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	case []string:
+		tbl := ls.CreateTable(len(v), 0)
+		for _, e := range v {
+			tbl.Append(lua.LString(e))
+		}
+
+		return tbl
+	case []float64:
+		tbl := ls.CreateTable(len(v), 0)
+		for _, e := range v {
+			tbl.Append(lua.LNumber(e))
+		}
+
+		return tbl
+	case map[string]string:
+		tbl := ls.CreateTable(0, len(v))
+		for k, e := range v {
+			tbl.RawSetString(k, lua.LString(e))
+		}
+
+		return tbl
+	default:
+		return lua.LNil
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+func TestLoadParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			doc: `
+allowed_regions: [eu-west-1, eu-central-1]
+max_instances: 10
+required_tags:
+  Owner: team
+`,
+			want: map[string]interface{}{
+				"allowed_regions": []interface{}{"eu-west-1", "eu-central-1"},
+				"max_instances":   10,
+				"required_tags":   map[string]interface{}{"Owner": "team"},
+			},
+		},
+		{
+			name: "json",
+			doc:  `{"allowed_regions": ["eu-west-1"], "encrypted": true}`,
+			want: map[string]interface{}{
+				"allowed_regions": []interface{}{"eu-west-1"},
+				"encrypted":       true,
+			},
+		},
+		{
+			name: "empty",
+			doc:  "",
+			want: map[string]interface{}{},
+		},
+		{
+			name:    "not a mapping",
+			doc:     `[eu-west-1]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadParams(strings.NewReader(tt.doc))
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseParam(t *testing.T) {
+	rules := []Rule{
+		{Name: "r1", Params: []Param{{Name: "owner"}, {Name: "max", Type: "Number"}}},
+		{Name: "r2", Params: []Param{
+			{Name: "regions", Type: ParamTypeStringList},
+			{Name: "tags", Type: ParamTypeStringMap},
+			{Name: "encrypted", Type: ParamTypeBool},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		param   string
+		value   string
+		want    interface{}
+		wantErr string
+	}{
+		{name: "string", param: "owner", value: "10", want: "10"},
+		{name: "number", param: "max", value: "10", want: float64(10)},
+		{name: "bool", param: "encrypted", value: "true", want: true},
+		{name: "list", param: "regions", value: "[eu-west-1, eu-central-1]", want: []string{"eu-west-1", "eu-central-1"}},
+		{name: "map", param: "tags", value: "{Owner: team}", want: map[string]string{"Owner": "team"}},
+		{
+			name:    "invalid value",
+			param:   "max",
+			value:   "ten",
+			wantErr: "invalid value for parameter 'max': expected a number, got string ten",
+		},
+		{
+			name:    "invalid yaml",
+			param:   "regions",
+			value:   "[eu-west-1",
+			wantErr: "invalid value for parameter 'regions': failed to parse '[eu-west-1'",
+		},
+		{
+			name:    "unknown parameter",
+			param:   "region",
+			value:   "eu-west-1",
+			wantErr: "unknown parameter 'region'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseParam(rules, tt.param, tt.value)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.True(t, strings.HasPrefix(err.Error(), tt.wantErr), err.Error())
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNew_Params(t *testing.T) {
+	regions := Param{Name: "regions", Type: ParamTypeStringList}
+
+	tests := []struct {
+		name    string
+		rules   []Rule
+		params  map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			rules:  []Rule{{Name: "r1", Params: []Param{regions, {Name: "max", Type: "Number", Default: 3}}}},
+			params: map[string]interface{}{"regions": []string{"eu-west-1"}},
+		},
+		{
+			name:    "missing value",
+			rules:   []Rule{{Name: "r1", Params: []Param{regions}}},
+			wantErr: "invalid parameters: missing value for parameter 'regions' of rule 'r1'",
+		},
+		{
+			name:    "invalid value",
+			rules:   []Rule{{Name: "r1", Params: []Param{regions}}},
+			params:  map[string]interface{}{"regions": []interface{}{"eu-west-1", 3}},
+			wantErr: "invalid parameters: invalid value for parameter 'regions' of rule 'r1': invalid element #2: expected a string, got int 3",
+		},
+		{
+			name:    "unknown parameter",
+			rules:   []Rule{{Name: "r1", Params: []Param{regions}}},
+			params:  map[string]interface{}{"regions": []string{}, "region": "eu-west-1"},
+			wantErr: "invalid parameters: unknown parameter 'region'",
+		},
+		{
+			name: "conflicting types",
+			rules: []Rule{
+				{Name: "r1", Params: []Param{regions}},
+				{Name: "r2", Params: []Param{{Name: "regions"}}},
+			},
+			params:  map[string]interface{}{"regions": []string{}},
+			wantErr: "invalid parameters: parameter 'regions' of rule 'r2' is a string, but is a list(string) in another rule",
+		},
+		{
+			name:    "invalid type",
+			rules:   []Rule{{Name: "r1", Params: []Param{{Name: "regions", Type: "set(string)"}}}},
+			wantErr: "invalid rules: invalid rule 'r1': invalid parameter 'regions': invalid parameter type 'set(string)'",
+		},
+		{
+			name:    "invalid default",
+			rules:   []Rule{{Name: "r1", Params: []Param{{Name: "encrypted", Type: ParamTypeBool, Default: "yes"}}}},
+			wantErr: "invalid rules: invalid rule 'r1': invalid default value for parameter 'encrypted': expected a bool, got string yes",
+		},
+		{
+			name:    "duplicate parameter",
+			rules:   []Rule{{Name: "r1", Params: []Param{regions, regions}}},
+			wantErr: "invalid rules: invalid rule 'r1': duplicate parameter 'regions'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.rules {
+				tt.rules[i].Script = `return true`
+			}
+
+			w, err := New(&Options{Rules: tt.rules, Params: tt.params})
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.True(t, strings.HasPrefix(err.Error(), tt.wantErr), err.Error())
+
+				return
+			}
+
+			require.NoError(t, err)
+			w.Close()
+		})
+	}
+}
+
+func TestWarden_Params(t *testing.T) {
+	planFile, err := terraform.NewPlanBuilder().Build()
+	require.NoError(t, err)
+
+	rules := []Rule{
+		{
+			Name: "typed",
+			Params: []Param{
+				{Name: "regions", Type: ParamTypeStringList},
+				{Name: "max", Type: ParamTypeNumber, Default: 3},
+				{Name: "encrypted", Type: ParamTypeBool, Default: true},
+				{Name: "tags", Type: ParamTypeStringMap, Default: map[string]string{"Owner": "platform"}},
+			},
+			Script: `
+local issues = {}
+for i, r in ipairs(params.regions) do
+	table.insert(issues, {message = i .. ":" .. r})
+end
+table.insert(issues, {message = "max:" .. params.max})
+table.insert(issues, {message = "encrypted:" .. tostring(params.encrypted)})
+table.insert(issues, {message = "owner:" .. params.tags.Owner})
+table.remove(params.regions)
+return issues
+`,
+		},
+		{
+			Name:   "shared",
+			Params: []Param{{Name: "regions", Type: ParamTypeStringList}},
+			Script: `return {message = "regions:" .. #params.regions}`,
+		},
+		{
+			Name:   "read-only",
+			Script: `params.max = 10`,
+		},
+	}
+
+	w, err := New(&Options{
+		Rules:  rules,
+		Params: map[string]interface{}{"regions": []interface{}{"eu-west-1", "eu-central-1"}, "max": 5},
+	})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	report, err := w.ValidatePlanFile(context.Background(), planFile)
+	assert.ErrorIs(t, err, ErrRuleErrored)
+	assert.Equal(t, []string{
+		"1:eu-west-1",
+		"2:eu-central-1",
+		"max:5",
+		"encrypted:true",
+		"owner:platform",
+		"regions:2",
+	}, findingMessages(report))
+
+	require.Len(t, report.Results, 3)
+	require.Error(t, report.Results[2].Err)
+	assert.Contains(t, report.Results[2].Err.Error(), "cannot set parameter 'max': the parameters are read-only")
+}
@@ -0,0 +1,96 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"sync"
+
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+)
+
+// statePool provides fresh Lua states to the rules.
+//
+// States are never reused: a state is closed once it has been used, and
+// replaced by a new one so that the next rule does not have to wait for the
+// sandbox to be initialized. This guarantees that nothing set by a rule can
+// leak into the next ones, whether in the same validation or not.
+type statePool struct {
+	newState func() (*wlua.LState, error)
+	states   chan *wlua.LState
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newStatePool(size int, newState func() (*wlua.LState, error)) *statePool {
+	return &statePool{
+		newState: newState,
+		states:   make(chan *wlua.LState, size),
+	}
+}
+
+// get returns a fresh state.
+func (p *statePool) get() (*wlua.LState, error) {
+	select {
+	case ls, ok := <-p.states:
+		if ok {
+			return ls, nil
+		}
+	default:
+	}
+
+	return p.newState()
+}
+
+// put closes a used state and replaces it with a fresh one.
+func (p *statePool) put(ls *wlua.LState) {
+	ls.Close()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return
+	}
+
+	fresh, err := p.newState()
+	if err != nil {
+		// The error will be reported by the next call to get.
+		return
+	}
+
+	select {
+	case p.states <- fresh:
+	default:
+		fresh.Close()
+	}
+}
+
+// close closes all the available states.
+func (p *statePool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+	close(p.states)
+
+	for ls := range p.states {
+		ls.Close()
+	}
+}
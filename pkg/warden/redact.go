@@ -0,0 +1,128 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"sort"
+	"strings"
+)
+
+// RedactedValue replaces the sensitive values in the reports.
+const RedactedValue = "(sensitive value)"
+
+// minRedactedLength is the length under which the sensitive values are not
+// redacted: scrubbing every occurrence of a short string like '1' or 'no'
+// would garble the messages while hiding nothing meaningful.
+const minRedactedLength = 4
+
+// redactor scrubs the known sensitive values from the texts of a report.
+type redactor struct {
+	replacer *strings.Replacer
+}
+
+// newRedactor creates a redactor for the given sensitive values. It returns
+// nil, which redacts nothing, if none of them is long enough to be redacted.
+func newRedactor(values []string) *redactor {
+	secrets := make([]string, 0, len(values))
+
+	for _, v := range values {
+		if len(v) >= minRedactedLength {
+			secrets = append(secrets, v)
+		}
+	}
+
+	if len(secrets) == 0 {
+		return nil
+	}
+
+	// The replacer tries the values in order at each position, so the
+	// longest ones must come first for a value containing another one to be
+	// fully redacted.
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, s := range secrets {
+		pairs = append(pairs, s, RedactedValue)
+	}
+
+	return &redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// redact returns s without any of the sensitive values.
+func (r *redactor) redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	return r.replacer.Replace(s)
+}
+
+// redactReport scrubs the sensitive values from the findings, including the
+// waived ones, from the errors of the rules and from the warnings of the
+// report.
+func (r *redactor) redactReport(report *Report) {
+	if r == nil || report == nil {
+		return
+	}
+
+	for i := range report.Results {
+		res := &report.Results[i]
+
+		for j := range res.Findings {
+			r.redactFinding(&res.Findings[j])
+		}
+
+		for j := range res.Waived {
+			r.redactFinding(&res.Waived[j].Finding)
+		}
+
+		if res.Err != nil {
+			if msg := r.redact(res.Err.Error()); msg != res.Err.Error() {
+				res.Err = &redactedError{msg: msg, err: res.Err}
+			}
+		}
+	}
+
+	for i := range report.Warnings {
+		report.Warnings[i] = r.redact(report.Warnings[i])
+	}
+}
+
+// redactFinding scrubs the sensitive values from all the texts of a finding
+// that can be set by the scripts.
+func (r *redactor) redactFinding(f *Finding) {
+	f.RuleID = r.redact(f.RuleID)
+	f.Address = r.redact(f.Address)
+	f.Message = r.redact(f.Message)
+	f.AttributePath = r.redact(f.AttributePath)
+}
+
+// redactedError is an error whose message was scrubbed of sensitive values.
+// It still wraps the original error, so that errors.Is and errors.As keep
+// working.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,102 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/xerrors"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		s      string
+		want   string
+	}{
+		{
+			name:   "no values",
+			values: nil,
+			s:      "password is hunter2",
+			want:   "password is hunter2",
+		},
+		{
+			name:   "value",
+			values: []string{"hunter2"},
+			s:      "password is hunter2, again hunter2",
+			want:   "password is (sensitive value), again (sensitive value)",
+		},
+		{
+			name:   "overlapping values",
+			values: []string{"hunter", "hunter2"},
+			s:      "password is hunter2",
+			want:   "password is (sensitive value)",
+		},
+		{
+			name:   "short values",
+			values: []string{"1", "no", "", "yes"},
+			s:      "1 user said no",
+			want:   "1 user said no",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, newRedactor(tt.values).redact(tt.s))
+		})
+	}
+}
+
+func TestRedactor_RedactReport(t *testing.T) {
+	report := &Report{
+		Results: []RuleResult{
+			{
+				Status: RuleStatusFail,
+				Findings: []Finding{{
+					RuleID:        "no-s3cr3t",
+					Address:       `null_resource.token["s3cr3t"]`,
+					Message:       "token s3cr3t is exposed",
+					AttributePath: `tags["s3cr3t"]`,
+				}},
+				Waived: []WaivedFinding{{
+					Finding: Finding{Address: `null_resource.key["s3cr3t"]`, Message: "key s3cr3t is exposed"},
+				}},
+			},
+			{
+				Status: RuleStatusError,
+				Err:    xerrors.Errorf("failed with s3cr3t: %w", ErrLimitExceeded),
+			},
+			{
+				Status: RuleStatusError,
+				Err:    ErrRuleErrored,
+			},
+		},
+		Warnings: []string{"warning about s3cr3t"},
+	}
+
+	newRedactor([]string{"s3cr3t"}).redactReport(report)
+
+	assert.Equal(t, "token (sensitive value) is exposed", report.Results[0].Findings[0].Message)
+	assert.Equal(t, `tags["(sensitive value)"]`, report.Results[0].Findings[0].AttributePath)
+	assert.Equal(t, `null_resource.token["(sensitive value)"]`, report.Results[0].Findings[0].Address)
+	assert.Equal(t, "no-(sensitive value)", report.Results[0].Findings[0].RuleID)
+	assert.Equal(t, `null_resource.key["(sensitive value)"]`, report.Results[0].Waived[0].Address)
+	assert.Equal(t, "key (sensitive value) is exposed", report.Results[0].Waived[0].Message)
+	assert.Equal(t, "failed with (sensitive value): execution limit exceeded", report.Results[1].Err.Error())
+	assert.ErrorIs(t, report.Results[1].Err, ErrLimitExceeded)
+	assert.Equal(t, ErrRuleErrored, report.Results[2].Err)
+	assert.Equal(t, []string{"warning about (sensitive value)"}, report.Warnings)
+}
@@ -0,0 +1,314 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"context"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/xerrors"
+)
+
+// DefaultRuleName is the name of the rule running Options.Script.
+const DefaultRuleName = "main"
+
+// Rule is a validation script with its metadata.
+//
+// The script of a rule follows the same conventions as Options.Script, and
+// the findings it returns default to the rule name and severity.
+type Rule struct {
+	// Name identifies the rule. It must be unique.
+	Name string
+	// Description explains what the rule checks.
+	Description string
+	// Severity is the default severity of the findings of the rule. It
+	// defaults to SeverityError.
+	Severity Severity
+	// Enforcement indicates how the failure of the rule affects the outcome
+	// of the validation. It defaults to EnforcementHardMandatory.
+	Enforcement Enforcement
+	// Tags can be used to classify the rules.
+	Tags []string
+	// Params are the parameters of the rule, exposed to its script by the
+	// read-only 'params' global table.
+	Params []Param
+	// Script is the Lua body of the rule.
+	Script string
+}
+
+// RuleStatus is the outcome of the execution of a rule.
+type RuleStatus string
+
+const (
+	// RuleStatusPass indicates that the rule did not report any error.
+	RuleStatusPass RuleStatus = "pass"
+	// RuleStatusFail indicates that the rule reported at least one finding
+	// with the error severity.
+	RuleStatusFail RuleStatus = "fail"
+	// RuleStatusError indicates that the rule could not be executed.
+	RuleStatusError RuleStatus = "error"
+	// RuleStatusWarn indicates that an advisory rule reported at least one
+	// finding with the error severity.
+	RuleStatusWarn RuleStatus = "warn"
+	// RuleStatusOverridden indicates that a soft-mandatory rule reported at
+	// least one finding with the error severity, but was overridden.
+	RuleStatusOverridden RuleStatus = "overridden"
+)
+
+// RuleResult is the result of the execution of a rule.
+type RuleResult struct {
+	Rule     Rule
+	Status   RuleStatus
+	Findings []Finding
+	// Waived are the findings waived by one of the waivers. They are not
+	// taken into account by Status.
+	Waived []WaivedFinding
+	// Override is the override of the rule when Status is
+	// RuleStatusOverridden.
+	Override *Override
+	// Err is the error that aborted the rule when Status is RuleStatusError.
+	Err error
+}
+
+// Report holds the results of the rules run by a validation.
+type Report struct {
+	Results []RuleResult
+	// Warnings are the problems that did not prevent the validation, e.g.
+	// a plan file created by an unsupported Terraform release.
+	Warnings []string
+	// ExpiredWaivers are the waivers that expired, and no longer apply.
+	ExpiredWaivers []Waiver
+}
+
+// Findings returns the findings of all the rules.
+func (r *Report) Findings() []Finding {
+	var findings []Finding
+
+	for _, res := range r.Results {
+		findings = append(findings, res.Findings...)
+	}
+
+	return findings
+}
+
+// Waived returns the findings of all the rules waived by one of the
+// waivers.
+func (r *Report) Waived() []WaivedFinding {
+	var waived []WaivedFinding
+
+	for _, res := range r.Results {
+		waived = append(waived, res.Waived...)
+	}
+
+	return waived
+}
+
+// Failed reports whether at least one of the rules failed. The advisory
+// rules and the overridden ones do not make the validation fail.
+func (r *Report) Failed() bool {
+	return r.hasStatus(RuleStatusFail)
+}
+
+// Errored reports whether at least one of the rules could not be executed.
+func (r *Report) Errored() bool {
+	return r.hasStatus(RuleStatusError)
+}
+
+func (r *Report) limitExceeded() bool {
+	for _, res := range r.Results {
+		if xerrors.Is(res.Err, ErrLimitExceeded) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Report) hasStatus(status RuleStatus) bool {
+	for _, res := range r.Results {
+		if res.Status == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// err returns the error summarizing the outcome of the validation.
+func (r *Report) err() error {
+	switch {
+	case r.limitExceeded():
+		return ErrLimitExceeded
+	case r.Errored():
+		return ErrRuleErrored
+	case r.Failed():
+		return ErrValidationFailed
+	case len(r.ExpiredWaivers) > 0:
+		return ErrWaiverExpired
+	default:
+		return nil
+	}
+}
+
+// rules returns the rules configured in the options, including the one
+// running Options.Script if set.
+func (o *Options) rules() ([]Rule, error) {
+	rules := make([]Rule, 0, len(o.Rules)+1)
+
+	if o.Script != "" {
+		rules = append(rules, Rule{Name: DefaultRuleName, Script: o.Script})
+	}
+
+	rules = append(rules, o.Rules...)
+
+	names := make(map[string]struct{}, len(rules))
+
+	for i := range rules {
+		r := &rules[i]
+
+		if r.Name == "" {
+			return nil, xerrors.Errorf("rule #%d has no name", i+1)
+		}
+
+		if _, ok := names[r.Name]; ok {
+			return nil, xerrors.Errorf("duplicate rule '%s'", r.Name)
+		}
+
+		names[r.Name] = struct{}{}
+
+		if r.Severity == "" {
+			r.Severity = SeverityError
+		} else {
+			sev, err := ParseSeverity(string(r.Severity))
+			if err != nil {
+				return nil, xerrors.Errorf("invalid rule '%s': %w", r.Name, err)
+			}
+
+			r.Severity = sev
+		}
+
+		if r.Enforcement == "" {
+			r.Enforcement = EnforcementHardMandatory
+		} else {
+			enforcement, err := ParseEnforcement(string(r.Enforcement))
+			if err != nil {
+				return nil, xerrors.Errorf("invalid rule '%s': %w", r.Name, err)
+			}
+
+			r.Enforcement = enforcement
+		}
+
+		if err := validateParams(r.Params); err != nil {
+			return nil, xerrors.Errorf("invalid rule '%s': %w", r.Name, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// compiledRule is a rule with its compiled script.
+type compiledRule struct {
+	Rule
+	proto  *lua.FunctionProto
+	params map[string]interface{}
+}
+
+// run executes the rule in its own global environment.
+// The execution is interrupted when the context is done or when the timeout,
+// if not zero, expires.
+func (r *compiledRule) run(
+	ctx context.Context,
+	ls *lua.LState,
+	globals *lua.LTable,
+	timeout time.Duration,
+	eval func(lua.LValue) ([]Finding, error),
+) RuleResult {
+	result := RuleResult{Rule: r.Rule}
+
+	ruleCtx := ctx
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ruleCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ls.SetContext(ruleCtx)
+	defer ls.RemoveContext()
+
+	// Each rule gets its own global environment, falling back to the shared
+	// one, so that the globals defined by a rule are not visible to others.
+	env := ls.NewTable()
+	meta := ls.NewTable()
+	meta.RawSetString("__index", globals)
+	ls.SetMetatable(env, meta)
+	env.RawSetString(ParamsGlobalName, lParams(ls, r.params))
+
+	fn := ls.NewFunctionFromProto(r.proto)
+	fn.Env = env
+
+	limits := &limitProbe{}
+
+	if err := ls.CallByParam(lua.P{Fn: limits.protect(ls, fn), NRet: 1, Protect: true}); err != nil {
+		result.Status = RuleStatusError
+
+		switch {
+		case ctx.Err() != nil:
+			result.Err = xerrors.Errorf("rule '%s' was interrupted: %w", r.Name, ctx.Err())
+		case ruleCtx.Err() != nil:
+			result.Err = xerrors.Errorf("rule '%s' exceeded its execution time of %v: %w", r.Name, timeout, ErrLimitExceeded)
+		case limits.callStack:
+			result.Err = xerrors.Errorf("rule '%s' exceeded the call stack size: %w", r.Name, ErrLimitExceeded)
+		case limits.registry:
+			result.Err = xerrors.Errorf("rule '%s' exceeded the registry size: %w", r.Name, ErrLimitExceeded)
+		default:
+			result.Err = xerrors.Errorf("failed to run rule '%s': %w", r.Name, err)
+		}
+
+		return result
+	}
+
+	ret := ls.Get(-1)
+	ls.Pop(1)
+
+	findings, err := eval(ret)
+	if err != nil {
+		result.Status = RuleStatusError
+		result.Err = xerrors.Errorf("invalid result for rule '%s': %w", r.Name, err)
+
+		return result
+	}
+
+	for i := range findings {
+		if findings[i].RuleID == "" {
+			findings[i].RuleID = r.Name
+		}
+
+		if findings[i].Severity == "" {
+			findings[i].Severity = r.Severity
+		}
+	}
+
+	result.Findings = findings
+	result.Status = RuleStatusPass
+
+	if hasErrors(findings) {
+		result.Status = RuleStatusFail
+	}
+
+	return result
+}
@@ -0,0 +1,163 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_Rules(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{
+			name: "valid rules",
+			options: Options{
+				Script: `return true`,
+				Rules: []Rule{
+					{Name: "r1", Script: `return true`},
+					{Name: "r2", Severity: "Warning", Script: `return true`},
+				},
+			},
+		},
+		{
+			name: "missing name",
+			options: Options{
+				Rules: []Rule{{Script: `return true`}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate name",
+			options: Options{
+				Script: `return true`,
+				Rules:  []Rule{{Name: DefaultRuleName, Script: `return true`}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid severity",
+			options: Options{
+				Rules: []Rule{{Name: "r1", Severity: "fatal", Script: `return true`}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid syntax",
+			options: Options{
+				Rules: []Rule{{Name: "r1", Script: `definitely not lua code`}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(&tt.options)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWarden_ValidatePlan_Rules(t *testing.T) {
+	w, err := New(&Options{
+		Rules: []Rule{
+			{
+				Name:        "passing",
+				Description: "always passes",
+				Tags:        []string{"test"},
+				Script:      `leaked = true return true`,
+			},
+			{
+				Name:   "erroring",
+				Script: `error("boom")`,
+			},
+			{
+				Name:   "failing",
+				Script: `return { "first", { message = "second", severity = "info" } }`,
+			},
+			{
+				Name:     "warning",
+				Severity: SeverityWarning,
+				Script:   `if leaked then return "globals leaked between rules" end return "only a warning"`,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	planFile, err := afero.NewReadOnlyFs(afero.NewOsFs()).Open(getTestDataPath(t, "tf-planfile"))
+	require.NoError(t, err)
+
+	report, err := w.ValidatePlan(context.Background(), planFile)
+	_ = planFile.Close()
+
+	assert.ErrorIs(t, err, ErrRuleErrored)
+	require.NotNil(t, report)
+	require.Len(t, report.Results, 4)
+
+	assert.True(t, report.Failed())
+	assert.True(t, report.Errored())
+
+	passing := report.Results[0]
+	assert.Equal(t, RuleStatusPass, passing.Status)
+	assert.Equal(t, "always passes", passing.Rule.Description)
+	assert.Equal(t, []string{"test"}, passing.Rule.Tags)
+	assert.Empty(t, passing.Findings)
+
+	erroring := report.Results[1]
+	assert.Equal(t, RuleStatusError, erroring.Status)
+	assert.Error(t, erroring.Err)
+	assert.Empty(t, erroring.Findings)
+
+	failing := report.Results[2]
+	assert.Equal(t, RuleStatusFail, failing.Status)
+	assert.Equal(t, []Finding{
+		{RuleID: "failing", Severity: SeverityError, Message: "first"},
+		{RuleID: "failing", Severity: SeverityInfo, Message: "second"},
+	}, failing.Findings)
+
+	warning := report.Results[3]
+	assert.Equal(t, RuleStatusPass, warning.Status)
+	assert.Equal(t, []Finding{
+		{RuleID: "warning", Severity: SeverityWarning, Message: "only a warning"},
+	}, warning.Findings)
+}
+
+func TestWarden_ValidatePlan_RulesWarningsOnly(t *testing.T) {
+	w, err := New(&Options{
+		Rules: []Rule{{Name: "warning", Severity: SeverityWarning, Script: `return "careful"`}},
+	})
+	require.NoError(t, err)
+
+	planFile, err := afero.NewReadOnlyFs(afero.NewOsFs()).Open(getTestDataPath(t, "tf-planfile"))
+	require.NoError(t, err)
+
+	report, err := w.ValidatePlan(context.Background(), planFile)
+	_ = planFile.Close()
+
+	assert.NoError(t, err)
+	assert.False(t, report.Failed())
+	assert.Len(t, report.Findings(), 1)
+}
@@ -0,0 +1,990 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/configs/configload"
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/terraform/plans/planfile"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
+	"github.com/hexbee-net/horus/pkg/terraform/states/statefile"
+)
+
+// PlanBuilder builds synthetic plan files, with chosen resource changes,
+// output changes, variables and configuration, e.g. to test policies
+// without running Terraform:
+//
+//	b := terraform.NewPlanBuilder()
+//	b.ConfigFile("main.tf", `resource "aws_s3_bucket" "logs" {}`)
+//	b.ResourceChange("aws_s3_bucket.logs", plans.Create).
+//		After(cty.ObjectVal(map[string]cty.Value{"acl": cty.StringVal("public-read")}))
+//
+//	planFile, err := b.Build()
+//
+// The prior state holds the objects and output values before their change,
+// and the previous run state is the same but for the objects given a
+// previous run value, which are then reported as drift.
+//
+// The values are encoded with their own type, like those of JSON plans, so
+// they can be decoded either with the provider schemas or without schema.
+type PlanBuilder struct {
+	mode      plans.Mode
+	variables map[string]cty.Value
+	resources []*ResourceChangeBuilder
+	outputs   []*OutputChangeBuilder
+	// modules holds the configuration files of the modules, indexed by
+	// module key and file name.
+	modules map[string]map[string][]byte
+}
+
+// NewPlanBuilder returns a builder of an empty plan, in normal mode.
+func NewPlanBuilder() *PlanBuilder {
+	return &PlanBuilder{
+		mode:      plans.NormalMode,
+		variables: map[string]cty.Value{},
+		modules:   map[string]map[string][]byte{"": {}},
+	}
+}
+
+// Mode sets the mode of the plan.
+func (b *PlanBuilder) Mode(mode plans.Mode) *PlanBuilder {
+	b.mode = mode
+
+	return b
+}
+
+// Variable sets the value of a root module variable.
+func (b *PlanBuilder) Variable(name string, v cty.Value) *PlanBuilder {
+	b.variables[name] = v
+
+	return b
+}
+
+// ConfigFile adds a configuration file to the root module.
+func (b *PlanBuilder) ConfigFile(filename string, src string) *PlanBuilder {
+	return b.ModuleConfigFile("", filename, src)
+}
+
+// ModuleConfigFile adds a configuration file to the module with the given
+// key, e.g. 'network' or 'network.subnets' for a nested module. The module
+// is stored in the 'modules/<name>' directory of its parent, which must call
+// it with that source, e.g. './modules/network'.
+func (b *PlanBuilder) ModuleConfigFile(module string, filename string, src string) *PlanBuilder {
+	if b.modules[module] == nil {
+		b.modules[module] = map[string][]byte{}
+	}
+
+	b.modules[module][filename] = []byte(src)
+
+	return b
+}
+
+// ResourceChange adds the change of a resource instance, with the given
+// address and action, and returns its builder. Its values are null until
+// set.
+func (b *PlanBuilder) ResourceChange(address string, action plans.Action) *ResourceChangeBuilder {
+	rb := &ResourceChangeBuilder{
+		address: address,
+		action:  action,
+		before:  cty.NullVal(cty.DynamicPseudoType),
+		after:   cty.NullVal(cty.DynamicPseudoType),
+	}
+
+	b.resources = append(b.resources, rb)
+
+	return rb
+}
+
+// OutputChange adds the change of a root module output value, with the
+// given name and action, and returns its builder. Its values are null until
+// set.
+func (b *PlanBuilder) OutputChange(name string, action plans.Action) *OutputChangeBuilder {
+	ob := &OutputChangeBuilder{
+		name:   name,
+		action: action,
+		before: cty.NullVal(cty.DynamicPseudoType),
+		after:  cty.NullVal(cty.DynamicPseudoType),
+	}
+
+	b.outputs = append(b.outputs, ob)
+
+	return ob
+}
+
+// Build returns the plan file, as loaded by LoadPlanFile.
+func (b *PlanBuilder) Build() (*PlanFile, error) {
+	planFile, _, err := b.build()
+
+	return planFile, err
+}
+
+// Create writes the plan file in the binary format of 'terraform plan -out'
+// to filename.
+func (b *PlanBuilder) Create(filename string) error {
+	planFile, snap, err := b.build()
+	if err != nil {
+		return err
+	}
+
+	if err := planfile.Create(filename, snap, planFile.PrevState, planFile.State, planFile.Plan); err != nil {
+		return xerrors.Errorf("failed to write plan file: %w", err)
+	}
+
+	return nil
+}
+
+func (b *PlanBuilder) build() (*PlanFile, *configload.Snapshot, error) {
+	snap := b.snapshot()
+
+	config, diags := configload.NewLoaderFromSnapshot(snap).LoadConfig(snap.Modules[""].Dir)
+	if diags.HasErrors() {
+		return nil, nil, xerrors.Errorf("failed to load configuration: %w", diags)
+	}
+
+	prior := states.NewState()
+	details := &PlanDetails{FormatVersion: maxTfplanFormatVersion}
+
+	plan := &plans.Plan{
+		UIMode:          b.mode,
+		VariableValues:  make(map[string]plans.DynamicValue, len(b.variables)),
+		Changes:         plans.NewChanges(),
+		ProviderSHA256s: map[string][]byte{},
+	}
+
+	for name, v := range b.variables {
+		val, err := plans.NewDynamicValue(unmarked(v), cty.DynamicPseudoType)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to encode the value of variable '%s': %w", name, err)
+		}
+
+		plan.VariableValues[name] = val
+	}
+
+	for _, rb := range b.resources {
+		rc, rcDetails, err := rb.build(prior)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to build the change of %s: %w", rb.address, err)
+		}
+
+		plan.Changes.Resources = append(plan.Changes.Resources, rc)
+		details.setResourceInstance(rc.Addr, rc.DeposedKey, rcDetails)
+	}
+
+	for _, ob := range b.outputs {
+		oc, err := ob.build(prior)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to build the change of output '%s': %w", ob.name, err)
+		}
+
+		plan.Changes.Outputs = append(plan.Changes.Outputs, oc)
+	}
+
+	prev := prior.DeepCopy()
+
+	for _, rb := range b.resources {
+		if err := rb.buildPrevRun(prev); err != nil {
+			return nil, nil, xerrors.Errorf("failed to build the previous run object of %s: %w", rb.address, err)
+		}
+	}
+
+	backend, err := localBackend()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan.Backend = backend
+	plan.PriorState = prior
+	plan.PrevRunState = prev
+
+	stateFile := statefile.New(prior, "", 0)
+	details.TerraformVersion = stateFile.TerraformVersion.String()
+
+	return &PlanFile{
+		Plan:      plan,
+		State:     stateFile,
+		PrevState: statefile.New(prev, "", 0),
+		Config:    config,
+		Details:   details,
+	}, snap, nil
+}
+
+// snapshot returns the snapshot of the configuration files.
+func (b *PlanBuilder) snapshot() *configload.Snapshot {
+	snap := &configload.Snapshot{
+		Modules: make(map[string]*configload.SnapshotModule, len(b.modules)),
+	}
+
+	for key, files := range b.modules {
+		mod := &configload.SnapshotModule{
+			Dir:   ".",
+			Files: files,
+		}
+
+		if key != "" {
+			names := strings.Split(key, ".")
+			dirs := make([]string, 0, 2*len(names)) //nolint:gomnd // two elements per module
+
+			for _, name := range names {
+				dirs = append(dirs, "modules", name)
+			}
+
+			mod.Dir = path.Join(dirs...)
+			mod.SourceAddr = "./modules/" + names[len(names)-1]
+		}
+
+		snap.Modules[key] = mod
+	}
+
+	return snap
+}
+
+// localBackend returns the configuration of the default local backend.
+func localBackend() (plans.Backend, error) {
+	config := cty.ObjectVal(map[string]cty.Value{
+		"path": cty.NullVal(cty.String),
+	})
+
+	val, err := plans.NewDynamicValue(config, config.Type())
+	if err != nil {
+		return plans.Backend{}, xerrors.Errorf("failed to encode the backend configuration: %w", err)
+	}
+
+	return plans.Backend{Type: "local", Config: val, Workspace: "default"}, nil
+}
+
+// ResourceChangeBuilder builds the change of a resource instance.
+type ResourceChangeBuilder struct {
+	address   string
+	action    plans.Action
+	provider  string
+	deposed   string
+	before    cty.Value
+	after     cty.Value
+	prevRun   *cty.Value
+	sensitive []cty.Path
+	reason    string
+	replace   []cty.Path
+}
+
+// Provider sets the source address of the provider of the resource, e.g.
+// 'hashicorp/aws'. It defaults to the provider implied by the resource type.
+func (rb *ResourceChangeBuilder) Provider(source string) *ResourceChangeBuilder {
+	rb.provider = source
+
+	return rb
+}
+
+// Deposed sets the deposed key of the changed object.
+func (rb *ResourceChangeBuilder) Deposed(key string) *ResourceChangeBuilder {
+	rb.deposed = key
+
+	return rb
+}
+
+// Before sets the value before the change. A non-null value is also the
+// value of the object in the prior state.
+func (rb *ResourceChangeBuilder) Before(v cty.Value) *ResourceChangeBuilder {
+	rb.before = v
+
+	return rb
+}
+
+// After sets the planned value.
+func (rb *ResourceChangeBuilder) After(v cty.Value) *ResourceChangeBuilder {
+	rb.after = v
+
+	return rb
+}
+
+// PrevRun sets the value of the object in the previous run state, which
+// defaults to the value before the change. A null value means that the
+// object did not exist.
+func (rb *ResourceChangeBuilder) PrevRun(v cty.Value) *ResourceChangeBuilder {
+	rb.prevRun = &v
+
+	return rb
+}
+
+// Sensitive marks the values at the given paths as sensitive, in every
+// value of the change which has them.
+func (rb *ResourceChangeBuilder) Sensitive(paths ...cty.Path) *ResourceChangeBuilder {
+	rb.sensitive = append(rb.sensitive, paths...)
+
+	return rb
+}
+
+// ActionReason sets the reason of the action, e.g. 'replace_because_tainted'.
+func (rb *ResourceChangeBuilder) ActionReason(reason string) *ResourceChangeBuilder {
+	rb.reason = reason
+
+	return rb
+}
+
+// RequiredReplace sets the paths of the attributes whose change requires
+// the replacement of the object.
+func (rb *ResourceChangeBuilder) RequiredReplace(paths ...cty.Path) *ResourceChangeBuilder {
+	rb.replace = append(rb.replace, paths...)
+
+	return rb
+}
+
+// build returns the change and its details, and adds the object before the
+// change to the prior state.
+func (rb *ResourceChangeBuilder) build(prior *states.State) (*plans.ResourceInstanceChangeSrc, *ResourceInstanceDetails, error) {
+	addr, providerAddr, err := rb.addresses()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	before, err := markPaths(rb.before, rb.sensitive)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	after, err := markPaths(rb.after, rb.sensitive)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	change, err := changeSrc(rb.action, before, after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := setStateObject(prior, addr, states.DeposedKey(rb.deposed), providerAddr, before); err != nil {
+		return nil, nil, err
+	}
+
+	details := &ResourceInstanceDetails{
+		PrevRunAddr:  addr,
+		ActionReason: rb.reason,
+	}
+
+	return &plans.ResourceInstanceChangeSrc{
+		Addr:         addr,
+		DeposedKey:   states.DeposedKey(rb.deposed),
+		ProviderAddr: providerAddr,
+		ChangeSrc:    *change,
+		// The reasons introduced after the vendored Terraform release are
+		// only kept in the details.
+		ActionReason:    actionReasons[rb.reason],
+		RequiredReplace: cty.NewPathSet(rb.replace...),
+	}, details, nil
+}
+
+// buildPrevRun sets the object in the previous run state, if its value was
+// set.
+func (rb *ResourceChangeBuilder) buildPrevRun(prev *states.State) error {
+	if rb.prevRun == nil {
+		return nil
+	}
+
+	addr, providerAddr, err := rb.addresses()
+	if err != nil {
+		return err
+	}
+
+	v, err := markPaths(*rb.prevRun, rb.sensitive)
+	if err != nil {
+		return err
+	}
+
+	module := prev.EnsureModule(addr.Module)
+
+	if v.IsNull() {
+		if rb.deposed != "" {
+			module.ForgetResourceInstanceDeposed(addr.Resource, states.DeposedKey(rb.deposed))
+		} else {
+			module.ForgetResourceInstanceAll(addr.Resource)
+		}
+
+		return nil
+	}
+
+	return setStateObject(prev, addr, states.DeposedKey(rb.deposed), providerAddr, v)
+}
+
+// addresses returns the address of the resource instance and of its
+// provider configuration.
+func (rb *ResourceChangeBuilder) addresses() (addrs.AbsResourceInstance, addrs.AbsProviderConfig, error) {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rb.address)
+	if diags.HasErrors() {
+		return addrs.AbsResourceInstance{}, addrs.AbsProviderConfig{}, xerrors.Errorf("invalid address: %w", diags.Err())
+	}
+
+	provider := addrs.NewDefaultProvider(addr.Resource.Resource.ImpliedProvider())
+
+	if rb.provider != "" {
+		if provider, diags = addrs.ParseProviderSourceString(rb.provider); diags.HasErrors() {
+			return addrs.AbsResourceInstance{}, addrs.AbsProviderConfig{}, xerrors.Errorf("invalid provider '%s': %w", rb.provider, diags.Err())
+		}
+	}
+
+	return addr, addrs.AbsProviderConfig{Module: addr.Module.Module(), Provider: provider}, nil
+}
+
+// setStateObject sets the object of a resource instance in the state,
+// unless its value is null.
+func setStateObject(
+	state *states.State,
+	addr addrs.AbsResourceInstance,
+	deposed states.DeposedKey,
+	providerAddr addrs.AbsProviderConfig,
+	v cty.Value,
+) error {
+	if v.IsNull() {
+		return nil
+	}
+
+	obj, err := (&states.ResourceInstanceObject{Value: v, Status: states.ObjectReady}).Encode(v.Type(), 0)
+	if err != nil {
+		return xerrors.Errorf("failed to encode the state object: %w", err)
+	}
+
+	module := state.EnsureModule(addr.Module)
+
+	if deposed != states.NotDeposed {
+		module.SetResourceInstanceDeposed(addr.Resource, deposed, obj, providerAddr)
+	} else {
+		module.SetResourceInstanceCurrent(addr.Resource, obj, providerAddr)
+	}
+
+	return nil
+}
+
+// OutputChangeBuilder builds the change of a root module output value.
+type OutputChangeBuilder struct {
+	name      string
+	action    plans.Action
+	before    cty.Value
+	after     cty.Value
+	sensitive bool
+}
+
+// Before sets the value before the change. A non-null value is also the
+// value of the output in the prior state.
+func (ob *OutputChangeBuilder) Before(v cty.Value) *OutputChangeBuilder {
+	ob.before = v
+
+	return ob
+}
+
+// After sets the planned value.
+func (ob *OutputChangeBuilder) After(v cty.Value) *OutputChangeBuilder {
+	ob.after = v
+
+	return ob
+}
+
+// Sensitive marks the output value as sensitive.
+func (ob *OutputChangeBuilder) Sensitive() *OutputChangeBuilder {
+	ob.sensitive = true
+
+	return ob
+}
+
+// build returns the change, and adds the value before the change to the
+// prior state.
+func (ob *OutputChangeBuilder) build(prior *states.State) (*plans.OutputChangeSrc, error) {
+	// Output values are sensitive as a whole.
+	sensitive := ob.sensitive || ob.before.ContainsMarked() || ob.after.ContainsMarked()
+	before, after := unmarked(ob.before), unmarked(ob.after)
+
+	change := &plans.ChangeSrc{Action: ob.action}
+
+	var err error
+
+	// Like in binary plans, the values of output changes are encoded with
+	// their type, since there is no schema to decode them with.
+	if change.Before, err = plans.NewDynamicValue(before, cty.DynamicPseudoType); err != nil {
+		return nil, xerrors.Errorf("failed to encode the prior value: %w", err)
+	}
+
+	if change.After, err = plans.NewDynamicValue(after, cty.DynamicPseudoType); err != nil {
+		return nil, xerrors.Errorf("failed to encode the planned value: %w", err)
+	}
+
+	if !before.IsNull() {
+		prior.RootModule().SetOutputValue(ob.name, before, sensitive)
+	}
+
+	return &plans.OutputChangeSrc{
+		Addr:      addrs.OutputValue{Name: ob.name}.Absolute(addrs.RootModuleInstance),
+		ChangeSrc: *change,
+		Sensitive: sensitive,
+	}, nil
+}
+
+// changeSrc encodes a change. The values are encoded with their own types:
+// the decoding of the change can then either infer them, or use the
+// provider schema.
+func changeSrc(action plans.Action, before cty.Value, after cty.Value) (*plans.ChangeSrc, error) {
+	before, beforeMarks := before.UnmarkDeepWithPaths()
+	after, afterMarks := after.UnmarkDeepWithPaths()
+
+	ret := &plans.ChangeSrc{
+		Action:         action,
+		BeforeValMarks: beforeMarks,
+		AfterValMarks:  afterMarks,
+	}
+
+	var err error
+
+	if ret.Before, err = plans.NewDynamicValue(before, before.Type()); err != nil {
+		return nil, xerrors.Errorf("failed to encode the prior value: %w", err)
+	}
+
+	if ret.After, err = plans.NewDynamicValue(after, after.Type()); err != nil {
+		return nil, xerrors.Errorf("failed to encode the planned value: %w", err)
+	}
+
+	return ret, nil
+}
+
+// markPaths marks the values of v at the given paths as sensitive. The
+// paths that v does not have are ignored.
+func markPaths(v cty.Value, paths []cty.Path) (cty.Value, error) {
+	if len(paths) == 0 {
+		return v, nil
+	}
+
+	set := cty.NewPathSet(paths...)
+
+	ret, err := cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+		if set.Has(p) {
+			return v.Mark(sensitiveMark), nil
+		}
+
+		return v, nil
+	})
+	if err != nil {
+		return cty.NilVal, xerrors.Errorf("failed to mark sensitive values: %w", err)
+	}
+
+	return ret, nil
+}
+
+// ParsePath parses an attribute path in an HCL-like syntax, e.g.
+// 'ebs_block_device[0].tags', as returned by Ambiguity.PathString.
+func ParsePath(s string) (cty.Path, error) {
+	traversal, diags := hclsyntax.ParseTraversalAbs([]byte(s), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, xerrors.Errorf("invalid path '%s': %w", s, diags)
+	}
+
+	ret := make(cty.Path, 0, len(traversal))
+
+	for _, step := range traversal {
+		switch t := step.(type) {
+		case hcl.TraverseRoot:
+			ret = ret.GetAttr(t.Name)
+		case hcl.TraverseAttr:
+			ret = ret.GetAttr(t.Name)
+		case hcl.TraverseIndex:
+			ret = ret.Index(t.Key)
+		default:
+			return nil, xerrors.Errorf("invalid path '%s'", s)
+		}
+	}
+
+	return ret, nil
+}
+
+// parseActionName parses the name of an action, as returned by the 'action'
+// methods. 'replace' is parsed as the replacement deleting the object
+// before creating the new one.
+func parseActionName(name string) (plans.Action, error) {
+	actions := []plans.Action{
+		plans.NoOp, plans.Create, plans.Read, plans.Update, plans.DeleteThenCreate, plans.Delete, actionForget,
+	}
+
+	for _, action := range actions {
+		if actionName(action) == name {
+			return action, nil
+		}
+	}
+
+	return plans.NoOp, xerrors.Errorf("unknown action '%s'", name)
+}
+
+// parseModeName parses the name of a plan mode: 'normal', 'destroy' or
+// 'refresh-only'.
+func parseModeName(name string) (plans.Mode, error) {
+	switch name {
+	case "normal":
+		return plans.NormalMode, nil
+	case "destroy":
+		return plans.DestroyMode, nil
+	case "refresh-only":
+		return plans.RefreshOnlyMode, nil
+	default:
+		return plans.NormalMode, xerrors.Errorf("unknown plan mode '%s'", name)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Lua Utilities
+
+const luaPlanBuilderTypeName = "planBuilder"
+
+const (
+	luaFunctionPlanBuilderMode         = "mode"
+	luaFunctionPlanBuilderVariable     = "variable"
+	luaFunctionPlanBuilderConfig       = "config"
+	luaFunctionPlanBuilderModuleConfig = "moduleConfig"
+	luaFunctionPlanBuilderResource     = "resource"
+	luaFunctionPlanBuilderOutput       = "output"
+)
+
+// RegisterPlanBuilderType registers the PlanBuilder type inside the Lua
+// state. Its methods return the builder, so that calls can be chained:
+//
+//	plan_builder()
+//	    :config("main.tf", 'resource "aws_s3_bucket" "logs" {}')
+//	    :resource("aws_s3_bucket.logs", "create", {after = {acl = "public-read"}})
+//
+// The options of 'resource' are 'before', 'after', 'prevRun', 'provider',
+// 'deposed', 'sensitive' (a list of attribute paths), 'actionReason' and
+// 'replacePaths' (a list of attribute paths). The options of 'output' are
+// 'before', 'after' and 'sensitive' (a boolean).
+func RegisterPlanBuilderType(ls *lua.LState) {
+	var methods = map[string]lua.LGFunction{
+		luaFunctionPlanBuilderMode:         planBuilderMode,
+		luaFunctionPlanBuilderVariable:     planBuilderVariable,
+		luaFunctionPlanBuilderConfig:       planBuilderConfig,
+		luaFunctionPlanBuilderModuleConfig: planBuilderModuleConfig,
+		luaFunctionPlanBuilderResource:     planBuilderResource,
+		luaFunctionPlanBuilderOutput:       planBuilderOutput,
+	}
+
+	mt := ls.NewTypeMetatable(luaPlanBuilderTypeName)
+	ls.SetGlobal(luaPlanBuilderTypeName, mt)
+
+	// methods
+	ls.SetField(mt, "__index", ls.SetFuncs(ls.NewTable(), methods))
+}
+
+// LPlanBuilder creates a new planBuilder userdata.
+func LPlanBuilder(ls *lua.LState, b *PlanBuilder) *lua.LUserData {
+	ud := ls.NewUserData()
+	ud.Value = b
+	ls.SetMetatable(ud, ls.GetTypeMetatable(luaPlanBuilderTypeName))
+
+	return ud
+}
+
+// CheckPlanBuilder checks whether the first lua argument is a *LUserData
+// with *PlanBuilder and returns this *PlanBuilder.
+func CheckPlanBuilder(ls *lua.LState) (*PlanBuilder, error) {
+	ud := ls.CheckUserData(1)
+	if v, ok := ud.Value.(*PlanBuilder); ok {
+		return v, nil
+	}
+
+	ls.ArgError(1, fmt.Sprintf("%s expected", luaPlanBuilderTypeName))
+
+	return nil, ErrInvalidType
+}
+
+// AsPlanBuilder returns the PlanBuilder of lv, if it is a planBuilder
+// userdata.
+func AsPlanBuilder(lv lua.LValue) (*PlanBuilder, bool) {
+	ud, ok := lv.(*lua.LUserData)
+	if !ok {
+		return nil, false
+	}
+
+	b, ok := ud.Value.(*PlanBuilder)
+
+	return b, ok
+}
+
+// setLuaOption sets an option of the resource change from its Lua value.
+func (rb *ResourceChangeBuilder) setLuaOption(name string, lv lua.LValue) error {
+	switch name {
+	case "before", "after", "prevRun":
+		v, err := ctyValue(lv)
+		if err != nil {
+			return err
+		}
+
+		switch name {
+		case "before":
+			rb.Before(v)
+		case "after":
+			rb.After(v)
+		default:
+			rb.PrevRun(v)
+		}
+
+	case "provider", "deposed", "actionReason":
+		s, ok := lv.(lua.LString)
+		if !ok {
+			return xerrors.New("a string is expected")
+		}
+
+		switch name {
+		case "provider":
+			rb.Provider(string(s))
+		case "deposed":
+			rb.Deposed(string(s))
+		default:
+			rb.ActionReason(string(s))
+		}
+
+	case "sensitive", "replacePaths":
+		paths, err := luaPaths(lv)
+		if err != nil {
+			return err
+		}
+
+		if name == "sensitive" {
+			rb.Sensitive(paths...)
+		} else {
+			rb.RequiredReplace(paths...)
+		}
+
+	default:
+		return xerrors.New("unknown option")
+	}
+
+	return nil
+}
+
+// setLuaOption sets an option of the output change from its Lua value.
+func (ob *OutputChangeBuilder) setLuaOption(name string, lv lua.LValue) error {
+	switch name {
+	case "before", "after":
+		v, err := ctyValue(lv)
+		if err != nil {
+			return err
+		}
+
+		if name == "before" {
+			ob.Before(v)
+		} else {
+			ob.After(v)
+		}
+
+	case "sensitive":
+		if lua.LVAsBool(lv) {
+			ob.Sensitive()
+		}
+
+	default:
+		return xerrors.New("unknown option")
+	}
+
+	return nil
+}
+
+// luaPaths converts a Lua sequence of attribute paths.
+func luaPaths(lv lua.LValue) ([]cty.Path, error) {
+	tbl, ok := lv.(*lua.LTable)
+	if !ok {
+		return nil, xerrors.New("a list of paths is expected")
+	}
+
+	ret := make([]cty.Path, 0, tbl.Len())
+
+	for i := 1; i <= tbl.Len(); i++ {
+		p, err := ParsePath(lua.LVAsString(tbl.RawGetInt(i)))
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, p)
+	}
+
+	return ret, nil
+}
+
+// setLuaOptions sets the options of the Lua table at position n, if any,
+// with set.
+func setLuaOptions(ls *lua.LState, n int, set func(string, lua.LValue) error) bool {
+	opts := ls.OptTable(n, nil)
+	if opts == nil {
+		return true
+	}
+
+	valid := true
+
+	opts.ForEach(func(k lua.LValue, v lua.LValue) {
+		if err := set(k.String(), v); err != nil && valid {
+			ls.ArgError(n, fmt.Sprintf("invalid option '%s': %v", k.String(), err))
+
+			valid = false
+		}
+	})
+
+	return valid
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+// planBuilderMode sets the mode of the plan: 'normal', 'destroy' or
+// 'refresh-only'.
+func planBuilderMode(ls *lua.LState) int {
+	b, err := CheckPlanBuilder(ls)
+	if err != nil {
+		return 0
+	}
+
+	mode, err := parseModeName(ls.CheckString(2)) //nolint:gomnd // second argument
+	if err != nil {
+		ls.ArgError(2, err.Error()) //nolint:gomnd // second argument
+
+		return 0
+	}
+
+	b.Mode(mode)
+	ls.Push(ls.Get(1))
+
+	return 1
+}
+
+// planBuilderVariable sets the value of a root module variable.
+func planBuilderVariable(ls *lua.LState) int {
+	const ArgPosValue = 3
+
+	b, err := CheckPlanBuilder(ls)
+	if err != nil {
+		return 0
+	}
+
+	name := ls.CheckString(2) //nolint:gomnd // second argument
+
+	v, err := ctyValue(ls.Get(ArgPosValue))
+	if err != nil {
+		ls.ArgError(ArgPosValue, err.Error())
+
+		return 0
+	}
+
+	b.Variable(name, v)
+	ls.Push(ls.Get(1))
+
+	return 1
+}
+
+// planBuilderConfig adds a configuration file to the root module.
+func planBuilderConfig(ls *lua.LState) int {
+	const ArgPosSource = 3
+
+	b, err := CheckPlanBuilder(ls)
+	if err != nil {
+		return 0
+	}
+
+	b.ConfigFile(ls.CheckString(2), ls.CheckString(ArgPosSource)) //nolint:gomnd // second argument
+	ls.Push(ls.Get(1))
+
+	return 1
+}
+
+// planBuilderModuleConfig adds a configuration file to a child module.
+func planBuilderModuleConfig(ls *lua.LState) int {
+	const (
+		ArgPosModule   = 2
+		ArgPosFilename = 3
+		ArgPosSource   = 4
+	)
+
+	b, err := CheckPlanBuilder(ls)
+	if err != nil {
+		return 0
+	}
+
+	b.ModuleConfigFile(ls.CheckString(ArgPosModule), ls.CheckString(ArgPosFilename), ls.CheckString(ArgPosSource))
+	ls.Push(ls.Get(1))
+
+	return 1
+}
+
+// planBuilderResource adds the change of a resource instance, with its
+// address, action and options.
+func planBuilderResource(ls *lua.LState) int {
+	const (
+		ArgPosAddress = 2
+		ArgPosAction  = 3
+		ArgPosOptions = 4
+	)
+
+	b, err := CheckPlanBuilder(ls)
+	if err != nil {
+		return 0
+	}
+
+	address := ls.CheckString(ArgPosAddress)
+
+	action, err := parseActionName(ls.CheckString(ArgPosAction))
+	if err != nil {
+		ls.ArgError(ArgPosAction, err.Error())
+
+		return 0
+	}
+
+	if !setLuaOptions(ls, ArgPosOptions, b.ResourceChange(address, action).setLuaOption) {
+		return 0
+	}
+
+	ls.Push(ls.Get(1))
+
+	return 1
+}
+
+// planBuilderOutput adds the change of a root module output value, with its
+// name, action and options.
+func planBuilderOutput(ls *lua.LState) int {
+	const (
+		ArgPosName    = 2
+		ArgPosAction  = 3
+		ArgPosOptions = 4
+	)
+
+	b, err := CheckPlanBuilder(ls)
+	if err != nil {
+		return 0
+	}
+
+	name := ls.CheckString(ArgPosName)
+
+	action, err := parseActionName(ls.CheckString(ArgPosAction))
+	if err != nil {
+		ls.ArgError(ArgPosAction, err.Error())
+
+		return 0
+	}
+
+	if !setLuaOptions(ls, ArgPosOptions, b.OutputChange(name, action).setLuaOption) {
+		return 0
+	}
+
+	ls.Push(ls.Get(1))
+
+	return 1
+}
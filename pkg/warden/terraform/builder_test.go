@@ -0,0 +1,322 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+)
+
+const testBuilderConfig = `
+resource "aws_instance" "web" {
+  instance_type = "t3.micro"
+}
+
+module "network" {
+  source = "./modules/network"
+}
+`
+
+func testPlanBuilder() *PlanBuilder {
+	b := NewPlanBuilder().
+		ConfigFile("main.tf", testBuilderConfig).
+		ModuleConfigFile("network", "main.tf", `resource "aws_vpc" "main" {}`).
+		Variable("region", cty.StringVal("eu-west-3"))
+
+	b.ResourceChange("aws_instance.web", plans.Update).
+		Before(cty.ObjectVal(map[string]cty.Value{
+			"id":            cty.StringVal("i-1"),
+			"instance_type": cty.StringVal("t3.micro"),
+			"password":      cty.StringVal("hunter2"),
+		})).
+		After(cty.ObjectVal(map[string]cty.Value{
+			"id":            cty.StringVal("i-1"),
+			"instance_type": cty.StringVal("t3.large"),
+			"password":      cty.StringVal("hunter2"),
+		})).
+		PrevRun(cty.ObjectVal(map[string]cty.Value{
+			"id":            cty.StringVal("i-1"),
+			"instance_type": cty.StringVal("t3.nano"),
+			"password":      cty.StringVal("hunter2"),
+		})).
+		Sensitive(cty.GetAttrPath("password"))
+
+	b.ResourceChange("module.network.aws_vpc.main", plans.Create).
+		Provider("registry.terraform.io/hashicorp/aws").
+		After(cty.ObjectVal(map[string]cty.Value{
+			"id":         cty.DynamicVal,
+			"cidr_block": cty.StringVal("10.0.0.0/16"),
+		}))
+
+	b.ResourceChange("aws_s3_bucket.old", plans.Delete).
+		Before(cty.ObjectVal(map[string]cty.Value{"bucket": cty.StringVal("old")}))
+
+	b.OutputChange("url", plans.Update).
+		Before(cty.StringVal("http://old")).
+		After(cty.StringVal("http://new"))
+
+	b.OutputChange("token", plans.Create).
+		After(cty.StringVal("s3cr3t")).
+		Sensitive()
+
+	return b
+}
+
+func checkTestPlanBuilderPlan(t *testing.T, planFile *PlanFile) {
+	t.Helper()
+
+	plan := &Plan{Plan: planFile.Plan, Details: planFile.Details}
+
+	resources, err := plan.FindResources(ResourceFilter{})
+	require.NoError(t, err)
+
+	actions := map[string]string{}
+	for _, rc := range resources {
+		actions[rc.Address()] = rc.Action()
+	}
+
+	assert.Equal(t, map[string]string{
+		"aws_instance.web":            "update",
+		"module.network.aws_vpc.main": "create",
+		"aws_s3_bucket.old":           "delete",
+	}, actions)
+
+	web := findTestResourceChange(t, planFile, "aws_instance.web")
+	assert.Equal(t, `provider["registry.terraform.io/hashicorp/aws"]`, web.ProviderName())
+	assert.Equal(t, "aws_instance.web", web.PrevRunAddress())
+
+	change, err := web.Change()
+	require.NoError(t, err)
+	assert.Equal(t, "t3.large", change.After.GetAttr("instance_type").AsString())
+	assert.True(t, change.After.GetAttr("password").HasMark(sensitiveMark))
+	assert.False(t, change.After.GetAttr("instance_type").HasMark(sensitiveMark))
+
+	vpc := findTestResourceChange(t, planFile, "module.network.aws_vpc.main")
+
+	after, err := vpc.After()
+	require.NoError(t, err)
+	assert.False(t, after.GetAttr("id").IsKnown())
+
+	assert.NotNil(t, planFile.State.State.ResourceInstance(web.tfResource.Addr))
+	assert.Nil(t, planFile.State.State.ResourceInstance(vpc.tfResource.Addr))
+
+	drift, err := plan.Drift()
+	require.NoError(t, err)
+	require.Len(t, drift, 1)
+	assert.Equal(t, "aws_instance.web", drift[0].Address())
+	assert.Equal(t, []string{"instance_type"}, drift[0].ChangedPaths())
+
+	outputs := plan.Outputs()
+	require.Len(t, outputs, 2)
+	assert.Equal(t, "token", outputs[0].Name())
+	assert.True(t, outputs[0].Sensitive())
+	assert.Equal(t, "url", outputs[1].Name())
+	assert.False(t, outputs[1].Sensitive())
+
+	url := planFile.State.State.RootModule().OutputValues["url"]
+	require.NotNil(t, url)
+	assert.Equal(t, cty.StringVal("http://old"), url.Value)
+
+	require.NotNil(t, planFile.Config)
+	assert.NotNil(t, planFile.ResourceRange("aws_instance.web"))
+	assert.NotNil(t, planFile.ResourceRange("module.network.aws_vpc.main"))
+
+	assert.Contains(t, planFile.Plan.VariableValues, "region")
+	assert.Equal(t, []string{"hunter2", "s3cr3t"}, planFile.SensitiveValues(nil))
+}
+
+func TestPlanBuilder_Build(t *testing.T) {
+	planFile, err := testPlanBuilder().Build()
+	require.NoError(t, err)
+
+	checkTestPlanBuilderPlan(t, planFile)
+}
+
+func TestPlanBuilder_Create(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tfplan")
+
+	require.NoError(t, testPlanBuilder().Create(filename))
+
+	file, err := afero.NewOsFs().Open(filename)
+	require.NoError(t, err)
+
+	defer file.Close()
+
+	planFile, err := LoadPlanFile(file)
+	require.NoError(t, err)
+
+	assert.Empty(t, planFile.Diagnostics)
+	checkTestPlanBuilderPlan(t, planFile)
+}
+
+func TestPlanBuilder_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *PlanBuilder)
+	}{
+		{
+			name: "invalid address",
+			build: func(b *PlanBuilder) {
+				b.ResourceChange("aws_instance", plans.Create)
+			},
+		},
+		{
+			name: "invalid provider",
+			build: func(b *PlanBuilder) {
+				b.ResourceChange("aws_instance.web", plans.Create).Provider("in valid")
+			},
+		},
+		{
+			name: "invalid configuration",
+			build: func(b *PlanBuilder) {
+				b.ConfigFile("main.tf", `resource "aws_instance" {`)
+			},
+		},
+		{
+			name: "missing module",
+			build: func(b *PlanBuilder) {
+				b.ConfigFile("main.tf", `module "network" { source = "./modules/network" }`)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewPlanBuilder()
+			tt.build(b)
+
+			_, err := b.Build()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    cty.Path
+		wantErr bool
+	}{
+		{path: "tags", want: cty.GetAttrPath("tags")},
+		{path: "tags.Owner", want: cty.GetAttrPath("tags").GetAttr("Owner")},
+		{path: `tags["Owner"]`, want: cty.GetAttrPath("tags").Index(cty.StringVal("Owner"))},
+		{path: "ebs_block_device[0].tags", want: cty.GetAttrPath("ebs_block_device").IndexInt(0).GetAttr("tags")},
+		{path: "[0]", wantErr: true},
+		{path: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ParsePath(tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equals(got), "%#v", got)
+		})
+	}
+}
+
+func TestPlanBuilder_Lua(t *testing.T) {
+	const script = `
+b:config("main.tf", [[
+resource "aws_instance" "web" {}
+module "network" { source = "./modules/network" }
+]])
+ :moduleConfig("network", "main.tf", 'resource "aws_vpc" "main" {}')
+ :variable("region", "eu-west-3")
+ :resource("aws_instance.web", "update", {
+     before = {id = "i-1", instance_type = "t3.micro", password = "hunter2", tags = {"a", "b"}},
+     after = {id = "i-1", instance_type = "t3.large", password = "hunter2", tags = {"a", "b"}},
+     prevRun = {id = "i-1", instance_type = "t3.nano", password = "hunter2", tags = {"a", "b"}},
+     sensitive = {"password"},
+     replacePaths = {"instance_type"},
+     actionReason = "replace_because_tainted",
+ })
+ :resource("module.network.aws_vpc.main", "create", {
+     provider = "registry.terraform.io/hashicorp/aws",
+     after = {id = unknown, cidr_block = "10.0.0.0/16"},
+ })
+ :resource("aws_s3_bucket.old", "delete", {before = {bucket = "old"}})
+ :output("url", "update", {before = "http://old", after = "http://new"})
+ :output("token", "create", {after = "s3cr3t", sensitive = true})
+`
+
+	ls := lua.NewState()
+	defer ls.Close()
+
+	b := NewPlanBuilder()
+
+	RegisterPlanBuilderType(ls)
+	ls.SetGlobal("b", LPlanBuilder(ls, b))
+	ls.SetGlobal("unknown", wlua.LUnknown(ls))
+
+	require.NoError(t, ls.DoString(script))
+
+	got, ok := AsPlanBuilder(ls.GetGlobal("b"))
+	require.True(t, ok)
+	assert.Same(t, b, got)
+
+	planFile, err := b.Build()
+	require.NoError(t, err)
+
+	web := findTestResourceChange(t, planFile, "aws_instance.web")
+	assert.Equal(t, "replace_because_tainted", web.ActionReason())
+	assert.True(t, web.tfResource.RequiredReplace.Has(cty.GetAttrPath("instance_type")))
+
+	checkTestPlanBuilderPlan(t, planFile)
+}
+
+func TestPlanBuilder_LuaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{name: "unknown action", script: `b:resource("aws_instance.web", "destroy")`},
+		{name: "unknown mode", script: `b:mode("apply")`},
+		{name: "unknown option", script: `b:resource("aws_instance.web", "create", {color = "red"})`},
+		{name: "invalid value", script: `b:resource("aws_instance.web", "create", {after = {print}})`},
+		{name: "mixed table", script: `b:resource("aws_instance.web", "create", {after = {1, a = 2}})`},
+		{name: "invalid path", script: `b:resource("aws_instance.web", "create", {sensitive = {"[0]"}})`},
+		{name: "invalid output option", script: `b:output("url", "create", {value = "x"})`},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ls := lua.NewState()
+			defer ls.Close()
+
+			RegisterPlanBuilderType(ls)
+			ls.SetGlobal("b", LPlanBuilder(ls, NewPlanBuilder()))
+
+			assert.Error(t, ls.DoString(tt.script))
+		})
+	}
+}
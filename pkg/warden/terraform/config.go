@@ -0,0 +1,132 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	version "github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2"
+
+	"github.com/hexbee-net/horus/pkg/terraform/configs"
+	"github.com/hexbee-net/horus/pkg/terraform/configs/configload"
+	"github.com/hexbee-net/horus/pkg/terraform/modsdir"
+	"github.com/hexbee-net/horus/pkg/terraform/tfdiags"
+)
+
+// modulesDir is the directory, relative to the root module, where
+// 'terraform init' installs the remote modules.
+const modulesDir = ".terraform/modules"
+
+// LoadConfigDir loads the configuration of the root module in dir, and of
+// all its descendants.
+//
+// Local modules are loaded from their source directory, so the configuration
+// can be loaded without running 'terraform init' first. Remote modules are
+// loaded from the modules installed by 'terraform init', if any; the
+// modules that are not installed are left out of the configuration, with a
+// warning.
+//
+// Unlike configload.Loader.LoadConfig, the paths of the installed modules
+// are resolved relative to dir rather than to the working directory.
+func LoadConfigDir(dir string) (*configs.Config, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	loader, err := configload.NewLoader(&configload.Config{ModulesDir: filepath.Join(dir, modulesDir)})
+	if err != nil {
+		return nil, diags.Append(err)
+	}
+
+	if !loader.IsConfigDir(dir) {
+		return nil, diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"No configuration files",
+			fmt.Sprintf("The directory %s contains no Terraform configuration files.", dir),
+		))
+	}
+
+	manifest, err := modsdir.ReadManifestSnapshotForDir(filepath.Join(dir, modulesDir))
+	if err != nil {
+		return nil, diags.Append(err)
+	}
+
+	rootMod, hclDiags := loader.Parser().LoadConfigDir(dir)
+	diags = diags.Append(hclDiags)
+
+	if rootMod == nil || diags.HasErrors() {
+		return nil, diags
+	}
+
+	walker := &moduleWalker{
+		parser:   loader.Parser(),
+		dir:      dir,
+		manifest: manifest,
+	}
+
+	config, hclDiags := configs.BuildConfig(rootMod, configs.ModuleWalkerFunc(walker.loadModule))
+	diags = diags.Append(hclDiags)
+
+	return config, diags
+}
+
+type moduleWalker struct {
+	parser   *configs.Parser
+	dir      string
+	manifest modsdir.Manifest
+}
+
+func (w *moduleWalker) loadModule(req *configs.ModuleRequest) (*configs.Module, *version.Version, hcl.Diagnostics) {
+	if isLocalSourceAddr(req.SourceAddr) {
+		mod, diags := w.parser.LoadConfigDir(filepath.Join(req.Parent.Module.SourceDir, req.SourceAddr))
+
+		return mod, nil, diags
+	}
+
+	record, ok := w.manifest[w.manifest.ModuleKey(req.Path)]
+	if !ok || record.SourceAddr != req.SourceAddr {
+		return nil, nil, hcl.Diagnostics{{
+			Severity: hcl.DiagWarning,
+			Summary:  "Module not installed",
+			Detail: fmt.Sprintf(
+				"The module %s is not installed, or was installed from another source; it is not validated. Run 'terraform init' to install it.",
+				req.Path,
+			),
+			Subject: req.SourceAddrRange.Ptr(),
+		}}
+	}
+
+	dir := record.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(w.dir, dir)
+	}
+
+	mod, diags := w.parser.LoadConfigDir(dir)
+
+	return mod, record.Version, diags
+}
+
+// isLocalSourceAddr reports whether the source address of a module refers to
+// a directory of the same source tree.
+func isLocalSourceAddr(addr string) bool {
+	for _, prefix := range []string{"./", "../", ".\\", "..\\"} {
+		if strings.HasPrefix(addr, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
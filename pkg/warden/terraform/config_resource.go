@@ -0,0 +1,482 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/configs"
+)
+
+// ConfigResource is a resource declared in a module of a configuration.
+type ConfigResource struct {
+	module *configs.Config
+	res    *configs.Resource
+}
+
+// Address returns the address of the resource in the configuration, e.g.
+// 'module.net.aws_subnet.private'.
+func (r *ConfigResource) Address() string {
+	return r.res.Addr().InModule(r.module.Path).String()
+}
+
+// ModuleAddress returns the address of the module declaring the resource, or
+// an empty string for the root module.
+func (r *ConfigResource) ModuleAddress() string {
+	return r.module.Path.String()
+}
+
+// Type returns the resource type, e.g. 'aws_instance'.
+func (r *ConfigResource) Type() string {
+	return r.res.Type
+}
+
+// Name returns the resource name as declared in the configuration.
+func (r *ConfigResource) Name() string {
+	return r.res.Name
+}
+
+// Mode returns either 'managed' or 'data'.
+func (r *ConfigResource) Mode() string {
+	return resourceModeName(r.res.Mode)
+}
+
+// ProviderName returns the address of the provider configuration managing
+// the resource, e.g. 'provider["registry.terraform.io/hashicorp/aws"]'.
+func (r *ConfigResource) ProviderName() string {
+	return r.providerConfig().String()
+}
+
+// Range returns the source range of the declaration of the resource.
+func (r *ConfigResource) Range() hcl.Range {
+	return r.res.DeclRange
+}
+
+// DependsOn returns the explicit dependencies of the resource, as declared
+// in its 'depends_on' argument.
+func (r *ConfigResource) DependsOn() []hcl.Traversal {
+	return r.res.DependsOn
+}
+
+// Managed returns the settings specific to managed resources, or nil for
+// data resources.
+func (r *ConfigResource) Managed() *configs.ManagedResource {
+	return r.res.Managed
+}
+
+// addr returns the address of the resource as an instance without key, so
+// it can be matched by resource filters.
+func (r *ConfigResource) addr() addrs.AbsResourceInstance {
+	return r.res.Addr().Instance(addrs.NoKey).Absolute(r.module.Path.UnkeyedInstanceShim())
+}
+
+func (r *ConfigResource) providerConfig() addrs.AbsProviderConfig {
+	return r.module.Root.ResolveAbsProviderAddr(r.res.ProviderConfigAddr(), r.module.Path)
+}
+
+// -----------------------------------------------------------------------------
+// Lua Utilities
+
+const luaConfigResourceTypeName = "configResource"
+
+const (
+	luaFunctionConfigResourceGetAddress       = "address"
+	luaFunctionConfigResourceGetModuleAddress = "moduleAddress"
+	luaFunctionConfigResourceGetType          = "type"
+	luaFunctionConfigResourceGetName          = "name"
+	luaFunctionConfigResourceGetMode          = "mode"
+	luaFunctionConfigResourceGetProviderName  = "providerName"
+	luaFunctionConfigResourceGetRange         = "range"
+	luaFunctionConfigResourceGetCount         = "count"
+	luaFunctionConfigResourceGetForEach       = "forEach"
+	luaFunctionConfigResourceGetAttributes    = "attributes"
+	luaFunctionConfigResourceGetDependsOn     = "dependsOn"
+	luaFunctionConfigResourceGetLifecycle     = "lifecycle"
+)
+
+// RegisterConfigResourceType registers the ConfigResource type inside the
+// Lua state.
+func RegisterConfigResourceType(ls *lua.LState) {
+	var methods = map[string]lua.LGFunction{
+		luaFunctionConfigResourceGetAddress:       configResourceGetAddress,
+		luaFunctionConfigResourceGetModuleAddress: configResourceGetModuleAddress,
+		luaFunctionConfigResourceGetType:          configResourceGetType,
+		luaFunctionConfigResourceGetName:          configResourceGetName,
+		luaFunctionConfigResourceGetMode:          configResourceGetMode,
+		luaFunctionConfigResourceGetProviderName:  configResourceGetProviderName,
+		luaFunctionConfigResourceGetRange:         configResourceGetRange,
+		luaFunctionConfigResourceGetCount:         configResourceGetCount,
+		luaFunctionConfigResourceGetForEach:       configResourceGetForEach,
+		luaFunctionConfigResourceGetAttributes:    configResourceGetAttributes,
+		luaFunctionConfigResourceGetDependsOn:     configResourceGetDependsOn,
+		luaFunctionConfigResourceGetLifecycle:     configResourceGetLifecycle,
+	}
+
+	mt := ls.NewTypeMetatable(luaConfigResourceTypeName)
+	ls.SetGlobal(luaConfigResourceTypeName, mt)
+
+	// methods
+	ls.SetField(mt, "__index", ls.SetFuncs(ls.NewTable(), methods))
+}
+
+// LConfigResource creates a new configResource userdata wrapping the
+// specified resource.
+func LConfigResource(ls *lua.LState, r *ConfigResource) *lua.LUserData {
+	ud := ls.NewUserData()
+	ud.Value = r
+	ls.SetMetatable(ud, ls.GetTypeMetatable(luaConfigResourceTypeName))
+
+	return ud
+}
+
+// CheckConfigResource checks whether the first lua argument is a *LUserData
+// with *ConfigResource and returns this *ConfigResource.
+func CheckConfigResource(ls *lua.LState) (*ConfigResource, error) {
+	ud := ls.CheckUserData(1)
+	if v, ok := ud.Value.(*ConfigResource); ok {
+		return v, nil
+	}
+
+	ls.ArgError(1, fmt.Sprintf("%s expected", luaConfigResourceTypeName))
+
+	return nil, ErrInvalidType
+}
+
+// LRange converts a source range to a table with the 'filename',
+// 'start_line', 'start_column', 'end_line' and 'end_column' fields. Ranges
+// without file, such as those of the configuration of JSON plans, are
+// converted to nil.
+func LRange(ls *lua.LState, rng hcl.Range) lua.LValue {
+	if rng.Filename == "" {
+		return lua.LNil
+	}
+
+	tbl := ls.NewTable()
+
+	tbl.RawSetString("filename", lua.LString(rng.Filename))
+	tbl.RawSetString("start_line", lua.LNumber(rng.Start.Line))
+	tbl.RawSetString("start_column", lua.LNumber(rng.Start.Column))
+	tbl.RawSetString("end_line", lua.LNumber(rng.End.Line))
+	tbl.RawSetString("end_column", lua.LNumber(rng.End.Column))
+
+	return tbl
+}
+
+// LExpression converts an expression of the configuration to a table with
+// the following fields:
+//   - 'constant': whether the expression has a known value without any
+//     variable,
+//   - 'value': the value of the expression if it is constant, or nil,
+//   - 'references': the references to other objects in the expression,
+//     e.g. 'var.name' or 'aws_vpc.main.id',
+//   - 'range': the source range of the expression.
+//
+// A nil expression is converted to nil.
+func LExpression(ls *lua.LState, expr hcl.Expression) lua.LValue {
+	if expr == nil {
+		return lua.LNil
+	}
+
+	tbl := ls.NewTable()
+
+	vars := expr.Variables()
+
+	constant := false
+
+	if len(vars) == 0 {
+		if v, diags := expr.Value(nil); !diags.HasErrors() && v.IsWhollyKnown() {
+			constant = true
+
+			tbl.RawSetString("value", luaValue(ls, v))
+		}
+	}
+
+	tbl.RawSetString("constant", lua.LBool(constant))
+	tbl.RawSetString("references", LTraversals(ls, vars))
+	tbl.RawSetString("range", LRange(ls, expr.Range()))
+
+	return tbl
+}
+
+// LBody converts the content of a block to a table indexed by attribute
+// name and nested block type. Attributes are converted with LExpression,
+// nested blocks to arrays of tables of their content.
+//
+// The nested blocks of configuration files in the JSON syntax cannot be told
+// apart from attributes without the schema of the block: all their arguments
+// are converted as attributes.
+func LBody(ls *lua.LState, body hcl.Body) lua.LValue {
+	if body == nil {
+		return lua.LNil
+	}
+
+	tbl := ls.NewTable()
+
+	switch b := body.(type) {
+	case *hclsyntax.Body:
+		for name, attr := range b.Attributes {
+			tbl.RawSetString(name, LExpression(ls, attr.Expr))
+		}
+
+		for _, block := range b.Blocks {
+			appendBlock(ls, tbl, block.Type, LBody(ls, block.Body))
+		}
+
+	case *jsonBody:
+		for name, expr := range b.attrs {
+			tbl.RawSetString(name, LExpression(ls, expr))
+		}
+
+		for name, blocks := range b.blocks {
+			for _, block := range blocks {
+				appendBlock(ls, tbl, name, LBody(ls, block))
+			}
+		}
+
+	default:
+		attrs, _ := body.JustAttributes()
+		for name, attr := range attrs {
+			tbl.RawSetString(name, LExpression(ls, attr.Expr))
+		}
+	}
+
+	return tbl
+}
+
+// appendBlock appends the content of a nested block to the array of the
+// blocks of its type.
+func appendBlock(ls *lua.LState, tbl *lua.LTable, blockType string, block lua.LValue) {
+	blocks, ok := tbl.RawGetString(blockType).(*lua.LTable)
+	if !ok {
+		blocks = ls.NewTable()
+		tbl.RawSetString(blockType, blocks)
+	}
+
+	blocks.Append(block)
+}
+
+// LTraversals converts traversals, such as the 'depends_on' argument of a
+// block, to an array of their source form.
+func LTraversals(ls *lua.LState, traversals []hcl.Traversal) *lua.LTable {
+	tbl := ls.CreateTable(len(traversals), 0)
+	for _, t := range traversals {
+		tbl.Append(lua.LString(traversalString(t)))
+	}
+
+	return tbl
+}
+
+// traversalString returns the source form of a traversal, e.g.
+// 'aws_instance.web[0].id'.
+func traversalString(traversal hcl.Traversal) string {
+	var sb strings.Builder
+
+	for _, step := range traversal {
+		switch s := step.(type) {
+		case hcl.TraverseRoot:
+			sb.WriteString(s.Name)
+		case hcl.TraverseAttr:
+			// Relative traversals, such as those of 'ignore_changes', start
+			// with an attribute.
+			if sb.Len() > 0 {
+				sb.WriteString(".")
+			}
+
+			sb.WriteString(s.Name)
+		case hcl.TraverseIndex:
+			sb.WriteString("[")
+			sb.WriteString(indexKeyString(s.Key))
+			sb.WriteString("]")
+		case hcl.TraverseSplat:
+			sb.WriteString("[*]")
+		}
+	}
+
+	return sb.String()
+}
+
+func indexKeyString(key cty.Value) string {
+	if !key.IsKnown() || key.IsNull() {
+		return "?"
+	}
+
+	switch key.Type() {
+	case cty.String:
+		return fmt.Sprintf("%q", key.AsString())
+	case cty.Number:
+		return key.AsBigFloat().Text('f', -1)
+	default:
+		return "?"
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+func configResourceGetAddress(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Address()))
+
+	return 1
+}
+
+func configResourceGetModuleAddress(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.ModuleAddress()))
+
+	return 1
+}
+
+func configResourceGetType(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Type()))
+
+	return 1
+}
+
+func configResourceGetName(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Name()))
+
+	return 1
+}
+
+func configResourceGetMode(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Mode()))
+
+	return 1
+}
+
+func configResourceGetProviderName(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.ProviderName()))
+
+	return 1
+}
+
+func configResourceGetRange(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(LRange(ls, r.Range()))
+
+	return 1
+}
+
+func configResourceGetCount(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(LExpression(ls, r.res.Count))
+
+	return 1
+}
+
+func configResourceGetForEach(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(LExpression(ls, r.res.ForEach))
+
+	return 1
+}
+
+func configResourceGetAttributes(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(LBody(ls, r.res.Config))
+
+	return 1
+}
+
+func configResourceGetDependsOn(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(LTraversals(ls, r.DependsOn()))
+
+	return 1
+}
+
+// configResourceGetLifecycle returns the 'lifecycle' settings of a managed
+// resource as a table with the 'create_before_destroy', 'prevent_destroy',
+// 'ignore_changes' and 'ignore_all_changes' fields, or nil for data
+// resources.
+func configResourceGetLifecycle(ls *lua.LState) int {
+	r, err := CheckConfigResource(ls)
+	if err != nil {
+		return 0
+	}
+
+	managed := r.Managed()
+	if managed == nil {
+		ls.Push(lua.LNil)
+
+		return 1
+	}
+
+	tbl := ls.NewTable()
+	tbl.RawSetString("create_before_destroy", lua.LBool(managed.CreateBeforeDestroy))
+	tbl.RawSetString("prevent_destroy", lua.LBool(managed.PreventDestroy))
+	tbl.RawSetString("ignore_changes", LTraversals(ls, managed.IgnoreChanges))
+	tbl.RawSetString("ignore_all_changes", lua.LBool(managed.IgnoreAllChanges))
+
+	ls.Push(tbl)
+
+	return 1
+}
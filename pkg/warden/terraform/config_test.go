@@ -0,0 +1,266 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/horus/pkg/terraform/tfdiags"
+)
+
+func loadTestConfig(t *testing.T) *Module {
+	t.Helper()
+
+	cfg, diags := LoadConfigDir(getTestDataPath(t, "config"))
+	require.False(t, diags.HasErrors(), diags.Err())
+
+	return NewModule(cfg)
+}
+
+func configResourceAddresses(resources []*ConfigResource) []string {
+	addresses := make([]string, 0, len(resources))
+	for _, r := range resources {
+		addresses = append(addresses, r.Address())
+	}
+
+	return addresses
+}
+
+func TestLoadConfigDir(t *testing.T) {
+	cfg, diags := LoadConfigDir(getTestDataPath(t, "config"))
+	require.False(t, diags.HasErrors(), diags.Err())
+	require.NotNil(t, cfg)
+
+	require.Len(t, diags, 1)
+	assert.Equal(t, tfdiags.Warning, diags[0].Severity())
+	assert.Equal(t, "Module not installed", diags[0].Description().Summary)
+	assert.Contains(t, diags[0].Description().Detail, "module.dns")
+
+	_, diags = LoadConfigDir(getTestDataPath(t, "config/missing"))
+	assert.True(t, diags.HasErrors())
+}
+
+func TestModule(t *testing.T) {
+	root := loadTestConfig(t)
+
+	assert.Equal(t, "", root.Path())
+	assert.Equal(t, "", root.Source())
+	assert.Equal(t, []string{"aws_instance.web", "data.aws_ami.ubuntu"}, configResourceAddresses(root.Resources()))
+
+	children := root.Children()
+	require.Len(t, children, 2)
+
+	assert.Equal(t, "module.labels", children[0].Path())
+	assert.Equal(t, "cloudposse/label/null", children[0].Source())
+	assert.Equal(t, "0.25.0", children[0].Version())
+	assert.Equal(t, "module.net", children[1].Path())
+	assert.Equal(t, "./modules/net", children[1].Source())
+	assert.Equal(t, "", children[1].Version())
+
+	var names []string
+	for _, v := range root.Variables() {
+		names = append(names, v.Name)
+	}
+
+	assert.Equal(t, []string{"db_password", "instance_type", "region"}, names)
+
+	names = nil
+	for _, o := range root.Outputs() {
+		names = append(names, o.Name)
+	}
+
+	assert.Equal(t, []string{"environment", "instance_ids"}, names)
+
+	names = nil
+	for _, c := range root.ModuleCalls() {
+		names = append(names, c.Name)
+	}
+
+	assert.Equal(t, []string{"dns", "labels", "net"}, names)
+
+	names = nil
+	for _, l := range root.Locals() {
+		names = append(names, l.Name)
+	}
+
+	assert.Equal(t, []string{"environment", "name_prefix"}, names)
+
+	providers := root.RequiredProviders()
+	require.Len(t, providers, 2)
+	assert.Equal(t, "registry.terraform.io/hashicorp/aws", providers[0].Type.String())
+	assert.Equal(t, "~> 3.0", providers[0].Requirement.Required.String())
+	assert.Equal(t, "null", providers[1].Name)
+
+	configs := root.ProviderConfigs()
+	require.Len(t, configs, 2)
+	assert.Equal(t, "", configs[0].Alias)
+	assert.Equal(t, "east", configs[1].Alias)
+
+	assert.Equal(t, "module.net", root.Child("net").Path())
+	assert.Nil(t, root.Child("dns"))
+	assert.Nil(t, NewModule(nil))
+}
+
+func TestModule_FindResources(t *testing.T) {
+	root := loadTestConfig(t)
+
+	tests := []struct {
+		name    string
+		filter  ResourceFilter
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "all",
+			want: []string{
+				"aws_instance.web",
+				"data.aws_ami.ubuntu",
+				"module.labels.null_resource.label",
+				"module.net.aws_flow_log.main",
+				"module.net.aws_vpc.main",
+				"module.net.null_resource.marker",
+			},
+		},
+		{
+			name:   "type and name",
+			filter: ResourceFilter{Type: "null_resource", Name: "mark*"},
+			want:   []string{"module.net.null_resource.marker"},
+		},
+		{
+			name:   "root module",
+			filter: ResourceFilter{Module: RootModule, Provider: "hashicorp/aws"},
+			want:   []string{"aws_instance.web", "data.aws_ami.ubuntu"},
+		},
+		{
+			name:   "module",
+			filter: ResourceFilter{Module: "module.net"},
+			want:   []string{"module.net.aws_flow_log.main", "module.net.aws_vpc.main", "module.net.null_resource.marker"},
+		},
+		{
+			name:    "action",
+			filter:  ResourceFilter{Action: "create"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := root.FindResources(tt.filter)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrUnsupportedFilter)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, configResourceAddresses(got))
+		})
+	}
+}
+
+func TestModule_ResourceRange(t *testing.T) {
+	root := loadTestConfig(t)
+
+	tests := []struct {
+		name     string
+		address  string
+		wantFile string
+		wantLine int
+	}{
+		{
+			name:     "root resource instance",
+			address:  "aws_instance.web[1]",
+			wantFile: "main.tf",
+			wantLine: 17,
+		},
+		{
+			name:     "module resource instance",
+			address:  `module.net.null_resource.marker["a"]`,
+			wantFile: "modules/net/main.tf",
+			wantLine: 9,
+		},
+		{
+			name:    "unknown resource",
+			address: "aws_instance.missing",
+		},
+		{
+			name:    "invalid address",
+			address: "not an address",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := root.ResourceRange(tt.address)
+			if tt.wantFile == "" {
+				assert.Nil(t, got)
+
+				return
+			}
+
+			require.NotNil(t, got)
+			assert.Equal(t, getTestDataPath(t, filepath.Join("config", tt.wantFile)), got.Filename)
+			assert.Equal(t, tt.wantLine, got.Start.Line)
+		})
+	}
+}
+
+func TestConfigResource(t *testing.T) {
+	root := loadTestConfig(t)
+
+	resources, err := root.FindResource("aws_vpc", "main")
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+
+	r := resources[0]
+	assert.Equal(t, "module.net.aws_vpc.main", r.Address())
+	assert.Equal(t, "module.net", r.ModuleAddress())
+	assert.Equal(t, "aws_vpc", r.Type())
+	assert.Equal(t, "main", r.Name())
+	assert.Equal(t, "managed", r.Mode())
+	assert.Equal(t, `module.net.provider["registry.terraform.io/hashicorp/aws"]`, r.ProviderName())
+	assert.Equal(t, 5, r.Range().Start.Line)
+	assert.Empty(t, r.DependsOn())
+	assert.False(t, r.Managed().PreventDestroy)
+
+	resources, err = root.FindResource("aws_ami", "")
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	assert.Nil(t, resources[0].Managed())
+}
+
+func TestTraversalString(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{name: "attribute", src: "aws_vpc.main.id", want: "aws_vpc.main.id"},
+		{name: "index", src: `aws_instance.web[0].tags["Name"]`, want: `aws_instance.web[0].tags["Name"]`},
+		{name: "relative", src: "tags", want: "tags"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traversal, diags := hclsyntax.ParseTraversalAbs([]byte(tt.src), "", hcl.InitialPos)
+			require.False(t, diags.HasErrors())
+
+			assert.Equal(t, tt.want, traversalString(traversal))
+		})
+	}
+}
@@ -0,0 +1,508 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/tfdiags"
+)
+
+const (
+	// DriftActionUpdate is the action of the objects modified outside of
+	// Terraform.
+	DriftActionUpdate = "update"
+	// DriftActionDelete is the action of the objects deleted outside of
+	// Terraform.
+	DriftActionDelete = "delete"
+)
+
+// ResourceDrift is a change made outside of Terraform to a resource instance
+// object since the previous run, as detected by the refresh of the plan.
+type ResourceDrift struct {
+	// prev is the object at the end of the previous run.
+	prev *ResourceInstance
+	// prior is the refreshed object, or nil if it was deleted.
+	prior *ResourceInstance
+
+	changedPaths []cty.Path
+}
+
+// Address returns the absolute address of the resource instance.
+func (r *ResourceDrift) Address() string {
+	return r.prev.Address()
+}
+
+// ModuleAddress returns the address of the module instance containing the
+// resource, or an empty string for the root module.
+func (r *ResourceDrift) ModuleAddress() string {
+	return r.prev.ModuleAddress()
+}
+
+// Type returns the resource type, e.g. 'aws_instance'.
+func (r *ResourceDrift) Type() string {
+	return r.prev.Type()
+}
+
+// Name returns the resource name as declared in the configuration.
+func (r *ResourceDrift) Name() string {
+	return r.prev.Name()
+}
+
+// ProviderName returns the address of the provider configuration managing
+// the resource.
+func (r *ResourceDrift) ProviderName() string {
+	return r.prev.ProviderName()
+}
+
+// Deposed returns the deposed key of the object, or an empty string for the
+// current object of the instance.
+func (r *ResourceDrift) Deposed() string {
+	return r.prev.Deposed()
+}
+
+// Action returns either DriftActionUpdate or DriftActionDelete.
+func (r *ResourceDrift) Action() string {
+	if r.prior == nil {
+		return DriftActionDelete
+	}
+
+	return DriftActionUpdate
+}
+
+// Before returns the value of the object at the end of the previous run.
+func (r *ResourceDrift) Before() (cty.Value, error) {
+	return r.prev.Value()
+}
+
+// After returns the refreshed value of the object, or a null value if the
+// object was deleted.
+func (r *ResourceDrift) After() (cty.Value, error) {
+	if r.prior == nil {
+		return cty.NullVal(cty.DynamicPseudoType), nil
+	}
+
+	return r.prior.Value()
+}
+
+// ChangedPaths returns the paths of the attributes whose value changed, in
+// alphabetical order, e.g. 'instance_type' or 'tags.Owner'. It is empty for
+// deleted objects.
+func (r *ResourceDrift) ChangedPaths() []string {
+	paths := make([]string, 0, len(r.changedPaths))
+	for _, p := range r.changedPaths {
+		paths = append(paths, strings.TrimPrefix(tfdiags.FormatCtyPath(p), "."))
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+// Drift returns the managed resource instance objects changed outside of
+// Terraform, found by comparing the state at the end of the previous run
+// with the refreshed state of the plan. The objects are ordered by address.
+//
+// Objects created outside of Terraform are unknown to the state, and thus
+// never reported.
+func (p *Plan) Drift() ([]*ResourceDrift, error) {
+	return p.FindDrift(ResourceFilter{})
+}
+
+// FindDrift returns the drift of the managed resource instance objects
+// matching all the criteria of the filter. The Action criterion is matched
+// against the action of the drift, either DriftActionUpdate or
+// DriftActionDelete.
+func (p *Plan) FindDrift(filter ResourceFilter) ([]*ResourceDrift, error) {
+	drift := make([]*ResourceDrift, 0)
+
+	if p.PrevRunState == nil || p.PriorState == nil {
+		return drift, nil
+	}
+
+	action := filter.Action
+	filter.Action = ""
+
+	prev, err := (&State{State: p.PrevRunState, Schemas: p.Schemas}).FindResources(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	prior, err := (&State{State: p.PriorState, Schemas: p.Schemas}).FindResources(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	priorObjects := make(map[string]*ResourceInstance, len(prior))
+	for _, obj := range prior {
+		priorObjects[objectKey(obj)] = obj
+	}
+
+	for _, obj := range prev {
+		// Data resources are read again by every plan: their changes are
+		// not drift.
+		if obj.addr.Resource.Resource.Mode != addrs.ManagedResourceMode {
+			continue
+		}
+
+		rd, err := resourceDrift(obj, priorObjects[objectKey(obj)])
+		if err != nil {
+			return nil, err
+		}
+
+		if rd != nil && (action == "" || action == rd.Action()) {
+			drift = append(drift, rd)
+		}
+	}
+
+	return drift, nil
+}
+
+// resourceDrift compares the object of the previous run with the refreshed
+// one, and returns nil if they are equal.
+func resourceDrift(prev, prior *ResourceInstance) (*ResourceDrift, error) {
+	if prior == nil {
+		return &ResourceDrift{prev: prev}, nil
+	}
+
+	before, err := prev.Value()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode the previous run object: %w", err)
+	}
+
+	after, err := prior.Value()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode the refreshed object: %w", err)
+	}
+
+	paths := changedPaths(unmarked(before), unmarked(after), nil)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	return &ResourceDrift{prev: prev, prior: prior, changedPaths: paths}, nil
+}
+
+// objectKey identifies a resource instance object across states.
+func objectKey(obj *ResourceInstance) string {
+	return obj.Address() + " " + obj.Deposed()
+}
+
+// changedPaths returns the paths of the parts of two values that differ.
+// Objects, maps, lists and tuples are compared element by element; sets,
+// sequences of different lengths and values of different types are reported
+// as a whole.
+func changedPaths(before, after cty.Value, path cty.Path) []cty.Path {
+	if before.RawEquals(after) {
+		return nil
+	}
+
+	if !before.IsWhollyKnown() || !after.IsWhollyKnown() || before.IsNull() || after.IsNull() {
+		return []cty.Path{path.Copy()}
+	}
+
+	bty, aty := before.Type(), after.Type()
+
+	switch {
+	case isMapping(bty) && isMapping(aty):
+		var paths []cty.Path
+
+		for _, k := range mappingKeys(before, after) {
+			step := mappingStep(bty, k)
+			paths = append(paths, changedPaths(mappingElem(before, k), mappingElem(after, k), append(path, step))...)
+		}
+
+		return paths
+
+	case isSequence(bty) && isSequence(aty) && before.LengthInt() == after.LengthInt():
+		var paths []cty.Path
+
+		for i := 0; i < before.LengthInt(); i++ {
+			idx := cty.NumberIntVal(int64(i))
+			paths = append(paths, changedPaths(before.Index(idx), after.Index(idx), append(path, cty.IndexStep{Key: idx}))...)
+		}
+
+		return paths
+
+	default:
+		return []cty.Path{path.Copy()}
+	}
+}
+
+func isMapping(ty cty.Type) bool {
+	return ty.IsObjectType() || ty.IsMapType()
+}
+
+func isSequence(ty cty.Type) bool {
+	return ty.IsListType() || ty.IsTupleType()
+}
+
+// mappingKeys returns the union of the keys of two objects or maps, in
+// alphabetical order.
+func mappingKeys(a, b cty.Value) []string {
+	keys := map[string]struct{}{}
+
+	for _, v := range []cty.Value{a, b} {
+		for it := v.ElementIterator(); it.Next(); {
+			k, _ := it.Element()
+			keys[k.AsString()] = struct{}{}
+		}
+	}
+
+	ret := make([]string, 0, len(keys))
+	for k := range keys {
+		ret = append(ret, k)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// mappingElem returns the element of an object or map with the given key,
+// or a null value if there is none.
+func mappingElem(v cty.Value, key string) cty.Value {
+	if v.Type().IsObjectType() {
+		if !v.Type().HasAttribute(key) {
+			return cty.NullVal(cty.DynamicPseudoType)
+		}
+
+		return v.GetAttr(key)
+	}
+
+	k := cty.StringVal(key)
+	if v.HasIndex(k).False() {
+		return cty.NullVal(cty.DynamicPseudoType)
+	}
+
+	return v.Index(k)
+}
+
+func mappingStep(ty cty.Type, key string) cty.PathStep {
+	if ty.IsObjectType() {
+		return cty.GetAttrStep{Name: key}
+	}
+
+	return cty.IndexStep{Key: cty.StringVal(key)}
+}
+
+// -----------------------------------------------------------------------------
+// Lua Utilities
+
+const luaResourceDriftTypeName = "resourceDrift"
+
+const (
+	luaFunctionResourceDriftGetAddress           = "address"
+	luaFunctionResourceDriftGetModuleAddress     = "moduleAddress"
+	luaFunctionResourceDriftGetType              = "type"
+	luaFunctionResourceDriftGetName              = "name"
+	luaFunctionResourceDriftGetProviderName      = "providerName"
+	luaFunctionResourceDriftGetDeposed           = "deposed"
+	luaFunctionResourceDriftGetAction            = "action"
+	luaFunctionResourceDriftGetBefore            = "before"
+	luaFunctionResourceDriftGetAfter             = "after"
+	luaFunctionResourceDriftGetChangedAttributes = "changedAttributes"
+)
+
+// RegisterResourceDriftType registers the ResourceDrift type inside the Lua
+// state.
+func RegisterResourceDriftType(ls *lua.LState) {
+	var methods = map[string]lua.LGFunction{
+		luaFunctionResourceDriftGetAddress:           resourceDriftGetAddress,
+		luaFunctionResourceDriftGetModuleAddress:     resourceDriftGetModuleAddress,
+		luaFunctionResourceDriftGetType:              resourceDriftGetType,
+		luaFunctionResourceDriftGetName:              resourceDriftGetName,
+		luaFunctionResourceDriftGetProviderName:      resourceDriftGetProviderName,
+		luaFunctionResourceDriftGetDeposed:           resourceDriftGetDeposed,
+		luaFunctionResourceDriftGetAction:            resourceDriftGetAction,
+		luaFunctionResourceDriftGetBefore:            resourceDriftGetBefore,
+		luaFunctionResourceDriftGetAfter:             resourceDriftGetAfter,
+		luaFunctionResourceDriftGetChangedAttributes: resourceDriftGetChangedAttributes,
+	}
+
+	mt := ls.NewTypeMetatable(luaResourceDriftTypeName)
+	ls.SetGlobal(luaResourceDriftTypeName, mt)
+
+	// methods
+	ls.SetField(mt, "__index", ls.SetFuncs(ls.NewTable(), methods))
+}
+
+// LResourceDrift creates a new resourceDrift userdata wrapping the specified
+// object.
+func LResourceDrift(ls *lua.LState, rd *ResourceDrift) *lua.LUserData {
+	ud := ls.NewUserData()
+	ud.Value = rd
+	ls.SetMetatable(ud, ls.GetTypeMetatable(luaResourceDriftTypeName))
+
+	return ud
+}
+
+// CheckResourceDrift checks whether the first lua argument is a *LUserData
+// with *ResourceDrift and returns this *ResourceDrift.
+func CheckResourceDrift(ls *lua.LState) (*ResourceDrift, error) {
+	ud := ls.CheckUserData(1)
+	if v, ok := ud.Value.(*ResourceDrift); ok {
+		return v, nil
+	}
+
+	ls.ArgError(1, fmt.Sprintf("%s expected", luaResourceDriftTypeName))
+
+	return nil, ErrInvalidType
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+func resourceDriftGetAddress(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Address()))
+
+	return 1
+}
+
+func resourceDriftGetModuleAddress(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.ModuleAddress()))
+
+	return 1
+}
+
+func resourceDriftGetType(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Type()))
+
+	return 1
+}
+
+func resourceDriftGetName(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Name()))
+
+	return 1
+}
+
+func resourceDriftGetProviderName(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.ProviderName()))
+
+	return 1
+}
+
+func resourceDriftGetDeposed(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	if deposed := r.Deposed(); deposed != "" {
+		ls.Push(lua.LString(deposed))
+	} else {
+		ls.Push(lua.LNil)
+	}
+
+	return 1
+}
+
+func resourceDriftGetAction(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Action()))
+
+	return 1
+}
+
+func resourceDriftGetBefore(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	val, err := r.Before()
+	if err != nil {
+		ls.RaiseError("%v", err)
+
+		return 0
+	}
+
+	ls.Push(luaValue(ls, val))
+
+	return 1
+}
+
+func resourceDriftGetAfter(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	val, err := r.After()
+	if err != nil {
+		ls.RaiseError("%v", err)
+
+		return 0
+	}
+
+	ls.Push(luaValue(ls, val))
+
+	return 1
+}
+
+func resourceDriftGetChangedAttributes(ls *lua.LState) int {
+	r, err := CheckResourceDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	paths := r.ChangedPaths()
+
+	ret := ls.CreateTable(len(paths), 0)
+	for _, p := range paths {
+		ret.Append(lua.LString(p))
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
@@ -0,0 +1,252 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const testDriftJSONPlan = `{
+  "format_version": "0.2",
+  "terraform_version": "1.0.3",
+  "prior_state": {
+    "format_version": "0.2",
+    "terraform_version": "1.0.3",
+    "values": {
+      "root_module": {
+        "resources": [
+          {
+            "address": "aws_instance.web",
+            "mode": "managed",
+            "type": "aws_instance",
+            "name": "web",
+            "provider_name": "registry.terraform.io/hashicorp/aws",
+            "values": { "id": "i-1", "instance_type": "t3.large", "tags": { "Name": "web", "Owner": "bob" } }
+          },
+          {
+            "address": "aws_instance.api",
+            "mode": "managed",
+            "type": "aws_instance",
+            "name": "api",
+            "provider_name": "registry.terraform.io/hashicorp/aws",
+            "values": { "id": "i-2", "instance_type": "t3.micro", "tags": {} }
+          },
+          {
+            "address": "data.aws_ami.ubuntu",
+            "mode": "data",
+            "type": "aws_ami",
+            "name": "ubuntu",
+            "provider_name": "registry.terraform.io/hashicorp/aws",
+            "values": { "id": "ami-2" }
+          }
+        ]
+      }
+    }
+  },
+  "resource_drift": [
+    {
+      "address": "aws_instance.web",
+      "mode": "managed",
+      "type": "aws_instance",
+      "name": "web",
+      "provider_name": "registry.terraform.io/hashicorp/aws",
+      "change": {
+        "actions": ["update"],
+        "before": { "id": "i-1", "instance_type": "t3.micro", "tags": { "Name": "web" } },
+        "after": { "id": "i-1", "instance_type": "t3.large", "tags": { "Name": "web", "Owner": "bob" } }
+      }
+    },
+    {
+      "address": "aws_instance.db",
+      "mode": "managed",
+      "type": "aws_instance",
+      "name": "db",
+      "provider_name": "registry.terraform.io/hashicorp/aws",
+      "change": {
+        "actions": ["delete"],
+        "before": { "id": "i-3", "instance_type": "t3.small", "tags": {} },
+        "after": null
+      }
+    },
+    {
+      "address": "data.aws_ami.ubuntu",
+      "mode": "data",
+      "type": "aws_ami",
+      "name": "ubuntu",
+      "provider_name": "registry.terraform.io/hashicorp/aws",
+      "change": {
+        "actions": ["update"],
+        "before": { "id": "ami-1" },
+        "after": { "id": "ami-2" }
+      }
+    }
+  ],
+  "resource_changes": []
+}`
+
+func driftAddresses(drift []*ResourceDrift) []string {
+	addresses := make([]string, 0, len(drift))
+	for _, d := range drift {
+		addresses = append(addresses, d.Address())
+	}
+
+	return addresses
+}
+
+func TestPlan_FindDrift(t *testing.T) {
+	planFile := loadTestJSONPlan(t, testDriftJSONPlan)
+	plan := &Plan{Plan: planFile.Plan}
+
+	tests := []struct {
+		name    string
+		filter  ResourceFilter
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "all",
+			want: []string{"aws_instance.db", "aws_instance.web"},
+		},
+		{
+			name:   "name",
+			filter: ResourceFilter{Type: "aws_instance", Name: "w*"},
+			want:   []string{"aws_instance.web"},
+		},
+		{
+			name:   "delete action",
+			filter: ResourceFilter{Action: DriftActionDelete},
+			want:   []string{"aws_instance.db"},
+		},
+		{
+			name:   "other action",
+			filter: ResourceFilter{Action: "create"},
+			want:   []string{},
+		},
+		{
+			name:   "brackets are not a character class",
+			filter: ResourceFilter{Name: "["},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := plan.FindDrift(tt.filter)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, driftAddresses(got))
+		})
+	}
+}
+
+func TestResourceDrift(t *testing.T) {
+	planFile := loadTestJSONPlan(t, testDriftJSONPlan)
+
+	drift, err := (&Plan{Plan: planFile.Plan}).Drift()
+	require.NoError(t, err)
+	require.Len(t, drift, 2)
+
+	deleted, updated := drift[0], drift[1]
+
+	assert.Equal(t, DriftActionUpdate, updated.Action())
+	assert.Equal(t, "aws_instance", updated.Type())
+	assert.Equal(t, "web", updated.Name())
+	assert.Equal(t, `provider["registry.terraform.io/hashicorp/aws"]`, updated.ProviderName())
+	assert.Equal(t, []string{"instance_type", "tags.Owner"}, updated.ChangedPaths())
+
+	before, err := updated.Before()
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal("t3.micro"), before.GetAttr("instance_type"))
+
+	after, err := updated.After()
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal("t3.large"), after.GetAttr("instance_type"))
+
+	assert.Equal(t, DriftActionDelete, deleted.Action())
+	assert.Empty(t, deleted.ChangedPaths())
+
+	after, err = deleted.After()
+	require.NoError(t, err)
+	assert.True(t, after.IsNull())
+
+	// Without the state of the previous run, there is nothing to compare.
+	drift, err = (&Plan{Plan: loadTestPlanFile(t, "tf-planfile").Plan}).Drift()
+	require.NoError(t, err)
+	assert.Empty(t, drift)
+}
+
+func TestChangedPaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		before cty.Value
+		after  cty.Value
+		want   []cty.Path
+	}{
+		{
+			name:   "equal",
+			before: cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("x")}),
+			after:  cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("x")}),
+		},
+		{
+			name:   "attribute",
+			before: cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("x"), "b": cty.True}),
+			after:  cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("y"), "b": cty.True}),
+			want:   []cty.Path{cty.GetAttrPath("a")},
+		},
+		{
+			name:   "added attribute",
+			before: cty.EmptyObjectVal,
+			after:  cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("y")}),
+			want:   []cty.Path{cty.GetAttrPath("a")},
+		},
+		{
+			name:   "list element",
+			before: cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+			after:  cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("c")}),
+			want:   []cty.Path{cty.IndexIntPath(1)},
+		},
+		{
+			name:   "list length",
+			before: cty.ListVal([]cty.Value{cty.StringVal("a")}),
+			after:  cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("c")}),
+			want:   []cty.Path{{}},
+		},
+		{
+			name:   "set",
+			before: cty.ObjectVal(map[string]cty.Value{"s": cty.SetVal([]cty.Value{cty.StringVal("a")})}),
+			after:  cty.ObjectVal(map[string]cty.Value{"s": cty.SetVal([]cty.Value{cty.StringVal("b")})}),
+			want:   []cty.Path{cty.GetAttrPath("s")},
+		},
+		{
+			name:   "null",
+			before: cty.ObjectVal(map[string]cty.Value{"m": cty.MapValEmpty(cty.String)}),
+			after:  cty.ObjectVal(map[string]cty.Value{"m": cty.NullVal(cty.Map(cty.String))}),
+			want:   []cty.Path{cty.GetAttrPath("m")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, changedPaths(tt.before, tt.after, nil))
+		})
+	}
+}
@@ -18,4 +18,7 @@ import "golang.org/x/xerrors"
 
 var (
 	ErrInvalidType = xerrors.New("validation failed")
+	// ErrUnsupportedFilter is returned when a resource filter uses criteria
+	// that do not apply to the searched resources.
+	ErrUnsupportedFilter = xerrors.New("unsupported filter")
 )
@@ -0,0 +1,630 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	version "github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/configs"
+	"github.com/hexbee-net/horus/pkg/terraform/tfdiags"
+)
+
+// jsonConfig is the configuration section of a JSON plan.
+type jsonConfig struct {
+	ProviderConfig map[string]jsonProviderConfig `json:"provider_config"`
+	RootModule     jsonConfigModule              `json:"root_module"`
+}
+
+type jsonProviderConfig struct {
+	Name              string                     `json:"name"`
+	FullName          string                     `json:"full_name"`
+	Alias             string                     `json:"alias"`
+	ModuleAddress     string                     `json:"module_address"`
+	VersionConstraint string                     `json:"version_constraint"`
+	Expressions       map[string]json.RawMessage `json:"expressions"`
+}
+
+type jsonConfigModule struct {
+	Outputs     map[string]jsonConfigOutput   `json:"outputs"`
+	Resources   []jsonConfigResource          `json:"resources"`
+	ModuleCalls map[string]jsonModuleCall     `json:"module_calls"`
+	Variables   map[string]jsonConfigVariable `json:"variables"`
+}
+
+type jsonConfigOutput struct {
+	Sensitive   bool            `json:"sensitive"`
+	Expression  *jsonExpression `json:"expression"`
+	DependsOn   []string        `json:"depends_on"`
+	Description string          `json:"description"`
+}
+
+type jsonConfigResource struct {
+	Address           string                     `json:"address"`
+	Mode              string                     `json:"mode"`
+	Type              string                     `json:"type"`
+	Name              string                     `json:"name"`
+	ProviderConfigKey string                     `json:"provider_config_key"`
+	Expressions       map[string]json.RawMessage `json:"expressions"`
+	CountExpression   *jsonExpression            `json:"count_expression"`
+	ForEachExpression *jsonExpression            `json:"for_each_expression"`
+	DependsOn         []string                   `json:"depends_on"`
+}
+
+type jsonModuleCall struct {
+	Source            string                     `json:"source"`
+	Expressions       map[string]json.RawMessage `json:"expressions"`
+	CountExpression   *jsonExpression            `json:"count_expression"`
+	ForEachExpression *jsonExpression            `json:"for_each_expression"`
+	Module            jsonConfigModule           `json:"module"`
+	VersionConstraint string                     `json:"version_constraint"`
+	DependsOn         []string                   `json:"depends_on"`
+}
+
+type jsonConfigVariable struct {
+	Default     json.RawMessage `json:"default"`
+	Description string          `json:"description"`
+	Sensitive   bool            `json:"sensitive"`
+}
+
+// config returns the configuration tree described by the JSON plan. A plan
+// without configuration is given an empty root module.
+func (c *jsonConfig) config() (*configs.Config, error) {
+	root := &configs.Config{
+		Path:     addrs.RootModule,
+		Children: map[string]*configs.Config{},
+	}
+	root.Root = root
+
+	if c == nil {
+		root.Module = newJSONConfigModule()
+
+		return root, nil
+	}
+
+	if err := c.RootModule.build(root); err != nil {
+		return nil, err
+	}
+
+	for key, pc := range c.ProviderConfig {
+		if err := pc.addTo(root); err != nil {
+			return nil, xerrors.Errorf("failed to load provider configuration '%s': %w", key, err)
+		}
+	}
+
+	// Resources refer to the configuration of their provider by key, which
+	// is resolved once all the provider configurations are known.
+	if err := c.RootModule.resolveProviders(root, c.ProviderConfig); err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
+
+func newJSONConfigModule() *configs.Module {
+	return &configs.Module{
+		ProviderConfigs:      map[string]*configs.Provider{},
+		ProviderRequirements: &configs.RequiredProviders{RequiredProviders: map[string]*configs.RequiredProvider{}},
+		ProviderLocalNames:   map[addrs.Provider]string{},
+		Variables:            map[string]*configs.Variable{},
+		Locals:               map[string]*configs.Local{},
+		Outputs:              map[string]*configs.Output{},
+		ModuleCalls:          map[string]*configs.ModuleCall{},
+		ManagedResources:     map[string]*configs.Resource{},
+		DataResources:        map[string]*configs.Resource{},
+	}
+}
+
+func (m *jsonConfigModule) build(cfg *configs.Config) error {
+	mod := newJSONConfigModule()
+	cfg.Module = mod
+
+	for name, v := range m.Variables {
+		def := cty.NilVal
+
+		if v.Default != nil {
+			var err error
+			if def, err = decodeJSONValue(v.Default); err != nil {
+				return xerrors.Errorf("failed to decode the default value of variable '%s': %w", name, err)
+			}
+		}
+
+		mod.Variables[name] = &configs.Variable{
+			Name:           name,
+			Description:    v.Description,
+			Default:        def,
+			Type:           cty.DynamicPseudoType,
+			ParsingMode:    configs.VariableParseHCL,
+			Sensitive:      v.Sensitive,
+			DescriptionSet: v.Description != "",
+			SensitiveSet:   v.Sensitive,
+		}
+	}
+
+	for name, o := range m.Outputs {
+		mod.Outputs[name] = &configs.Output{
+			Name:           name,
+			Description:    o.Description,
+			Expr:           o.Expression.expr(),
+			DependsOn:      parseTraversals(o.DependsOn),
+			Sensitive:      o.Sensitive,
+			DescriptionSet: o.Description != "",
+			SensitiveSet:   o.Sensitive,
+		}
+	}
+
+	for i := range m.Resources {
+		r, err := m.Resources[i].resource()
+		if err != nil {
+			return xerrors.Errorf("failed to load %s: %w", m.Resources[i].Address, err)
+		}
+
+		if r.Mode == addrs.DataResourceMode {
+			mod.DataResources[r.Addr().String()] = r
+		} else {
+			mod.ManagedResources[r.Addr().String()] = r
+		}
+	}
+
+	for name, call := range m.ModuleCalls {
+		mc := &configs.ModuleCall{
+			Name:       name,
+			SourceAddr: call.Source,
+			SourceSet:  call.Source != "",
+			Config:     newJSONBody(call.Expressions),
+			Count:      call.CountExpression.expr(),
+			ForEach:    call.ForEachExpression.expr(),
+			DependsOn:  parseTraversals(call.DependsOn),
+		}
+
+		if call.VersionConstraint != "" {
+			constraints, err := version.NewConstraint(call.VersionConstraint)
+			if err != nil {
+				return xerrors.Errorf("invalid version constraint of module '%s': %w", name, err)
+			}
+
+			mc.Version.Required = constraints
+		}
+
+		mod.ModuleCalls[name] = mc
+
+		child := &configs.Config{
+			Root:       cfg.Root,
+			Parent:     cfg,
+			Path:       cfg.Path.Child(name),
+			Children:   map[string]*configs.Config{},
+			SourceAddr: call.Source,
+		}
+
+		if err := call.Module.build(child); err != nil {
+			return xerrors.Errorf("failed to load module '%s': %w", name, err)
+		}
+
+		cfg.Children[name] = child
+	}
+
+	return nil
+}
+
+func (r *jsonConfigResource) resource() (*configs.Resource, error) {
+	ret := &configs.Resource{
+		Name:      r.Name,
+		Type:      r.Type,
+		Config:    newJSONBody(r.Expressions),
+		Count:     r.CountExpression.expr(),
+		ForEach:   r.ForEachExpression.expr(),
+		DependsOn: parseTraversals(r.DependsOn),
+	}
+
+	switch r.Mode {
+	case "managed":
+		ret.Mode = addrs.ManagedResourceMode
+		ret.Managed = &configs.ManagedResource{}
+	case "data":
+		ret.Mode = addrs.DataResourceMode
+	default:
+		return nil, xerrors.Errorf("invalid resource mode '%s'", r.Mode)
+	}
+
+	return ret, nil
+}
+
+// resolveProviders sets the provider of the resources of the module and of
+// its children.
+func (m *jsonConfigModule) resolveProviders(cfg *configs.Config, providers map[string]jsonProviderConfig) error {
+	for i := range m.Resources {
+		jr := &m.Resources[i]
+
+		r := cfg.Module.ResourceByAddr(addrs.Resource{Mode: resourceMode(jr.Mode), Type: jr.Type, Name: jr.Name})
+		if r == nil {
+			continue
+		}
+
+		name, alias := splitProviderConfigKey(jr.ProviderConfigKey)
+
+		if pc, ok := providers[jr.ProviderConfigKey]; ok && pc.FullName != "" {
+			provider, diags := addrs.ParseProviderSourceString(pc.FullName)
+			if diags.HasErrors() {
+				return xerrors.Errorf("invalid provider name '%s' of %s: %w", pc.FullName, jr.Address, diags.Err())
+			}
+
+			r.Provider = provider
+		} else {
+			r.Provider = addrs.ImpliedProviderForUnqualifiedType(name)
+		}
+
+		// Like in the source configuration, the provider configuration is
+		// only referenced when it is not the default one.
+		if alias != "" || (name != "" && name != r.Addr().ImpliedProvider()) {
+			r.ProviderConfigRef = &configs.ProviderConfigRef{
+				Name:  name,
+				Alias: alias,
+			}
+		}
+	}
+
+	for name, call := range m.ModuleCalls {
+		if err := call.Module.resolveProviders(cfg.Children[name], providers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pc *jsonProviderConfig) addTo(root *configs.Config) error {
+	path := addrs.RootModule
+
+	if pc.ModuleAddress != "" {
+		module, diags := addrs.ParseModuleInstanceStr(pc.ModuleAddress)
+		if diags.HasErrors() {
+			return xerrors.Errorf("invalid module address '%s': %w", pc.ModuleAddress, diags.Err())
+		}
+
+		path = module.Module()
+	}
+
+	cfg := root.Descendent(path)
+	if cfg == nil {
+		return xerrors.Errorf("unknown module '%s'", pc.ModuleAddress)
+	}
+
+	provider := &configs.Provider{
+		Name:   pc.Name,
+		Alias:  pc.Alias,
+		Config: newJSONBody(pc.Expressions),
+	}
+
+	if pc.VersionConstraint != "" {
+		constraints, err := version.NewConstraint(pc.VersionConstraint)
+		if err != nil {
+			return xerrors.Errorf("invalid version constraint: %w", err)
+		}
+
+		provider.Version.Required = constraints
+	}
+
+	key := pc.Name
+	if pc.Alias != "" {
+		key += "." + pc.Alias
+	}
+
+	cfg.Module.ProviderConfigs[key] = provider
+
+	// Like resources, provider configurations without a full name use the
+	// provider implied by their local name.
+	providerType := addrs.ImpliedProviderForUnqualifiedType(pc.Name)
+
+	if pc.FullName != "" {
+		var diags tfdiags.Diagnostics
+		if providerType, diags = addrs.ParseProviderSourceString(pc.FullName); diags.HasErrors() {
+			return xerrors.Errorf("invalid provider name '%s': %w", pc.FullName, diags.Err())
+		}
+	}
+
+	cfg.Module.ProviderLocalNames[providerType] = pc.Name
+
+	if _, ok := cfg.Module.ProviderRequirements.RequiredProviders[pc.Name]; !ok {
+		cfg.Module.ProviderRequirements.RequiredProviders[pc.Name] = &configs.RequiredProvider{
+			Name:        pc.Name,
+			Source:      providerType.String(),
+			Type:        providerType,
+			Requirement: provider.Version,
+		}
+	}
+
+	return nil
+}
+
+// splitProviderConfigKey returns the local name and the alias of the
+// provider configuration identified by a key such as 'module.vpc:aws.east'.
+func splitProviderConfigKey(key string) (name, alias string) {
+	if i := strings.LastIndex(key, ":"); i >= 0 {
+		key = key[i+1:]
+	}
+
+	if i := strings.Index(key, "."); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+
+	return key, ""
+}
+
+func resourceMode(mode string) addrs.ResourceMode {
+	if mode == "data" {
+		return addrs.DataResourceMode
+	}
+
+	return addrs.ManagedResourceMode
+}
+
+func parseTraversals(refs []string) []hcl.Traversal {
+	ret := make([]hcl.Traversal, 0, len(refs))
+
+	for _, ref := range refs {
+		traversal, diags := hclsyntax.ParseTraversalAbs([]byte(ref), "", hcl.InitialPos)
+		if diags.HasErrors() {
+			continue
+		}
+
+		ret = append(ret, traversal)
+	}
+
+	return ret
+}
+
+// jsonExpression is an expression of the configuration of a JSON plan. Only
+// the value of constant expressions and the references of the others are
+// known.
+type jsonExpression struct {
+	ConstantValue json.RawMessage `json:"constant_value"`
+	References    []string        `json:"references"`
+}
+
+// expr returns the expression as an hcl.Expression, or nil if there is no
+// expression.
+func (e *jsonExpression) expr() hcl.Expression {
+	if e == nil {
+		return nil
+	}
+
+	val := cty.DynamicVal
+
+	if e.ConstantValue != nil {
+		v, err := decodeJSONValue(e.ConstantValue)
+		if err == nil {
+			val = v
+		}
+	}
+
+	return &jsonExpr{
+		val:        val,
+		references: parseTraversals(e.References),
+	}
+}
+
+// jsonExpr implements hcl.Expression for the expressions of a JSON plan.
+// The value of an expression that is not constant is unknown.
+type jsonExpr struct {
+	val        cty.Value
+	references []hcl.Traversal
+}
+
+func (e *jsonExpr) Value(*hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+	return e.val, nil
+}
+
+func (e *jsonExpr) Variables() []hcl.Traversal {
+	return e.references
+}
+
+func (e *jsonExpr) Range() hcl.Range {
+	return hcl.Range{}
+}
+
+func (e *jsonExpr) StartRange() hcl.Range {
+	return hcl.Range{}
+}
+
+// jsonBody implements hcl.Body for the expressions of a block of the
+// configuration of a JSON plan.
+//
+// Nested blocks are represented either by a list of bodies, or by a single
+// body. Blocks nested as maps cannot be told apart from single blocks and
+// are read as such.
+type jsonBody struct {
+	attrs  map[string]*jsonExpr
+	blocks map[string][]*jsonBody
+}
+
+func newJSONBody(expressions map[string]json.RawMessage) *jsonBody {
+	body := &jsonBody{
+		attrs:  map[string]*jsonExpr{},
+		blocks: map[string][]*jsonBody{},
+	}
+
+	for name, raw := range expressions {
+		var expr jsonExpression
+		if isJSONExpression(raw) && json.Unmarshal(raw, &expr) == nil {
+			if e, ok := expr.expr().(*jsonExpr); ok {
+				body.attrs[name] = e
+			}
+
+			continue
+		}
+
+		var list []map[string]json.RawMessage
+		if json.Unmarshal(raw, &list) == nil {
+			for _, b := range list {
+				body.blocks[name] = append(body.blocks[name], newJSONBody(b))
+			}
+
+			continue
+		}
+
+		var single map[string]json.RawMessage
+		if json.Unmarshal(raw, &single) == nil {
+			body.blocks[name] = append(body.blocks[name], newJSONBody(single))
+		}
+	}
+
+	return body
+}
+
+// isJSONExpression reports whether a raw JSON object is an expression, i.e.
+// only has the keys of an expression.
+func isJSONExpression(raw json.RawMessage) bool {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return false
+	}
+
+	for k := range obj {
+		if k != "constant_value" && k != "references" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *jsonBody) Content(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Diagnostics) {
+	content, remain, diags := b.partialContent(schema)
+
+	for _, name := range sortedAttrNames(remain.attrs) {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Unsupported argument",
+			Detail:   "An argument named \"" + name + "\" is not expected here.",
+		})
+	}
+
+	for _, name := range sortedBlockNames(remain.blocks) {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Unsupported block type",
+			Detail:   "Blocks of type \"" + name + "\" are not expected here.",
+		})
+	}
+
+	return content, diags
+}
+
+func (b *jsonBody) PartialContent(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	return b.partialContent(schema)
+}
+
+func (b *jsonBody) partialContent(schema *hcl.BodySchema) (*hcl.BodyContent, *jsonBody, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	content := &hcl.BodyContent{
+		Attributes: hcl.Attributes{},
+	}
+
+	remain := &jsonBody{
+		attrs:  make(map[string]*jsonExpr, len(b.attrs)),
+		blocks: make(map[string][]*jsonBody, len(b.blocks)),
+	}
+
+	for name, expr := range b.attrs {
+		remain.attrs[name] = expr
+	}
+
+	for name, blocks := range b.blocks {
+		remain.blocks[name] = blocks
+	}
+
+	for _, as := range schema.Attributes {
+		expr, ok := b.attrs[as.Name]
+		if !ok {
+			if as.Required {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Missing required argument",
+					Detail:   "The argument \"" + as.Name + "\" is required, but no definition was found.",
+				})
+			}
+
+			continue
+		}
+
+		content.Attributes[as.Name] = &hcl.Attribute{Name: as.Name, Expr: expr}
+
+		delete(remain.attrs, as.Name)
+	}
+
+	for _, bs := range schema.Blocks {
+		for _, block := range b.blocks[bs.Type] {
+			content.Blocks = append(content.Blocks, &hcl.Block{Type: bs.Type, Body: block})
+		}
+
+		delete(remain.blocks, bs.Type)
+	}
+
+	return content, remain, diags
+}
+
+func (b *jsonBody) JustAttributes() (hcl.Attributes, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	for _, name := range sortedBlockNames(b.blocks) {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Unexpected block",
+			Detail:   "Blocks of type \"" + name + "\" are not allowed here.",
+		})
+	}
+
+	attrs := make(hcl.Attributes, len(b.attrs))
+	for name, expr := range b.attrs {
+		attrs[name] = &hcl.Attribute{Name: name, Expr: expr}
+	}
+
+	return attrs, diags
+}
+
+func (b *jsonBody) MissingItemRange() hcl.Range {
+	return hcl.Range{}
+}
+
+func sortedAttrNames(attrs map[string]*jsonExpr) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func sortedBlockNames(blocks map[string][]*jsonBody) []string {
+	names := make([]string, 0, len(blocks))
+	for name := range blocks {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,719 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	version "github.com/hashicorp/go-version"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/configs"
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
+	"github.com/hexbee-net/horus/pkg/terraform/states/statefile"
+)
+
+// jsonPlan is the document produced by 'terraform show -json' for a plan.
+type jsonPlan struct {
+	FormatVersion    string                      `json:"format_version"`
+	TerraformVersion string                      `json:"terraform_version"`
+	Variables        map[string]jsonPlanVariable `json:"variables"`
+	PriorState       *jsonState                  `json:"prior_state"`
+	ResourceDrift    []jsonResourceChange        `json:"resource_drift"`
+	ResourceChanges  []jsonResourceChange        `json:"resource_changes"`
+	OutputChanges    map[string]jsonChange       `json:"output_changes"`
+	Configuration    *jsonConfig                 `json:"configuration"`
+	Checks           []jsonCheck                 `json:"checks"`
+	Errored          bool                        `json:"errored"`
+	Timestamp        string                      `json:"timestamp"`
+}
+
+type jsonPlanVariable struct {
+	Value json.RawMessage `json:"value"`
+}
+
+type jsonResourceChange struct {
+	Address      string     `json:"address"`
+	PrevAddress  string     `json:"previous_address"`
+	Mode         string     `json:"mode"`
+	Type         string     `json:"type"`
+	Name         string     `json:"name"`
+	ProviderName string     `json:"provider_name"`
+	Deposed      string     `json:"deposed"`
+	Change       jsonChange `json:"change"`
+	ActionReason string     `json:"action_reason"`
+}
+
+type jsonChange struct {
+	Actions         []string          `json:"actions"`
+	Before          json.RawMessage   `json:"before"`
+	After           json.RawMessage   `json:"after"`
+	AfterUnknown    json.RawMessage   `json:"after_unknown"`
+	BeforeSensitive json.RawMessage   `json:"before_sensitive"`
+	AfterSensitive  json.RawMessage   `json:"after_sensitive"`
+	ReplacePaths    []json.RawMessage `json:"replace_paths"`
+	Importing       *jsonImporting    `json:"importing"`
+	GeneratedConfig string            `json:"generated_config"`
+}
+
+type jsonImporting struct {
+	ID string `json:"id"`
+}
+
+type jsonCheck struct {
+	Address   jsonCheckAddress    `json:"address"`
+	Status    string              `json:"status"`
+	Instances []jsonCheckInstance `json:"instances"`
+}
+
+type jsonCheckAddress struct {
+	Kind      string `json:"kind"`
+	ToDisplay string `json:"to_display"`
+}
+
+type jsonCheckInstance struct {
+	Address  jsonCheckAddress   `json:"address"`
+	Status   string             `json:"status"`
+	Problems []jsonCheckProblem `json:"problems"`
+}
+
+type jsonCheckProblem struct {
+	Message string `json:"message"`
+}
+
+type jsonState struct {
+	FormatVersion    string           `json:"format_version"`
+	TerraformVersion string           `json:"terraform_version"`
+	Values           *jsonStateValues `json:"values"`
+}
+
+type jsonStateValues struct {
+	Outputs    map[string]jsonStateOutput `json:"outputs"`
+	RootModule jsonStateModule            `json:"root_module"`
+}
+
+type jsonStateOutput struct {
+	Sensitive bool            `json:"sensitive"`
+	Value     json.RawMessage `json:"value"`
+}
+
+type jsonStateModule struct {
+	Address      string              `json:"address"`
+	Resources    []jsonStateResource `json:"resources"`
+	ChildModules []jsonStateModule   `json:"child_modules"`
+}
+
+type jsonStateResource struct {
+	Address         string          `json:"address"`
+	ProviderName    string          `json:"provider_name"`
+	SchemaVersion   uint64          `json:"schema_version"`
+	Values          json.RawMessage `json:"values"`
+	SensitiveValues json.RawMessage `json:"sensitive_values"`
+	DependsOn       []string        `json:"depends_on"`
+	Tainted         bool            `json:"tainted"`
+	DeposedKey      string          `json:"deposed_key"`
+}
+
+// LoadJSONPlanFile loads a plan in the JSON format produced by
+// 'terraform show -json'.
+//
+// The JSON format does not carry the source of the configuration: the
+// configuration of the returned plan file has no source ranges and its
+// expressions only know their constant values and references. It does not
+// carry the state of the previous run either, which is rebuilt from the
+// prior state and the drift reported by Terraform.
+func LoadJSONPlanFile(r io.Reader) (*PlanFile, error) {
+	var doc jsonPlan
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	if err := dec.Decode(&doc); err != nil {
+		return nil, xerrors.Errorf("failed to decode JSON plan: %w", err)
+	}
+
+	if doc.FormatVersion == "" {
+		return nil, xerrors.New("failed to decode JSON plan: missing format version")
+	}
+
+	config, err := doc.Configuration.config()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load configuration data: %w", err)
+	}
+
+	state, err := doc.PriorState.stateFile(doc.TerraformVersion)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load state data: %w", err)
+	}
+
+	prevState, err := doc.prevStateFile(state)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load previous state data: %w", err)
+	}
+
+	plan, details, err := doc.plan(config)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load plan data: %w", err)
+	}
+
+	plan.PriorState = state.State
+	plan.PrevRunState = prevState.State
+
+	return &PlanFile{
+		Plan:      plan,
+		State:     state,
+		PrevState: prevState,
+		Config:    config,
+		Details:   details,
+	}, nil
+}
+
+func (p *jsonPlan) plan(config *configs.Config) (*plans.Plan, *PlanDetails, error) {
+	plan := &plans.Plan{
+		VariableValues: make(map[string]plans.DynamicValue, len(p.Variables)),
+		Changes:        plans.NewChanges(),
+	}
+
+	details, err := p.details()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, v := range p.Variables {
+		val, err := decodeJSONValue(v.Value)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to decode the value of variable '%s': %w", name, err)
+		}
+
+		if plan.VariableValues[name], err = plans.NewDynamicValue(val, cty.DynamicPseudoType); err != nil {
+			return nil, nil, xerrors.Errorf("failed to encode the value of variable '%s': %w", name, err)
+		}
+	}
+
+	for i := range p.ResourceChanges {
+		rc, rcDetails, err := p.ResourceChanges[i].resourceChange(config)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to load the change of %s: %w", p.ResourceChanges[i].Address, err)
+		}
+
+		plan.Changes.Resources = append(plan.Changes.Resources, rc)
+		details.setResourceInstance(rc.Addr, rc.DeposedKey, rcDetails)
+	}
+
+	names := make([]string, 0, len(p.OutputChanges))
+	for name := range p.OutputChanges {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		oc, err := outputChange(name, p.OutputChanges[name])
+		if err != nil {
+			return nil, nil, xerrors.Errorf("failed to load the change of output '%s': %w", name, err)
+		}
+
+		plan.Changes.Outputs = append(plan.Changes.Outputs, oc)
+	}
+
+	return plan, details, nil
+}
+
+// details returns the details of the plan, but those of the resource
+// changes.
+func (p *jsonPlan) details() (*PlanDetails, error) {
+	ret := &PlanDetails{
+		TerraformVersion: p.TerraformVersion,
+		Errored:          p.Errored,
+		Timestamp:        p.Timestamp,
+	}
+
+	for i := range p.Checks {
+		ret.Checks = append(ret.Checks, p.Checks[i].checkResult())
+	}
+
+	// The drift is reported with the same structure as the changes, with
+	// no configuration to resolve provider aliases from.
+	for i := range p.ResourceDrift {
+		rc, _, err := p.ResourceDrift[i].resourceChange(nil)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to load the drift of %s: %w", p.ResourceDrift[i].Address, err)
+		}
+
+		ret.ResourceDrift = append(ret.ResourceDrift, rc)
+	}
+
+	if len(p.OutputChanges) > 0 {
+		ret.Outputs = make(map[string]*OutputDetails, len(p.OutputChanges))
+	}
+
+	for name, c := range p.OutputChanges {
+		beforeMarks, err := sensitivePathMarks(c.BeforeSensitive)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to decode the sensitivity of output '%s': %w", name, err)
+		}
+
+		afterMarks, err := sensitivePathMarks(c.AfterSensitive)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to decode the sensitivity of output '%s': %w", name, err)
+		}
+
+		ret.Outputs[name] = &OutputDetails{
+			BeforeSensitive: len(beforeMarks) > 0,
+			AfterSensitive:  len(afterMarks) > 0,
+		}
+	}
+
+	return ret, nil
+}
+
+func (c *jsonCheck) checkResult() CheckResult {
+	ret := CheckResult{
+		Kind:       c.Address.Kind,
+		ConfigAddr: c.Address.ToDisplay,
+		Status:     CheckStatus(c.Status),
+	}
+
+	for _, inst := range c.Instances {
+		obj := CheckObjectResult{
+			Address: inst.Address.ToDisplay,
+			Status:  CheckStatus(inst.Status),
+		}
+
+		for _, problem := range inst.Problems {
+			obj.FailureMessages = append(obj.FailureMessages, problem.Message)
+		}
+
+		ret.Objects = append(ret.Objects, obj)
+	}
+
+	return ret
+}
+
+func (rc *jsonResourceChange) resourceChange(
+	config *configs.Config,
+) (*plans.ResourceInstanceChangeSrc, *ResourceInstanceDetails, error) {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rc.Address)
+	if diags.HasErrors() {
+		return nil, nil, xerrors.Errorf("invalid address: %w", diags.Err())
+	}
+
+	provider, err := rc.providerConfig(addr, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	change, err := rc.Change.changeSrc()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	replace, err := rc.Change.requiredReplace()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	details := &ResourceInstanceDetails{
+		PrevRunAddr:     addr,
+		GeneratedConfig: rc.Change.GeneratedConfig,
+		ActionReason:    rc.ActionReason,
+	}
+
+	if rc.PrevAddress != "" {
+		if details.PrevRunAddr, diags = addrs.ParseAbsResourceInstanceStr(rc.PrevAddress); diags.HasErrors() {
+			return nil, nil, xerrors.Errorf("invalid previous address: %w", diags.Err())
+		}
+	}
+
+	if rc.Change.Importing != nil {
+		details.Importing = &Importing{ID: rc.Change.Importing.ID}
+	}
+
+	return &plans.ResourceInstanceChangeSrc{
+		Addr:         addr,
+		DeposedKey:   states.DeposedKey(rc.Deposed),
+		ProviderAddr: provider,
+		ChangeSrc:    *change,
+		// The reasons introduced after the vendored Terraform release are
+		// only kept in the details.
+		ActionReason:    actionReasons[rc.ActionReason],
+		RequiredReplace: replace,
+	}, details, nil
+}
+
+// providerConfig returns the address of the provider configuration of the
+// resource. The alias of the configuration is only known from the
+// configuration section of the plan.
+func (rc *jsonResourceChange) providerConfig(
+	addr addrs.AbsResourceInstance,
+	config *configs.Config,
+) (addrs.AbsProviderConfig, error) {
+	provider, diags := addrs.ParseProviderSourceString(rc.ProviderName)
+	if diags.HasErrors() {
+		return addrs.AbsProviderConfig{}, xerrors.Errorf("invalid provider name '%s': %w", rc.ProviderName, diags.Err())
+	}
+
+	ret := addrs.AbsProviderConfig{
+		Module:   addr.Module.Module(),
+		Provider: provider,
+	}
+
+	if config != nil {
+		if module := config.DescendentForInstance(addr.Module); module != nil {
+			if res := module.Module.ResourceByAddr(addr.Resource.Resource); res != nil && res.ProviderConfigRef != nil {
+				ret.Alias = res.ProviderConfigRef.Alias
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func (c *jsonChange) changeSrc() (*plans.ChangeSrc, error) {
+	action, err := parseActions(c.Actions)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := decodeJSONValue(c.Before)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode the prior value: %w", err)
+	}
+
+	after, err := decodeJSONValue(c.After)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode the planned value: %w", err)
+	}
+
+	afterUnknown, err := decodeJSONFlags(c.AfterUnknown)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode the unknown values: %w", err)
+	}
+
+	beforeMarks, err := sensitivePathMarks(c.BeforeSensitive)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode the sensitive prior values: %w", err)
+	}
+
+	afterMarks, err := sensitivePathMarks(c.AfterSensitive)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode the sensitive planned values: %w", err)
+	}
+
+	after = withUnknowns(after, afterUnknown)
+
+	ret := &plans.ChangeSrc{
+		Action:         action,
+		BeforeValMarks: beforeMarks,
+		AfterValMarks:  afterMarks,
+	}
+
+	// The values are encoded with their own types: the decoding of the
+	// change can then either infer them, or use the provider schema.
+	if ret.Before, err = plans.NewDynamicValue(before, before.Type()); err != nil {
+		return nil, xerrors.Errorf("failed to encode the prior value: %w", err)
+	}
+
+	if ret.After, err = plans.NewDynamicValue(after, after.Type()); err != nil {
+		return nil, xerrors.Errorf("failed to encode the planned value: %w", err)
+	}
+
+	return ret, nil
+}
+
+func (c *jsonChange) requiredReplace() (cty.PathSet, error) {
+	paths := make([]cty.Path, 0, len(c.ReplacePaths))
+
+	for _, raw := range c.ReplacePaths {
+		var steps []interface{}
+		if err := json.Unmarshal(raw, &steps); err != nil {
+			return cty.PathSet{}, xerrors.Errorf("invalid replace path: %w", err)
+		}
+
+		var path cty.Path
+
+		for _, step := range steps {
+			switch s := step.(type) {
+			case string:
+				path = path.GetAttr(s)
+			case float64:
+				path = path.IndexInt(int(s))
+			default:
+				return cty.PathSet{}, xerrors.Errorf("invalid replace path step %v", step)
+			}
+		}
+
+		paths = append(paths, path)
+	}
+
+	return cty.NewPathSet(paths...), nil
+}
+
+func outputChange(name string, c jsonChange) (*plans.OutputChangeSrc, error) {
+	change, err := c.changeSrc()
+	if err != nil {
+		return nil, err
+	}
+
+	// Output values are sensitive as a whole.
+	sensitive := len(change.BeforeValMarks) > 0 || len(change.AfterValMarks) > 0
+	change.BeforeValMarks, change.AfterValMarks = nil, nil
+
+	// Like in binary plans, the values of output changes are encoded with
+	// their type, since there is no schema to decode them with.
+	if change.Before, err = dynamicOutputValue(change.Before); err != nil {
+		return nil, xerrors.Errorf("failed to encode the prior value: %w", err)
+	}
+
+	if change.After, err = dynamicOutputValue(change.After); err != nil {
+		return nil, xerrors.Errorf("failed to encode the planned value: %w", err)
+	}
+
+	return &plans.OutputChangeSrc{
+		Addr:      addrs.OutputValue{Name: name}.Absolute(addrs.RootModuleInstance),
+		ChangeSrc: *change,
+		Sensitive: sensitive,
+	}, nil
+}
+
+// dynamicOutputValue re-encodes a value encoded with its own type so that it
+// can be decoded as cty.DynamicPseudoType.
+func dynamicOutputValue(v plans.DynamicValue) (plans.DynamicValue, error) {
+	val, _, err := DecodeSchemaless(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return plans.NewDynamicValue(val, cty.DynamicPseudoType) //nolint:wrapcheck // the error is wrapped by the caller.
+}
+
+func parseActions(actions []string) (plans.Action, error) {
+	switch key := fmt.Sprint(actions); key {
+	case "[no-op]":
+		return plans.NoOp, nil
+	case "[create]":
+		return plans.Create, nil
+	case "[read]":
+		return plans.Read, nil
+	case "[update]":
+		return plans.Update, nil
+	case "[delete]":
+		return plans.Delete, nil
+	case "[delete create]":
+		return plans.DeleteThenCreate, nil
+	case "[create delete]":
+		return plans.CreateThenDelete, nil
+	case "[forget]":
+		return actionForget, nil
+	default:
+		return plans.NoOp, xerrors.Errorf("unsupported actions %s", key)
+	}
+}
+
+// stateFile returns the state described by the prior state of a plan. A plan
+// without prior state applies to an empty state.
+func (s *jsonState) stateFile(terraformVersion string) (*statefile.File, error) {
+	ret := &statefile.File{
+		State: states.NewState(),
+	}
+
+	if s != nil && s.TerraformVersion != "" {
+		terraformVersion = s.TerraformVersion
+	}
+
+	if terraformVersion != "" {
+		v, err := version.NewVersion(terraformVersion)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid Terraform version '%s': %w", terraformVersion, err)
+		}
+
+		ret.TerraformVersion = v
+	}
+
+	if s == nil || s.Values == nil {
+		return ret, nil
+	}
+
+	root := ret.State.RootModule()
+
+	for name, out := range s.Values.Outputs {
+		val, err := decodeJSONValue(out.Value)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to decode the value of output '%s': %w", name, err)
+		}
+
+		root.SetOutputValue(name, val, out.Sensitive)
+	}
+
+	if err := s.Values.RootModule.addResources(ret.State); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (m *jsonStateModule) addResources(state *states.State) error {
+	for i := range m.Resources {
+		if err := m.Resources[i].addTo(state); err != nil {
+			return xerrors.Errorf("failed to load %s: %w", m.Resources[i].Address, err)
+		}
+	}
+
+	for i := range m.ChildModules {
+		if err := m.ChildModules[i].addResources(state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *jsonStateResource) addTo(state *states.State) error {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(r.Address)
+	if diags.HasErrors() {
+		return xerrors.Errorf("invalid address: %w", diags.Err())
+	}
+
+	provider, diags := addrs.ParseProviderSourceString(r.ProviderName)
+	if diags.HasErrors() {
+		return xerrors.Errorf("invalid provider name '%s': %w", r.ProviderName, diags.Err())
+	}
+
+	sensitive, err := sensitivePathMarks(r.SensitiveValues)
+	if err != nil {
+		return xerrors.Errorf("failed to decode the sensitive values: %w", err)
+	}
+
+	obj := &states.ResourceInstanceObjectSrc{
+		SchemaVersion:      r.SchemaVersion,
+		AttrsJSON:          r.Values,
+		AttrSensitivePaths: sensitive,
+		Status:             states.ObjectReady,
+	}
+
+	if r.Tainted {
+		obj.Status = states.ObjectTainted
+	}
+
+	for _, dep := range r.DependsOn {
+		depAddr, diags := addrs.ParseAbsResourceStr(dep)
+		if diags.HasErrors() {
+			return xerrors.Errorf("invalid dependency '%s': %w", dep, diags.Err())
+		}
+
+		obj.Dependencies = append(obj.Dependencies, depAddr.Config())
+	}
+
+	providerAddr := addrs.AbsProviderConfig{
+		Module:   addr.Module.Module(),
+		Provider: provider,
+	}
+
+	module := state.EnsureModule(addr.Module)
+
+	if r.DeposedKey != "" {
+		module.SetResourceInstanceDeposed(addr.Resource, states.DeposedKey(r.DeposedKey), obj, providerAddr)
+	} else {
+		module.SetResourceInstanceCurrent(addr.Resource, obj, providerAddr)
+	}
+
+	return nil
+}
+
+// prevStateFile rebuilds the state of the previous run by reverting the
+// drift detected during the refresh onto the prior state.
+func (p *jsonPlan) prevStateFile(prior *statefile.File) (*statefile.File, error) {
+	ret := &statefile.File{
+		TerraformVersion: prior.TerraformVersion,
+		State:            prior.State.DeepCopy(),
+	}
+
+	for i := range p.ResourceDrift {
+		if err := p.ResourceDrift[i].revertDrift(ret.State); err != nil {
+			return nil, xerrors.Errorf("failed to load the drift of %s: %w", p.ResourceDrift[i].Address, err)
+		}
+	}
+
+	return ret, nil
+}
+
+func (rc *jsonResourceChange) revertDrift(state *states.State) error {
+	addr, diags := addrs.ParseAbsResourceInstanceStr(rc.Address)
+	if diags.HasErrors() {
+		return xerrors.Errorf("invalid address: %w", diags.Err())
+	}
+
+	provider, diags := addrs.ParseProviderSourceString(rc.ProviderName)
+	if diags.HasErrors() {
+		return xerrors.Errorf("invalid provider name '%s': %w", rc.ProviderName, diags.Err())
+	}
+
+	providerAddr := addrs.AbsProviderConfig{
+		Module:   addr.Module.Module(),
+		Provider: provider,
+	}
+
+	var obj *states.ResourceInstanceObjectSrc
+
+	if !isJSONNull(rc.Change.Before) {
+		sensitive, err := sensitivePathMarks(rc.Change.BeforeSensitive)
+		if err != nil {
+			return xerrors.Errorf("failed to decode the sensitive prior values: %w", err)
+		}
+
+		obj = &states.ResourceInstanceObjectSrc{
+			AttrsJSON:          rc.Change.Before,
+			AttrSensitivePaths: sensitive,
+			Status:             states.ObjectReady,
+		}
+
+		// The drift does not record the schema version of the object,
+		// which is not expected to change outside of Terraform.
+		if current := stateObject(state, addr, states.DeposedKey(rc.Deposed)); current != nil {
+			obj.SchemaVersion = current.SchemaVersion
+			obj.Dependencies = current.Dependencies
+			obj.Status = current.Status
+		}
+	}
+
+	module := state.EnsureModule(addr.Module)
+
+	if rc.Deposed != "" {
+		module.SetResourceInstanceDeposed(addr.Resource, states.DeposedKey(rc.Deposed), obj, providerAddr)
+	} else {
+		module.SetResourceInstanceCurrent(addr.Resource, obj, providerAddr)
+	}
+
+	return nil
+}
+
+func stateObject(state *states.State, addr addrs.AbsResourceInstance, key states.DeposedKey) *states.ResourceInstanceObjectSrc {
+	is := state.ResourceInstance(addr)
+
+	switch {
+	case is == nil:
+		return nil
+	case key == states.NotDeposed:
+		return is.Current
+	default:
+		return is.Deposed[key]
+	}
+}
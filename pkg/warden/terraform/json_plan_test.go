@@ -0,0 +1,604 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
+)
+
+func TestLoadPlanFile_JSONParity(t *testing.T) {
+	binary := loadTestPlanFile(t, "tf-planfile")
+	jsonPlan := loadTestPlanFile(t, "tf-plan.json")
+
+	require.Len(t, jsonPlan.Plan.Changes.Resources, len(binary.Plan.Changes.Resources))
+
+	for _, schemas := range []*ProviderSchemas{nil, loadTestProviderSchemas(t)} {
+		binaryPlan := &Plan{Plan: binary.Plan, Schemas: schemas}
+		jsonPlan := &Plan{Plan: jsonPlan.Plan, Schemas: schemas}
+
+		want, err := binaryPlan.FindResources(ResourceFilter{})
+		require.NoError(t, err)
+
+		for _, w := range want {
+			got, err := jsonPlan.FindResources(ResourceFilter{Type: w.Type(), Name: w.Name()})
+			require.NoError(t, err)
+
+			var rc *ResourceChange
+
+			for _, g := range got {
+				if g.Address() == w.Address() {
+					rc = g
+				}
+			}
+
+			require.NotNil(t, rc, w.Address())
+
+			assert.Equal(t, w.Index(), rc.Index())
+			assert.Equal(t, w.Mode(), rc.Mode())
+			assert.Equal(t, w.ProviderName(), rc.ProviderName())
+			assert.Equal(t, w.Actions(), rc.Actions())
+
+			wantChange, err := w.Change()
+			require.NoError(t, err)
+
+			gotChange, err := rc.Change()
+			require.NoError(t, err)
+
+			assert.True(t, wantChange.Before.RawEquals(gotChange.Before), "%s: %#v", w.Address(), gotChange.Before)
+			assert.True(t, wantChange.After.RawEquals(gotChange.After), "%s: %#v", w.Address(), gotChange.After)
+		}
+	}
+}
+
+func TestLoadPlanFile_JSONConfig(t *testing.T) {
+	planFile := loadTestPlanFile(t, "tf-plan.json")
+
+	assert.Equal(t, "1.0.3", planFile.State.TerraformVersion.String())
+	assert.True(t, planFile.State.State.Empty())
+	assert.True(t, planFile.PrevState.State.Empty())
+
+	root := planFile.Config
+	require.NotNil(t, root)
+	assert.Same(t, root, root.Root)
+
+	simple := root.Module.ResourceByAddr(addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "aws_instance", Name: "simple_resource"})
+	require.NotNil(t, simple)
+	assert.Equal(t, addrs.NewDefaultProvider("aws"), simple.Provider)
+	assert.Nil(t, simple.ProviderConfigRef)
+	assert.Nil(t, simple.Count)
+
+	attrs, diags := simple.Config.JustAttributes()
+	require.False(t, diags.HasErrors())
+
+	instanceType, diags := attrs["instance_type"].Expr.Value(nil)
+	require.False(t, diags.HasErrors())
+	assert.Equal(t, cty.StringVal("t2.micro"), instanceType)
+
+	multiple := root.Module.ResourceByAddr(addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "aws_instance", Name: "multiple_resource"})
+	require.NotNil(t, multiple)
+
+	count, _ := multiple.Count.Value(nil)
+	assert.True(t, count.RawEquals(cty.NumberIntVal(3)))
+
+	foo := root.Module.ResourceByAddr(addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "null_resource", Name: "foo"})
+	require.NotNil(t, foo)
+	assert.Equal(t, addrs.NewDefaultProvider("null"), foo.Provider)
+
+	aws := root.Module.ProviderConfigs["aws"]
+	require.NotNil(t, aws)
+	assert.Equal(t, "~> 3.27", aws.Version.Required.String())
+
+	// Without full name, the required provider is implied by the local name.
+	awsReq := root.Module.ProviderRequirements.RequiredProviders["aws"]
+	require.NotNil(t, awsReq)
+	assert.Equal(t, addrs.NewDefaultProvider("aws"), awsReq.Type)
+	assert.Equal(t, "~> 3.27", awsReq.Requirement.Required.String())
+
+	// JSON plans carry no source ranges.
+	assert.Nil(t, planFile.ResourceRange("aws_instance.simple_resource"))
+}
+
+const testJSONPlan = `{
+  "format_version": "0.2",
+  "terraform_version": "1.0.3",
+  "variables": {
+    "region": { "value": "eu-west-3" }
+  },
+  "prior_state": {
+    "format_version": "0.2",
+    "terraform_version": "1.0.3",
+    "values": {
+      "outputs": {
+        "password": { "sensitive": true, "value": "hunter2" }
+      },
+      "root_module": {
+        "resources": [
+          {
+            "address": "aws_instance.web",
+            "mode": "managed",
+            "type": "aws_instance",
+            "name": "web",
+            "provider_name": "registry.terraform.io/hashicorp/aws",
+            "schema_version": 1,
+            "values": { "id": "i-1", "instance_type": "t3.large", "tags": { "Name": "web" } },
+            "sensitive_values": { "tags": {} },
+            "depends_on": ["aws_vpc.main"]
+          }
+        ],
+        "child_modules": [
+          {
+            "address": "module.net",
+            "resources": [
+              {
+                "address": "module.net.aws_vpc.main[\"a\"]",
+                "mode": "managed",
+                "type": "aws_vpc",
+                "name": "main",
+                "index": "a",
+                "provider_name": "registry.terraform.io/hashicorp/aws",
+                "schema_version": 1,
+                "values": { "id": "vpc-1" },
+                "tainted": true
+              }
+            ]
+          }
+        ]
+      }
+    }
+  },
+  "resource_drift": [
+    {
+      "address": "aws_instance.web",
+      "mode": "managed",
+      "type": "aws_instance",
+      "name": "web",
+      "provider_name": "registry.terraform.io/hashicorp/aws",
+      "change": {
+        "actions": ["update"],
+        "before": { "id": "i-1", "instance_type": "t3.micro", "tags": { "Name": "web" } },
+        "after": { "id": "i-1", "instance_type": "t3.large", "tags": { "Name": "web" } }
+      }
+    }
+  ],
+  "resource_changes": [
+    {
+      "address": "aws_instance.web",
+      "mode": "managed",
+      "type": "aws_instance",
+      "name": "web",
+      "provider_name": "registry.terraform.io/hashicorp/aws",
+      "change": {
+        "actions": ["delete", "create"],
+        "before": { "id": "i-1", "instance_type": "t3.large", "password": "old", "tags": { "Name": "web" } },
+        "after": { "instance_type": "t3.micro", "password": "new", "tags": { "Name": "web" }, "ips": [null, "10.0.0.1"] },
+        "after_unknown": { "id": true, "ips": [true, false], "tags": {} },
+        "before_sensitive": { "password": true, "tags": {} },
+        "after_sensitive": { "password": true, "tags": {}, "ips": [false, false] },
+        "replace_paths": [["instance_type"]]
+      },
+      "action_reason": "replace_because_cannot_update"
+    },
+    {
+      "address": "module.net.aws_vpc.main[\"a\"]",
+      "mode": "managed",
+      "type": "aws_vpc",
+      "name": "main",
+      "index": "a",
+      "provider_name": "registry.terraform.io/hashicorp/aws",
+      "change": {
+        "actions": ["no-op"],
+        "before": { "id": "vpc-1" },
+        "after": { "id": "vpc-1" },
+        "after_unknown": {},
+        "before_sensitive": {},
+        "after_sensitive": {}
+      }
+    }
+  ],
+  "output_changes": {
+    "password": {
+      "actions": ["update"],
+      "before": "hunter2",
+      "after": null,
+      "after_unknown": true,
+      "before_sensitive": true,
+      "after_sensitive": true
+    },
+    "id": {
+      "actions": ["create"],
+      "before": null,
+      "after": "i-2",
+      "after_unknown": false,
+      "before_sensitive": false,
+      "after_sensitive": false
+    }
+  },
+  "configuration": {
+    "provider_config": {
+      "aws": { "name": "aws" },
+      "module.net:aws.east": {
+        "name": "aws",
+        "full_name": "registry.terraform.io/hashicorp/aws",
+        "alias": "east",
+        "module_address": "module.net",
+        "expressions": { "region": { "constant_value": "us-east-1" } }
+      }
+    },
+    "root_module": {
+      "outputs": {
+        "password": { "sensitive": true, "expression": { "references": ["var.password"] } }
+      },
+      "resources": [
+        {
+          "address": "aws_instance.web",
+          "mode": "managed",
+          "type": "aws_instance",
+          "name": "web",
+          "provider_config_key": "aws",
+          "expressions": {
+            "instance_type": { "constant_value": "t3.micro" },
+            "subnet_id": { "references": ["module.net.subnet_id", "module.net"] },
+            "root_block_device": [ { "volume_size": { "constant_value": 20 } } ]
+          },
+          "depends_on": ["aws_vpc.main"]
+        }
+      ],
+      "module_calls": {
+        "net": {
+          "source": "terraform-aws-modules/vpc/aws",
+          "version_constraint": "~> 3.0",
+          "expressions": { "cidr": { "constant_value": "10.0.0.0/16" } },
+          "module": {
+            "resources": [
+              {
+                "address": "aws_vpc.main",
+                "mode": "managed",
+                "type": "aws_vpc",
+                "name": "main",
+                "provider_config_key": "module.net:aws.east",
+                "for_each_expression": { "references": ["var.vpcs"] }
+              },
+              {
+                "address": "data.aws_ami.ubuntu",
+                "mode": "data",
+                "type": "aws_ami",
+                "name": "ubuntu",
+                "provider_config_key": "module.net:aws.east"
+              }
+            ],
+            "variables": {
+              "vpcs": { "default": ["a"], "description": "VPC names" }
+            }
+          }
+        }
+      },
+      "variables": {
+        "password": { "sensitive": true }
+      }
+    }
+  }
+}`
+
+func loadTestJSONPlan(t *testing.T, doc string) *PlanFile {
+	t.Helper()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "plan.json", []byte(doc), 0o600))
+
+	file, err := fs.Open("plan.json")
+	require.NoError(t, err)
+
+	defer file.Close()
+
+	planFile, err := LoadPlanFile(file)
+	require.NoError(t, err)
+
+	return planFile
+}
+
+func TestLoadJSONPlanFile_Changes(t *testing.T) {
+	planFile := loadTestJSONPlan(t, testJSONPlan)
+	plan := &Plan{Plan: planFile.Plan}
+
+	web := findTestResourceChange(t, planFile, "aws_instance.web")
+	assert.Equal(t, "replace", web.Action())
+	assert.Equal(t, []string{"delete", "create"}, web.Actions())
+	assert.Equal(t, plans.ResourceInstanceReplaceBecauseCannotUpdate, web.tfResource.ActionReason)
+	assert.True(t, web.tfResource.RequiredReplace.Has(cty.GetAttrPath("instance_type")))
+
+	change, err := web.Change()
+	require.NoError(t, err)
+
+	after, afterMarks := change.After.UnmarkDeepWithPaths()
+	assert.False(t, after.GetAttr("id").IsKnown())
+	assert.False(t, after.GetAttr("ips").Index(cty.NumberIntVal(0)).IsKnown())
+	assert.Equal(t, cty.StringVal("10.0.0.1"), after.GetAttr("ips").Index(cty.NumberIntVal(1)))
+	assert.Equal(t, []cty.PathValueMarks{{
+		Path:  cty.GetAttrPath("password"),
+		Marks: cty.NewValueMarks(sensitiveMark),
+	}}, afterMarks)
+
+	before, _ := change.Before.UnmarkDeep()
+	assert.Equal(t, cty.StringVal("old"), before.GetAttr("password"))
+	assert.True(t, change.Before.GetAttr("password").HasMark(sensitiveMark))
+
+	vpcs, err := plan.FindResources(ResourceFilter{Module: "module.net", Provider: "aws"})
+	require.NoError(t, err)
+	require.Len(t, vpcs, 1)
+	assert.Equal(t, "module.net", vpcs[0].ModuleAddress())
+	assert.Equal(t, addrs.StringKey("a"), vpcs[0].Index())
+	assert.Equal(t, "no-op", vpcs[0].Action())
+	assert.Equal(t, "east", vpcs[0].tfResource.ProviderAddr.Alias)
+
+	outputs := planFile.Plan.Changes.Outputs
+	require.Len(t, outputs, 2)
+	assert.Equal(t, "output.id", outputs[0].Addr.String())
+	assert.False(t, outputs[0].Sensitive)
+	assert.Equal(t, plans.Create, outputs[0].Action)
+	assert.Equal(t, "output.password", outputs[1].Addr.String())
+	assert.True(t, outputs[1].Sensitive)
+	assert.Equal(t, plans.Update, outputs[1].Action)
+
+	region, err := planFile.Plan.VariableValues["region"].Decode(cty.DynamicPseudoType)
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal("eu-west-3"), region)
+}
+
+func TestLoadJSONPlanFile_States(t *testing.T) {
+	planFile := loadTestJSONPlan(t, testJSONPlan)
+
+	prior := planFile.State.State
+	assert.Same(t, prior, planFile.Plan.PriorState)
+
+	web := prior.ResourceInstance(addrs.Resource{
+		Mode: addrs.ManagedResourceMode,
+		Type: "aws_instance",
+		Name: "web",
+	}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance))
+	require.NotNil(t, web)
+	require.NotNil(t, web.Current)
+	assert.JSONEq(t, `{"id": "i-1", "instance_type": "t3.large", "tags": {"Name": "web"}}`, string(web.Current.AttrsJSON))
+	assert.Equal(t, uint64(1), web.Current.SchemaVersion)
+	assert.Equal(t, states.ObjectReady, web.Current.Status)
+	require.Len(t, web.Current.Dependencies, 1)
+	assert.Equal(t, "aws_vpc.main", web.Current.Dependencies[0].String())
+
+	vpcAddr, diags := addrs.ParseAbsResourceInstanceStr(`module.net.aws_vpc.main["a"]`)
+	require.False(t, diags.HasErrors())
+
+	vpc := prior.ResourceInstance(vpcAddr)
+	require.NotNil(t, vpc)
+	assert.Equal(t, states.ObjectTainted, vpc.Current.Status)
+
+	password := prior.RootModule().OutputValues["password"]
+	require.NotNil(t, password)
+	assert.True(t, password.Sensitive)
+	assert.Equal(t, cty.StringVal("hunter2"), password.Value)
+
+	// The previous run state has the values from before the drift.
+	prev := planFile.PrevState.State
+	assert.Same(t, prev, planFile.Plan.PrevRunState)
+
+	prevWeb := prev.ResourceInstance(addrs.Resource{
+		Mode: addrs.ManagedResourceMode,
+		Type: "aws_instance",
+		Name: "web",
+	}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance))
+	require.NotNil(t, prevWeb)
+	assert.JSONEq(t, `{"id": "i-1", "instance_type": "t3.micro", "tags": {"Name": "web"}}`, string(prevWeb.Current.AttrsJSON))
+	assert.Equal(t, uint64(1), prevWeb.Current.SchemaVersion)
+	assert.NotNil(t, prev.ResourceInstance(vpcAddr))
+}
+
+func TestLoadJSONPlanFile_Config(t *testing.T) {
+	planFile := loadTestJSONPlan(t, testJSONPlan)
+	root := planFile.Config
+
+	password := root.Module.Variables["password"]
+	require.NotNil(t, password)
+	assert.True(t, password.Sensitive)
+	assert.Equal(t, cty.NilVal, password.Default)
+
+	output := root.Module.Outputs["password"]
+	require.NotNil(t, output)
+	assert.True(t, output.Sensitive)
+	require.Len(t, output.Expr.Variables(), 1)
+	assert.Equal(t, "var", output.Expr.Variables()[0].RootName())
+
+	web := root.Module.ResourceByAddr(addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "aws_instance", Name: "web"})
+	require.NotNil(t, web)
+	require.Len(t, web.DependsOn, 1)
+
+	content, diags := web.Config.Content(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{
+			{Name: "instance_type", Required: true},
+			{Name: "subnet_id"},
+		},
+		Blocks: []hcl.BlockHeaderSchema{{Type: "root_block_device"}},
+	})
+	require.False(t, diags.HasErrors(), diags.Error())
+
+	subnet, _ := content.Attributes["subnet_id"].Expr.Value(nil)
+	assert.False(t, subnet.IsKnown())
+	assert.Len(t, content.Attributes["subnet_id"].Expr.Variables(), 2)
+
+	require.Len(t, content.Blocks, 1)
+
+	blockAttrs, diags := content.Blocks[0].Body.JustAttributes()
+	require.False(t, diags.HasErrors())
+
+	size, _ := blockAttrs["volume_size"].Expr.Value(nil)
+	assert.True(t, size.RawEquals(cty.NumberIntVal(20)))
+
+	_, diags = web.Config.Content(&hcl.BodySchema{})
+	assert.True(t, diags.HasErrors())
+
+	_, diags = web.Config.JustAttributes()
+	assert.True(t, diags.HasErrors())
+
+	net := root.Children["net"]
+	require.NotNil(t, net)
+	assert.Same(t, root, net.Parent)
+	assert.Equal(t, "module.net", net.Path.String())
+	assert.Equal(t, "terraform-aws-modules/vpc/aws", net.SourceAddr)
+	assert.Equal(t, "~> 3.0", root.Module.ModuleCalls["net"].Version.Required.String())
+	assert.Equal(t, []string{"a"}, ctyStrings(t, net.Module.Variables["vpcs"].Default))
+
+	vpc := net.Module.ResourceByAddr(addrs.Resource{Mode: addrs.ManagedResourceMode, Type: "aws_vpc", Name: "main"})
+	require.NotNil(t, vpc)
+	require.NotNil(t, vpc.ProviderConfigRef)
+	assert.Equal(t, "aws", vpc.ProviderConfigRef.Name)
+	assert.Equal(t, "east", vpc.ProviderConfigRef.Alias)
+	assert.NotNil(t, vpc.ForEach)
+	assert.NotNil(t, vpc.Managed)
+
+	ami := net.Module.ResourceByAddr(addrs.Resource{Mode: addrs.DataResourceMode, Type: "aws_ami", Name: "ubuntu"})
+	require.NotNil(t, ami)
+	assert.Nil(t, ami.Managed)
+
+	east := net.Module.ProviderConfigs["aws.east"]
+	require.NotNil(t, east)
+	assert.Equal(t, "east", east.Alias)
+	assert.Equal(t, "registry.terraform.io/hashicorp/aws", net.Module.ProviderRequirements.RequiredProviders["aws"].Source)
+}
+
+func ctyStrings(t *testing.T, v cty.Value) []string {
+	t.Helper()
+
+	var ret []string
+	for it := v.ElementIterator(); it.Next(); {
+		_, e := it.Element()
+		ret = append(ret, e.AsString())
+	}
+
+	return ret
+}
+
+func TestLoadJSONPlanFile_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr string
+	}{
+		{
+			name:    "invalid document",
+			doc:     `{"format_version": `,
+			wantErr: "failed to decode JSON plan",
+		},
+		{
+			name:    "not a plan",
+			doc:     `{"hello": "world"}`,
+			wantErr: "missing format version",
+		},
+		{
+			name: "invalid actions",
+			doc: `{"format_version": "0.2", "resource_changes": [{
+				"address": "null_resource.a", "provider_name": "registry.terraform.io/hashicorp/null",
+				"change": {"actions": ["explode"]}
+			}]}`,
+			wantErr: "unsupported actions [explode]",
+		},
+		{
+			name: "invalid address",
+			doc: `{"format_version": "0.2", "resource_changes": [{
+				"address": "not an address", "provider_name": "registry.terraform.io/hashicorp/null",
+				"change": {"actions": ["create"]}
+			}]}`,
+			wantErr: "invalid address",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadJSONPlanFile(strings.NewReader(tt.doc))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLoadJSONPlanFile_Details(t *testing.T) {
+	planFile := loadTestJSONPlan(t, `{
+		"format_version": "1.2",
+		"terraform_version": "1.7.0",
+		"errored": true,
+		"timestamp": "2024-01-17T10:00:00Z",
+		"resource_changes": [{
+			"address": "null_resource.new",
+			"previous_address": "null_resource.old",
+			"provider_name": "registry.terraform.io/hashicorp/null",
+			"action_reason": "replace_by_triggers",
+			"change": {
+				"actions": ["create"], "before": null, "after": {"id": "abc"},
+				"importing": {"id": "abc"}, "generated_config": "resource \"null_resource\" \"new\" {}"
+			}
+		}, {
+			"address": "null_resource.gone",
+			"provider_name": "registry.terraform.io/hashicorp/null",
+			"change": {"actions": ["forget"], "before": {"id": "gone"}, "after": null}
+		}],
+		"checks": [{
+			"address": {"kind": "resource", "to_display": "null_resource.new"},
+			"status": "fail",
+			"instances": [{
+				"address": {"to_display": "null_resource.new"},
+				"status": "fail",
+				"problems": [{"message": "the id must be known"}]
+			}]
+		}]
+	}`)
+
+	details := planFile.Details
+	require.NotNil(t, details)
+	assert.Equal(t, "1.7.0", details.TerraformVersion)
+	assert.True(t, details.Errored)
+	assert.Equal(t, "2024-01-17T10:00:00Z", details.Timestamp)
+	assert.Equal(t, []CheckResult{{
+		Kind:       "resource",
+		ConfigAddr: "null_resource.new",
+		Status:     CheckStatusFail,
+		Objects: []CheckObjectResult{{
+			Address:         "null_resource.new",
+			Status:          CheckStatusFail,
+			FailureMessages: []string{"the id must be known"},
+		}},
+	}}, details.Checks)
+
+	plan := &Plan{Plan: planFile.Plan, Details: details}
+	resources, err := plan.FindResources(ResourceFilter{})
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+
+	assert.Equal(t, "null_resource.old", resources[0].PrevRunAddress())
+	assert.Equal(t, &Importing{ID: "abc"}, resources[0].Importing())
+	assert.Equal(t, "replace_by_triggers", resources[0].ActionReason())
+
+	assert.Equal(t, "forget", resources[1].Action())
+	assert.Equal(t, "null_resource.gone", resources[1].PrevRunAddress())
+	assert.Nil(t, resources[1].Importing())
+}
@@ -0,0 +1,156 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/xerrors"
+)
+
+// decodeJSONValue decodes a value of a JSON plan, with the type implied by
+// its JSON representation. An absent value is decoded as an untyped null.
+func decodeJSONValue(raw json.RawMessage) (cty.Value, error) {
+	if isJSONNull(raw) {
+		return cty.NullVal(cty.DynamicPseudoType), nil
+	}
+
+	ty, err := ctyjson.ImpliedType(raw)
+	if err != nil {
+		return cty.NilVal, xerrors.Errorf("failed to infer the value type: %w", err)
+	}
+
+	val, err := ctyjson.Unmarshal(raw, ty)
+	if err != nil {
+		return cty.NilVal, err //nolint:wrapcheck // the error is wrapped by the caller.
+	}
+
+	return val, nil
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+
+	return len(raw) == 0 || bytes.Equal(raw, []byte("null"))
+}
+
+// decodeJSONFlags decodes the structures of a JSON plan flagging unknown or
+// sensitive values, such as 'after_unknown'. They mirror the structure of
+// the value they apply to, with true marking a flagged value.
+func decodeJSONFlags(raw json.RawMessage) (interface{}, error) {
+	if isJSONNull(raw) {
+		return nil, nil
+	}
+
+	var flags interface{}
+	if err := json.Unmarshal(raw, &flags); err != nil {
+		return nil, err //nolint:wrapcheck // the error is wrapped by the caller.
+	}
+
+	return flags, nil
+}
+
+// withUnknowns replaces the values flagged in the given structure by unknown
+// values. The JSON plan omits unknown attributes from objects and replaces
+// unknown elements of sequences by null, so attributes missing from an
+// object are added back.
+func withUnknowns(val cty.Value, flags interface{}) cty.Value {
+	switch f := flags.(type) {
+	case bool:
+		if f {
+			return cty.DynamicVal
+		}
+
+		return val
+	case map[string]interface{}:
+		if val.IsNull() || !val.IsKnown() || !val.Type().IsObjectType() {
+			return val
+		}
+
+		attrs := val.AsValueMap()
+		if attrs == nil {
+			attrs = make(map[string]cty.Value, len(f))
+		}
+
+		for name, flag := range f {
+			attr, ok := attrs[name]
+			if !ok {
+				attr = cty.NullVal(cty.DynamicPseudoType)
+			}
+
+			attrs[name] = withUnknowns(attr, flag)
+		}
+
+		return cty.ObjectVal(attrs)
+	case []interface{}:
+		if val.IsNull() || !val.IsKnown() || !val.Type().IsTupleType() {
+			return val
+		}
+
+		elems := val.AsValueSlice()
+		for i := range elems {
+			if i < len(f) {
+				elems[i] = withUnknowns(elems[i], f[i])
+			}
+		}
+
+		if len(elems) == 0 {
+			return val
+		}
+
+		return cty.TupleVal(elems)
+	default:
+		return val
+	}
+}
+
+// sensitivePathMarks returns the paths of the values flagged as sensitive in
+// a structure such as 'after_sensitive'.
+func sensitivePathMarks(raw json.RawMessage) ([]cty.PathValueMarks, error) {
+	flags, err := decodeJSONFlags(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []cty.PathValueMarks
+
+	var walk func(path cty.Path, flags interface{})
+	walk = func(path cty.Path, flags interface{}) {
+		switch f := flags.(type) {
+		case bool:
+			if f {
+				ret = append(ret, cty.PathValueMarks{
+					Path:  path.Copy(),
+					Marks: cty.NewValueMarks(sensitiveMark),
+				})
+			}
+		case map[string]interface{}:
+			for name, flag := range f {
+				walk(path.GetAttr(name), flag)
+			}
+		case []interface{}:
+			for i, flag := range f {
+				walk(path.IndexInt(i), flag)
+			}
+		}
+	}
+
+	walk(nil, flags)
+
+	return ret, nil
+}
@@ -0,0 +1,395 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lua
+
+import (
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/typeexpr"
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+const luaModuleTypeName = "configModule"
+
+const (
+	luaFunctionModulePath              = "path"
+	luaFunctionModuleSource            = "source"
+	luaFunctionModuleVersion           = "version"
+	luaFunctionModuleChildren          = "children"
+	luaFunctionModuleChild             = "child"
+	luaFunctionModuleResources         = "resources"
+	luaFunctionModuleFindResource      = "findResource"
+	luaFunctionModuleVariables         = "variables"
+	luaFunctionModuleOutputs           = "outputs"
+	luaFunctionModuleLocals            = "locals"
+	luaFunctionModuleProviders         = "providers"
+	luaFunctionModuleModuleCalls       = "moduleCalls"
+	luaFunctionModuleRequiredProviders = "requiredProviders"
+)
+
+// RegisterModuleType registers the configuration module type inside the Lua
+// state.
+func RegisterModuleType(ls *lua.LState) {
+	var methods = map[string]lua.LGFunction{
+		luaFunctionModulePath:              modulePath,
+		luaFunctionModuleSource:            moduleSource,
+		luaFunctionModuleVersion:           moduleVersion,
+		luaFunctionModuleChildren:          moduleChildren,
+		luaFunctionModuleChild:             moduleChild,
+		luaFunctionModuleResources:         moduleResources,
+		luaFunctionModuleFindResource:      moduleFindResource,
+		luaFunctionModuleVariables:         moduleVariables,
+		luaFunctionModuleOutputs:           moduleOutputs,
+		luaFunctionModuleLocals:            moduleLocals,
+		luaFunctionModuleProviders:         moduleProviders,
+		luaFunctionModuleModuleCalls:       moduleModuleCalls,
+		luaFunctionModuleRequiredProviders: moduleRequiredProviders,
+	}
+
+	mt := ls.NewTypeMetatable(luaModuleTypeName)
+	ls.SetGlobal(luaModuleTypeName, mt)
+
+	// methods
+	ls.SetField(mt, "__index", ls.SetFuncs(ls.NewTable(), methods))
+}
+
+// LModule creates a new configModule userdata wrapping the specified module,
+// or returns nil if the module is nil.
+func LModule(ls *lua.LState, m *terraform.Module) lua.LValue {
+	if m == nil {
+		return lua.LNil
+	}
+
+	ud := ls.NewUserData()
+	ud.Value = m
+	ls.SetMetatable(ud, ls.GetTypeMetatable(luaModuleTypeName))
+
+	return ud
+}
+
+// CheckModule checks whether the first lua argument is a *LUserData with
+// *terraform.Module and returns this *terraform.Module.
+func CheckModule(ls *lua.LState) (*terraform.Module, error) {
+	ud := ls.CheckUserData(1)
+	if v, ok := ud.Value.(*terraform.Module); ok {
+		return v, nil
+	}
+
+	ls.ArgError(1, "configModule expected")
+
+	return nil, xerrors.New("not a configModule variable")
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+func modulePath(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(m.Path()))
+
+	return 1
+}
+
+func moduleSource(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(optionalString(m.Source()))
+
+	return 1
+}
+
+func moduleVersion(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(optionalString(m.Version()))
+
+	return 1
+}
+
+func moduleChildren(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	children := m.Children()
+
+	ret := ls.CreateTable(len(children), 0)
+	for _, child := range children {
+		ret.Append(LModule(ls, child))
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// moduleChild returns the module called with the given name, or nil if
+// there is no such call or if the called module is not installed.
+func moduleChild(ls *lua.LState) int {
+	const ArgPosName = 2
+
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	name := ls.CheckString(ArgPosName)
+
+	ls.Push(LModule(ls, m.Child(name)))
+
+	return 1
+}
+
+func moduleResources(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lConfigResources(ls, m.Resources()))
+
+	return 1
+}
+
+func moduleFindResource(ls *lua.LState) int {
+	invalidCall := false
+
+	m, err := CheckModule(ls)
+	if err != nil {
+		invalidCall = true
+	}
+
+	filter, ok := checkFindResourceArgs(ls, luaFunctionModuleFindResource)
+	if !ok || invalidCall {
+		return 0
+	}
+
+	resources, err := m.FindResources(filter)
+	if err != nil {
+		ls.RaiseError("failed to search for resources in configuration: %v", err)
+
+		return 0
+	}
+
+	ls.Push(lConfigResources(ls, resources))
+
+	return 1
+}
+
+// moduleVariables returns the input variables of the module as a table
+// indexed by name, whose values are tables with the 'name', 'type',
+// 'description', 'sensitive', 'has_default', 'default' and 'range' fields.
+func moduleVariables(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	variables := m.Variables()
+
+	ret := ls.CreateTable(0, len(variables))
+
+	for _, v := range variables {
+		entry := ls.NewTable()
+		entry.RawSetString("name", lua.LString(v.Name))
+		entry.RawSetString("type", lua.LString(typeexpr.TypeString(v.Type)))
+		entry.RawSetString("description", optionalString(v.Description))
+		entry.RawSetString("sensitive", lua.LBool(v.Sensitive))
+		entry.RawSetString("has_default", lua.LBool(v.Default != cty.NilVal))
+		entry.RawSetString("default", lValue(ls, v.Default, v.Sensitive))
+		entry.RawSetString("range", terraform.LRange(ls, v.DeclRange))
+		ret.RawSetString(v.Name, entry)
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// moduleOutputs returns the output values of the module as a table indexed
+// by name, whose values are tables with the 'name', 'description',
+// 'sensitive', 'value' and 'range' fields.
+func moduleOutputs(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	outputs := m.Outputs()
+
+	ret := ls.CreateTable(0, len(outputs))
+
+	for _, o := range outputs {
+		entry := ls.NewTable()
+		entry.RawSetString("name", lua.LString(o.Name))
+		entry.RawSetString("description", optionalString(o.Description))
+		entry.RawSetString("sensitive", lua.LBool(o.Sensitive))
+		entry.RawSetString("value", terraform.LExpression(ls, o.Expr))
+		entry.RawSetString("range", terraform.LRange(ls, o.DeclRange))
+		ret.RawSetString(o.Name, entry)
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// moduleLocals returns the local values of the module as a table indexed by
+// name, whose values are tables with the 'name', 'value' and 'range' fields.
+func moduleLocals(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	locals := m.Locals()
+
+	ret := ls.CreateTable(0, len(locals))
+
+	for _, l := range locals {
+		entry := ls.NewTable()
+		entry.RawSetString("name", lua.LString(l.Name))
+		entry.RawSetString("value", terraform.LExpression(ls, l.Expr))
+		entry.RawSetString("range", terraform.LRange(ls, l.DeclRange))
+		ret.RawSetString(l.Name, entry)
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// moduleProviders returns the provider configurations of the module as a
+// table indexed by local name and alias, e.g. 'aws' or 'aws.east', whose
+// values are tables with the 'name', 'alias', 'version', 'attributes' and
+// 'range' fields.
+func moduleProviders(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	providers := m.ProviderConfigs()
+
+	ret := ls.CreateTable(0, len(providers))
+
+	for _, p := range providers {
+		key := p.Name
+		if p.Alias != "" {
+			key += "." + p.Alias
+		}
+
+		entry := ls.NewTable()
+		entry.RawSetString("name", lua.LString(p.Name))
+		entry.RawSetString("alias", optionalString(p.Alias))
+		entry.RawSetString("version", optionalString(p.Version.Required.String()))
+		entry.RawSetString("attributes", terraform.LBody(ls, p.Config))
+		entry.RawSetString("range", terraform.LRange(ls, p.DeclRange))
+		ret.RawSetString(key, entry)
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// moduleModuleCalls returns the module blocks of the module as a table
+// indexed by name, whose values are tables with the 'name', 'source',
+// 'version', 'count', 'for_each', 'inputs', 'depends_on' and 'range' fields.
+func moduleModuleCalls(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	calls := m.ModuleCalls()
+
+	ret := ls.CreateTable(0, len(calls))
+
+	for _, c := range calls {
+		entry := ls.NewTable()
+		entry.RawSetString("name", lua.LString(c.Name))
+		entry.RawSetString("source", lua.LString(c.SourceAddr))
+		entry.RawSetString("version", optionalString(c.Version.Required.String()))
+		entry.RawSetString("count", terraform.LExpression(ls, c.Count))
+		entry.RawSetString("for_each", terraform.LExpression(ls, c.ForEach))
+		entry.RawSetString("inputs", terraform.LBody(ls, c.Config))
+		entry.RawSetString("depends_on", terraform.LTraversals(ls, c.DependsOn))
+		entry.RawSetString("range", terraform.LRange(ls, c.DeclRange))
+		ret.RawSetString(c.Name, entry)
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// moduleRequiredProviders returns the providers required by the module as a
+// table indexed by local name, whose values are tables with the 'name',
+// 'source', 'version' and 'range' fields.
+func moduleRequiredProviders(ls *lua.LState) int {
+	m, err := CheckModule(ls)
+	if err != nil {
+		return 0
+	}
+
+	providers := m.RequiredProviders()
+
+	ret := ls.CreateTable(0, len(providers))
+
+	for _, p := range providers {
+		entry := ls.NewTable()
+		entry.RawSetString("name", lua.LString(p.Name))
+		entry.RawSetString("source", lua.LString(p.Type.String()))
+		entry.RawSetString("version", optionalString(p.Requirement.Required.String()))
+		entry.RawSetString("range", terraform.LRange(ls, p.DeclRange))
+		ret.RawSetString(p.Name, entry)
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+func lConfigResources(ls *lua.LState, resources []*terraform.ConfigResource) *lua.LTable {
+	ret := ls.CreateTable(len(resources), 0)
+	for _, r := range resources {
+		ret.Append(terraform.LConfigResource(ls, r))
+	}
+
+	return ret
+}
+
+// optionalString converts an empty string to nil.
+func optionalString(s string) lua.LValue {
+	if s == "" {
+		return lua.LNil
+	}
+
+	return lua.LString(s)
+}
@@ -0,0 +1,123 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lua
+
+import (
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+const luaDriftTypeName = "drift"
+
+const (
+	luaFunctionDriftResources    = "resources"
+	luaFunctionDriftFindResource = "findResource"
+)
+
+// RegisterDriftType registers the drift type inside the Lua state.
+func RegisterDriftType(ls *lua.LState) {
+	var methods = map[string]lua.LGFunction{
+		luaFunctionDriftResources:    driftResources,
+		luaFunctionDriftFindResource: driftFindResource,
+	}
+
+	mt := ls.NewTypeMetatable(luaDriftTypeName)
+	ls.SetGlobal(luaDriftTypeName, mt)
+
+	// methods
+	ls.SetField(mt, "__index", ls.SetFuncs(ls.NewTable(), methods))
+}
+
+// LDrift creates a new drift userdata exposing the changes made outside of
+// Terraform detected by the plan.
+func LDrift(ls *lua.LState, plan *terraform.Plan) *lua.LUserData {
+	ud := ls.NewUserData()
+	ud.Value = &drift{plan: plan}
+	ls.SetMetatable(ud, ls.GetTypeMetatable(luaDriftTypeName))
+
+	return ud
+}
+
+// drift wraps the plan so that the drift userdata does not share the
+// methods of the plan userdata.
+type drift struct {
+	plan *terraform.Plan
+}
+
+// CheckDrift checks whether the first lua argument is a drift *LUserData and
+// returns its plan.
+func CheckDrift(ls *lua.LState) (*terraform.Plan, error) {
+	ud := ls.CheckUserData(1)
+	if v, ok := ud.Value.(*drift); ok {
+		return v.plan, nil
+	}
+
+	ls.ArgError(1, "drift expected")
+
+	return nil, xerrors.New("not a drift variable")
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+// driftResources returns all the resource instance objects changed outside
+// of Terraform, ordered by address.
+func driftResources(ls *lua.LState) int {
+	p, err := CheckDrift(ls)
+	if err != nil {
+		return 0
+	}
+
+	return pushDrift(ls, p, terraform.ResourceFilter{})
+}
+
+func driftFindResource(ls *lua.LState) int {
+	// We use a flag instead of returning immediately to gather as much errors
+	// in one run to spare the user from needing to run the script multiple
+	// times before catching all the possible errors in the script.
+	invalidCall := false
+
+	p, err := CheckDrift(ls)
+	if err != nil {
+		invalidCall = true
+	}
+
+	filter, ok := checkFindResourceArgs(ls, luaFunctionDriftFindResource)
+	if !ok || invalidCall {
+		return 0
+	}
+
+	return pushDrift(ls, p, filter)
+}
+
+func pushDrift(ls *lua.LState, p *terraform.Plan, filter terraform.ResourceFilter) int {
+	resources, err := p.FindDrift(filter)
+	if err != nil {
+		ls.RaiseError("failed to search for drift in plan file: %v", err)
+
+		return 0
+	}
+
+	ret := ls.CreateTable(len(resources), 0)
+	for _, r := range resources {
+		ret.Append(terraform.LResourceDrift(ls, r))
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
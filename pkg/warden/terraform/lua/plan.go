@@ -42,12 +42,16 @@ const luaPlanTypeName = "plan"
 
 const (
 	luaFunctionPlanFindResource = "findResource"
+	luaFunctionPlanChecks       = "checks"
+	luaFunctionPlanOutputs      = "outputs"
 )
 
 // RegisterPlanType registers the plan type inside the Lua state.
 func RegisterPlanType(ls *lua.LState) {
 	var methods = map[string]lua.LGFunction{
 		luaFunctionPlanFindResource: planFindResource,
+		luaFunctionPlanChecks:       planChecks,
+		luaFunctionPlanOutputs:      planOutputs,
 	}
 
 	mt := ls.NewTypeMetatable(luaPlanTypeName)
@@ -81,12 +85,6 @@ func CheckPlan(ls *lua.LState) (*terraform.Plan, error) {
 // Lua Functions
 
 func planFindResource(ls *lua.LState) int {
-	const (
-		ArgCount           = 3
-		ArgPosResourceType = 2
-		ArgPosResourceName = 3
-	)
-
 	// We use a flag instead of returning immediately to gather as much errors
 	// in one run to spare the user from needing to run the script multiple
 	// times before catching all the possible errors in the script.
@@ -97,42 +95,203 @@ func planFindResource(ls *lua.LState) int {
 		invalidCall = true
 	}
 
-	if top := ls.GetTop(); top != ArgCount {
-		if top < ArgCount {
-			ls.ArgError(1, fmt.Sprintf("not enough arguments in call to '%s'", luaFunctionPlanFindResource))
-		} else {
-			ls.ArgError(1, fmt.Sprintf("too many arguments in call to '%s'", luaFunctionPlanFindResource))
+	filter, ok := checkFindResourceArgs(ls, luaFunctionPlanFindResource)
+	if !ok || invalidCall {
+		return 0
+	}
+
+	resources, err := p.FindResources(filter)
+	if err != nil {
+		ls.RaiseError("failed to search for resources in plan file: %v", err)
+
+		return 0
+	}
+
+	ret := ls.CreateTable(len(resources), 0)
+	for _, r := range resources {
+		ret.Append(terraform.LResourceChange(ls, r))
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// checkFindResourceArgs checks the arguments of the functions searching for
+// resources, which accept either a filter table, or a resource type and an
+// optional name pattern.
+func checkFindResourceArgs(ls *lua.LState, funcName string) (terraform.ResourceFilter, bool) {
+	const (
+		ArgCountMin        = 2
+		ArgCountMax        = 3
+		ArgPosFilter       = 2
+		ArgPosResourceType = 2
+		ArgPosResourceName = 3
+	)
+
+	var (
+		filter terraform.ResourceFilter
+		err    error
+	)
+
+	valid := true
+
+	top := ls.GetTop()
+	if top < ArgCountMin {
+		ls.ArgError(1, fmt.Sprintf("not enough arguments in call to '%s'", funcName))
+
+		valid = false
+	} else if top > ArgCountMax {
+		ls.ArgError(1, fmt.Sprintf("too many arguments in call to '%s'", funcName))
+
+		valid = false
+	}
+
+	if tbl, ok := ls.Get(ArgPosFilter).(*lua.LTable); ok && top == ArgCountMin {
+		if filter, err = checkResourceFilter(ls, tbl); err != nil {
+			valid = false
+		}
+	} else {
+		if filter.Type, err = wlua.CheckString(ls, ArgPosResourceType); err != nil {
+			valid = false
 		}
 
-		invalidCall = true
+		if top >= ArgPosResourceName {
+			if filter.Name, err = wlua.CheckString(ls, ArgPosResourceName); err != nil {
+				valid = false
+			}
+		}
 	}
 
-	resourceType, err := wlua.CheckString(ls, ArgPosResourceType)
-	if err != nil {
-		invalidCall = true
+	return filter, valid
+}
+
+// checkResourceFilter builds a resource filter from a Lua table with the
+// 'type', 'name', 'module', 'action' and 'provider' string fields.
+func checkResourceFilter(ls *lua.LState, tbl *lua.LTable) (terraform.ResourceFilter, error) {
+	var (
+		filter terraform.ResourceFilter
+		err    error
+	)
+
+	fields := map[string]*string{
+		"type":     &filter.Type,
+		"name":     &filter.Name,
+		"module":   &filter.Module,
+		"action":   &filter.Action,
+		"provider": &filter.Provider,
 	}
 
-	resourceName, err := wlua.CheckString(ls, ArgPosResourceName)
+	tbl.ForEach(func(k lua.LValue, v lua.LValue) {
+		field, ok := fields[k.String()]
+		if !ok {
+			ls.ArgError(2, fmt.Sprintf("unknown filter field '%s'", k.String()))
+			err = xerrors.Errorf("unknown filter field '%s'", k.String())
+
+			return
+		}
+
+		str, ok := v.(lua.LString)
+		if !ok {
+			ls.ArgError(2, fmt.Sprintf("filter field '%s' must be a string", k.String()))
+			err = xerrors.Errorf("invalid value for filter field '%s'", k.String())
+
+			return
+		}
+
+		*field = string(str)
+	})
+
+	return filter, err
+}
+
+// planChecks returns the results of the checks evaluated by Terraform during
+// planning, as an array of tables with the 'kind', 'address', 'status' and
+// 'objects' fields. Every object has the 'address', 'status' and
+// 'failure_messages' fields.
+func planChecks(ls *lua.LState) int {
+	p, err := CheckPlan(ls)
 	if err != nil {
-		invalidCall = true
+		return 0
 	}
 
-	if invalidCall {
-		return 0
+	var checks []terraform.CheckResult
+	if p.Details != nil {
+		checks = p.Details.Checks
 	}
 
-	resource, err := p.FindResource(resourceType, resourceName)
-	if err != nil {
-		ls.RaiseError("failed to search for resource %s.%s in plan file", resourceType, resourceName)
+	ret := ls.CreateTable(len(checks), 0)
+
+	for _, check := range checks {
+		objects := ls.CreateTable(len(check.Objects), 0)
 
+		for _, obj := range check.Objects {
+			messages := ls.CreateTable(len(obj.FailureMessages), 0)
+			for _, msg := range obj.FailureMessages {
+				messages.Append(lua.LString(msg))
+			}
+
+			entry := ls.NewTable()
+			entry.RawSetString("address", lua.LString(obj.Address))
+			entry.RawSetString("status", lua.LString(obj.Status))
+			entry.RawSetString("failure_messages", messages)
+			objects.Append(entry)
+		}
+
+		entry := ls.NewTable()
+		entry.RawSetString("kind", lua.LString(check.Kind))
+		entry.RawSetString("address", lua.LString(check.ConfigAddr))
+		entry.RawSetString("status", lua.LString(check.Status))
+		entry.RawSetString("objects", objects)
+		ret.Append(entry)
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// planOutputs returns the changes of the output values of the root module,
+// ordered by name, as tables with the 'address', 'name', 'action', 'before',
+// 'after', 'after_unknown', 'sensitive' and 'before_sensitive' fields.
+func planOutputs(ls *lua.LState) int {
+	p, err := CheckPlan(ls)
+	if err != nil {
 		return 0
 	}
 
-	if resource != nil {
-		ls.Push(lua.LString("resource"))
+	outputs := p.Outputs()
+
+	ret := ls.CreateTable(len(outputs), 0)
+
+	for _, o := range outputs {
+		before, err := o.Before()
+		if err != nil {
+			ls.RaiseError("failed to read the output changes of the plan: %v", err)
+
+			return 0
+		}
 
-		return 1
+		after, err := o.After()
+		if err != nil {
+			ls.RaiseError("failed to read the output changes of the plan: %v", err)
+
+			return 0
+		}
+
+		entry := ls.NewTable()
+		entry.RawSetString("address", lua.LString(o.Address()))
+		entry.RawSetString("name", lua.LString(o.Name()))
+		entry.RawSetString("action", lua.LString(o.Action()))
+		entry.RawSetString("before", lValue(ls, before, o.BeforeSensitive()))
+		entry.RawSetString("after", lValue(ls, after, o.Sensitive()))
+		entry.RawSetString("after_unknown", lua.LBool(!after.IsWhollyKnown()))
+		entry.RawSetString("sensitive", lua.LBool(o.Sensitive()))
+		entry.RawSetString("before_sensitive", lua.LBool(o.BeforeSensitive()))
+		ret.Append(entry)
 	}
 
-	return 0
+	ls.Push(ret)
+
+	return 1
 }
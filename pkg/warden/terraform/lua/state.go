@@ -0,0 +1,124 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lua
+
+import (
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+const luaStateTypeName = "state"
+
+const (
+	luaFunctionStateFindResource = "findResource"
+	luaFunctionStateOutputs      = "outputs"
+)
+
+// RegisterStateType registers the state type inside the Lua state.
+func RegisterStateType(ls *lua.LState) {
+	var methods = map[string]lua.LGFunction{
+		luaFunctionStateFindResource: stateFindResource,
+		luaFunctionStateOutputs:      stateOutputs,
+	}
+
+	mt := ls.NewTypeMetatable(luaStateTypeName)
+	ls.SetGlobal(luaStateTypeName, mt)
+
+	// methods
+	ls.SetField(mt, "__index", ls.SetFuncs(ls.NewTable(), methods))
+}
+
+// LTerraformState creates a new state userdata wrapping the specified
+// Terraform state.
+func LTerraformState(ls *lua.LState, state *terraform.State) *lua.LUserData {
+	ud := ls.NewUserData()
+	ud.Value = state
+	ls.SetMetatable(ud, ls.GetTypeMetatable(luaStateTypeName))
+
+	return ud
+}
+
+// CheckTerraformState checks whether the first lua argument is a *LUserData
+// with *terraform.State and returns this *terraform.State.
+func CheckTerraformState(ls *lua.LState) (*terraform.State, error) {
+	ud := ls.CheckUserData(1)
+	if v, ok := ud.Value.(*terraform.State); ok {
+		return v, nil
+	}
+
+	ls.ArgError(1, "state expected")
+
+	return nil, xerrors.New("not a state variable")
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+func stateFindResource(ls *lua.LState) int {
+	invalidCall := false
+
+	s, err := CheckTerraformState(ls)
+	if err != nil {
+		invalidCall = true
+	}
+
+	filter, ok := checkFindResourceArgs(ls, luaFunctionStateFindResource)
+	if !ok || invalidCall {
+		return 0
+	}
+
+	resources, err := s.FindResources(filter)
+	if err != nil {
+		ls.RaiseError("failed to search for resources in state: %v", err)
+
+		return 0
+	}
+
+	ret := ls.CreateTable(len(resources), 0)
+	for _, r := range resources {
+		ret.Append(terraform.LResourceInstance(ls, r))
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
+
+// stateOutputs returns the output values of the root module as a table
+// indexed by name, whose values are tables with the 'value' and 'sensitive'
+// fields.
+func stateOutputs(ls *lua.LState) int {
+	s, err := CheckTerraformState(ls)
+	if err != nil {
+		return 0
+	}
+
+	outputs := s.Outputs()
+
+	ret := ls.CreateTable(0, len(outputs))
+
+	for _, o := range outputs {
+		entry := ls.NewTable()
+		entry.RawSetString("value", lValue(ls, o.Value, o.Sensitive))
+		entry.RawSetString("sensitive", lua.LBool(o.Sensitive))
+		ret.RawSetString(o.Name, entry)
+	}
+
+	ls.Push(ret)
+
+	return 1
+}
@@ -16,8 +16,11 @@ package lua
 
 import (
 	lua "github.com/yuin/gopher-lua"
-	luar "layeh.com/gopher-luar"
+	"github.com/zclconf/go-cty/cty"
 
+	"github.com/hexbee-net/horus/pkg/terraform/configs"
+	"github.com/hexbee-net/horus/pkg/terraform/states/statefile"
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
 	"github.com/hexbee-net/horus/pkg/warden/terraform"
 )
 
@@ -26,25 +29,68 @@ const (
 	stateFieldName     = "state"
 	prevStateFieldName = "prevState"
 	configFieldName    = "config"
+	driftFieldName     = "drift"
+	unknownFieldName   = "unknown"
+)
+
+const (
+	luaFunctionIsSensitive = "isSensitive"
+	luaFunctionUnwrap      = "unwrap"
+	luaFunctionIsKnown     = "is_known"
+	luaFunctionIsNull      = "is_null"
+	luaFunctionToNumber    = "toNumber"
+	luaFunctionBigNumber   = "bigNumber"
+
+	// camelCase aliases, consistent with the other functions of the module.
+	luaFunctionIsKnownAlias = "isKnown"
+	luaFunctionIsNullAlias  = "isNull"
 )
 
 var exports = map[string]lua.LGFunction{ //nolint:gochecknoglobals // wip
-	// "filter": filter,
+	luaFunctionIsSensitive: isSensitive,
+	luaFunctionUnwrap:      unwrap,
+	luaFunctionIsKnown:     isKnown,
+	luaFunctionIsNull:      isNull,
+	luaFunctionToNumber:    toNumber,
+	luaFunctionBigNumber:   bigNumber,
+
+	luaFunctionIsKnownAlias: isKnown,
+	luaFunctionIsNullAlias:  isNull,
 }
 
-func GetLoader(planFile *terraform.PlanFile) lua.LGFunction {
+// GetLoader returns the loader of the 'tf' module exposing the content of
+// the plan file. 'tf.config' is nil when the configuration of the plan file
+// could not be loaded. 'tf.drift' lists the changes made outside of Terraform
+// since the previous run. The provider schemas are optional.
+func GetLoader(planFile *terraform.PlanFile, schemas *terraform.ProviderSchemas) lua.LGFunction {
 	return func(L *lua.LState) int {
-		// register user types
-		RegisterPlanType(L)
+		mod := newModule(L)
+
+		// register fields
+		plan := &terraform.Plan{Plan: planFile.Plan, Schemas: schemas, Details: planFile.Details}
+
+		L.SetField(mod, planFieldName, LPlan(L, plan))
+		L.SetField(mod, driftFieldName, LDrift(L, plan))
+		L.SetField(mod, stateFieldName, lStateFile(L, planFile.State, schemas))
+		L.SetField(mod, prevStateFieldName, lStateFile(L, planFile.PrevState, schemas))
+		L.SetField(mod, configFieldName, LModule(L, terraform.NewModule(planFile.Config)))
+
+		// returns the module
+		L.Push(mod)
+
+		return 1
+	}
+}
 
-		// register functions
-		mod := L.SetFuncs(L.NewTable(), exports)
+// GetStateLoader returns the loader of the 'tf' module exposing a state
+// file, with the same API as the state of a plan file. The provider schemas
+// are optional.
+func GetStateLoader(stateFile *statefile.File, schemas *terraform.ProviderSchemas) lua.LGFunction {
+	return func(L *lua.LState) int {
+		mod := newModule(L)
 
 		// register fields
-		L.SetField(mod, planFieldName, LPlan(L, &terraform.Plan{Plan: planFile.Plan}))
-		L.SetField(mod, stateFieldName, luar.New(L, planFile.State))
-		L.SetField(mod, prevStateFieldName, luar.New(L, planFile.PrevState))
-		L.SetField(mod, configFieldName, luar.New(L, planFile.Config))
+		L.SetField(mod, stateFieldName, lStateFile(L, stateFile, schemas))
 
 		// returns the module
 		L.Push(mod)
@@ -52,3 +98,152 @@ func GetLoader(planFile *terraform.PlanFile) lua.LGFunction {
 		return 1
 	}
 }
+
+// GetConfigLoader returns the loader of the 'tf' module exposing a
+// configuration, without any plan or state. The provider schemas are
+// optional.
+func GetConfigLoader(config *configs.Config, schemas *terraform.ProviderSchemas) lua.LGFunction {
+	return func(L *lua.LState) int {
+		mod := newModule(L)
+
+		// register fields
+		L.SetField(mod, configFieldName, LModule(L, terraform.NewModule(config)))
+
+		// returns the module
+		L.Push(mod)
+
+		return 1
+	}
+}
+
+// newModule registers the types of the 'tf' module and returns the module
+// with its functions. 'tf.unknown' is the sentinel of the values that will
+// only be known after apply.
+func newModule(L *lua.LState) *lua.LTable {
+	// register user types
+	RegisterPlanType(L)
+	RegisterStateType(L)
+	RegisterModuleType(L)
+	RegisterDriftType(L)
+	terraform.RegisterResourceChangeType(L)
+	terraform.RegisterResourceInstanceType(L)
+	terraform.RegisterConfigResourceType(L)
+	terraform.RegisterResourceDriftType(L)
+	terraform.RegisterSensitiveValueType(L)
+
+	// register functions
+	mod := L.SetFuncs(L.NewTable(), exports)
+	L.SetField(mod, unknownFieldName, wlua.LUnknown(L))
+
+	return mod
+}
+
+func lStateFile(L *lua.LState, file *statefile.File, schemas *terraform.ProviderSchemas) lua.LValue {
+	if file == nil {
+		return lua.LNil
+	}
+
+	return LTerraformState(L, &terraform.State{State: file.State, Schemas: schemas})
+}
+
+// lValue converts a cty value to its Lua equivalent, wrapping it in a
+// sensitive userdata if sensitive is true.
+func lValue(L *lua.LState, v cty.Value, sensitive bool) lua.LValue {
+	if sensitive {
+		return terraform.LSensitiveValue(L, v)
+	}
+
+	return terraform.LValue(L, v)
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+// isSensitive returns whether its argument is a sensitive value.
+func isSensitive(L *lua.LState) int {
+	L.Push(lua.LBool(terraform.IsLSensitiveValue(L.CheckAny(1))))
+
+	return 1
+}
+
+// unwrap returns the value wrapped by its argument if it is a sensitive
+// value, or the argument itself otherwise, so that it can be called on any
+// value.
+func unwrap(L *lua.LState) int {
+	L.Push(terraform.LUnwrap(L, L.CheckAny(1)))
+
+	return 1
+}
+
+// isKnown returns whether its argument is wholly known, i.e. it neither is
+// nor contains a value that will only be known after apply.
+func isKnown(L *lua.LState) int {
+	known, err := terraform.IsLKnown(L.CheckAny(1))
+	if err != nil {
+		L.ArgError(1, err.Error())
+
+		return 0
+	}
+
+	L.Push(lua.LBool(known))
+
+	return 1
+}
+
+// isNull returns whether its argument is null. A value that will only be
+// known after apply is not null.
+func isNull(L *lua.LState) int {
+	null, err := terraform.IsLNull(L.CheckAny(1))
+	if err != nil {
+		L.ArgError(1, err.Error())
+
+		return 0
+	}
+
+	L.Push(lua.LBool(null))
+
+	return 1
+}
+
+// toNumber converts its argument to a plain number, rounding the big numbers
+// to the nearest plain number so that they can be compared to plain numbers.
+// Strings representing numbers are converted as well, and the other values
+// to nil.
+func toNumber(L *lua.LState) int {
+	lv := L.CheckAny(1)
+
+	if n, ok := lv.(lua.LNumber); ok {
+		L.Push(n)
+
+		return 1
+	}
+
+	f, ok := wlua.ToBigFloat(lv)
+	if !ok {
+		L.Push(lua.LNil)
+
+		return 1
+	}
+
+	n, _ := f.Float64()
+	L.Push(lua.LNumber(n))
+
+	return 1
+}
+
+// bigNumber converts its argument to a big number, so that it can be
+// compared exactly to big numbers. Strings representing numbers are
+// converted as well, e.g. tf.bigNumber("12345678901234567890"), and the
+// other values to nil.
+func bigNumber(L *lua.LState) int {
+	f, ok := wlua.ToBigFloat(L.CheckAny(1))
+	if !ok {
+		L.Push(lua.LNil)
+
+		return 1
+	}
+
+	L.Push(wlua.LBigNumber(L, f))
+
+	return 1
+}
@@ -0,0 +1,303 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/configs"
+)
+
+// Module is a module of a configuration, along with its descendants.
+type Module struct {
+	cfg *configs.Config
+}
+
+// NewModule wraps a module of a configuration, or returns nil if there is
+// no configuration.
+func NewModule(cfg *configs.Config) *Module {
+	if cfg == nil || cfg.Module == nil {
+		return nil
+	}
+
+	return &Module{cfg: cfg}
+}
+
+// Path returns the address of the module in the configuration, e.g.
+// 'module.net.module.subnets', or an empty string for the root module.
+func (m *Module) Path() string {
+	return m.cfg.Path.String()
+}
+
+// Source returns the source address of the module, or an empty string for
+// the root module.
+func (m *Module) Source() string {
+	return m.cfg.SourceAddr
+}
+
+// Version returns the version of the module installed from a registry, or
+// an empty string.
+func (m *Module) Version() string {
+	if m.cfg.Version == nil {
+		return ""
+	}
+
+	return m.cfg.Version.String()
+}
+
+// Children returns the modules called by the module, ordered by name.
+// The remote modules that are not installed are not included.
+func (m *Module) Children() []*Module {
+	names := make([]string, 0, len(m.cfg.Children))
+	for name := range m.cfg.Children {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	children := make([]*Module, 0, len(names))
+	for _, name := range names {
+		children = append(children, NewModule(m.cfg.Children[name]))
+	}
+
+	return children
+}
+
+// Child returns the module called by the module with the given name, or nil
+// if there is no such call or if the called module is not installed.
+func (m *Module) Child(name string) *Module {
+	return NewModule(m.cfg.Children[name])
+}
+
+// Resources returns the resources declared in the module, but not in its
+// descendants, ordered by address.
+func (m *Module) Resources() []*ConfigResource {
+	mod := m.cfg.Module
+
+	resources := make([]*ConfigResource, 0, len(mod.ManagedResources)+len(mod.DataResources))
+	for _, r := range mod.ManagedResources {
+		resources = append(resources, &ConfigResource{module: m.cfg, res: r})
+	}
+
+	for _, r := range mod.DataResources {
+		resources = append(resources, &ConfigResource{module: m.cfg, res: r})
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Address() < resources[j].Address()
+	})
+
+	return resources
+}
+
+// FindResource returns the resources of the given type whose name matches
+// the specified pattern, in the module and its descendants.
+func (m *Module) FindResource(resourceType string, resourceName string) ([]*ConfigResource, error) {
+	return m.FindResources(ResourceFilter{Type: resourceType, Name: resourceName})
+}
+
+// FindResources returns the resources of the module and its descendants
+// matching all the criteria of the filter. The resources of a module come
+// before those of its children.
+//
+// Configurations hold no planned action: filtering on the action is an
+// error.
+func (m *Module) FindResources(filter ResourceFilter) ([]*ConfigResource, error) {
+	if filter.Action != "" {
+		return nil, xerrors.Errorf("%w: resources of a configuration have no action", ErrUnsupportedFilter)
+	}
+
+	resources := make([]*ConfigResource, 0)
+
+	for _, r := range m.Resources() {
+		ok, err := filter.matchResource(r.addr(), r.providerConfig())
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			resources = append(resources, r)
+		}
+	}
+
+	for _, child := range m.Children() {
+		found, err := child.FindResources(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, found...)
+	}
+
+	return resources, nil
+}
+
+// Variables returns the input variables of the module, ordered by name.
+func (m *Module) Variables() []*configs.Variable {
+	names := make([]string, 0, len(m.cfg.Module.Variables))
+	for name := range m.cfg.Module.Variables {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	variables := make([]*configs.Variable, 0, len(names))
+	for _, name := range names {
+		variables = append(variables, m.cfg.Module.Variables[name])
+	}
+
+	return variables
+}
+
+// Outputs returns the output values of the module, ordered by name.
+func (m *Module) Outputs() []*configs.Output {
+	names := make([]string, 0, len(m.cfg.Module.Outputs))
+	for name := range m.cfg.Module.Outputs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	outputs := make([]*configs.Output, 0, len(names))
+	for _, name := range names {
+		outputs = append(outputs, m.cfg.Module.Outputs[name])
+	}
+
+	return outputs
+}
+
+// Locals returns the local values of the module, ordered by name.
+func (m *Module) Locals() []*configs.Local {
+	names := make([]string, 0, len(m.cfg.Module.Locals))
+	for name := range m.cfg.Module.Locals {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	locals := make([]*configs.Local, 0, len(names))
+	for _, name := range names {
+		locals = append(locals, m.cfg.Module.Locals[name])
+	}
+
+	return locals
+}
+
+// ProviderConfigs returns the provider configurations of the module, i.e.
+// its 'provider' blocks, ordered by local name then alias.
+func (m *Module) ProviderConfigs() []*configs.Provider {
+	keys := make([]string, 0, len(m.cfg.Module.ProviderConfigs))
+	for key := range m.cfg.Module.ProviderConfigs {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	providers := make([]*configs.Provider, 0, len(keys))
+	for _, key := range keys {
+		providers = append(providers, m.cfg.Module.ProviderConfigs[key])
+	}
+
+	return providers
+}
+
+// ModuleCalls returns the module blocks of the module, ordered by name,
+// including those of the remote modules that are not installed.
+func (m *Module) ModuleCalls() []*configs.ModuleCall {
+	names := make([]string, 0, len(m.cfg.Module.ModuleCalls))
+	for name := range m.cfg.Module.ModuleCalls {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	calls := make([]*configs.ModuleCall, 0, len(names))
+	for _, name := range names {
+		calls = append(calls, m.cfg.Module.ModuleCalls[name])
+	}
+
+	return calls
+}
+
+// RequiredProviders returns the providers required by the module in its
+// 'required_providers' block, ordered by local name.
+func (m *Module) RequiredProviders() []*configs.RequiredProvider {
+	if m.cfg.Module.ProviderRequirements == nil {
+		return nil
+	}
+
+	reqs := m.cfg.Module.ProviderRequirements.RequiredProviders
+
+	names := make([]string, 0, len(reqs))
+	for name := range reqs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	providers := make([]*configs.RequiredProvider, 0, len(names))
+	for _, name := range names {
+		providers = append(providers, reqs[name])
+	}
+
+	return providers
+}
+
+// ResourceRange returns the source range of the declaration of the resource
+// with the given address, or nil if it cannot be found. Instance keys in the
+// address are ignored.
+func (m *Module) ResourceRange(address string) *hcl.Range {
+	if m == nil {
+		return nil
+	}
+
+	return resourceRange(m.cfg, address)
+}
+
+// resourceRange returns the source range of the declaration of a resource in
+// a configuration, or nil if it cannot be found or if the configuration has
+// no source.
+func resourceRange(cfg *configs.Config, address string) *hcl.Range {
+	if cfg == nil {
+		return nil
+	}
+
+	addr, diags := addrs.ParseAbsResourceInstanceStr(address)
+	if diags.HasErrors() {
+		return nil
+	}
+
+	module := cfg.DescendentForInstance(addr.Module)
+	if module == nil {
+		return nil
+	}
+
+	resource := module.Module.ResourceByAddr(addr.Resource.Resource)
+	if resource == nil {
+		return nil
+	}
+
+	// The configuration of JSON plans has no source ranges.
+	rng := resource.DeclRange
+	if rng.Filename == "" {
+		return nil
+	}
+
+	return &rng
+}
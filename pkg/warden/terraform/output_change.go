@@ -0,0 +1,119 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"sort"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+)
+
+// OutputChange is the planned change of an output value of the root module.
+type OutputChange struct {
+	src             *plans.OutputChangeSrc
+	beforeSensitive bool
+	afterSensitive  bool
+}
+
+// Address returns the address of the output value, e.g. 'output.db_password'.
+func (o *OutputChange) Address() string {
+	return o.src.Addr.String()
+}
+
+// Name returns the name of the output value.
+func (o *OutputChange) Name() string {
+	return o.src.Addr.OutputValue.Name
+}
+
+// Action returns the name of the planned action: 'no-op', 'create', 'update'
+// or 'delete'.
+func (o *OutputChange) Action() string {
+	return actionName(o.src.Action)
+}
+
+// Sensitive reports whether the output value is sensitive after the change.
+//
+// Binary plans only record whether the value is sensitive either before or
+// after the change: an output value that was sensitive in the prior state is
+// then always reported as sensitive.
+func (o *OutputChange) Sensitive() bool {
+	return o.afterSensitive
+}
+
+// BeforeSensitive reports whether the output value was sensitive before the
+// change. It is false for new output values.
+func (o *OutputChange) BeforeSensitive() bool {
+	return o.beforeSensitive
+}
+
+// Before returns the value of the output before the change, or a null value
+// if the output does not exist yet.
+func (o *OutputChange) Before() (cty.Value, error) {
+	change, err := o.src.Decode()
+	if err != nil {
+		return cty.NilVal, xerrors.Errorf("failed to decode the change of %s: %w", o.Address(), err)
+	}
+
+	return unmarked(change.Before), nil
+}
+
+// After returns the planned value of the output after the change, or a null
+// value if the output is going to be removed. The value is unknown, in part
+// or as a whole, when it depends on values only known after apply.
+func (o *OutputChange) After() (cty.Value, error) {
+	change, err := o.src.Decode()
+	if err != nil {
+		return cty.NilVal, xerrors.Errorf("failed to decode the change of %s: %w", o.Address(), err)
+	}
+
+	return unmarked(change.After), nil
+}
+
+// Outputs returns the changes of the output values of the root module,
+// ordered by name.
+func (p *Plan) Outputs() []*OutputChange {
+	outputs := make([]*OutputChange, 0, len(p.Changes.Outputs))
+
+	for _, oc := range p.Changes.Outputs {
+		if !oc.Addr.Module.IsRoot() {
+			continue
+		}
+
+		name := oc.Addr.OutputValue.Name
+		change := &OutputChange{src: oc, afterSensitive: oc.Sensitive}
+
+		if p.PriorState != nil {
+			if prior := p.PriorState.RootModule().OutputValues[name]; prior != nil {
+				change.beforeSensitive = prior.Sensitive
+			}
+		}
+
+		if details := p.Details.Output(name); details != nil {
+			change.beforeSensitive = details.BeforeSensitive
+			change.afterSensitive = details.AfterSensitive
+		}
+
+		outputs = append(outputs, change)
+	}
+
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].Name() < outputs[j].Name()
+	})
+
+	return outputs
+}
@@ -0,0 +1,114 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+)
+
+// testTfplanOutputChange is the change of a sensitive output value of the
+// root module, which becomes known after apply.
+func testTfplanOutputChange(t *testing.T) []byte {
+	t.Helper()
+
+	before, err := plans.NewDynamicValue(cty.StringVal("old"), cty.DynamicPseudoType)
+	require.NoError(t, err)
+
+	after, err := plans.NewDynamicValue(cty.UnknownVal(cty.String), cty.DynamicPseudoType)
+	require.NoError(t, err)
+
+	return pbTestMessage(pbPlanOutputChanges,
+		pbTestString(pbOutputName, "token"),
+		pbTestMessage(pbOutputChange,
+			pbTestVarint(pbChangeAction, 3),
+			pbTestMessage(pbChangeValues, pbTestBytes(pbDynamicValueMsgpack, before)),
+			pbTestMessage(pbChangeValues, pbTestBytes(pbDynamicValueMsgpack, after)),
+		),
+		pbTestVarint(pbOutputSensitive, 1),
+	)
+}
+
+func TestPlan_Outputs(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		planFile := loadTestJSONPlan(t, testJSONPlan)
+		plan := &Plan{Plan: planFile.Plan, Details: planFile.Details}
+
+		outputs := plan.Outputs()
+		require.Len(t, outputs, 2)
+
+		id := outputs[0]
+		assert.Equal(t, "output.id", id.Address())
+		assert.Equal(t, "id", id.Name())
+		assert.Equal(t, "create", id.Action())
+		assert.False(t, id.Sensitive())
+		assert.False(t, id.BeforeSensitive())
+
+		before, err := id.Before()
+		require.NoError(t, err)
+		assert.True(t, before.IsNull())
+
+		after, err := id.After()
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal("i-2"), after)
+
+		password := outputs[1]
+		assert.Equal(t, "password", password.Name())
+		assert.Equal(t, "update", password.Action())
+		assert.True(t, password.Sensitive())
+		assert.True(t, password.BeforeSensitive())
+
+		before, err = password.Before()
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal("hunter2"), before)
+
+		after, err = password.After()
+		require.NoError(t, err)
+		assert.False(t, after.IsKnown())
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		file := rewriteTestPlanFile(t, map[string][]byte{
+			tfplanFilename: testTfplan(3, "1.5.7", testTfplanOutputChange(t)),
+		})
+
+		planFile, err := LoadPlanFile(file)
+		require.NoError(t, err)
+
+		plan := &Plan{Plan: planFile.Plan, Details: planFile.Details}
+
+		outputs := plan.Outputs()
+		require.Len(t, outputs, 1)
+
+		token := outputs[0]
+		assert.Equal(t, "output.token", token.Address())
+		assert.Equal(t, "update", token.Action())
+		assert.True(t, token.Sensitive())
+		assert.False(t, token.BeforeSensitive())
+
+		before, err := token.Before()
+		require.NoError(t, err)
+		assert.Equal(t, cty.StringVal("old"), before)
+
+		after, err := token.After()
+		require.NoError(t, err)
+		assert.False(t, after.IsKnown())
+	})
+}
@@ -15,22 +15,141 @@
 package terraform
 
 import (
+	"path"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
 	"github.com/hexbee-net/horus/pkg/terraform/plans"
 )
 
 type Plan struct {
 	*plans.Plan
+
+	// Schemas are the provider schemas used to decode the resource changes.
+	// They are optional.
+	Schemas *ProviderSchemas
+
+	// Details holds the information of the plan that the plans model cannot
+	// represent. They are optional.
+	Details *PlanDetails
+}
+
+// ResourceFilter describes the criteria used to select resource changes in
+// a plan. Empty fields match everything.
+type ResourceFilter struct {
+	// Type is the exact resource type, e.g. 'aws_instance'.
+	Type string
+	// Name is a glob pattern matched against the resource name, e.g. 'web_*'.
+	// Only '*' and '?' are wildcards: the other characters, including '['
+	// and ']', match themselves.
+	Name string
+	// Module is a glob pattern matched against the address of the module
+	// instance containing the resource, e.g. 'module.network*' or
+	// 'module.net["a"]', with the same wildcards as Name.
+	// Use RootModule to only select resources of the root module.
+	Module string
+	// Action is the planned action: 'create', 'read', 'update', 'delete',
+	// 'replace' or 'no-op'.
+	Action string
+	// Provider matches either the provider type ('aws'), its source address
+	// ('hashicorp/aws' or 'registry.terraform.io/hashicorp/aws') or the full
+	// provider configuration address.
+	Provider string
 }
 
+// RootModule is the ResourceFilter.Module value selecting the root module.
+const RootModule = "root"
+
+// FindResource returns the changes of all the instances of the resources
+// of the given type whose name matches the specified pattern.
 func (p *Plan) FindResource(resourceType string, resourceName string) ([]*ResourceChange, error) {
+	return p.FindResources(ResourceFilter{Type: resourceType, Name: resourceName})
+}
+
+// FindResources returns the changes matching all the criteria of the filter,
+// in the order they appear in the plan.
+func (p *Plan) FindResources(filter ResourceFilter) ([]*ResourceChange, error) {
 	resources := make([]*ResourceChange, 0)
 
 	for _, r := range p.Changes.Resources {
-		if r.Addr.Resource.Resource.Type == resourceType && r.Addr.Resource.Resource.Name == resourceName {
-			rc := &ResourceChange{}
+		rc := NewResourceChange(r)
+		rc.schema = p.Schemas.ResourceTypeSchema(r.ProviderAddr.Provider, r.Addr.Resource.Resource.Mode, r.Addr.Resource.Resource.Type)
+		rc.details = p.Details.ResourceInstance(r.Addr, r.DeposedKey)
+
+		ok, err := filter.Match(rc)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
 			resources = append(resources, rc)
 		}
 	}
 
 	return resources, nil
 }
+
+// Match reports whether the resource change matches the filter.
+func (f *ResourceFilter) Match(rc *ResourceChange) (bool, error) {
+	if f.Action != "" && f.Action != rc.Action() {
+		return false, nil
+	}
+
+	return f.matchResource(rc.tfResource.Addr, rc.tfResource.ProviderAddr)
+}
+
+// matchResource reports whether the resource instance managed by the
+// provider configuration matches all the criteria of the filter but the
+// action.
+func (f *ResourceFilter) matchResource(addr addrs.AbsResourceInstance, provider addrs.AbsProviderConfig) (bool, error) {
+	if f.Type != "" && f.Type != addr.Resource.Resource.Type {
+		return false, nil
+	}
+
+	if f.Provider != "" && !f.matchProvider(provider) {
+		return false, nil
+	}
+
+	if ok, err := matchPattern(f.Name, addr.Resource.Resource.Name); !ok || err != nil {
+		return false, err
+	}
+
+	if f.Module == RootModule {
+		return addr.Module.IsRoot(), nil
+	}
+
+	return matchPattern(f.Module, addr.Module.String())
+}
+
+func (f *ResourceFilter) matchProvider(addr addrs.AbsProviderConfig) bool {
+	switch f.Provider {
+	case addr.Provider.Type, addr.Provider.ForDisplay(), addr.Provider.String(), addr.String():
+		return true
+	default:
+		return false
+	}
+}
+
+// globEscaper escapes the characters of path.Match patterns other than the
+// '*' and '?' wildcards, so that the brackets of the instance keys of the
+// addresses match themselves.
+var globEscaper = strings.NewReplacer( //nolint:gochecknoglobals // read-only replacer
+	`\`, `\\`,
+	`[`, `\[`,
+	`]`, `\]`,
+)
+
+func matchPattern(pattern string, value string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+
+	ok, err := path.Match(globEscaper.Replace(pattern), value)
+	if err != nil {
+		return false, xerrors.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+
+	return ok, nil
+}
@@ -0,0 +1,154 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
+)
+
+// actionForget is the action of the resource instances removed from the
+// state without being destroyed, introduced with Terraform 1.7. It is not
+// part of the vendored plans model.
+const actionForget plans.Action = '.'
+
+// PlanDetails holds the information of a plan that the plans model of the
+// vendored Terraform release cannot represent, such as the fields added to
+// the plan format by later Terraform releases.
+type PlanDetails struct {
+	// FormatVersion is the version of the format of the plan, if known.
+	FormatVersion uint64
+	// TerraformVersion is the version of Terraform that created the plan.
+	TerraformVersion string
+	// Errored indicates that the planning operation failed, and that the
+	// plan is incomplete.
+	Errored bool
+	// Timestamp is the time at which the plan was created, in RFC 3339
+	// format, if known.
+	Timestamp string
+	// Checks are the results of the checks, such as preconditions,
+	// postconditions and check blocks, evaluated during planning.
+	Checks []CheckResult
+	// ResourceDrift are the changes made outside of Terraform detected
+	// during the refresh, as reported by the plan.
+	ResourceDrift []*plans.ResourceInstanceChangeSrc
+	// Outputs holds the sensitivity of the root module output values before
+	// and after their change, indexed by name, when the plan records it.
+	Outputs map[string]*OutputDetails
+
+	resources map[resourceInstanceObjectKey]*ResourceInstanceDetails
+}
+
+// ResourceInstanceDetails holds the information about the change of a
+// resource instance that the plans model cannot represent.
+type ResourceInstanceDetails struct {
+	// PrevRunAddr is the address of the resource instance in the previous
+	// run, which differs from the current one when the resource was moved.
+	PrevRunAddr addrs.AbsResourceInstance
+	// Importing is set when the resource instance is imported by the plan.
+	Importing *Importing
+	// GeneratedConfig is the configuration generated for the imported
+	// resource instance, if any.
+	GeneratedConfig string
+	// ActionReason is the reason of the planned action, e.g.
+	// 'replace_because_tainted'. It is empty when there is no specific
+	// reason.
+	ActionReason string
+}
+
+// OutputDetails holds the information about the change of an output value
+// that the plans model cannot represent: the plans model only records
+// whether the value is sensitive either before or after the change.
+type OutputDetails struct {
+	BeforeSensitive bool
+	AfterSensitive  bool
+}
+
+// Importing describes the import of a resource instance.
+type Importing struct {
+	// ID is the identifier of the imported remote object.
+	ID string
+}
+
+// CheckStatus is the outcome of a check.
+type CheckStatus string
+
+const (
+	CheckStatusUnknown CheckStatus = "unknown"
+	CheckStatusPass    CheckStatus = "pass"
+	CheckStatusFail    CheckStatus = "fail"
+	CheckStatusError   CheckStatus = "error"
+)
+
+// CheckResult is the aggregated result of the checks of a configuration
+// object, e.g. of the preconditions and postconditions of a resource.
+type CheckResult struct {
+	// Kind is the kind of the checked object: 'resource', 'output_value',
+	// 'check' or 'var'.
+	Kind string
+	// ConfigAddr is the address of the checked object in the
+	// configuration, e.g. 'aws_instance.web'.
+	ConfigAddr string
+	Status     CheckStatus
+	// Objects are the results of the checks of every dynamic instance of
+	// the configuration object.
+	Objects []CheckObjectResult
+}
+
+// CheckObjectResult is the result of the checks of a single instance.
+type CheckObjectResult struct {
+	// Address is the address of the instance, e.g. 'aws_instance.web[0]'.
+	Address         string
+	Status          CheckStatus
+	FailureMessages []string
+}
+
+type resourceInstanceObjectKey struct {
+	addr    string
+	deposed states.DeposedKey
+}
+
+// ResourceInstance returns the details of the change of the resource
+// instance object, or nil if there are none.
+func (d *PlanDetails) ResourceInstance(addr addrs.AbsResourceInstance, deposed states.DeposedKey) *ResourceInstanceDetails {
+	if d == nil {
+		return nil
+	}
+
+	return d.resources[resourceInstanceObjectKey{addr: addr.String(), deposed: deposed}]
+}
+
+// Output returns the details of the change of the root module output value
+// with the given name, or nil if there are none.
+func (d *PlanDetails) Output(name string) *OutputDetails {
+	if d == nil {
+		return nil
+	}
+
+	return d.Outputs[name]
+}
+
+func (d *PlanDetails) setResourceInstance(
+	addr addrs.AbsResourceInstance,
+	deposed states.DeposedKey,
+	details *ResourceInstanceDetails,
+) {
+	if d.resources == nil {
+		d.resources = map[resourceInstanceObjectKey]*ResourceInstanceDetails{}
+	}
+
+	d.resources[resourceInstanceObjectKey{addr: addr.String(), deposed: deposed}] = details
+}
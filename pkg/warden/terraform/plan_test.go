@@ -0,0 +1,183 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+)
+
+func TestPlan_FindResources(t *testing.T) {
+	planFile := loadTestPlanFile(t, "tf-planfile")
+	plan := &Plan{Plan: planFile.Plan}
+
+	tests := []struct {
+		name    string
+		filter  ResourceFilter
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "no criteria",
+			filter: ResourceFilter{},
+			want: []string{
+				"null_resource.foo",
+				"aws_instance.multiple_resource[0]",
+				"aws_instance.multiple_resource[1]",
+				"aws_instance.multiple_resource[2]",
+				"aws_instance.simple_resource",
+			},
+		},
+		{
+			name:   "type only",
+			filter: ResourceFilter{Type: "aws_instance"},
+			want: []string{
+				"aws_instance.multiple_resource[0]",
+				"aws_instance.multiple_resource[1]",
+				"aws_instance.multiple_resource[2]",
+				"aws_instance.simple_resource",
+			},
+		},
+		{
+			name:   "type and name",
+			filter: ResourceFilter{Type: "aws_instance", Name: "simple_resource"},
+			want:   []string{"aws_instance.simple_resource"},
+		},
+		{
+			name:   "name glob",
+			filter: ResourceFilter{Name: "multiple_*"},
+			want: []string{
+				"aws_instance.multiple_resource[0]",
+				"aws_instance.multiple_resource[1]",
+				"aws_instance.multiple_resource[2]",
+			},
+		},
+		{
+			name:   "root module",
+			filter: ResourceFilter{Type: "null_resource", Module: RootModule},
+			want:   []string{"null_resource.foo"},
+		},
+		{
+			name:   "module glob",
+			filter: ResourceFilter{Module: "module.*"},
+			want:   []string{},
+		},
+		{
+			name:   "action",
+			filter: ResourceFilter{Type: "null_resource", Action: "create"},
+			want:   []string{"null_resource.foo"},
+		},
+		{
+			name:   "action - no match",
+			filter: ResourceFilter{Action: "delete"},
+			want:   []string{},
+		},
+		{
+			name:   "provider type",
+			filter: ResourceFilter{Provider: "null"},
+			want:   []string{"null_resource.foo"},
+		},
+		{
+			name:   "provider source",
+			filter: ResourceFilter{Name: "simple_resource", Provider: "hashicorp/aws"},
+			want:   []string{"aws_instance.simple_resource"},
+		},
+		{
+			name:   "provider config address",
+			filter: ResourceFilter{Provider: `provider["registry.terraform.io/hashicorp/null"]`},
+			want:   []string{"null_resource.foo"},
+		},
+		{
+			name:   "brackets are not a character class",
+			filter: ResourceFilter{Name: "[a-"},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := plan.FindResources(tt.filter)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			addresses := make([]string, 0, len(got))
+			for _, rc := range got {
+				addresses = append(addresses, rc.Address())
+			}
+
+			assert.ElementsMatch(t, tt.want, addresses)
+		})
+	}
+}
+
+func TestPlan_FindResources_ModuleInstances(t *testing.T) {
+	b := NewPlanBuilder()
+	b.ResourceChange(`module.net["a"].aws_vpc.main`, plans.Create)
+	b.ResourceChange(`module.net["b"].aws_vpc.main`, plans.Create)
+	b.ResourceChange(`module.net[0].aws_vpc.main`, plans.Create)
+
+	planFile, err := b.Build()
+	require.NoError(t, err)
+
+	plan := &Plan{Plan: planFile.Plan}
+
+	tests := []struct {
+		name   string
+		filter ResourceFilter
+		want   []string
+	}{
+		{
+			name:   "exact for_each key",
+			filter: ResourceFilter{Module: `module.net["a"]`},
+			want:   []string{`module.net["a"].aws_vpc.main`},
+		},
+		{
+			name:   "exact count index",
+			filter: ResourceFilter{Module: `module.net[0]`},
+			want:   []string{`module.net[0].aws_vpc.main`},
+		},
+		{
+			name:   "wildcard key",
+			filter: ResourceFilter{Module: `module.net["*"]`},
+			want:   []string{`module.net["a"].aws_vpc.main`, `module.net["b"].aws_vpc.main`},
+		},
+		{
+			name:   "wildcard instance",
+			filter: ResourceFilter{Module: `module.net*`, Name: "main"},
+			want:   []string{`module.net["a"].aws_vpc.main`, `module.net["b"].aws_vpc.main`, `module.net[0].aws_vpc.main`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := plan.FindResources(tt.filter)
+			require.NoError(t, err)
+
+			addresses := make([]string, 0, len(got))
+			for _, rc := range got {
+				addresses = append(addresses, rc.Address())
+			}
+
+			assert.ElementsMatch(t, tt.want, addresses)
+		})
+	}
+}
@@ -18,12 +18,245 @@ import (
 	"fmt"
 
 	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
 
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/configs/configschema"
 	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
 )
 
+// ResourceChange is a planned change for a single resource instance object.
 type ResourceChange struct {
-	tfResource *plans.ResourceInstanceChange
+	tfResource *plans.ResourceInstanceChangeSrc
+	schema     *configschema.Block
+	details    *ResourceInstanceDetails
+}
+
+// NewResourceChange wraps a change read from a plan file.
+func NewResourceChange(src *plans.ResourceInstanceChangeSrc) *ResourceChange {
+	return &ResourceChange{tfResource: src}
+}
+
+// Address returns the absolute address of the resource instance,
+// e.g. 'module.net.aws_subnet.private[1]'.
+func (r *ResourceChange) Address() string {
+	return r.tfResource.Addr.String()
+}
+
+// ModuleAddress returns the address of the module instance containing the
+// resource, or an empty string for the root module.
+func (r *ResourceChange) ModuleAddress() string {
+	return r.tfResource.Addr.Module.String()
+}
+
+// Type returns the resource type, e.g. 'aws_instance'.
+func (r *ResourceChange) Type() string {
+	return r.tfResource.Addr.Resource.Resource.Type
+}
+
+// Name returns the resource name as declared in the configuration.
+func (r *ResourceChange) Name() string {
+	return r.tfResource.Addr.Resource.Resource.Name
+}
+
+// Mode returns either 'managed' or 'data'.
+func (r *ResourceChange) Mode() string {
+	return resourceModeName(r.tfResource.Addr.Resource.Resource.Mode)
+}
+
+// Index returns the instance key of the resource (an addrs.IntKey for
+// 'count', an addrs.StringKey for 'for_each'), or addrs.NoKey.
+func (r *ResourceChange) Index() addrs.InstanceKey {
+	return r.tfResource.Addr.Resource.Key
+}
+
+// ProviderName returns the address of the provider configuration used to
+// plan the change, e.g. 'provider["registry.terraform.io/hashicorp/aws"]'.
+func (r *ResourceChange) ProviderName() string {
+	return r.tfResource.ProviderAddr.String()
+}
+
+// Deposed returns the deposed key of the object targeted by the change, or
+// an empty string if the change applies to the current object.
+func (r *ResourceChange) Deposed() string {
+	if r.tfResource.DeposedKey == states.NotDeposed {
+		return ""
+	}
+
+	return string(r.tfResource.DeposedKey)
+}
+
+// Action returns the name of the planned action: 'no-op', 'create', 'read',
+// 'update', 'delete', 'replace' or 'forget'.
+func (r *ResourceChange) Action() string {
+	return actionName(r.tfResource.Action)
+}
+
+// Actions returns the planned action as the ordered list of elementary
+// actions Terraform will perform, e.g. ['delete', 'create'] for a replacement
+// where the existing object is destroyed first.
+func (r *ResourceChange) Actions() []string {
+	switch r.tfResource.Action {
+	case plans.DeleteThenCreate:
+		return []string{actionName(plans.Delete), actionName(plans.Create)}
+	case plans.CreateThenDelete:
+		return []string{actionName(plans.Create), actionName(plans.Delete)}
+	default:
+		return []string{actionName(r.tfResource.Action)}
+	}
+}
+
+// PrevRunAddress returns the address of the resource instance in the
+// previous run, which differs from Address when the resource was moved.
+func (r *ResourceChange) PrevRunAddress() string {
+	if r.details == nil {
+		return r.Address()
+	}
+
+	return r.details.PrevRunAddr.String()
+}
+
+// Importing returns the description of the import of the resource instance,
+// or nil if the plan does not import it.
+func (r *ResourceChange) Importing() *Importing {
+	if r.details == nil {
+		return nil
+	}
+
+	return r.details.Importing
+}
+
+// ActionReason returns the reason of the planned action, e.g.
+// 'replace_because_tainted', or an empty string if there is none.
+func (r *ResourceChange) ActionReason() string {
+	if r.details != nil {
+		return r.details.ActionReason
+	}
+
+	for name, reason := range actionReasons {
+		if reason == r.tfResource.ActionReason {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// Schema returns the schema of the resource type, or nil if it is unknown.
+func (r *ResourceChange) Schema() *configschema.Block {
+	return r.schema
+}
+
+// Before returns the value of the object before the change, or a null value
+// if the object does not exist yet.
+func (r *ResourceChange) Before() (cty.Value, error) {
+	change, err := r.Change()
+	if err != nil {
+		return cty.NilVal, err
+	}
+
+	return unmarked(change.Before), nil
+}
+
+// After returns the planned value of the object after the change, or a null
+// value if the object is going to be destroyed.
+func (r *ResourceChange) After() (cty.Value, error) {
+	change, err := r.Change()
+	if err != nil {
+		return cty.NilVal, err
+	}
+
+	return unmarked(change.After), nil
+}
+
+// Ambiguities returns the parts of the prior and planned values whose type
+// could not be determined because the change is decoded without schema.
+// It returns nil when the resource type schema is known.
+func (r *ResourceChange) Ambiguities() (before []Ambiguity, after []Ambiguity, err error) {
+	if r.schema != nil {
+		return nil, nil, nil
+	}
+
+	if _, before, err = DecodeSchemaless(r.tfResource.Before); err != nil {
+		return nil, nil, xerrors.Errorf("failed to decode the prior value of %s: %w", r.Address(), err)
+	}
+
+	if _, after, err = DecodeSchemaless(r.tfResource.After); err != nil {
+		return nil, nil, xerrors.Errorf("failed to decode the planned value of %s: %w", r.Address(), err)
+	}
+
+	return before, after, nil
+}
+
+// Change decodes the prior and planned values of the object.
+//
+// The values are decoded with the resource type schema when it is known, in
+// which case the attributes flagged as sensitive in the schema are marked
+// as such, in addition to the values marked sensitive in the plan itself.
+// Without a schema, the values are decoded using the type implied by their
+// serialized form.
+func (r *ResourceChange) Change() (*plans.Change, error) {
+	if r.schema == nil {
+		before, _, err := DecodeSchemaless(r.tfResource.Before)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to decode the prior value of %s: %w", r.Address(), err)
+		}
+
+		after, _, err := DecodeSchemaless(r.tfResource.After)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to decode the planned value of %s: %w", r.Address(), err)
+		}
+
+		return &plans.Change{
+			Action: r.tfResource.Action,
+			Before: before.MarkWithPaths(r.tfResource.BeforeValMarks),
+			After:  after.MarkWithPaths(r.tfResource.AfterValMarks),
+		}, nil
+	}
+
+	change, err := r.tfResource.ChangeSrc.Decode(r.schema.ImpliedType())
+	if err != nil {
+		return nil, xerrors.Errorf("failed to decode the change of %s with the provider schema: %w", r.Address(), err)
+	}
+
+	change.Before = change.Before.MarkWithPaths(r.schema.ValueMarks(unmarked(change.Before), nil))
+	change.After = change.After.MarkWithPaths(r.schema.ValueMarks(unmarked(change.After), nil))
+
+	return change, nil
+}
+
+func resourceModeName(mode addrs.ResourceMode) string {
+	switch mode {
+	case addrs.ManagedResourceMode:
+		return "managed"
+	case addrs.DataResourceMode:
+		return "data"
+	default:
+		return ""
+	}
+}
+
+func actionName(action plans.Action) string {
+	switch action {
+	case plans.NoOp:
+		return "no-op"
+	case plans.Create:
+		return "create"
+	case plans.Read:
+		return "read"
+	case plans.Update:
+		return "update"
+	case plans.DeleteThenCreate, plans.CreateThenDelete:
+		return "replace"
+	case plans.Delete:
+		return "delete"
+	case actionForget:
+		return "forget"
+	default:
+		return ""
+	}
 }
 
 // -----------------------------------------------------------------------------
@@ -41,6 +274,9 @@ const (
 	luaFunctionResourceChangeGetProviderName  = "providerName"
 	luaFunctionResourceChangeGetDeposed       = "deposed"
 	luaFunctionResourceChangeGetChange        = "change"
+	luaFunctionResourceChangeGetPrevRunAddr   = "prevRunAddress"
+	luaFunctionResourceChangeGetImporting     = "importing"
+	luaFunctionResourceChangeGetActionReason  = "actionReason"
 )
 
 // RegisterResourceChangeType registers the ResourceChange type inside the Lua state.
@@ -55,6 +291,9 @@ func RegisterResourceChangeType(ls *lua.LState) {
 		luaFunctionResourceChangeGetProviderName:  resourceChangeGetProviderName,
 		luaFunctionResourceChangeGetDeposed:       resourceChangeGetDeposed,
 		luaFunctionResourceChangeGetChange:        resourceChangeGetChange,
+		luaFunctionResourceChangeGetPrevRunAddr:   resourceChangeGetPrevRunAddress,
+		luaFunctionResourceChangeGetImporting:     resourceChangeGetImporting,
+		luaFunctionResourceChangeGetActionReason:  resourceChangeGetActionReason,
 	}
 
 	mt := ls.NewTypeMetatable(luaResourceChangeTypeName)
@@ -64,6 +303,16 @@ func RegisterResourceChangeType(ls *lua.LState) {
 	ls.SetField(mt, "__index", ls.SetFuncs(ls.NewTable(), methods))
 }
 
+// LResourceChange creates a new resourceChange userdata wrapping the
+// specified change.
+func LResourceChange(ls *lua.LState, rc *ResourceChange) *lua.LUserData {
+	ud := ls.NewUserData()
+	ud.Value = rc
+	ls.SetMetatable(ud, ls.GetTypeMetatable(luaResourceChangeTypeName))
+
+	return ud
+}
+
 // CheckResourceChange checks whether the first lua argument is a *LUserData
 // with *ResourceChange and returns this *ResourceChange.
 func CheckResourceChange(ls *lua.LState) (*ResourceChange, error) {
@@ -81,11 +330,25 @@ func CheckResourceChange(ls *lua.LState) (*ResourceChange, error) {
 // Lua Functions
 
 func resourceChangeGetAddress(ls *lua.LState) int {
-	panic("not implemented")
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Address()))
+
+	return 1
 }
 
 func resourceChangeGetModuleAddress(ls *lua.LState) int {
-	panic("not implemented")
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.ModuleAddress()))
+
+	return 1
 }
 
 func resourceChangeGetType(ls *lua.LState) int {
@@ -94,7 +357,7 @@ func resourceChangeGetType(ls *lua.LState) int {
 		return 0
 	}
 
-	ls.Push(lua.LString(r.tfResource.Addr.Resource.Resource.Type))
+	ls.Push(lua.LString(r.Type()))
 
 	return 1
 }
@@ -105,27 +368,182 @@ func resourceChangeGetName(ls *lua.LState) int {
 		return 0
 	}
 
-	ls.Push(lua.LString(r.tfResource.Addr.Resource.Resource.Name))
+	ls.Push(lua.LString(r.Name()))
 
 	return 1
 }
 
 func resourceChangeGetMode(ls *lua.LState) int {
-	panic("not implemented")
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Mode()))
+
+	return 1
 }
 
 func resourceChangeGetIndex(ls *lua.LState) int {
-	panic("not implemented")
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(luaInstanceKey(r.Index()))
+
+	return 1
+}
+
+// luaInstanceKey converts an instance key to a Lua number or string, or nil
+// for addrs.NoKey.
+func luaInstanceKey(key addrs.InstanceKey) lua.LValue {
+	switch k := key.(type) {
+	case addrs.IntKey:
+		return lua.LNumber(k)
+	case addrs.StringKey:
+		return lua.LString(k)
+	default:
+		return lua.LNil
+	}
 }
 
 func resourceChangeGetProviderName(ls *lua.LState) int {
-	panic("not implemented")
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.ProviderName()))
+
+	return 1
 }
 
 func resourceChangeGetDeposed(ls *lua.LState) int {
-	panic("not implemented")
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	if deposed := r.Deposed(); deposed != "" {
+		ls.Push(lua.LString(deposed))
+	} else {
+		ls.Push(lua.LNil)
+	}
+
+	return 1
+}
+
+func resourceChangeGetPrevRunAddress(ls *lua.LState) int {
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.PrevRunAddress()))
+
+	return 1
+}
+
+func resourceChangeGetImporting(ls *lua.LState) int {
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	importing := r.Importing()
+	if importing == nil {
+		ls.Push(lua.LNil)
+
+		return 1
+	}
+
+	tbl := ls.NewTable()
+	tbl.RawSetString("id", lua.LString(importing.ID))
+
+	if r.details.GeneratedConfig != "" {
+		tbl.RawSetString("generated_config", lua.LString(r.details.GeneratedConfig))
+	}
+
+	ls.Push(tbl)
+
+	return 1
+}
+
+func resourceChangeGetActionReason(ls *lua.LState) int {
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	if reason := r.ActionReason(); reason != "" {
+		ls.Push(lua.LString(reason))
+	} else {
+		ls.Push(lua.LNil)
+	}
+
+	return 1
 }
 
 func resourceChangeGetChange(ls *lua.LState) int {
-	panic("not implemented")
+	r, err := CheckResourceChange(ls)
+	if err != nil {
+		return 0
+	}
+
+	c, err := r.Change()
+	if err != nil {
+		ls.RaiseError("%v", err)
+
+		return 0
+	}
+
+	actions := ls.CreateTable(len(r.Actions()), 0)
+	for _, a := range r.Actions() {
+		actions.Append(lua.LString(a))
+	}
+
+	change := ls.NewTable()
+	ls.SetField(change, "action", lua.LString(r.Action()))
+	ls.SetField(change, "actions", actions)
+	ls.SetField(change, "before", luaValue(ls, c.Before))
+	ls.SetField(change, "after", luaValue(ls, c.After))
+	ls.SetField(change, "after_unknown", luaUnknownView(ls, c.After))
+	ls.SetField(change, "before_sensitive", luaSensitiveView(ls, c.Before))
+	ls.SetField(change, "after_sensitive", luaSensitiveView(ls, c.After))
+
+	beforeAmbiguities, afterAmbiguities, err := r.Ambiguities()
+	if err != nil {
+		ls.RaiseError("%v", err)
+
+		return 0
+	}
+
+	ls.SetField(change, "schemaless", lua.LBool(r.schema == nil))
+	ls.SetField(change, "ambiguities", luaAmbiguities(ls, beforeAmbiguities, afterAmbiguities))
+
+	ls.Push(change)
+
+	return 1
+}
+
+// luaAmbiguities returns the ambiguities of a change as a Lua array of
+// tables with the 'value' ('before' or 'after'), 'path' and 'kind' fields.
+func luaAmbiguities(ls *lua.LState, before []Ambiguity, after []Ambiguity) *lua.LTable {
+	tbl := ls.CreateTable(len(before)+len(after), 0)
+
+	add := func(value string, ambiguities []Ambiguity) {
+		for _, a := range ambiguities {
+			entry := ls.NewTable()
+			entry.RawSetString("value", lua.LString(value))
+			entry.RawSetString("path", lua.LString(a.PathString()))
+			entry.RawSetString("kind", lua.LString(a.Kind))
+			tbl.Append(entry)
+		}
+	}
+
+	add("before", before)
+	add("after", after)
+
+	return tbl
 }
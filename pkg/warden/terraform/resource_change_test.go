@@ -0,0 +1,287 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"path"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
+)
+
+func getTestDataPath(t *testing.T, localPath string) string {
+	t.Helper()
+
+	return path.Clean(path.Join("../../../testData/", localPath))
+}
+
+func loadTestPlanFile(t *testing.T, name string) *PlanFile {
+	t.Helper()
+
+	fs := afero.NewReadOnlyFs(afero.NewOsFs())
+	file, err := fs.Open(getTestDataPath(t, name))
+	require.NoError(t, err)
+
+	defer file.Close()
+
+	planFile, err := LoadPlanFile(file)
+	require.NoError(t, err)
+
+	return planFile
+}
+
+func findTestResourceChange(t *testing.T, planFile *PlanFile, address string) *ResourceChange {
+	t.Helper()
+
+	for _, r := range planFile.Plan.Changes.Resources {
+		if r.Addr.String() == address {
+			return NewResourceChange(r)
+		}
+	}
+
+	require.FailNowf(t, "resource change not found", "no change for %s in the plan", address)
+
+	return nil
+}
+
+// callResourceChange calls the specified method on rc from a Lua script and
+// returns its result.
+func callResourceChange(t *testing.T, rc *ResourceChange, method string) lua.LValue {
+	t.Helper()
+
+	ls := lua.NewState()
+	defer ls.Close()
+
+	RegisterResourceChangeType(ls)
+	ls.SetGlobal("rc", LResourceChange(ls, rc))
+
+	require.NoError(t, ls.DoString("return rc:"+method+"()"))
+
+	return ls.Get(-1)
+}
+
+func syntheticResourceChange(t *testing.T, address string, deposed states.DeposedKey, action plans.Action) *ResourceChange {
+	t.Helper()
+
+	addr, diags := addrs.ParseAbsResourceInstanceStr(address)
+	require.False(t, diags.HasErrors(), diags.Err())
+
+	before, err := plans.NewDynamicValue(cty.ObjectVal(map[string]cty.Value{
+		"id":   cty.StringVal("i-123"),
+		"size": cty.NumberIntVal(10),
+	}), cty.Object(map[string]cty.Type{"id": cty.String, "size": cty.Number}))
+	require.NoError(t, err)
+
+	return NewResourceChange(&plans.ResourceInstanceChangeSrc{
+		Addr:       addr,
+		DeposedKey: deposed,
+		ProviderAddr: addrs.AbsProviderConfig{
+			Module:   addr.Module.Module(),
+			Provider: addrs.NewDefaultProvider("aws"),
+			Alias:    "west",
+		},
+		ChangeSrc: plans.ChangeSrc{
+			Action: action,
+			Before: before,
+		},
+	})
+}
+
+func TestResourceChange_LuaAccessors(t *testing.T) {
+	planFile := loadTestPlanFile(t, "tf-planfile")
+
+	tests := []struct {
+		name    string
+		address string
+		change  *ResourceChange
+		method  string
+		want    lua.LValue
+	}{
+		{
+			name:    "address - single",
+			address: "aws_instance.simple_resource",
+			method:  "address",
+			want:    lua.LString("aws_instance.simple_resource"),
+		},
+		{
+			name:    "address - count",
+			address: "aws_instance.multiple_resource[2]",
+			method:  "address",
+			want:    lua.LString("aws_instance.multiple_resource[2]"),
+		},
+		{
+			name:   "address - module",
+			change: syntheticResourceChange(t, `module.net["a"].data.aws_subnet.x["k"]`, states.NotDeposed, plans.Read),
+			method: "address",
+			want:   lua.LString(`module.net["a"].data.aws_subnet.x["k"]`),
+		},
+		{
+			name:    "moduleAddress - root",
+			address: "null_resource.foo",
+			method:  "moduleAddress",
+			want:    lua.LString(""),
+		},
+		{
+			name:   "moduleAddress - nested",
+			change: syntheticResourceChange(t, `module.net["a"].module.sub.aws_subnet.x`, states.NotDeposed, plans.Update),
+			method: "moduleAddress",
+			want:   lua.LString(`module.net["a"].module.sub`),
+		},
+		{
+			name:    "type",
+			address: "null_resource.foo",
+			method:  "type",
+			want:    lua.LString("null_resource"),
+		},
+		{
+			name:    "name",
+			address: "aws_instance.multiple_resource[0]",
+			method:  "name",
+			want:    lua.LString("multiple_resource"),
+		},
+		{
+			name:    "mode - managed",
+			address: "aws_instance.simple_resource",
+			method:  "mode",
+			want:    lua.LString("managed"),
+		},
+		{
+			name:   "mode - data",
+			change: syntheticResourceChange(t, "data.aws_ami.ubuntu", states.NotDeposed, plans.Read),
+			method: "mode",
+			want:   lua.LString("data"),
+		},
+		{
+			name:    "index - no key",
+			address: "aws_instance.simple_resource",
+			method:  "index",
+			want:    lua.LNil,
+		},
+		{
+			name:    "index - count",
+			address: "aws_instance.multiple_resource[1]",
+			method:  "index",
+			want:    lua.LNumber(1),
+		},
+		{
+			name:   "index - for_each",
+			change: syntheticResourceChange(t, `aws_instance.web["blue"]`, states.NotDeposed, plans.Delete),
+			method: "index",
+			want:   lua.LString("blue"),
+		},
+		{
+			name:    "providerName - default",
+			address: "aws_instance.simple_resource",
+			method:  "providerName",
+			want:    lua.LString(`provider["registry.terraform.io/hashicorp/aws"]`),
+		},
+		{
+			name:   "providerName - aliased in module",
+			change: syntheticResourceChange(t, "module.net.aws_vpc.main", states.NotDeposed, plans.Update),
+			method: "providerName",
+			want:   lua.LString(`module.net.provider["registry.terraform.io/hashicorp/aws"].west`),
+		},
+		{
+			name:    "deposed - current object",
+			address: "null_resource.foo",
+			method:  "deposed",
+			want:    lua.LNil,
+		},
+		{
+			name:   "deposed - deposed object",
+			change: syntheticResourceChange(t, "aws_instance.web", states.DeposedKey("00000001"), plans.Delete),
+			method: "deposed",
+			want:   lua.LString("00000001"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := tt.change
+			if rc == nil {
+				rc = findTestResourceChange(t, planFile, tt.address)
+			}
+
+			assert.Equal(t, tt.want, callResourceChange(t, rc, tt.method))
+		})
+	}
+}
+
+func TestResourceChange_LuaChange(t *testing.T) {
+	planFile := loadTestPlanFile(t, "tf-planfile")
+
+	tests := []struct {
+		name    string
+		address string
+		change  *ResourceChange
+		script  string
+		want    []lua.LValue
+	}{
+		{
+			name:    "create",
+			address: "null_resource.foo",
+			script:  `local c = rc:change() return c.action, c.actions[1], #c.actions, c.before, c.after.triggers.foo`,
+			want:    []lua.LValue{lua.LString("create"), lua.LString("create"), lua.LNumber(1), lua.LNil, lua.LString("bar")},
+		},
+		{
+			name:    "create - nested values",
+			address: "aws_instance.multiple_resource[0]",
+			script:  `local c = rc:change() return c.after.instance_type, c.after.tags.Name, c.after.source_dest_check, tostring(c.after.id)`,
+			want:    []lua.LValue{lua.LString("t2.micro"), lua.LString("ExampleAppServerInstance 2"), lua.LTrue, lua.LString("(known after apply)")},
+		},
+		{
+			name:   "replace",
+			change: syntheticResourceChange(t, "aws_instance.web", states.NotDeposed, plans.CreateThenDelete),
+			script: `local c = rc:change() return c.action, c.actions[1], c.actions[2], c.before.id, c.before.size, c.after`,
+			want:   []lua.LValue{lua.LString("replace"), lua.LString("create"), lua.LString("delete"), lua.LString("i-123"), lua.LNumber(10), lua.LNil},
+		},
+		{
+			name:   "no-op",
+			change: syntheticResourceChange(t, "aws_instance.web", states.NotDeposed, plans.NoOp),
+			script: `return rc:change().action`,
+			want:   []lua.LValue{lua.LString("no-op")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := tt.change
+			if rc == nil {
+				rc = findTestResourceChange(t, planFile, tt.address)
+			}
+
+			ls := lua.NewState()
+			defer ls.Close()
+
+			RegisterResourceChangeType(ls)
+			ls.SetGlobal("rc", LResourceChange(ls, rc))
+
+			require.NoError(t, ls.DoString(tt.script))
+
+			got := make([]lua.LValue, 0, ls.GetTop())
+			for i := 1; i <= ls.GetTop(); i++ {
+				got = append(got, ls.Get(i))
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,435 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/configs/configschema"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
+)
+
+// ResourceInstance is an object of a resource instance recorded in a state.
+type ResourceInstance struct {
+	addr     addrs.AbsResourceInstance
+	deposed  states.DeposedKey
+	provider addrs.AbsProviderConfig
+	obj      *states.ResourceInstanceObjectSrc
+	schema   *configschema.Block
+}
+
+// Address returns the absolute address of the resource instance,
+// e.g. 'module.net.aws_subnet.private[1]'.
+func (r *ResourceInstance) Address() string {
+	return r.addr.String()
+}
+
+// ModuleAddress returns the address of the module instance containing the
+// resource, or an empty string for the root module.
+func (r *ResourceInstance) ModuleAddress() string {
+	return r.addr.Module.String()
+}
+
+// Type returns the resource type, e.g. 'aws_instance'.
+func (r *ResourceInstance) Type() string {
+	return r.addr.Resource.Resource.Type
+}
+
+// Name returns the resource name as declared in the configuration.
+func (r *ResourceInstance) Name() string {
+	return r.addr.Resource.Resource.Name
+}
+
+// Mode returns either 'managed' or 'data'.
+func (r *ResourceInstance) Mode() string {
+	return resourceModeName(r.addr.Resource.Resource.Mode)
+}
+
+// Index returns the instance key of the resource (an addrs.IntKey for
+// 'count', an addrs.StringKey for 'for_each'), or addrs.NoKey.
+func (r *ResourceInstance) Index() addrs.InstanceKey {
+	return r.addr.Resource.Key
+}
+
+// ProviderName returns the address of the provider configuration managing
+// the resource, e.g. 'provider["registry.terraform.io/hashicorp/aws"]'.
+func (r *ResourceInstance) ProviderName() string {
+	return r.provider.String()
+}
+
+// Deposed returns the deposed key of the object, or an empty string for the
+// current object of the instance.
+func (r *ResourceInstance) Deposed() string {
+	if r.deposed == states.NotDeposed {
+		return ""
+	}
+
+	return string(r.deposed)
+}
+
+// Status returns either 'ready' or 'tainted'.
+func (r *ResourceInstance) Status() string {
+	if r.obj.Status == states.ObjectTainted {
+		return "tainted"
+	}
+
+	return "ready"
+}
+
+// SchemaVersion returns the version of the resource type schema the object
+// was recorded with.
+func (r *ResourceInstance) SchemaVersion() uint64 {
+	return r.obj.SchemaVersion
+}
+
+// Dependencies returns the addresses of the resources the object depends on.
+func (r *ResourceInstance) Dependencies() []string {
+	deps := make([]string, 0, len(r.obj.Dependencies))
+	for _, dep := range r.obj.Dependencies {
+		deps = append(deps, dep.String())
+	}
+
+	return deps
+}
+
+// CreateBeforeDestroy reports whether the object is replaced by creating its
+// replacement first.
+func (r *ResourceInstance) CreateBeforeDestroy() bool {
+	return r.obj.CreateBeforeDestroy
+}
+
+// Schema returns the schema of the resource type, or nil if it is unknown.
+func (r *ResourceInstance) Schema() *configschema.Block {
+	return r.schema
+}
+
+// Value decodes the attributes of the object.
+//
+// The value is decoded with the resource type schema when it is known, in
+// which case the attributes flagged as sensitive in the schema are marked
+// as such, in addition to the values recorded as sensitive in the state.
+// Without a schema, the value is decoded using the type implied by its JSON
+// form; the attributes of states written before Terraform 0.12 are then
+// exposed as a map of their flattened keys.
+func (r *ResourceInstance) Value() (cty.Value, error) {
+	if r.schema != nil {
+		obj, err := r.obj.Decode(r.schema.ImpliedType())
+		if err != nil {
+			return cty.NilVal, xerrors.Errorf("failed to decode %s with the provider schema: %w", r.Address(), err)
+		}
+
+		val := unmarked(obj.Value)
+
+		return val.MarkWithPaths(r.schema.ValueMarks(val, nil)).MarkWithPaths(r.obj.AttrSensitivePaths), nil
+	}
+
+	if r.obj.AttrsFlat != nil {
+		attrs := make(map[string]cty.Value, len(r.obj.AttrsFlat))
+		for k, v := range r.obj.AttrsFlat {
+			attrs[k] = cty.StringVal(v)
+		}
+
+		return cty.MapVal(attrs), nil
+	}
+
+	val, err := decodeJSONValue(r.obj.AttrsJSON)
+	if err != nil {
+		return cty.NilVal, xerrors.Errorf("failed to decode %s: %w", r.Address(), err)
+	}
+
+	return val.MarkWithPaths(schemalessPathMarks(r.obj.AttrSensitivePaths)), nil
+}
+
+// schemalessPathMarks adapts the paths of marks to values decoded without
+// schema, in which maps are decoded as objects: their keys are accessed as
+// attributes.
+func schemalessPathMarks(pvm []cty.PathValueMarks) []cty.PathValueMarks {
+	ret := make([]cty.PathValueMarks, 0, len(pvm))
+
+	for _, m := range pvm {
+		path := make(cty.Path, 0, len(m.Path))
+
+		for _, step := range m.Path {
+			if idx, ok := step.(cty.IndexStep); ok && idx.Key.Type() == cty.String {
+				step = cty.GetAttrStep{Name: idx.Key.AsString()}
+			}
+
+			path = append(path, step)
+		}
+
+		ret = append(ret, cty.PathValueMarks{Path: path, Marks: m.Marks})
+	}
+
+	return ret
+}
+
+// -----------------------------------------------------------------------------
+// Lua Utilities
+
+const luaResourceInstanceTypeName = "resourceInstance"
+
+const (
+	luaFunctionResourceInstanceGetAddress             = "address"
+	luaFunctionResourceInstanceGetModuleAddress       = "moduleAddress"
+	luaFunctionResourceInstanceGetType                = "type"
+	luaFunctionResourceInstanceGetName                = "name"
+	luaFunctionResourceInstanceGetMode                = "mode"
+	luaFunctionResourceInstanceGetIndex               = "index"
+	luaFunctionResourceInstanceGetProviderName        = "providerName"
+	luaFunctionResourceInstanceGetDeposed             = "deposed"
+	luaFunctionResourceInstanceGetStatus              = "status"
+	luaFunctionResourceInstanceGetSchemaVersion       = "schemaVersion"
+	luaFunctionResourceInstanceGetDependencies        = "dependencies"
+	luaFunctionResourceInstanceGetCreateBeforeDestroy = "createBeforeDestroy"
+	luaFunctionResourceInstanceGetValue               = "value"
+	luaFunctionResourceInstanceGetSensitive           = "sensitive"
+)
+
+// RegisterResourceInstanceType registers the ResourceInstance type inside
+// the Lua state.
+func RegisterResourceInstanceType(ls *lua.LState) {
+	var methods = map[string]lua.LGFunction{
+		luaFunctionResourceInstanceGetAddress:             resourceInstanceGetAddress,
+		luaFunctionResourceInstanceGetModuleAddress:       resourceInstanceGetModuleAddress,
+		luaFunctionResourceInstanceGetType:                resourceInstanceGetType,
+		luaFunctionResourceInstanceGetName:                resourceInstanceGetName,
+		luaFunctionResourceInstanceGetMode:                resourceInstanceGetMode,
+		luaFunctionResourceInstanceGetIndex:               resourceInstanceGetIndex,
+		luaFunctionResourceInstanceGetProviderName:        resourceInstanceGetProviderName,
+		luaFunctionResourceInstanceGetDeposed:             resourceInstanceGetDeposed,
+		luaFunctionResourceInstanceGetStatus:              resourceInstanceGetStatus,
+		luaFunctionResourceInstanceGetSchemaVersion:       resourceInstanceGetSchemaVersion,
+		luaFunctionResourceInstanceGetDependencies:        resourceInstanceGetDependencies,
+		luaFunctionResourceInstanceGetCreateBeforeDestroy: resourceInstanceGetCreateBeforeDestroy,
+		luaFunctionResourceInstanceGetValue:               resourceInstanceGetValue,
+		luaFunctionResourceInstanceGetSensitive:           resourceInstanceGetSensitive,
+	}
+
+	mt := ls.NewTypeMetatable(luaResourceInstanceTypeName)
+	ls.SetGlobal(luaResourceInstanceTypeName, mt)
+
+	// methods
+	ls.SetField(mt, "__index", ls.SetFuncs(ls.NewTable(), methods))
+}
+
+// LResourceInstance creates a new resourceInstance userdata wrapping the
+// specified object.
+func LResourceInstance(ls *lua.LState, ri *ResourceInstance) *lua.LUserData {
+	ud := ls.NewUserData()
+	ud.Value = ri
+	ls.SetMetatable(ud, ls.GetTypeMetatable(luaResourceInstanceTypeName))
+
+	return ud
+}
+
+// CheckResourceInstance checks whether the first lua argument is a
+// *LUserData with *ResourceInstance and returns this *ResourceInstance.
+func CheckResourceInstance(ls *lua.LState) (*ResourceInstance, error) {
+	ud := ls.CheckUserData(1)
+	if v, ok := ud.Value.(*ResourceInstance); ok {
+		return v, nil
+	}
+
+	ls.ArgError(1, fmt.Sprintf("%s expected", luaResourceInstanceTypeName))
+
+	return nil, ErrInvalidType
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+func resourceInstanceGetAddress(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Address()))
+
+	return 1
+}
+
+func resourceInstanceGetModuleAddress(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.ModuleAddress()))
+
+	return 1
+}
+
+func resourceInstanceGetType(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Type()))
+
+	return 1
+}
+
+func resourceInstanceGetName(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Name()))
+
+	return 1
+}
+
+func resourceInstanceGetMode(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Mode()))
+
+	return 1
+}
+
+func resourceInstanceGetIndex(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(luaInstanceKey(r.Index()))
+
+	return 1
+}
+
+func resourceInstanceGetProviderName(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.ProviderName()))
+
+	return 1
+}
+
+func resourceInstanceGetDeposed(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	if deposed := r.Deposed(); deposed != "" {
+		ls.Push(lua.LString(deposed))
+	} else {
+		ls.Push(lua.LNil)
+	}
+
+	return 1
+}
+
+func resourceInstanceGetStatus(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LString(r.Status()))
+
+	return 1
+}
+
+func resourceInstanceGetSchemaVersion(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LNumber(r.SchemaVersion()))
+
+	return 1
+}
+
+func resourceInstanceGetDependencies(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	deps := r.Dependencies()
+
+	tbl := ls.CreateTable(len(deps), 0)
+	for _, dep := range deps {
+		tbl.Append(lua.LString(dep))
+	}
+
+	ls.Push(tbl)
+
+	return 1
+}
+
+func resourceInstanceGetCreateBeforeDestroy(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(lua.LBool(r.CreateBeforeDestroy()))
+
+	return 1
+}
+
+func resourceInstanceGetValue(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	val, err := r.Value()
+	if err != nil {
+		ls.RaiseError("%v", err)
+
+		return 0
+	}
+
+	ls.Push(luaValue(ls, val))
+
+	return 1
+}
+
+func resourceInstanceGetSensitive(ls *lua.LState) int {
+	r, err := CheckResourceInstance(ls)
+	if err != nil {
+		return 0
+	}
+
+	val, err := r.Value()
+	if err != nil {
+		ls.RaiseError("%v", err)
+
+		return 0
+	}
+
+	ls.Push(luaSensitiveView(ls, val))
+
+	return 1
+}
@@ -0,0 +1,117 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/terraform/tfdiags"
+)
+
+// AmbiguityKind describes why the type of a part of a value decoded without
+// schema could not be determined.
+type AmbiguityKind string
+
+const (
+	// AmbiguousSequence flags a sequence that can be a list, a set or a tuple.
+	// Its elements are decoded in their serialized order, which is not
+	// meaningful for sets.
+	AmbiguousSequence AmbiguityKind = "sequence"
+	// AmbiguousMapping flags a mapping that can be either a map or an object.
+	AmbiguousMapping AmbiguityKind = "mapping"
+	// AmbiguousUnknown flags a value that will only be known after apply, and
+	// whose type is therefore not known either.
+	AmbiguousUnknown AmbiguityKind = "unknown"
+)
+
+// Ambiguity flags a part of a value decoded without schema whose actual
+// type could not be determined from its serialized form.
+type Ambiguity struct {
+	Path cty.Path
+	Kind AmbiguityKind
+}
+
+// PathString returns the path of the ambiguous value in an HCL-like syntax,
+// e.g. 'ebs_block_device[0].tags'.
+func (a Ambiguity) PathString() string {
+	return strings.TrimPrefix(tfdiags.FormatCtyPath(a.Path), ".")
+}
+
+// DecodeSchemaless decodes a plan value without any schema, using the type
+// implied by its serialized form.
+//
+// The msgpack encoding used in plan files does not record the difference
+// between lists, sets and tuples, nor between maps and objects, and unknown
+// values are stored without any type. Lists and sets are therefore decoded
+// as tuples, maps as objects and unknown values as cty.DynamicVal, and each
+// of these occurrences is reported as an ambiguity.
+//
+// An absent value is decoded as an untyped null.
+func DecodeSchemaless(v plans.DynamicValue) (cty.Value, []Ambiguity, error) {
+	if v == nil {
+		return cty.NullVal(cty.DynamicPseudoType), nil, nil
+	}
+
+	ty, err := v.ImpliedType()
+	if err != nil {
+		return cty.NilVal, nil, xerrors.Errorf("failed to infer the value type: %w", err)
+	}
+
+	val, err := v.Decode(ty)
+	if err != nil {
+		return cty.NilVal, nil, xerrors.Errorf("failed to decode value: %w", err)
+	}
+
+	return val, findAmbiguities(val), nil
+}
+
+func findAmbiguities(val cty.Value) []Ambiguity {
+	var ambiguities []Ambiguity
+
+	_ = cty.Walk(val, func(path cty.Path, v cty.Value) (bool, error) {
+		// The root of a resource value is always an object.
+		if len(path) == 0 {
+			return true, nil
+		}
+
+		var kind AmbiguityKind
+
+		switch ty := v.Type(); {
+		case !v.IsKnown():
+			kind = AmbiguousUnknown
+		case v.IsNull():
+			return false, nil
+		case ty.IsTupleType():
+			kind = AmbiguousSequence
+		case ty.IsObjectType():
+			kind = AmbiguousMapping
+		default:
+			return true, nil
+		}
+
+		ambiguities = append(ambiguities, Ambiguity{
+			Path: path.Copy(),
+			Kind: kind,
+		})
+
+		return true, nil
+	})
+
+	return ambiguities
+}
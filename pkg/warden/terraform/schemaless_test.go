@@ -0,0 +1,158 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+)
+
+func TestDecodeSchemaless(t *testing.T) {
+	type ambiguity struct {
+		path string
+		kind AmbiguityKind
+	}
+
+	tests := []struct {
+		name        string
+		value       cty.Value
+		want        cty.Value
+		ambiguities []ambiguity
+	}{
+		{
+			name: "primitives",
+			value: cty.ObjectVal(map[string]cty.Value{
+				"instance_type": cty.StringVal("t2.micro"),
+				"count":         cty.NumberIntVal(3),
+				"enabled":       cty.True,
+				"name":          cty.NullVal(cty.String),
+			}),
+			want: cty.ObjectVal(map[string]cty.Value{
+				"instance_type": cty.StringVal("t2.micro"),
+				"count":         cty.NumberIntVal(3),
+				"enabled":       cty.True,
+				"name":          cty.NullVal(cty.DynamicPseudoType),
+			}),
+		},
+		{
+			name: "map and list",
+			value: cty.ObjectVal(map[string]cty.Value{
+				"tags":  cty.MapVal(map[string]cty.Value{"Name": cty.StringVal("web")}),
+				"zones": cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+			}),
+			want: cty.ObjectVal(map[string]cty.Value{
+				"tags":  cty.ObjectVal(map[string]cty.Value{"Name": cty.StringVal("web")}),
+				"zones": cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+			}),
+			ambiguities: []ambiguity{
+				{path: "tags", kind: AmbiguousMapping},
+				{path: "zones", kind: AmbiguousSequence},
+			},
+		},
+		{
+			name: "nested set",
+			value: cty.ObjectVal(map[string]cty.Value{
+				"ebs": cty.SetVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"size": cty.NumberIntVal(8)}),
+				}),
+			}),
+			want: cty.ObjectVal(map[string]cty.Value{
+				"ebs": cty.TupleVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"size": cty.NumberIntVal(8)}),
+				}),
+			}),
+			ambiguities: []ambiguity{
+				{path: "ebs", kind: AmbiguousSequence},
+				{path: "ebs[0]", kind: AmbiguousMapping},
+			},
+		},
+		{
+			name: "unknown",
+			value: cty.ObjectVal(map[string]cty.Value{
+				"id":  cty.UnknownVal(cty.String),
+				"ips": cty.UnknownVal(cty.List(cty.String)),
+			}),
+			want: cty.ObjectVal(map[string]cty.Value{
+				"id":  cty.DynamicVal,
+				"ips": cty.DynamicVal,
+			}),
+			ambiguities: []ambiguity{
+				{path: "id", kind: AmbiguousUnknown},
+				{path: "ips", kind: AmbiguousUnknown},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dv, err := plans.NewDynamicValue(tt.value, tt.value.Type())
+			require.NoError(t, err)
+
+			got, ambiguities, err := DecodeSchemaless(dv)
+			require.NoError(t, err)
+
+			assert.True(t, tt.want.RawEquals(got), "got %#v", got)
+
+			gotAmbiguities := make([]ambiguity, 0, len(ambiguities))
+			for _, a := range ambiguities {
+				gotAmbiguities = append(gotAmbiguities, ambiguity{path: a.PathString(), kind: a.Kind})
+			}
+
+			assert.ElementsMatch(t, tt.ambiguities, gotAmbiguities)
+		})
+	}
+}
+
+func TestDecodeSchemaless_Absent(t *testing.T) {
+	got, ambiguities, err := DecodeSchemaless(nil)
+	require.NoError(t, err)
+	assert.True(t, got.IsNull())
+	assert.Empty(t, ambiguities)
+}
+
+func TestDecodeSchemaless_Invalid(t *testing.T) {
+	_, _, err := DecodeSchemaless(plans.DynamicValue{0xc1})
+	assert.Error(t, err)
+}
+
+func TestResourceChange_LuaAmbiguities(t *testing.T) {
+	planFile := loadTestPlanFile(t, "tf-planfile")
+	rc := findTestResourceChange(t, planFile, "null_resource.foo")
+
+	ls := lua.NewState()
+	defer ls.Close()
+
+	RegisterResourceChangeType(ls)
+	ls.SetGlobal("rc", LResourceChange(ls, rc))
+
+	require.NoError(t, ls.DoString(`
+local c = rc:change()
+local found = {}
+for _, a in ipairs(c.ambiguities) do
+	found[#found + 1] = a.value .. " " .. a.path .. " " .. a.kind
+end
+table.sort(found)
+return c.schemaless, c.after.triggers.foo, table.concat(found, ", ")
+`))
+
+	assert.Equal(t, lua.LTrue, ls.Get(1))
+	assert.Equal(t, lua.LString("bar"), ls.Get(2))
+	assert.Equal(t, lua.LString("after id unknown, after triggers mapping"), ls.Get(3))
+}
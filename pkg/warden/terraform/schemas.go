@@ -0,0 +1,249 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"encoding/json"
+	"io"
+
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/configs/configschema"
+)
+
+// ProviderSchemas holds the schemas of the resources and data sources of a
+// set of providers, as reported by 'terraform providers schema -json'.
+type ProviderSchemas struct {
+	providers map[addrs.Provider]*providerSchema
+}
+
+type providerSchema struct {
+	resources   map[string]*configschema.Block
+	dataSources map[string]*configschema.Block
+}
+
+// LoadProviderSchemas reads provider schemas in the format produced by
+// 'terraform providers schema -json'.
+func LoadProviderSchemas(r io.Reader) (*ProviderSchemas, error) {
+	var doc jsonProviderSchemas
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, xerrors.Errorf("failed to parse provider schemas: %w", err)
+	}
+
+	schemas := &ProviderSchemas{
+		providers: make(map[addrs.Provider]*providerSchema, len(doc.ProviderSchemas)),
+	}
+
+	for source, ps := range doc.ProviderSchemas {
+		provider, diags := addrs.ParseProviderSourceString(source)
+		if diags.HasErrors() {
+			return nil, xerrors.Errorf("invalid provider source address '%s': %w", source, diags.Err())
+		}
+
+		s := &providerSchema{
+			resources:   make(map[string]*configschema.Block, len(ps.ResourceSchemas)),
+			dataSources: make(map[string]*configschema.Block, len(ps.DataSourceSchemas)),
+		}
+
+		for name, rs := range ps.ResourceSchemas {
+			block, err := rs.Block.configSchema()
+			if err != nil {
+				return nil, xerrors.Errorf("invalid schema for resource type '%s': %w", name, err)
+			}
+
+			s.resources[name] = block
+		}
+
+		for name, ds := range ps.DataSourceSchemas {
+			block, err := ds.Block.configSchema()
+			if err != nil {
+				return nil, xerrors.Errorf("invalid schema for data source '%s': %w", name, err)
+			}
+
+			s.dataSources[name] = block
+		}
+
+		schemas.providers[provider] = s
+	}
+
+	return schemas, nil
+}
+
+// ResourceTypeSchema returns the schema of the specified resource or data
+// source type, or nil if it is unknown.
+func (s *ProviderSchemas) ResourceTypeSchema(provider addrs.Provider, mode addrs.ResourceMode, typeName string) *configschema.Block {
+	if s == nil {
+		return nil
+	}
+
+	ps, ok := s.providers[provider]
+	if !ok {
+		return nil
+	}
+
+	switch mode {
+	case addrs.ManagedResourceMode:
+		return ps.resources[typeName]
+	case addrs.DataResourceMode:
+		return ps.dataSources[typeName]
+	default:
+		return nil
+	}
+}
+
+// -----------------------------------------------------------------------------
+// JSON Format
+
+type jsonProviderSchemas struct {
+	FormatVersion   string                        `json:"format_version"`
+	ProviderSchemas map[string]jsonProviderSchema `json:"provider_schemas"`
+}
+
+type jsonProviderSchema struct {
+	ResourceSchemas   map[string]jsonSchema `json:"resource_schemas"`
+	DataSourceSchemas map[string]jsonSchema `json:"data_source_schemas"`
+}
+
+type jsonSchema struct {
+	Version uint64    `json:"version"`
+	Block   jsonBlock `json:"block"`
+}
+
+type jsonBlock struct {
+	Attributes map[string]jsonAttribute `json:"attributes"`
+	BlockTypes map[string]jsonBlockType `json:"block_types"`
+	Deprecated bool                     `json:"deprecated"`
+}
+
+type jsonAttribute struct {
+	Type       json.RawMessage   `json:"type"`
+	NestedType *jsonNestedObject `json:"nested_type"`
+	Required   bool              `json:"required"`
+	Optional   bool              `json:"optional"`
+	Computed   bool              `json:"computed"`
+	Sensitive  bool              `json:"sensitive"`
+	Deprecated bool              `json:"deprecated"`
+}
+
+type jsonNestedObject struct {
+	Attributes  map[string]jsonAttribute `json:"attributes"`
+	NestingMode string                   `json:"nesting_mode"`
+	MinItems    int                      `json:"min_items"`
+	MaxItems    int                      `json:"max_items"`
+}
+
+type jsonBlockType struct {
+	NestingMode string    `json:"nesting_mode"`
+	Block       jsonBlock `json:"block"`
+	MinItems    int       `json:"min_items"`
+	MaxItems    int       `json:"max_items"`
+}
+
+func (b *jsonBlock) configSchema() (*configschema.Block, error) {
+	block := &configschema.Block{
+		Attributes: make(map[string]*configschema.Attribute, len(b.Attributes)),
+		BlockTypes: make(map[string]*configschema.NestedBlock, len(b.BlockTypes)),
+		Deprecated: b.Deprecated,
+	}
+
+	for name, a := range b.Attributes {
+		attr, err := a.configSchema()
+		if err != nil {
+			return nil, xerrors.Errorf("attribute '%s': %w", name, err)
+		}
+
+		block.Attributes[name] = attr
+	}
+
+	for name, bt := range b.BlockTypes {
+		nested, err := bt.Block.configSchema()
+		if err != nil {
+			return nil, xerrors.Errorf("block '%s': %w", name, err)
+		}
+
+		nesting, err := nestingMode(bt.NestingMode)
+		if err != nil {
+			return nil, xerrors.Errorf("block '%s': %w", name, err)
+		}
+
+		block.BlockTypes[name] = &configschema.NestedBlock{
+			Block:    *nested,
+			Nesting:  nesting,
+			MinItems: bt.MinItems,
+			MaxItems: bt.MaxItems,
+		}
+	}
+
+	return block, nil
+}
+
+func (a *jsonAttribute) configSchema() (*configschema.Attribute, error) {
+	attr := &configschema.Attribute{
+		Required:   a.Required,
+		Optional:   a.Optional,
+		Computed:   a.Computed,
+		Sensitive:  a.Sensitive,
+		Deprecated: a.Deprecated,
+	}
+
+	if a.NestedType == nil {
+		if err := attr.Type.UnmarshalJSON(a.Type); err != nil {
+			return nil, xerrors.Errorf("invalid type: %w", err)
+		}
+
+		return attr, nil
+	}
+
+	nesting, err := nestingMode(a.NestedType.NestingMode)
+	if err != nil {
+		return nil, err
+	}
+
+	attr.NestedType = &configschema.Object{
+		Attributes: make(map[string]*configschema.Attribute, len(a.NestedType.Attributes)),
+		Nesting:    nesting,
+		MinItems:   a.NestedType.MinItems,
+		MaxItems:   a.NestedType.MaxItems,
+	}
+
+	for name, na := range a.NestedType.Attributes {
+		nested, err := na.configSchema()
+		if err != nil {
+			return nil, xerrors.Errorf("attribute '%s': %w", name, err)
+		}
+
+		attr.NestedType.Attributes[name] = nested
+	}
+
+	return attr, nil
+}
+
+func nestingMode(mode string) (configschema.NestingMode, error) {
+	switch mode {
+	case "single":
+		return configschema.NestingSingle, nil
+	case "group":
+		return configschema.NestingGroup, nil
+	case "list":
+		return configschema.NestingList, nil
+	case "set":
+		return configschema.NestingSet, nil
+	case "map":
+		return configschema.NestingMap, nil
+	default:
+		return configschema.NestingSingle, xerrors.Errorf("invalid nesting mode '%s'", mode)
+	}
+}
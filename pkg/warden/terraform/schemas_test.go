@@ -0,0 +1,199 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+)
+
+func loadTestProviderSchemas(t *testing.T) *ProviderSchemas {
+	t.Helper()
+
+	fs := afero.NewReadOnlyFs(afero.NewOsFs())
+	file, err := fs.Open(getTestDataPath(t, "provider-schemas.json"))
+	require.NoError(t, err)
+
+	defer file.Close()
+
+	schemas, err := LoadProviderSchemas(file)
+	require.NoError(t, err)
+
+	return schemas
+}
+
+func TestLoadProviderSchemas(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			doc:  `{"format_version": "0.2"}`,
+		},
+		{
+			name: "valid",
+			doc: `{"format_version": "0.2", "provider_schemas": {"registry.terraform.io/hashicorp/null": {
+				"resource_schemas": {"null_resource": {"block": {"attributes": {
+					"id": {"type": "string", "computed": true},
+					"triggers": {"type": ["map", "string"], "optional": true}
+				}}}}
+			}}}`,
+		},
+		{
+			name:    "invalid json",
+			doc:     `{"format_version": `,
+			wantErr: true,
+		},
+		{
+			name:    "invalid provider address",
+			doc:     `{"provider_schemas": {"not a valid/provider/address/at/all": {}}}`,
+			wantErr: true,
+		},
+		{
+			name: "invalid attribute type",
+			doc: `{"provider_schemas": {"hashicorp/null": {"resource_schemas": {"null_resource": {"block": {
+				"attributes": {"id": {"type": "integer"}}
+			}}}}}}`,
+			wantErr: true,
+		},
+		{
+			name: "nested blocks and attributes",
+			doc: `{"provider_schemas": {"hashicorp/aws": {"resource_schemas": {"aws_instance": {"block": {
+				"attributes": {"disks": {"nested_type": {"nesting_mode": "set", "attributes": {"size": {"type": "number"}}}}},
+				"block_types": {"ebs": {"nesting_mode": "list", "block": {"attributes": {"size": {"type": "number"}}}}}
+			}}}}}}`,
+		},
+		{
+			name: "invalid block nesting mode",
+			doc: `{"provider_schemas": {"hashicorp/aws": {"resource_schemas": {"aws_instance": {"block": {
+				"block_types": {"ebs": {"nesting_mode": "lst", "block": {}}}
+			}}}}}}`,
+			wantErr: true,
+		},
+		{
+			name: "invalid nested attribute nesting mode",
+			doc: `{"provider_schemas": {"hashicorp/aws": {"resource_schemas": {"aws_instance": {"block": {
+				"attributes": {"disks": {"nested_type": {"nesting_mode": "sets", "attributes": {}}}}
+			}}}}}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadProviderSchemas(strings.NewReader(tt.doc))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, got)
+			}
+		})
+	}
+}
+
+func TestProviderSchemas_ResourceTypeSchema(t *testing.T) {
+	schemas := loadTestProviderSchemas(t)
+
+	aws := addrs.NewDefaultProvider("aws")
+
+	schema := schemas.ResourceTypeSchema(aws, addrs.ManagedResourceMode, "aws_instance")
+	require.NotNil(t, schema)
+	assert.Equal(t, cty.Map(cty.String), schema.Attributes["tags"].Type)
+	assert.True(t, schema.Attributes["password_data"].Sensitive)
+
+	assert.NotNil(t, schemas.ResourceTypeSchema(aws, addrs.DataResourceMode, "aws_ami"))
+	assert.Nil(t, schemas.ResourceTypeSchema(aws, addrs.DataResourceMode, "aws_instance"))
+	assert.Nil(t, schemas.ResourceTypeSchema(addrs.NewDefaultProvider("google"), addrs.ManagedResourceMode, "aws_instance"))
+	assert.Nil(t, (*ProviderSchemas)(nil).ResourceTypeSchema(aws, addrs.ManagedResourceMode, "aws_instance"))
+}
+
+func TestResourceChange_LuaChangeWithSchemas(t *testing.T) {
+	planFile := loadTestPlanFile(t, "tf-planfile")
+	plan := &Plan{Plan: planFile.Plan, Schemas: loadTestProviderSchemas(t)}
+
+	tests := []struct {
+		name    string
+		address string
+		script  string
+		want    []lua.LValue
+	}{
+		{
+			name:    "decoded values",
+			address: "aws_instance.simple_resource",
+			script:  `local c = rc:change() return c.after.instance_type, c.after.tags.Name, #c.after.credit_specification, tostring(c.after.id)`,
+			want:    []lua.LValue{lua.LString("t2.micro"), lua.LString("ExampleAppServerInstance 1"), lua.LNumber(0), lua.LString("(known after apply)")},
+		},
+		{
+			name:    "after unknown",
+			address: "aws_instance.simple_resource",
+			script:  `local u = rc:change().after_unknown return u.id, u.arn, u.ami, next(u.tags)`,
+			want:    []lua.LValue{lua.LTrue, lua.LTrue, lua.LNil, lua.LNil},
+		},
+		{
+			name:    "sensitive from schema",
+			address: "aws_instance.simple_resource",
+			script:  `local c = rc:change() return c.after_sensitive.password_data, c.after_sensitive.ami, c.before_sensitive`,
+			want:    []lua.LValue{lua.LTrue, lua.LNil, lua.LFalse},
+		},
+		{
+			name:    "without sensitive attributes",
+			address: "null_resource.foo",
+			script:  `local c = rc:change() return c.after.triggers.foo, next(c.after_sensitive.triggers), c.after_unknown.id`,
+			want:    []lua.LValue{lua.LString("bar"), lua.LNil, lua.LTrue},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, err := plan.FindResources(ResourceFilter{})
+			require.NoError(t, err)
+
+			var rc *ResourceChange
+
+			for _, r := range resources {
+				if r.Address() == tt.address {
+					rc = r
+				}
+			}
+
+			require.NotNil(t, rc)
+			require.NotNil(t, rc.Schema())
+
+			ls := lua.NewState()
+			defer ls.Close()
+
+			RegisterResourceChangeType(ls)
+			ls.SetGlobal("rc", LResourceChange(ls, rc))
+
+			require.NoError(t, ls.DoString(tt.script))
+
+			got := make([]lua.LValue, 0, ls.GetTop())
+			for i := 1; i <= ls.GetTop(); i++ {
+				got = append(got, ls.Get(i))
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
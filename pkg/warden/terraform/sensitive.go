@@ -0,0 +1,347 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"fmt"
+	"sort"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+)
+
+// SensitiveValue is a value marked as sensitive, either in the plan or state
+// itself, or by the provider schema.
+//
+// It is exposed to Lua as an opaque value which cannot be converted to a
+// string, so that a rule cannot leak it by accident, e.g. in the message of
+// a finding. The rules must explicitly unwrap it to read it.
+type SensitiveValue struct {
+	value cty.Value
+}
+
+// NewSensitiveValue returns the sensitive value wrapping v. The marks of v
+// are discarded.
+func NewSensitiveValue(v cty.Value) *SensitiveValue {
+	return &SensitiveValue{value: unmarked(v)}
+}
+
+// Value returns the wrapped value.
+func (s *SensitiveValue) Value() cty.Value {
+	return s.value
+}
+
+// addSensitiveStrings adds to the set the string representations of the
+// known primitive values marked as sensitive in v, or of all of them if
+// sensitive is true. Booleans are ignored, since they reveal nothing by
+// themselves.
+func addSensitiveStrings(set map[string]struct{}, v cty.Value, sensitive bool) {
+	if v == cty.NilVal {
+		return
+	}
+
+	sensitive = sensitive || v.HasMark(sensitiveMark)
+
+	v, _ = v.Unmark()
+	if v.IsNull() || !v.IsKnown() {
+		return
+	}
+
+	ty := v.Type()
+
+	switch {
+	case ty == cty.String:
+		if sensitive {
+			set[v.AsString()] = struct{}{}
+		}
+
+	case ty == cty.Number:
+		if sensitive {
+			set[v.AsBigFloat().Text('f', -1)] = struct{}{}
+		}
+
+	case ty.IsCollectionType() || ty.IsObjectType() || ty.IsTupleType():
+		for it := v.ElementIterator(); it.Next(); {
+			_, ev := it.Element()
+			addSensitiveStrings(set, ev, sensitive)
+		}
+	}
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	ret := make([]string, 0, len(set))
+	for s := range set {
+		ret = append(ret, s)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// SensitiveValues returns the string representations of all the known
+// values marked as sensitive in the state, including the sensitive outputs.
+//
+// The objects that cannot be decoded are skipped, since the rules cannot
+// read them either.
+func (s *State) SensitiveValues() []string {
+	set := map[string]struct{}{}
+
+	if s.State == nil {
+		return nil
+	}
+
+	if resources, err := s.FindResources(ResourceFilter{}); err == nil {
+		for _, r := range resources {
+			if v, err := r.Value(); err == nil {
+				addSensitiveStrings(set, v, false)
+			}
+		}
+	}
+
+	for _, o := range s.Outputs() {
+		addSensitiveStrings(set, o.Value, o.Sensitive)
+	}
+
+	return sortedSet(set)
+}
+
+// SensitiveValues returns the string representations of all the known
+// values marked as sensitive in the changes of the plan and in its prior
+// and previous run states, including the sensitive outputs.
+//
+// The objects that cannot be decoded are skipped, since the rules cannot
+// read them either.
+func (p *Plan) SensitiveValues() []string {
+	set := map[string]struct{}{}
+
+	if p.Plan == nil {
+		return nil
+	}
+
+	if resources, err := p.FindResources(ResourceFilter{}); err == nil {
+		for _, r := range resources {
+			if change, err := r.Change(); err == nil {
+				addSensitiveStrings(set, change.Before, false)
+				addSensitiveStrings(set, change.After, false)
+			}
+		}
+	}
+
+	for _, o := range p.Outputs() {
+		if before, err := o.Before(); err == nil {
+			addSensitiveStrings(set, before, o.BeforeSensitive())
+		}
+
+		if after, err := o.After(); err == nil {
+			addSensitiveStrings(set, after, o.Sensitive())
+		}
+	}
+
+	for _, state := range []*State{
+		{State: p.PriorState, Schemas: p.Schemas},
+		{State: p.PrevRunState, Schemas: p.Schemas},
+	} {
+		for _, s := range state.SensitiveValues() {
+			set[s] = struct{}{}
+		}
+	}
+
+	return sortedSet(set)
+}
+
+// SensitiveValues returns the string representations of all the known
+// values marked as sensitive in the plan file, including the values of the
+// root module variables and the defaults of the variables declared as
+// sensitive in the configuration. The provider schemas are optional.
+func (pf *PlanFile) SensitiveValues(schemas *ProviderSchemas) []string {
+	set := map[string]struct{}{}
+
+	for _, s := range (&Plan{Plan: pf.Plan, Schemas: schemas, Details: pf.Details}).SensitiveValues() {
+		set[s] = struct{}{}
+	}
+
+	if pf.Config == nil || pf.Config.Module == nil {
+		return sortedSet(set)
+	}
+
+	for _, s := range NewModule(pf.Config).SensitiveValues() {
+		set[s] = struct{}{}
+	}
+
+	if pf.Plan != nil {
+		for name, v := range pf.Config.Module.Variables {
+			dv, ok := pf.Plan.VariableValues[name]
+			if !ok || !v.Sensitive {
+				continue
+			}
+
+			if val, err := dv.Decode(cty.DynamicPseudoType); err == nil {
+				addSensitiveStrings(set, val, true)
+			}
+		}
+	}
+
+	return sortedSet(set)
+}
+
+// SensitiveValues returns the string representations of the defaults of
+// the variables declared as sensitive in the module and its descendants.
+func (m *Module) SensitiveValues() []string {
+	set := map[string]struct{}{}
+	m.addSensitiveValues(set)
+
+	return sortedSet(set)
+}
+
+func (m *Module) addSensitiveValues(set map[string]struct{}) {
+	for _, v := range m.Variables() {
+		addSensitiveStrings(set, v.Default, v.Sensitive)
+	}
+
+	for _, child := range m.Children() {
+		child.addSensitiveValues(set)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Lua Utilities
+
+const luaSensitiveValueTypeName = "sensitive"
+
+const (
+	luaFunctionSensitiveValueUnwrap = "unwrap"
+)
+
+// RegisterSensitiveValueType registers the SensitiveValue type inside the
+// Lua state.
+//
+// Converting a sensitive value to a string, including by concatenation,
+// raises an error. Two sensitive values are equal if their wrapped values
+// are.
+func RegisterSensitiveValueType(ls *lua.LState) {
+	var methods = map[string]lua.LGFunction{
+		luaFunctionSensitiveValueUnwrap: sensitiveValueUnwrap,
+	}
+
+	mt := ls.NewTypeMetatable(luaSensitiveValueTypeName)
+	ls.SetGlobal(luaSensitiveValueTypeName, mt)
+
+	// methods
+	ls.SetField(mt, "__index", ls.SetFuncs(ls.NewTable(), methods))
+
+	// operators
+	ls.SetField(mt, "__tostring", ls.NewFunction(sensitiveValueToString))
+	ls.SetField(mt, "__concat", ls.NewFunction(sensitiveValueToString))
+	ls.SetField(mt, "__eq", ls.NewFunction(sensitiveValueEqual))
+}
+
+// LSensitiveValue creates a new sensitive userdata wrapping v, or returns
+// nil if v is null, or the unknown sentinel if v is unknown.
+func LSensitiveValue(ls *lua.LState, v cty.Value) lua.LValue {
+	if v == cty.NilVal {
+		return lua.LNil
+	}
+
+	switch v = unmarked(v); {
+	case !v.IsKnown():
+		return wlua.LUnknown(ls)
+	case v.IsNull():
+		return lua.LNil
+	}
+
+	ud := ls.NewUserData()
+	ud.Value = NewSensitiveValue(v)
+	ls.SetMetatable(ud, ls.GetTypeMetatable(luaSensitiveValueTypeName))
+
+	return ud
+}
+
+// CheckSensitiveValue checks whether the first lua argument is a *LUserData
+// with *SensitiveValue and returns this *SensitiveValue.
+func CheckSensitiveValue(ls *lua.LState) (*SensitiveValue, error) {
+	ud := ls.CheckUserData(1)
+	if v, ok := ud.Value.(*SensitiveValue); ok {
+		return v, nil
+	}
+
+	ls.ArgError(1, fmt.Sprintf("%s expected", luaSensitiveValueTypeName))
+
+	return nil, ErrInvalidType
+}
+
+// IsLSensitiveValue reports whether v is a sensitive userdata.
+func IsLSensitiveValue(v lua.LValue) bool {
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return false
+	}
+
+	_, ok = ud.Value.(*SensitiveValue)
+
+	return ok
+}
+
+// LUnwrap returns the Lua value wrapped by v if it is a sensitive userdata,
+// or v itself otherwise.
+func LUnwrap(ls *lua.LState, v lua.LValue) lua.LValue {
+	if ud, ok := v.(*lua.LUserData); ok {
+		if s, ok := ud.Value.(*SensitiveValue); ok {
+			return luaValue(ls, s.value)
+		}
+	}
+
+	return v
+}
+
+// -----------------------------------------------------------------------------
+// Lua Functions
+
+func sensitiveValueUnwrap(ls *lua.LState) int {
+	s, err := CheckSensitiveValue(ls)
+	if err != nil {
+		return 0
+	}
+
+	ls.Push(luaValue(ls, s.value))
+
+	return 1
+}
+
+func sensitiveValueToString(ls *lua.LState) int {
+	ls.RaiseError("sensitive values cannot be converted to strings, call unwrap() to read them")
+
+	return 0
+}
+
+func sensitiveValueEqual(ls *lua.LState) int {
+	a, aOk := ls.Get(1).(*lua.LUserData)
+	b, bOk := ls.Get(2).(*lua.LUserData) //nolint:gomnd // second operand
+
+	if !aOk || !bOk {
+		ls.Push(lua.LFalse)
+
+		return 1
+	}
+
+	sa, aOk := a.Value.(*SensitiveValue)
+	sb, bOk := b.Value.(*SensitiveValue)
+
+	ls.Push(lua.LBool(aOk && bOk && sa.value.RawEquals(sb.value)))
+
+	return 1
+}
@@ -0,0 +1,126 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+)
+
+func TestState_SensitiveValues(t *testing.T) {
+	state := loadTestState(t, "tf-state.tfstate", nil)
+
+	assert.Equal(t, []string{"hunter2", "s3cr3t"}, state.SensitiveValues())
+	assert.Empty(t, (&State{}).SensitiveValues())
+}
+
+func TestPlanFile_SensitiveValues(t *testing.T) {
+	planFile := loadTestJSONPlan(t, testJSONPlan)
+
+	assert.Equal(t, []string{"hunter2", "new", "old"}, planFile.SensitiveValues(nil))
+}
+
+func TestLSensitiveValue(t *testing.T) {
+	secret := cty.ObjectVal(map[string]cty.Value{
+		"password": cty.StringVal("s3cr3t").Mark(sensitiveMark),
+		"pin":      cty.NumberIntVal(1234).Mark(sensitiveMark),
+		"user":     cty.StringVal("admin"),
+	})
+
+	tests := []struct {
+		name    string
+		script  string
+		want    lua.LValue
+		wantErr bool
+	}{
+		{
+			name:   "plain attribute",
+			script: `return v.user`,
+			want:   lua.LString("admin"),
+		},
+		{
+			name:   "type",
+			script: `return type(v.password)`,
+			want:   lua.LString("userdata"),
+		},
+		{
+			name:   "unwrap",
+			script: `return v.password:unwrap()`,
+			want:   lua.LString("s3cr3t"),
+		},
+		{
+			name:   "unwrap number",
+			script: `return v.pin:unwrap() + 1`,
+			want:   lua.LNumber(1235),
+		},
+		{
+			name:   "equal",
+			script: `return v.password == w.password`,
+			want:   lua.LTrue,
+		},
+		{
+			name:   "not equal",
+			script: `return v.password == w.pin`,
+			want:   lua.LFalse,
+		},
+		{
+			name:    "tostring",
+			script:  `return tostring(v.password)`,
+			wantErr: true,
+		},
+		{
+			name:    "concatenation",
+			script:  `return "password: " .. v.password`,
+			wantErr: true,
+		},
+		{
+			name:   "format",
+			script: `return string.format("%s", v.password):find("s3cr3t")`,
+			want:   lua.LNil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ls := lua.NewState()
+			defer ls.Close()
+
+			RegisterSensitiveValueType(ls)
+			ls.SetGlobal("v", luaValue(ls, secret))
+			ls.SetGlobal("w", luaValue(ls, secret))
+
+			err := ls.DoString(tt.script)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ls.Get(-1))
+		})
+	}
+
+	ls := lua.NewState()
+	defer ls.Close()
+
+	assert.Equal(t, lua.LNil, LSensitiveValue(ls, cty.NullVal(cty.String).Mark(sensitiveMark)))
+	assert.Equal(t, wlua.LUnknown(ls), LSensitiveValue(ls, cty.UnknownVal(cty.String)))
+}
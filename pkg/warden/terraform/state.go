@@ -0,0 +1,201 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"io"
+	"sort"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
+	"github.com/hexbee-net/horus/pkg/terraform/states/statefile"
+)
+
+// LoadStateFile loads a state file, in any of the formats written by
+// Terraform since 0.7; the states of earlier formats are upgraded to the
+// latest one.
+func LoadStateFile(r io.Reader) (*statefile.File, error) {
+	file, err := statefile.Read(r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load state data: %w", err)
+	}
+
+	return file, nil
+}
+
+// State is a snapshot of the infrastructure managed by Terraform.
+type State struct {
+	*states.State
+
+	// Schemas are the provider schemas used to decode the resource
+	// instances. They are optional.
+	Schemas *ProviderSchemas
+}
+
+// Output is an output value of the root module recorded in a state.
+type Output struct {
+	Name      string
+	Value     cty.Value
+	Sensitive bool
+}
+
+// FindResource returns all the objects of the instances of the resources of
+// the given type whose name matches the specified pattern.
+func (s *State) FindResource(resourceType string, resourceName string) ([]*ResourceInstance, error) {
+	return s.FindResources(ResourceFilter{Type: resourceType, Name: resourceName})
+}
+
+// FindResources returns the objects of the resource instances matching all
+// the criteria of the filter, ordered by address. The deposed objects of an
+// instance follow its current object.
+//
+// States hold no planned action: filtering on the action is an error.
+func (s *State) FindResources(filter ResourceFilter) ([]*ResourceInstance, error) {
+	if filter.Action != "" {
+		return nil, xerrors.Errorf("%w: resources of a state have no action", ErrUnsupportedFilter)
+	}
+
+	resources := make([]*ResourceInstance, 0)
+
+	if s.State == nil {
+		return resources, nil
+	}
+
+	for _, module := range sortedModules(s.State) {
+		for _, rs := range sortedResources(module) {
+			for _, key := range sortedInstanceKeys(rs) {
+				addr := rs.Addr.Instance(key)
+
+				ok, err := filter.matchResource(addr, rs.ProviderConfig)
+				if err != nil {
+					return nil, err
+				}
+
+				if ok {
+					resources = append(resources, s.instanceObjects(rs, key)...)
+				}
+			}
+		}
+	}
+
+	return resources, nil
+}
+
+func (s *State) instanceObjects(rs *states.Resource, key addrs.InstanceKey) []*ResourceInstance {
+	is := rs.Instances[key]
+	addr := rs.Addr.Instance(key)
+	schema := s.Schemas.ResourceTypeSchema(rs.ProviderConfig.Provider, addr.Resource.Resource.Mode, addr.Resource.Resource.Type)
+
+	objects := make([]*ResourceInstance, 0, 1+len(is.Deposed))
+
+	if is.Current != nil {
+		objects = append(objects, &ResourceInstance{
+			addr:     addr,
+			deposed:  states.NotDeposed,
+			provider: rs.ProviderConfig,
+			obj:      is.Current,
+			schema:   schema,
+		})
+	}
+
+	deposedKeys := make([]string, 0, len(is.Deposed))
+	for k := range is.Deposed {
+		deposedKeys = append(deposedKeys, string(k))
+	}
+
+	sort.Strings(deposedKeys)
+
+	for _, k := range deposedKeys {
+		objects = append(objects, &ResourceInstance{
+			addr:     addr,
+			deposed:  states.DeposedKey(k),
+			provider: rs.ProviderConfig,
+			obj:      is.Deposed[states.DeposedKey(k)],
+			schema:   schema,
+		})
+	}
+
+	return objects
+}
+
+// Outputs returns the output values of the root module, ordered by name.
+// Only the outputs of the root module are recorded in states.
+func (s *State) Outputs() []Output {
+	if s.State == nil || s.RootModule() == nil {
+		return nil
+	}
+
+	values := s.RootModule().OutputValues
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	outputs := make([]Output, 0, len(names))
+	for _, name := range names {
+		outputs = append(outputs, Output{
+			Name:      name,
+			Value:     values[name].Value,
+			Sensitive: values[name].Sensitive,
+		})
+	}
+
+	return outputs
+}
+
+func sortedModules(state *states.State) []*states.Module {
+	modules := make([]*states.Module, 0, len(state.Modules))
+	for _, m := range state.Modules {
+		modules = append(modules, m)
+	}
+
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Addr.Less(modules[j].Addr)
+	})
+
+	return modules
+}
+
+func sortedResources(module *states.Module) []*states.Resource {
+	resources := make([]*states.Resource, 0, len(module.Resources))
+	for _, rs := range module.Resources {
+		resources = append(resources, rs)
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Addr.String() < resources[j].Addr.String()
+	})
+
+	return resources
+}
+
+func sortedInstanceKeys(rs *states.Resource) []addrs.InstanceKey {
+	keys := make([]addrs.InstanceKey, 0, len(rs.Instances))
+	for k := range rs.Instances {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return addrs.InstanceKeyLess(keys[i], keys[j])
+	})
+
+	return keys
+}
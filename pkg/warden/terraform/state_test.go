@@ -0,0 +1,220 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func loadTestState(t *testing.T, name string, schemas *ProviderSchemas) *State {
+	t.Helper()
+
+	fs := afero.NewReadOnlyFs(afero.NewOsFs())
+	file, err := fs.Open(getTestDataPath(t, name))
+	require.NoError(t, err)
+
+	defer file.Close()
+
+	stateFile, err := LoadStateFile(file)
+	require.NoError(t, err)
+
+	return &State{State: stateFile.State, Schemas: schemas}
+}
+
+func instanceAddresses(resources []*ResourceInstance) []string {
+	addresses := make([]string, 0, len(resources))
+	for _, r := range resources {
+		addresses = append(addresses, r.Address()+strings.TrimSuffix(" "+r.Deposed(), " "))
+	}
+
+	return addresses
+}
+
+func TestLoadStateFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{
+			name: "version 4",
+			doc:  `{"version": 4, "terraform_version": "1.0.3", "serial": 1, "lineage": "x", "resources": []}`,
+		},
+		{
+			name: "version 3",
+			doc:  `{"version": 3, "terraform_version": "0.11.14", "serial": 1, "lineage": "x", "modules": [{"path": ["root"]}]}`,
+		},
+		{
+			name:    "empty",
+			doc:     ``,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported version",
+			doc:     `{"version": 5, "terraform_version": "2.0.0"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not a state",
+			doc:     `hello`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadStateFile(strings.NewReader(tt.doc))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, got.State)
+			}
+		})
+	}
+}
+
+func TestState_FindResources(t *testing.T) {
+	state := loadTestState(t, "tf-state.tfstate", nil)
+
+	tests := []struct {
+		name    string
+		filter  ResourceFilter
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "all",
+			want: []string{
+				"aws_instance.multiple_resource[0]",
+				"aws_instance.multiple_resource[0] 00000001",
+				"aws_instance.simple_resource",
+				`module.net.null_resource.foo["a"]`,
+				`module.net.null_resource.foo["b"]`,
+			},
+		},
+		{
+			name:   "type and name",
+			filter: ResourceFilter{Type: "aws_instance", Name: "simple_*"},
+			want:   []string{"aws_instance.simple_resource"},
+		},
+		{
+			name:   "root module",
+			filter: ResourceFilter{Module: RootModule, Provider: "hashicorp/null"},
+			want:   []string{},
+		},
+		{
+			name:   "module",
+			filter: ResourceFilter{Module: "module.net"},
+			want:   []string{`module.net.null_resource.foo["a"]`, `module.net.null_resource.foo["b"]`},
+		},
+		{
+			name:    "action",
+			filter:  ResourceFilter{Action: "create"},
+			wantErr: true,
+		},
+		{
+			name:   "brackets are not a character class",
+			filter: ResourceFilter{Name: "["},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := state.FindResources(tt.filter)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, instanceAddresses(got))
+		})
+	}
+}
+
+func TestResourceInstance(t *testing.T) {
+	state := loadTestState(t, "tf-state.tfstate", nil)
+
+	resources, err := state.FindResource("aws_instance", "multiple_resource")
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+
+	current, deposed := resources[0], resources[1]
+	assert.Equal(t, "tainted", current.Status())
+	assert.Equal(t, "", current.Deposed())
+	assert.Equal(t, []string{"aws_instance.simple_resource"}, current.Dependencies())
+	assert.Equal(t, uint64(1), current.SchemaVersion())
+	assert.Equal(t, `provider["registry.terraform.io/hashicorp/aws"]`, current.ProviderName())
+	assert.Equal(t, "ready", deposed.Status())
+	assert.Equal(t, "00000001", deposed.Deposed())
+
+	resources, err = state.FindResource("null_resource", "foo")
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+
+	val, err := resources[1].Value()
+	require.NoError(t, err)
+	assert.True(t, val.GetAttr("triggers").GetAttr("token").HasMark(sensitiveMark))
+	assert.Equal(t, cty.StringVal("8212585058302700791"), val.GetAttr("id"))
+}
+
+func TestResourceInstance_ValueWithSchemas(t *testing.T) {
+	state := loadTestState(t, "tf-state.tfstate", loadTestProviderSchemas(t))
+
+	resources, err := state.FindResource("aws_instance", "simple_resource")
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	require.NotNil(t, resources[0].Schema())
+	assert.True(t, resources[0].CreateBeforeDestroy())
+
+	val, err := resources[0].Value()
+	require.NoError(t, err)
+	assert.True(t, val.GetAttr("password_data").HasMark(sensitiveMark))
+	assert.Equal(t, cty.StringVal("t2.micro"), unmarked(val.GetAttr("instance_type")))
+	assert.Equal(t, cty.Map(cty.String), val.GetAttr("tags").Type())
+}
+
+func TestResourceInstance_LegacyState(t *testing.T) {
+	state := loadTestState(t, "tf-state-v3.tfstate", nil)
+
+	resources, err := state.FindResource("null_resource", "foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"null_resource.foo[0]", "null_resource.foo[1]"}, instanceAddresses(resources))
+
+	val, err := resources[0].Value()
+	require.NoError(t, err)
+	assert.Equal(t, cty.StringVal("0"), val.Index(cty.StringVal("triggers.what")))
+	assert.Equal(t, "ready", resources[0].Status())
+	assert.Equal(t, `provider["registry.terraform.io/-/null"]`, resources[0].ProviderName())
+}
+
+func TestState_Outputs(t *testing.T) {
+	state := loadTestState(t, "tf-state.tfstate", nil)
+
+	outputs := state.Outputs()
+	require.Len(t, outputs, 2)
+	assert.Equal(t, Output{Name: "db_password", Value: cty.StringVal("hunter2"), Sensitive: true}, outputs[0])
+	assert.Equal(t, "instance_id", outputs[1].Name)
+	assert.False(t, outputs[1].Sensitive)
+
+	assert.Empty(t, (&State{}).Outputs())
+}
@@ -15,6 +15,13 @@
 package terraform
 
 import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/hashicorp/hcl/v2"
 	"github.com/spf13/afero"
 	"golang.org/x/xerrors"
 
@@ -22,6 +29,7 @@ import (
 	"github.com/hexbee-net/horus/pkg/terraform/plans"
 	"github.com/hexbee-net/horus/pkg/terraform/plans/planfile"
 	"github.com/hexbee-net/horus/pkg/terraform/states/statefile"
+	"github.com/hexbee-net/horus/pkg/terraform/tfdiags"
 )
 
 type PlanFile struct {
@@ -29,9 +37,32 @@ type PlanFile struct {
 	State     *statefile.File
 	PrevState *statefile.File
 	Config    *configs.Config
+
+	// Details holds the information of the plan that the plans model cannot
+	// represent.
+	Details *PlanDetails
+	// Diagnostics are the warnings raised while loading the plan file, e.g.
+	// when it was created by an unsupported Terraform release.
+	Diagnostics tfdiags.Diagnostics
 }
 
+// LoadPlanFile loads a plan file, either in the binary format written by
+// 'terraform plan -out', or in the JSON format produced by
+// 'terraform show -json'.
+//
+// Binary plan files created by any Terraform 1.x release are supported; the
+// plans of other releases are loaded on a best-effort basis, with warnings
+// in the diagnostics of the plan file.
 func LoadPlanFile(file afero.File) (*PlanFile, error) {
+	isJSON, err := isJSONPlanFile(file)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read planFile: %w", err)
+	}
+
+	if isJSON {
+		return LoadJSONPlanFile(file)
+	}
+
 	fi, err := file.Stat()
 	if err != nil {
 		return nil, xerrors.Errorf("failed to retrieve planFile information: %w", err)
@@ -42,30 +73,103 @@ func LoadPlanFile(file afero.File) (*PlanFile, error) {
 		return nil, xerrors.Errorf("failed to open plan planFile: %w", err)
 	}
 
-	plan, err := planReader.ReadPlan()
+	src, err := readTfplanEntry(file, fi.Size())
 	if err != nil {
 		return nil, xerrors.Errorf("failed to load plan data: %w", err)
 	}
 
+	plan, details, diags := readTfplan(src)
+	if diags.HasErrors() {
+		return nil, xerrors.Errorf("failed to load plan data: %w", diags.Err())
+	}
+
 	state, err := planReader.ReadStateFile()
 	if err != nil {
 		return nil, xerrors.Errorf("failed to load state data: %w", err)
 	}
 
-	prevState, err := planReader.ReadStateFile()
-	if err != nil {
-		return nil, xerrors.Errorf("failed to load state data: %w", err)
+	// Plans created by Terraform 1.0 have no previous run state.
+	prevState, err := planReader.ReadPrevStateFile()
+
+	switch {
+	case xerrors.Is(err, statefile.ErrNoState):
+		prevState = state
+	case err != nil:
+		return nil, xerrors.Errorf("failed to load previous state data: %w", err)
 	}
 
-	config, diags := planReader.ReadConfig()
-	if diags.HasErrors() {
-		return nil, xerrors.Errorf("failed to load configuration data: %w", diags.Err())
+	plan.PriorState = state.State
+	plan.PrevRunState = prevState.State
+
+	// The configuration may use language features introduced after the
+	// vendored Terraform release; the plan is still usable without it.
+	config, configDiags := planReader.ReadConfig()
+	if configDiags.HasErrors() {
+		config = nil
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Configuration not loaded",
+			fmt.Sprintf("The configuration snapshot of the plan cannot be loaded, source locations are not available: %s.", configDiags.Err()),
+		))
 	}
 
 	return &PlanFile{
-		Plan:      plan,
-		State:     state,
-		PrevState: prevState,
-		Config:    config,
+		Plan:        plan,
+		State:       state,
+		PrevState:   prevState,
+		Config:      config,
+		Details:     details,
+		Diagnostics: diags,
 	}, nil
 }
+
+// readTfplanEntry returns the content of the 'tfplan' entry of a plan file.
+func readTfplanEntry(file io.ReaderAt, size int64) ([]byte, error) {
+	r, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // the error is wrapped by the caller.
+	}
+
+	entry, err := r.Open(tfplanFilename)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // the error is wrapped by the caller.
+	}
+
+	defer entry.Close()
+
+	return ioutil.ReadAll(entry) //nolint:wrapcheck // the error is wrapped by the caller.
+}
+
+// isJSONPlanFile reports whether the file holds a JSON document, by looking
+// at its first significant byte. The file is rewound afterwards.
+func isJSONPlanFile(file afero.File) (bool, error) {
+	buf := make([]byte, jsonSniffLen)
+
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && !xerrors.Is(err, io.EOF) {
+		return false, err //nolint:wrapcheck // the error is wrapped by the caller.
+	}
+
+	// Some implementations of ReadAt move the read offset.
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, err //nolint:wrapcheck // the error is wrapped by the caller.
+	}
+
+	trimmed := bytes.TrimLeft(buf[:n], " \t\r\n")
+
+	return len(trimmed) > 0 && trimmed[0] == '{', nil
+}
+
+// jsonSniffLen is the number of bytes read to detect JSON plan files.
+const jsonSniffLen = 512
+
+// ResourceRange returns the source range of the declaration of the resource
+// with the given address in the configuration snapshot of the plan file, or
+// nil if it cannot be found.
+func (pf *PlanFile) ResourceRange(address string) *hcl.Range {
+	if pf == nil {
+		return nil
+	}
+
+	return resourceRange(pf.Config, address)
+}
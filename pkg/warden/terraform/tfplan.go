@@ -0,0 +1,1035 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"fmt"
+
+	version "github.com/hashicorp/go-version"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/hexbee-net/horus/pkg/terraform/addrs"
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
+	"github.com/hexbee-net/horus/pkg/terraform/tfdiags"
+)
+
+// The 'tfplan' entry of plan files is a protobuf message whose schema
+// evolves with Terraform releases. Unlike the reader of the vendored
+// Terraform release, which only accepts plans created by the very same
+// release, it is decoded field by field: fields unknown to Horus are
+// ignored, and the fields added by later releases are kept in the plan
+// details.
+
+const (
+	// tfplanFilename is the name of the plan entry of plan files.
+	tfplanFilename = "tfplan"
+	// minTfplanFormatVersion is the oldest plan format version supported,
+	// used by Terraform 1.0.
+	minTfplanFormatVersion = 3
+	// maxTfplanFormatVersion is the latest plan format version known.
+	maxTfplanFormatVersion = 3
+	// supportedTerraformMajor is the major version of the Terraform
+	// releases whose plans are supported.
+	supportedTerraformMajor = 1
+)
+
+// Field numbers of the tfplan protobuf messages.
+const (
+	// Plan.
+	pbPlanVersion           protowire.Number = 1
+	pbPlanVariables         protowire.Number = 2
+	pbPlanResourceChanges   protowire.Number = 3
+	pbPlanOutputChanges     protowire.Number = 4
+	pbPlanTargetAddrs       protowire.Number = 5
+	pbPlanBackend           protowire.Number = 13
+	pbPlanTerraformVersion  protowire.Number = 14
+	pbPlanForceReplaceAddrs protowire.Number = 16
+	pbPlanUIMode            protowire.Number = 17
+	pbPlanResourceDrift     protowire.Number = 18 // Terraform 1.1 to 1.3
+	pbPlanCheckResults      protowire.Number = 19 // Terraform 1.5
+	pbPlanErrored           protowire.Number = 20 // Terraform 1.3
+	pbPlanTimestamp         protowire.Number = 21 // Terraform 1.5
+
+	// Backend.
+	pbBackendType      protowire.Number = 1
+	pbBackendConfig    protowire.Number = 2
+	pbBackendWorkspace protowire.Number = 3
+
+	// ResourceInstanceChange.
+	pbResourceModulePath      protowire.Number = 1 // up to Terraform 1.0
+	pbResourceMode            protowire.Number = 2 // up to Terraform 1.0
+	pbResourceType            protowire.Number = 3 // up to Terraform 1.0
+	pbResourceName            protowire.Number = 4 // up to Terraform 1.0
+	pbResourceStrKey          protowire.Number = 5 // up to Terraform 1.0
+	pbResourceIntKey          protowire.Number = 6 // up to Terraform 1.0
+	pbResourceDeposedKey      protowire.Number = 7
+	pbResourceProvider        protowire.Number = 8
+	pbResourceChange          protowire.Number = 9
+	pbResourcePrivate         protowire.Number = 10
+	pbResourceRequiredReplace protowire.Number = 11
+	pbResourceActionReason    protowire.Number = 12
+	pbResourceAddr            protowire.Number = 13 // Terraform 1.1
+	pbResourcePrevRunAddr     protowire.Number = 14 // Terraform 1.1
+
+	// Change.
+	pbChangeAction               protowire.Number = 1
+	pbChangeValues               protowire.Number = 2
+	pbChangeBeforeSensitivePaths protowire.Number = 3
+	pbChangeAfterSensitivePaths  protowire.Number = 4
+	pbChangeImporting            protowire.Number = 5 // Terraform 1.5
+	pbChangeGeneratedConfig      protowire.Number = 6 // Terraform 1.5
+
+	// OutputChange.
+	pbOutputName      protowire.Number = 1
+	pbOutputChange    protowire.Number = 2
+	pbOutputSensitive protowire.Number = 3
+
+	// CheckResults.
+	pbCheckKind       protowire.Number = 1
+	pbCheckConfigAddr protowire.Number = 2
+	pbCheckStatus     protowire.Number = 3
+	pbCheckObjects    protowire.Number = 4
+
+	// CheckResults.ObjectResult.
+	pbCheckObjectAddr     protowire.Number = 1
+	pbCheckObjectStatus   protowire.Number = 2
+	pbCheckObjectFailures protowire.Number = 3
+
+	// Importing, map entries, DynamicValue and Path.
+	pbImportingID         protowire.Number = 1
+	pbMapKey              protowire.Number = 1
+	pbMapValue            protowire.Number = 2
+	pbDynamicValueMsgpack protowire.Number = 1
+	pbPathSteps           protowire.Number = 1
+	pbPathStepAttrName    protowire.Number = 1
+	pbPathStepElementKey  protowire.Number = 2
+	pbResourceModeManaged                  = 0
+	pbResourceModeData                     = 1
+)
+
+// tfplanActions maps the actions of the plan format to the plans model.
+var tfplanActions = map[uint64]plans.Action{ //nolint:gochecknoglobals // read-only table
+	0: plans.NoOp,
+	1: plans.Create,
+	2: plans.Read,
+	3: plans.Update,
+	5: plans.Delete,
+	6: plans.DeleteThenCreate,
+	7: plans.CreateThenDelete,
+	8: actionForget,
+}
+
+// tfplanActionReasons are the names of the reasons of the actions of the
+// plan format, as used by the JSON plan format.
+var tfplanActionReasons = map[uint64]string{ //nolint:gochecknoglobals // read-only table
+	0:  "",
+	1:  "replace_because_tainted",
+	2:  "replace_by_request",
+	3:  "replace_because_cannot_update",
+	4:  "delete_because_no_resource_config",
+	5:  "delete_because_wrong_repetition",
+	6:  "delete_because_count_index",
+	7:  "delete_because_each_key",
+	8:  "delete_because_no_module",
+	9:  "replace_by_triggers",
+	10: "read_because_config_unknown",
+	11: "read_because_dependency_pending",
+	12: "delete_because_no_move_target",
+	13: "read_because_check_nested",
+}
+
+// tfplanCheckKinds are the names of the kinds of checked objects, as used by
+// the JSON plan format.
+var tfplanCheckKinds = map[uint64]string{ //nolint:gochecknoglobals // read-only table
+	1: "resource",
+	2: "output_value",
+	3: "check",
+	4: "var",
+}
+
+var tfplanCheckStatuses = map[uint64]CheckStatus{ //nolint:gochecknoglobals // read-only table
+	0: CheckStatusUnknown,
+	1: CheckStatusPass,
+	2: CheckStatusFail,
+	3: CheckStatusError,
+}
+
+// actionReasons maps the reasons known to the plans model.
+var actionReasons = map[string]plans.ResourceInstanceChangeActionReason{ //nolint:gochecknoglobals // read-only table
+	"replace_because_tainted":       plans.ResourceInstanceReplaceBecauseTainted,
+	"replace_by_request":            plans.ResourceInstanceReplaceByRequest,
+	"replace_because_cannot_update": plans.ResourceInstanceReplaceBecauseCannotUpdate,
+}
+
+// readTfplan decodes the 'tfplan' entry of a plan file. Plans created by
+// unsupported Terraform releases are still decoded when possible, with
+// warnings; only the plans that cannot be read at all are reported as
+// errors.
+func readTfplan(src []byte) (*plans.Plan, *PlanDetails, tfdiags.Diagnostics) {
+	var diags tfdiags.Diagnostics
+
+	fields, err := pbFields(src)
+	if err != nil {
+		return nil, nil, diags.Append(tfdiags.Sourceless(tfdiags.Error, "Invalid plan file", fmt.Sprintf("The plan data cannot be decoded: %s.", err)))
+	}
+
+	plan := &plans.Plan{
+		VariableValues: map[string]plans.DynamicValue{},
+		Changes: &plans.Changes{
+			Outputs:   []*plans.OutputChangeSrc{},
+			Resources: []*plans.ResourceInstanceChangeSrc{},
+		},
+		ProviderSHA256s: map[string][]byte{},
+	}
+
+	details := &PlanDetails{}
+
+	r := &tfplanReader{plan: plan, details: details}
+
+	for _, f := range fields {
+		if err := r.readPlanField(f); err != nil {
+			return nil, nil, diags.Append(tfdiags.Sourceless(tfdiags.Error, "Invalid plan file", fmt.Sprintf("The plan data cannot be decoded: %s.", err)))
+		}
+	}
+
+	diags = diags.Append(checkTfplanVersions(details.FormatVersion, details.TerraformVersion))
+	diags = diags.Append(r.diags)
+
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+
+	return plan, details, diags
+}
+
+// checkTfplanVersions reports the plan format and Terraform versions that
+// are not supported.
+func checkTfplanVersions(formatVersion uint64, terraformVersion string) tfdiags.Diagnostics {
+	var diags tfdiags.Diagnostics
+
+	switch {
+	case formatVersion < minTfplanFormatVersion:
+		return diags.Append(tfdiags.Sourceless(
+			tfdiags.Error,
+			"Unsupported plan format",
+			fmt.Sprintf(
+				"The plan file uses format version %d, created by Terraform %s. Only plan files of format version %d and later, created by Terraform %d.x, are supported; validate the output of 'terraform show -json' instead.",
+				formatVersion, terraformVersion, minTfplanFormatVersion, supportedTerraformMajor,
+			),
+		))
+	case formatVersion > maxTfplanFormatVersion:
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Newer plan format",
+			fmt.Sprintf(
+				"The plan file uses format version %d, created by Terraform %s, which is newer than the versions known by Horus (up to %d). The plan may be incompletely read.",
+				formatVersion, terraformVersion, maxTfplanFormatVersion,
+			),
+		))
+	}
+
+	v, err := version.NewVersion(terraformVersion)
+
+	switch {
+	case err != nil:
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Unknown Terraform version",
+			fmt.Sprintf("The plan file was created by an unknown Terraform version %q.", terraformVersion),
+		))
+	case v.Segments()[0] != supportedTerraformMajor:
+		diags = diags.Append(tfdiags.Sourceless(
+			tfdiags.Warning,
+			"Unsupported Terraform version",
+			fmt.Sprintf(
+				"The plan file was created by Terraform %s, but only the plans of Terraform %d.x are supported. The plan may be incompletely read.",
+				terraformVersion, supportedTerraformMajor,
+			),
+		))
+	}
+
+	return diags
+}
+
+type tfplanReader struct {
+	plan    *plans.Plan
+	details *PlanDetails
+	diags   tfdiags.Diagnostics
+}
+
+//nolint:cyclop,funlen // one case per field of the plan message.
+func (r *tfplanReader) readPlanField(f pbField) error {
+	switch f.num {
+	case pbPlanVersion:
+		v, err := f.uint()
+		r.details.FormatVersion = v
+
+		return err
+	case pbPlanTerraformVersion:
+		v, err := f.string()
+		r.details.TerraformVersion = v
+
+		return err
+	case pbPlanUIMode:
+		v, err := f.uint()
+		if err != nil {
+			return err
+		}
+
+		switch v {
+		case 0:
+			r.plan.UIMode = plans.NormalMode
+		case 1:
+			r.plan.UIMode = plans.DestroyMode
+		case 2: //nolint:gomnd // refresh-only mode
+			r.plan.UIMode = plans.RefreshOnlyMode
+		default:
+			return xerrors.Errorf("invalid plan mode %d", v)
+		}
+
+		return nil
+	case pbPlanVariables:
+		name, val, err := decodeTfplanVariable(f)
+		if err != nil {
+			return xerrors.Errorf("invalid value for input variable %q: %w", name, err)
+		}
+
+		r.plan.VariableValues[name] = val
+
+		return nil
+	case pbPlanResourceChanges:
+		rc, details, err := decodeTfplanResourceChange(f)
+		if err != nil {
+			return err
+		}
+
+		r.plan.Changes.Resources = append(r.plan.Changes.Resources, rc)
+		r.details.setResourceInstance(rc.Addr, rc.DeposedKey, details)
+
+		return nil
+	case pbPlanResourceDrift:
+		rc, _, err := decodeTfplanResourceChange(f)
+		if err != nil {
+			r.diags = r.diags.Append(tfdiags.Sourceless(tfdiags.Warning, "Invalid resource drift", fmt.Sprintf("A resource drift of the plan cannot be decoded and is ignored: %s.", err)))
+
+			return nil
+		}
+
+		r.details.ResourceDrift = append(r.details.ResourceDrift, rc)
+
+		return nil
+	case pbPlanOutputChanges:
+		oc, err := decodeTfplanOutputChange(f)
+		if err != nil {
+			return err
+		}
+
+		r.plan.Changes.Outputs = append(r.plan.Changes.Outputs, oc)
+
+		return nil
+	case pbPlanTargetAddrs:
+		s, err := f.string()
+		if err != nil {
+			return err
+		}
+
+		target, diags := addrs.ParseTargetStr(s)
+		if diags.HasErrors() {
+			return xerrors.Errorf("invalid target address %q: %w", s, diags.Err())
+		}
+
+		r.plan.TargetAddrs = append(r.plan.TargetAddrs, target.Subject)
+
+		return nil
+	case pbPlanForceReplaceAddrs:
+		s, err := f.string()
+		if err != nil {
+			return err
+		}
+
+		addr, diags := addrs.ParseAbsResourceInstanceStr(s)
+		if diags.HasErrors() {
+			return xerrors.Errorf("invalid force-replace address %q: %w", s, diags.Err())
+		}
+
+		r.plan.ForceReplaceAddrs = append(r.plan.ForceReplaceAddrs, addr)
+
+		return nil
+	case pbPlanBackend:
+		backend, err := decodeTfplanBackend(f)
+		r.plan.Backend = backend
+
+		return err
+	case pbPlanCheckResults:
+		check, err := decodeTfplanCheckResults(f)
+		if err != nil {
+			r.diags = r.diags.Append(tfdiags.Sourceless(tfdiags.Warning, "Invalid check results", fmt.Sprintf("A check result of the plan cannot be decoded and is ignored: %s.", err)))
+
+			return nil
+		}
+
+		r.details.Checks = append(r.details.Checks, check)
+
+		return nil
+	case pbPlanErrored:
+		v, err := f.uint()
+		r.details.Errored = v != 0
+
+		return err
+	case pbPlanTimestamp:
+		v, err := f.string()
+		r.details.Timestamp = v
+
+		return err
+	default:
+		// The provider hashes of Terraform 1.0 share their field number
+		// with the relevant attributes of later releases; neither is used.
+		return nil
+	}
+}
+
+func decodeTfplanVariable(f pbField) (string, plans.DynamicValue, error) {
+	fields, err := f.message()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var (
+		name string
+		val  plans.DynamicValue
+	)
+
+	for _, f := range fields {
+		switch f.num {
+		case pbMapKey:
+			name, err = f.string()
+		case pbMapValue:
+			val, err = decodeTfplanValue(f)
+		}
+
+		if err != nil {
+			return name, nil, err
+		}
+	}
+
+	if val == nil {
+		return name, nil, xerrors.New("missing value")
+	}
+
+	return name, val, nil
+}
+
+func decodeTfplanBackend(f pbField) (plans.Backend, error) {
+	var ret plans.Backend
+
+	fields, err := f.message()
+	if err != nil {
+		return ret, err
+	}
+
+	for _, f := range fields {
+		switch f.num {
+		case pbBackendType:
+			ret.Type, err = f.string()
+		case pbBackendConfig:
+			ret.Config, err = decodeTfplanValue(f)
+		case pbBackendWorkspace:
+			ret.Workspace, err = f.string()
+		}
+
+		if err != nil {
+			return ret, xerrors.Errorf("invalid backend configuration: %w", err)
+		}
+	}
+
+	return ret, nil
+}
+
+// tfplanResourceChange holds the fields of a resource instance change.
+type tfplanResourceChange struct {
+	addr, prevRunAddr string
+	modulePath        string
+	mode              uint64
+	typeName, name    string
+	key               addrs.InstanceKey
+	deposedKey        string
+	provider          string
+	change            *pbField
+	private           []byte
+	requiredReplace   []cty.Path
+	actionReason      uint64
+}
+
+//nolint:cyclop // one case per field of the message.
+func decodeTfplanResourceChange(f pbField) (*plans.ResourceInstanceChangeSrc, *ResourceInstanceDetails, error) {
+	fields, err := f.message()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var raw tfplanResourceChange
+
+	for i := range fields {
+		f := fields[i]
+
+		switch f.num {
+		case pbResourceAddr:
+			raw.addr, err = f.string()
+		case pbResourcePrevRunAddr:
+			raw.prevRunAddr, err = f.string()
+		case pbResourceModulePath:
+			raw.modulePath, err = f.string()
+		case pbResourceMode:
+			raw.mode, err = f.uint()
+		case pbResourceType:
+			raw.typeName, err = f.string()
+		case pbResourceName:
+			raw.name, err = f.string()
+		case pbResourceStrKey:
+			var s string
+			s, err = f.string()
+			raw.key = addrs.StringKey(s)
+		case pbResourceIntKey:
+			var v uint64
+			v, err = f.uint()
+			raw.key = addrs.IntKey(int64(v))
+		case pbResourceDeposedKey:
+			raw.deposedKey, err = f.string()
+		case pbResourceProvider:
+			raw.provider, err = f.string()
+		case pbResourceChange:
+			raw.change = &fields[i]
+		case pbResourcePrivate:
+			raw.private, err = f.bytes()
+		case pbResourceRequiredReplace:
+			var p cty.Path
+			p, err = decodeTfplanPath(f)
+			raw.requiredReplace = append(raw.requiredReplace, p)
+		case pbResourceActionReason:
+			raw.actionReason, err = f.uint()
+		}
+
+		if err != nil {
+			return nil, nil, xerrors.Errorf("invalid resource change: %w", err)
+		}
+	}
+
+	return raw.resourceChange()
+}
+
+func (raw *tfplanResourceChange) resourceChange() (*plans.ResourceInstanceChangeSrc, *ResourceInstanceDetails, error) {
+	addr, err := raw.address()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ret := &plans.ResourceInstanceChangeSrc{
+		Addr:            addr,
+		RequiredReplace: cty.NewPathSet(raw.requiredReplace...),
+	}
+
+	details := &ResourceInstanceDetails{
+		PrevRunAddr: addr,
+	}
+
+	if raw.prevRunAddr != "" {
+		prev, diags := addrs.ParseAbsResourceInstanceStr(raw.prevRunAddr)
+		if diags.HasErrors() {
+			return nil, nil, xerrors.Errorf("invalid previous address %q of %s: %w", raw.prevRunAddr, addr, diags.Err())
+		}
+
+		details.PrevRunAddr = prev
+	}
+
+	providerAddr, diags := addrs.ParseAbsProviderConfigStr(raw.provider)
+	if diags.HasErrors() {
+		return nil, nil, xerrors.Errorf("invalid provider of %s: %w", addr, diags.Err())
+	}
+
+	ret.ProviderAddr = providerAddr
+
+	if raw.deposedKey != "" {
+		if len(raw.deposedKey) != len(states.NewDeposedKey()) {
+			return nil, nil, xerrors.Errorf("deposed object for %s has invalid deposed key %q", addr, raw.deposedKey)
+		}
+
+		ret.DeposedKey = states.DeposedKey(raw.deposedKey)
+	}
+
+	if raw.change == nil {
+		return nil, nil, xerrors.Errorf("invalid plan for resource %s: change object is absent", addr)
+	}
+
+	change, err := decodeTfplanChange(*raw.change, details)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("invalid plan for resource %s: %w", addr, err)
+	}
+
+	ret.ChangeSrc = *change
+
+	reason, ok := tfplanActionReasons[raw.actionReason]
+	if !ok {
+		return nil, nil, xerrors.Errorf("resource %s has unknown action reason %d", addr, raw.actionReason)
+	}
+
+	details.ActionReason = reason
+	ret.ActionReason = actionReasons[reason]
+
+	if len(raw.private) != 0 {
+		ret.Private = raw.private
+	}
+
+	return ret, details, nil
+}
+
+// address returns the address of the resource instance, stored as a string
+// since Terraform 1.1 and split across several fields before.
+func (raw *tfplanResourceChange) address() (addrs.AbsResourceInstance, error) {
+	if raw.addr != "" {
+		addr, diags := addrs.ParseAbsResourceInstanceStr(raw.addr)
+		if diags.HasErrors() {
+			return addrs.AbsResourceInstance{}, xerrors.Errorf("invalid resource address %q: %w", raw.addr, diags.Err())
+		}
+
+		return addr, nil
+	}
+
+	moduleAddr := addrs.RootModuleInstance
+
+	if raw.modulePath != "" {
+		var diags tfdiags.Diagnostics
+
+		moduleAddr, diags = addrs.ParseModuleInstanceStr(raw.modulePath)
+		if diags.HasErrors() {
+			return addrs.AbsResourceInstance{}, xerrors.Errorf("invalid module path %q: %w", raw.modulePath, diags.Err())
+		}
+	}
+
+	var mode addrs.ResourceMode
+
+	switch raw.mode {
+	case pbResourceModeManaged:
+		mode = addrs.ManagedResourceMode
+	case pbResourceModeData:
+		mode = addrs.DataResourceMode
+	default:
+		return addrs.AbsResourceInstance{}, xerrors.Errorf("resource has invalid mode %d", raw.mode)
+	}
+
+	if raw.typeName == "" || raw.name == "" {
+		return addrs.AbsResourceInstance{}, xerrors.New("resource change has no address")
+	}
+
+	return addrs.Resource{
+		Mode: mode,
+		Type: raw.typeName,
+		Name: raw.name,
+	}.Instance(raw.key).Absolute(moduleAddr), nil
+}
+
+//nolint:cyclop // one case per field of the message.
+func decodeTfplanChange(f pbField, details *ResourceInstanceDetails) (*plans.ChangeSrc, error) {
+	fields, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		rawAction                       uint64
+		values                          []plans.DynamicValue
+		beforeSensitive, afterSensitive []cty.Path
+	)
+
+	for _, f := range fields {
+		var p cty.Path
+
+		switch f.num {
+		case pbChangeAction:
+			rawAction, err = f.uint()
+		case pbChangeValues:
+			var v plans.DynamicValue
+			v, err = decodeTfplanValue(f)
+			values = append(values, v)
+		case pbChangeBeforeSensitivePaths:
+			p, err = decodeTfplanPath(f)
+			beforeSensitive = append(beforeSensitive, p)
+		case pbChangeAfterSensitivePaths:
+			p, err = decodeTfplanPath(f)
+			afterSensitive = append(afterSensitive, p)
+		case pbChangeImporting:
+			if details != nil {
+				details.Importing, err = decodeTfplanImporting(f)
+			}
+		case pbChangeGeneratedConfig:
+			if details != nil {
+				details.GeneratedConfig, err = f.string()
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	action, ok := tfplanActions[rawAction]
+	if !ok {
+		return nil, xerrors.Errorf("invalid change action %d", rawAction)
+	}
+
+	ret := &plans.ChangeSrc{
+		Action:         action,
+		BeforeValMarks: sensitivePaths(beforeSensitive),
+		AfterValMarks:  sensitivePaths(afterSensitive),
+	}
+
+	// The values of the change depend on its action.
+	beforeIdx, afterIdx := -1, -1
+
+	switch action {
+	case plans.NoOp:
+		beforeIdx, afterIdx = 0, 0
+	case plans.Create:
+		afterIdx = 0
+	case plans.Delete, actionForget:
+		beforeIdx = 0
+	default:
+		beforeIdx, afterIdx = 0, 1
+	}
+
+	if beforeIdx >= 0 {
+		if len(values) <= beforeIdx {
+			return nil, xerrors.Errorf("incorrect number of values (%d) for %s change", len(values), actionName(action))
+		}
+
+		ret.Before = values[beforeIdx]
+	}
+
+	if afterIdx >= 0 {
+		if len(values) <= afterIdx {
+			return nil, xerrors.Errorf("incorrect number of values (%d) for %s change", len(values), actionName(action))
+		}
+
+		ret.After = values[afterIdx]
+	}
+
+	return ret, nil
+}
+
+func decodeTfplanImporting(f pbField) (*Importing, error) {
+	fields, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Importing{}
+
+	for _, f := range fields {
+		if f.num == pbImportingID {
+			if ret.ID, err = f.string(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func decodeTfplanOutputChange(f pbField) (*plans.OutputChangeSrc, error) {
+	fields, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		name      string
+		change    *plans.ChangeSrc
+		sensitive uint64
+	)
+
+	for _, f := range fields {
+		switch f.num {
+		case pbOutputName:
+			name, err = f.string()
+		case pbOutputChange:
+			change, err = decodeTfplanChange(f, nil)
+		case pbOutputSensitive:
+			sensitive, err = f.uint()
+		}
+
+		if err != nil {
+			return nil, xerrors.Errorf("invalid plan for output %q: %w", name, err)
+		}
+	}
+
+	if change == nil {
+		return nil, xerrors.Errorf("invalid plan for output %q: change object is absent", name)
+	}
+
+	return &plans.OutputChangeSrc{
+		// All output values saved in the plan file are root module outputs.
+		Addr:      addrs.OutputValue{Name: name}.Absolute(addrs.RootModuleInstance),
+		ChangeSrc: *change,
+		Sensitive: sensitive != 0,
+	}, nil
+}
+
+func decodeTfplanCheckResults(f pbField) (CheckResult, error) {
+	var ret CheckResult
+
+	fields, err := f.message()
+	if err != nil {
+		return ret, err
+	}
+
+	for _, f := range fields {
+		var v uint64
+
+		switch f.num {
+		case pbCheckKind:
+			v, err = f.uint()
+			ret.Kind = tfplanCheckKinds[v]
+		case pbCheckConfigAddr:
+			ret.ConfigAddr, err = f.string()
+		case pbCheckStatus:
+			v, err = f.uint()
+			ret.Status = tfplanCheckStatuses[v]
+		case pbCheckObjects:
+			var obj CheckObjectResult
+			obj, err = decodeTfplanCheckObject(f)
+			ret.Objects = append(ret.Objects, obj)
+		}
+
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	if ret.Kind == "" || ret.Status == "" {
+		return ret, xerrors.Errorf("invalid check result of %q", ret.ConfigAddr)
+	}
+
+	return ret, nil
+}
+
+func decodeTfplanCheckObject(f pbField) (CheckObjectResult, error) {
+	var ret CheckObjectResult
+
+	fields, err := f.message()
+	if err != nil {
+		return ret, err
+	}
+
+	for _, f := range fields {
+		switch f.num {
+		case pbCheckObjectAddr:
+			ret.Address, err = f.string()
+		case pbCheckObjectStatus:
+			var v uint64
+			v, err = f.uint()
+			ret.Status = tfplanCheckStatuses[v]
+		case pbCheckObjectFailures:
+			var msg string
+			msg, err = f.string()
+			ret.FailureMessages = append(ret.FailureMessages, msg)
+		}
+
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	if ret.Status == "" {
+		return ret, xerrors.Errorf("invalid check status of %q", ret.Address)
+	}
+
+	return ret, nil
+}
+
+func decodeTfplanValue(f pbField) (plans.DynamicValue, error) {
+	fields, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		if f.num == pbDynamicValueMsgpack {
+			b, err := f.bytes()
+			if err != nil {
+				return nil, err
+			}
+
+			if len(b) > 0 {
+				return plans.DynamicValue(b), nil
+			}
+		}
+	}
+
+	return nil, xerrors.New("dynamic value does not have msgpack serialization")
+}
+
+func decodeTfplanPath(f pbField) (cty.Path, error) {
+	fields, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := cty.Path{}
+
+	for _, f := range fields {
+		if f.num != pbPathSteps {
+			continue
+		}
+
+		step, err := decodeTfplanPathStep(f)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, step)
+	}
+
+	return ret, nil
+}
+
+func decodeTfplanPathStep(f pbField) (cty.PathStep, error) {
+	fields, err := f.message()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		switch f.num {
+		case pbPathStepAttrName:
+			name, err := f.string()
+			if err != nil {
+				return nil, err
+			}
+
+			return cty.GetAttrStep{Name: name}, nil
+		case pbPathStepElementKey:
+			raw, err := decodeTfplanValue(f)
+			if err != nil {
+				return nil, xerrors.Errorf("error decoding path index step: %w", err)
+			}
+
+			ty, err := raw.ImpliedType()
+			if err != nil {
+				return nil, xerrors.Errorf("error determining path index type: %w", err)
+			}
+
+			key, err := raw.Decode(ty)
+			if err != nil {
+				return nil, xerrors.Errorf("error decoding path index value: %w", err)
+			}
+
+			return cty.IndexStep{Key: key}, nil
+		}
+	}
+
+	return nil, xerrors.New("unsupported path step")
+}
+
+func sensitivePaths(paths []cty.Path) []cty.PathValueMarks {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	ret := make([]cty.PathValueMarks, 0, len(paths))
+	for _, p := range paths {
+		ret = append(ret, cty.PathValueMarks{
+			Path:  p,
+			Marks: cty.NewValueMarks(sensitiveMark),
+		})
+	}
+
+	return ret
+}
+
+// pbField is a field of a protobuf message whose schema is unknown. Only the
+// varint and length-delimited wire types are used by the plan format.
+type pbField struct {
+	num    protowire.Number
+	typ    protowire.Type
+	varint uint64
+	raw    []byte
+}
+
+// pbFields splits a protobuf message into its fields.
+func pbFields(b []byte) ([]pbField, error) {
+	var fields []pbField
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+
+		b = b[n:]
+		f := pbField{num: num, typ: typ}
+
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			f.raw, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+func (f pbField) uint() (uint64, error) {
+	if f.typ != protowire.VarintType {
+		return 0, xerrors.Errorf("field %d is not a varint", f.num)
+	}
+
+	return f.varint, nil
+}
+
+func (f pbField) bytes() ([]byte, error) {
+	if f.typ != protowire.BytesType {
+		return nil, xerrors.Errorf("field %d is not length-delimited", f.num)
+	}
+
+	return f.raw, nil
+}
+
+func (f pbField) string() (string, error) {
+	b, err := f.bytes()
+
+	return string(b), err
+}
+
+func (f pbField) message() ([]pbField, error) {
+	b, err := f.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return pbFields(b)
+}
@@ -0,0 +1,416 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/terraform/states"
+)
+
+// Builders of tfplan protobuf messages.
+
+func pbTestVarint(num protowire.Number, v uint64) []byte {
+	b := protowire.AppendTag(nil, num, protowire.VarintType)
+
+	return protowire.AppendVarint(b, v)
+}
+
+func pbTestBytes(num protowire.Number, v []byte) []byte {
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+
+	return protowire.AppendBytes(b, v)
+}
+
+func pbTestString(num protowire.Number, v string) []byte {
+	return pbTestBytes(num, []byte(v))
+}
+
+func pbTestMessage(num protowire.Number, fields ...[]byte) []byte {
+	return pbTestBytes(num, bytes.Join(fields, nil))
+}
+
+func pbTestValue(t *testing.T, num protowire.Number, val cty.Value) []byte {
+	t.Helper()
+
+	dv, err := plans.NewDynamicValue(val, val.Type())
+	require.NoError(t, err)
+
+	return pbTestMessage(num, pbTestBytes(pbDynamicValueMsgpack, dv))
+}
+
+func testTfplan(formatVersion uint64, terraformVersion string, fields ...[]byte) []byte {
+	return bytes.Join(append([][]byte{
+		pbTestVarint(pbPlanVersion, formatVersion),
+		pbTestString(pbPlanTerraformVersion, terraformVersion),
+	}, fields...), nil)
+}
+
+const testProvider = `provider["registry.terraform.io/hashicorp/null"]`
+
+// testTfplanResourceChange is a change of Terraform 1.5 creating the
+// null_resource.new resource, moved from null_resource.old and imported.
+func testTfplanResourceChange(t *testing.T) []byte {
+	t.Helper()
+
+	return pbTestMessage(pbPlanResourceChanges,
+		pbTestString(pbResourceAddr, "null_resource.new"),
+		pbTestString(pbResourcePrevRunAddr, "null_resource.old"),
+		pbTestString(pbResourceProvider, testProvider),
+		pbTestMessage(pbResourceChange,
+			pbTestVarint(pbChangeAction, 1),
+			pbTestValue(t, pbChangeValues, cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal("abc")})),
+			pbTestMessage(pbChangeImporting, pbTestString(pbImportingID, "abc")),
+			pbTestString(pbChangeGeneratedConfig, `resource "null_resource" "new" {}`),
+		),
+		pbTestVarint(pbResourceActionReason, 9),
+	)
+}
+
+// testTfplanLegacyResourceChange is a change of Terraform 1.0 deleting the
+// module.mod.null_resource.foo["a"] resource.
+func testTfplanLegacyResourceChange(t *testing.T) []byte {
+	t.Helper()
+
+	return pbTestMessage(pbPlanResourceChanges,
+		pbTestString(pbResourceModulePath, "module.mod"),
+		pbTestVarint(pbResourceMode, pbResourceModeManaged),
+		pbTestString(pbResourceType, "null_resource"),
+		pbTestString(pbResourceName, "foo"),
+		pbTestString(pbResourceStrKey, "a"),
+		pbTestString(pbResourceProvider, `module.mod.`+testProvider),
+		pbTestMessage(pbResourceChange,
+			pbTestVarint(pbChangeAction, 5),
+			pbTestValue(t, pbChangeValues, cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal("foo")})),
+		),
+	)
+}
+
+func testTfplanCheckResults() []byte {
+	return pbTestMessage(pbPlanCheckResults,
+		pbTestVarint(pbCheckKind, 1),
+		pbTestString(pbCheckConfigAddr, "null_resource.new"),
+		pbTestVarint(pbCheckStatus, 2),
+		pbTestMessage(pbCheckObjects,
+			pbTestString(pbCheckObjectAddr, "null_resource.new"),
+			pbTestVarint(pbCheckObjectStatus, 2),
+			pbTestString(pbCheckObjectFailures, "the id must be known"),
+		),
+	)
+}
+
+func TestReadTfplan(t *testing.T) {
+	tests := []struct {
+		name         string
+		src          func(t *testing.T) []byte
+		wantErr      string
+		wantWarnings []string
+		check        func(t *testing.T, plan *plans.Plan, details *PlanDetails)
+	}{
+		{
+			name: "terraform 1.0",
+			src: func(t *testing.T) []byte {
+				return testTfplan(3, "1.0.3", testTfplanLegacyResourceChange(t))
+			},
+			check: func(t *testing.T, plan *plans.Plan, details *PlanDetails) {
+				require.Len(t, plan.Changes.Resources, 1)
+
+				rc := plan.Changes.Resources[0]
+				assert.Equal(t, `module.mod.null_resource.foo["a"]`, rc.Addr.String())
+				assert.Equal(t, plans.Delete, rc.Action)
+				assert.NotNil(t, rc.Before)
+				assert.Nil(t, rc.After)
+
+				rd := details.ResourceInstance(rc.Addr, states.NotDeposed)
+				require.NotNil(t, rd)
+				assert.Equal(t, rc.Addr, rd.PrevRunAddr)
+				assert.Nil(t, rd.Importing)
+			},
+		},
+		{
+			name: "terraform 1.5",
+			src: func(t *testing.T) []byte {
+				return testTfplan(3, "1.5.7",
+					testTfplanResourceChange(t),
+					testTfplanCheckResults(),
+					pbTestVarint(pbPlanErrored, 1),
+					pbTestString(pbPlanTimestamp, "2023-09-01T10:00:00Z"),
+					pbTestVarint(pbPlanUIMode, 2),
+				)
+			},
+			check: func(t *testing.T, plan *plans.Plan, details *PlanDetails) {
+				assert.Equal(t, plans.RefreshOnlyMode, plan.UIMode)
+				assert.True(t, details.Errored)
+				assert.Equal(t, "2023-09-01T10:00:00Z", details.Timestamp)
+				assert.Equal(t, "1.5.7", details.TerraformVersion)
+
+				require.Len(t, plan.Changes.Resources, 1)
+
+				rc := plan.Changes.Resources[0]
+				assert.Equal(t, "null_resource.new", rc.Addr.String())
+				assert.Equal(t, plans.Create, rc.Action)
+				assert.Equal(t, plans.ResourceInstanceChangeNoReason, rc.ActionReason)
+
+				rd := details.ResourceInstance(rc.Addr, states.NotDeposed)
+				require.NotNil(t, rd)
+				assert.Equal(t, "null_resource.old", rd.PrevRunAddr.String())
+				assert.Equal(t, &Importing{ID: "abc"}, rd.Importing)
+				assert.Equal(t, `resource "null_resource" "new" {}`, rd.GeneratedConfig)
+				assert.Equal(t, "replace_by_triggers", rd.ActionReason)
+
+				assert.Equal(t, []CheckResult{{
+					Kind:       "resource",
+					ConfigAddr: "null_resource.new",
+					Status:     CheckStatusFail,
+					Objects: []CheckObjectResult{{
+						Address:         "null_resource.new",
+						Status:          CheckStatusFail,
+						FailureMessages: []string{"the id must be known"},
+					}},
+				}}, details.Checks)
+			},
+		},
+		{
+			name: "forget action",
+			src: func(t *testing.T) []byte {
+				return testTfplan(3, "1.7.0", pbTestMessage(pbPlanResourceChanges,
+					pbTestString(pbResourceAddr, "null_resource.foo"),
+					pbTestString(pbResourceProvider, testProvider),
+					pbTestMessage(pbResourceChange,
+						pbTestVarint(pbChangeAction, 8),
+						pbTestValue(t, pbChangeValues, cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal("foo")})),
+					),
+				))
+			},
+			check: func(t *testing.T, plan *plans.Plan, details *PlanDetails) {
+				require.Len(t, plan.Changes.Resources, 1)
+				assert.Equal(t, "forget", actionName(plan.Changes.Resources[0].Action))
+			},
+		},
+		{
+			name: "invalid check results",
+			src: func(t *testing.T) []byte {
+				return testTfplan(3, "1.2.0", pbTestMessage(pbPlanCheckResults, pbTestString(pbCheckKind, "resource")))
+			},
+			wantWarnings: []string{"Invalid check results"},
+			check: func(t *testing.T, plan *plans.Plan, details *PlanDetails) {
+				assert.Empty(t, details.Checks)
+			},
+		},
+		{
+			name: "newer format version",
+			src: func(t *testing.T) []byte {
+				return testTfplan(4, "1.9.0", pbTestString(99, "unknown field"))
+			},
+			wantWarnings: []string{"Newer plan format"},
+		},
+		{
+			name: "terraform 0.15",
+			src: func(t *testing.T) []byte {
+				return testTfplan(3, "0.15.5")
+			},
+			wantWarnings: []string{"Unsupported Terraform version"},
+		},
+		{
+			name: "terraform 2.0",
+			src: func(t *testing.T) []byte {
+				return testTfplan(3, "2.0.0")
+			},
+			wantWarnings: []string{"Unsupported Terraform version"},
+		},
+		{
+			name: "invalid terraform version",
+			src: func(t *testing.T) []byte {
+				return testTfplan(3, "dev")
+			},
+			wantWarnings: []string{"Unknown Terraform version"},
+		},
+		{
+			name: "older format version",
+			src: func(t *testing.T) []byte {
+				return testTfplan(2, "0.12.0")
+			},
+			wantErr: "Unsupported plan format",
+		},
+		{
+			name: "invalid resource change",
+			src: func(t *testing.T) []byte {
+				return testTfplan(3, "1.1.0", pbTestMessage(pbPlanResourceChanges,
+					pbTestString(pbResourceAddr, "null_resource.foo"),
+					pbTestString(pbResourceProvider, testProvider),
+				))
+			},
+			wantErr: "change object is absent",
+		},
+		{
+			name: "truncated message",
+			src: func(t *testing.T) []byte {
+				src := testTfplan(3, "1.1.0")
+
+				return src[:len(src)-1]
+			},
+			wantErr: "Invalid plan file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, details, diags := readTfplan(tt.src(t))
+
+			if tt.wantErr != "" {
+				require.True(t, diags.HasErrors())
+				assert.Contains(t, diags.Err().Error(), tt.wantErr)
+
+				return
+			}
+
+			require.False(t, diags.HasErrors(), diags.Err())
+
+			var warnings []string
+			for _, diag := range diags {
+				warnings = append(warnings, diag.Description().Summary)
+			}
+
+			assert.Equal(t, tt.wantWarnings, warnings)
+
+			if tt.check != nil {
+				tt.check(t, plan, details)
+			}
+		})
+	}
+}
+
+// rewriteTestPlanFile copies the test plan file, replacing or removing some
+// of its entries, and returns the copy.
+func rewriteTestPlanFile(t *testing.T, entries map[string][]byte) afero.File {
+	t.Helper()
+
+	src, err := zip.OpenReader(getTestDataPath(t, "tf-planfile"))
+	require.NoError(t, err)
+
+	defer src.Close()
+
+	var buf bytes.Buffer
+
+	w := zip.NewWriter(&buf)
+
+	for _, f := range src.File {
+		content, ok := entries[f.Name]
+		if !ok {
+			r, err := f.Open()
+			require.NoError(t, err)
+
+			content, err = io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+		}
+
+		if content == nil {
+			continue
+		}
+
+		dst, err := w.Create(f.Name)
+		require.NoError(t, err)
+
+		_, err = dst.Write(content)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, w.Close())
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "planfile", buf.Bytes(), 0o600))
+
+	file, err := fs.Open("planfile")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { file.Close() })
+
+	return file
+}
+
+func TestLoadPlanFile_Tfplan(t *testing.T) {
+	t.Run("terraform 1.5", func(t *testing.T) {
+		file := rewriteTestPlanFile(t, map[string][]byte{
+			tfplanFilename: testTfplan(3, "1.5.7", testTfplanResourceChange(t), testTfplanCheckResults()),
+		})
+
+		planFile, err := LoadPlanFile(file)
+		require.NoError(t, err)
+
+		assert.Empty(t, planFile.Diagnostics)
+		assert.NotNil(t, planFile.Config)
+		assert.Len(t, planFile.Details.Checks, 1)
+		assert.Same(t, planFile.State.State, planFile.Plan.PriorState)
+
+		plan := &Plan{Plan: planFile.Plan, Details: planFile.Details}
+		resources, err := plan.FindResources(ResourceFilter{})
+		require.NoError(t, err)
+		require.Len(t, resources, 1)
+
+		rc := resources[0]
+		assert.Equal(t, "null_resource.old", rc.PrevRunAddress())
+		assert.Equal(t, &Importing{ID: "abc"}, rc.Importing())
+		assert.Equal(t, "replace_by_triggers", rc.ActionReason())
+	})
+
+	t.Run("without previous run state", func(t *testing.T) {
+		file := rewriteTestPlanFile(t, map[string][]byte{
+			"tfstate-prev": nil,
+		})
+
+		planFile, err := LoadPlanFile(file)
+		require.NoError(t, err)
+
+		assert.Same(t, planFile.State, planFile.PrevState)
+	})
+
+	t.Run("unsupported configuration", func(t *testing.T) {
+		file := rewriteTestPlanFile(t, map[string][]byte{
+			"tfconfig/m-/root.tf": []byte(`resource "null_resource" "foo" { unknown_block_syntax {`),
+		})
+
+		planFile, err := LoadPlanFile(file)
+		require.NoError(t, err)
+
+		assert.Nil(t, planFile.Config)
+		require.Len(t, planFile.Diagnostics, 1)
+		assert.Equal(t, "Configuration not loaded", planFile.Diagnostics[0].Description().Summary)
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		file := rewriteTestPlanFile(t, map[string][]byte{
+			tfplanFilename: testTfplan(2, "0.12.0"),
+		})
+
+		_, err := LoadPlanFile(file)
+		assert.Error(t, err)
+	})
+}
+
+func TestResourceChange_ActionReason(t *testing.T) {
+	rc := NewResourceChange(&plans.ResourceInstanceChangeSrc{ActionReason: plans.ResourceInstanceReplaceBecauseTainted})
+	assert.Equal(t, "replace_because_tainted", rc.ActionReason())
+	assert.Equal(t, "", NewResourceChange(&plans.ResourceInstanceChangeSrc{}).ActionReason())
+}
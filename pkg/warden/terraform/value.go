@@ -0,0 +1,163 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package terraform
+
+import (
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zclconf/go-cty/cty"
+
+	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+)
+
+// unmarked returns a copy of v without any mark.
+func unmarked(v cty.Value) cty.Value {
+	v, _ = v.UnmarkDeep()
+
+	return v
+}
+
+// LValue converts a cty value to its Lua equivalent, in the same way as the
+// values of resource changes.
+func LValue(ls *lua.LState, v cty.Value) lua.LValue {
+	return luaValue(ls, v)
+}
+
+// luaValue converts a cty value to its Lua equivalent with the value
+// bridge: null values are converted to nil, the values that will only be
+// known after apply to the unknown sentinel, and the values marked as
+// sensitive are wrapped in sensitive userdata.
+func luaValue(ls *lua.LState, v cty.Value) lua.LValue {
+	return valueBridge().ToLua(ls, v)
+}
+
+// valueBridge returns the bridge converting the values between cty and Lua,
+// which wraps the sensitive values in sensitive userdata.
+func valueBridge() *wlua.Bridge {
+	return &wlua.Bridge{
+		Marked:   luaMarkedValue,
+		UserData: ctyUserDataValue,
+	}
+}
+
+// luaMarkedValue wraps the values marked as sensitive in sensitive userdata,
+// and drops the other marks.
+func luaMarkedValue(ls *lua.LState, v cty.Value) lua.LValue {
+	if v.HasMark(sensitiveMark) {
+		return LSensitiveValue(ls, v)
+	}
+
+	v, _ = v.Unmark()
+
+	return luaValue(ls, v)
+}
+
+// ctyUserDataValue unwraps the sensitive userdata, and marks their value as
+// sensitive.
+func ctyUserDataValue(ud *lua.LUserData) (cty.Value, bool) {
+	if s, ok := ud.Value.(*SensitiveValue); ok {
+		return s.Value().Mark(sensitiveMark), true
+	}
+
+	return cty.NilVal, false
+}
+
+// luaUnknownView returns a Lua value mirroring the structure of v where
+// every value that will only be known after apply is replaced by true.
+//
+// Like in the JSON output of 'terraform show', known values are represented
+// by false in sequences and omitted from objects and maps.
+func luaUnknownView(ls *lua.LState, v cty.Value) lua.LValue {
+	return luaFlagView(ls, unmarked(v), func(v cty.Value) bool {
+		return !v.IsKnown()
+	})
+}
+
+// luaSensitiveView returns a Lua value mirroring the structure of v where
+// every value marked as sensitive is replaced by true.
+//
+// Like in the JSON output of 'terraform show', non-sensitive values are
+// represented by false in sequences and omitted from objects and maps.
+func luaSensitiveView(ls *lua.LState, v cty.Value) lua.LValue {
+	return luaFlagView(ls, v, func(v cty.Value) bool {
+		return v.HasMark(sensitiveMark)
+	})
+}
+
+const sensitiveMark = "sensitive"
+
+func luaFlagView(ls *lua.LState, v cty.Value, flagged func(cty.Value) bool) lua.LValue {
+	if v == cty.NilVal {
+		return lua.LFalse
+	}
+
+	if flagged(v) {
+		return lua.LTrue
+	}
+
+	v, _ = v.Unmark()
+	if v.IsNull() || !v.IsKnown() {
+		return lua.LFalse
+	}
+
+	ty := v.Type()
+
+	switch {
+	case ty.IsListType() || ty.IsSetType() || ty.IsTupleType():
+		tbl := ls.CreateTable(v.LengthInt(), 0)
+		i := 1
+
+		for it := v.ElementIterator(); it.Next(); i++ {
+			_, ev := it.Element()
+			tbl.RawSetInt(i, luaFlagView(ls, ev, flagged))
+		}
+
+		return tbl
+
+	case ty.IsMapType() || ty.IsObjectType():
+		tbl := ls.NewTable()
+		for it := v.ElementIterator(); it.Next(); {
+			k, ev := it.Element()
+			if fv := luaFlagView(ls, ev, flagged); fv != lua.LFalse {
+				tbl.RawSetString(k.AsString(), fv)
+			}
+		}
+
+		return tbl
+
+	default:
+		return lua.LFalse
+	}
+}
+
+// ctyValue converts a Lua value to its cty equivalent with the value
+// bridge: nil is converted to a null value, sequences to tuples, the other
+// tables to objects, and the unknown sentinel to an unknown value. Sensitive
+// values are unwrapped and marked as sensitive. An empty table is converted
+// to an empty object.
+func ctyValue(lv lua.LValue) (cty.Value, error) {
+	return valueBridge().ToCty(lv) //nolint:wrapcheck // this error actually comes from one of our own packages.
+}
+
+// IsLKnown reports whether a Lua value is wholly known, i.e. it is not the
+// unknown sentinel and does not contain it, including in sensitive values.
+func IsLKnown(lv lua.LValue) (bool, error) {
+	return valueBridge().IsKnown(lv) //nolint:wrapcheck // this error actually comes from one of our own packages.
+}
+
+// IsLNull reports whether a Lua value is null, including in sensitive
+// values. The unknown sentinel is not null.
+func IsLNull(lv lua.LValue) (bool, error) {
+	return valueBridge().IsNull(lv) //nolint:wrapcheck // this error actually comes from one of our own packages.
+}
@@ -0,0 +1,234 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// WaiverDateLayout is the layout of the expiry dates of the waivers.
+const WaiverDateLayout = "2006-01-02"
+
+// Waiver is a temporary exception to a rule, for the resources whose address
+// matches a pattern. The findings it waives are reported separately, and no
+// longer make the validation fail.
+type Waiver struct {
+	// RuleID is the ID of the rule of the waived findings.
+	RuleID string
+	// Address is the pattern of the addresses of the waived findings, where
+	// '*' matches any sequence of characters, e.g. 'module.legacy.*'.
+	Address string
+	// Justification explains why the exception is needed.
+	Justification string
+	// Owner is the person or team responsible for the exception.
+	Owner string
+	// Expires is the last day the waiver applies, in UTC.
+	Expires time.Time
+}
+
+// Expired reports whether the waiver no longer applies at t.
+func (w *Waiver) Expired(t time.Time) bool {
+	y, m, d := w.Expires.Date()
+
+	return !t.Before(time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC))
+}
+
+func (w *Waiver) String() string {
+	return fmt.Sprintf("waiver of rule '%s' for '%s' (owner: %s, expires: %s)",
+		w.RuleID, w.Address, w.Owner, w.Expires.Format(WaiverDateLayout))
+}
+
+// validate checks that all the fields of the waiver are set.
+func (w *Waiver) validate() error {
+	fields := []struct {
+		name  string
+		empty bool
+	}{
+		{"rule", w.RuleID == ""},
+		{"address", w.Address == ""},
+		{"justification", w.Justification == ""},
+		{"owner", w.Owner == ""},
+		{"expires", w.Expires.IsZero()},
+	}
+
+	for _, f := range fields {
+		if f.empty {
+			return xerrors.Errorf("missing %s", f.name)
+		}
+	}
+
+	return nil
+}
+
+// WaivedFinding is a finding waived by a waiver.
+type WaivedFinding struct {
+	Finding
+	Waiver Waiver
+}
+
+type waiverFile struct {
+	Waivers []waiverEntry `yaml:"waivers"`
+}
+
+type waiverEntry struct {
+	Rule          string `yaml:"rule"`
+	Address       string `yaml:"address"`
+	Justification string `yaml:"justification"`
+	Owner         string `yaml:"owner"`
+	Expires       string `yaml:"expires"`
+}
+
+// LoadWaivers loads the waivers of a YAML document of the form:
+//
+//	waivers:
+//	  - rule: s3/public
+//	    address: aws_s3_bucket.website*
+//	    justification: The website is served from the bucket.
+//	    owner: web-team
+//	    expires: 2021-12-31
+//
+// All the fields are required.
+func LoadWaivers(r io.Reader) ([]Waiver, error) {
+	var doc waiverFile
+
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	if err := dec.Decode(&doc); err != nil && !xerrors.Is(err, io.EOF) {
+		return nil, xerrors.Errorf("failed to decode waivers: %w", err)
+	}
+
+	ret := make([]Waiver, 0, len(doc.Waivers))
+
+	for i, e := range doc.Waivers {
+		w := Waiver{
+			RuleID:        e.Rule,
+			Address:       e.Address,
+			Justification: e.Justification,
+			Owner:         e.Owner,
+		}
+
+		if e.Expires != "" {
+			expires, err := time.Parse(WaiverDateLayout, e.Expires)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid waiver #%d: invalid expiry date '%s' (expected YYYY-MM-DD)", i+1, e.Expires)
+			}
+
+			w.Expires = expires
+		}
+
+		if err := w.validate(); err != nil {
+			return nil, xerrors.Errorf("invalid waiver #%d: %w", i+1, err)
+		}
+
+		ret = append(ret, w)
+	}
+
+	return ret, nil
+}
+
+// compiledWaiver is a waiver with its compiled address pattern.
+type compiledWaiver struct {
+	Waiver
+	address *regexp.Regexp
+}
+
+// waivers returns the waivers configured in the options, with their
+// compiled address patterns.
+func (o *Options) waivers() ([]compiledWaiver, error) {
+	ret := make([]compiledWaiver, 0, len(o.Waivers))
+
+	for i := range o.Waivers {
+		w := o.Waivers[i]
+
+		if err := w.validate(); err != nil {
+			return nil, xerrors.Errorf("invalid waiver #%d: %w", i+1, err)
+		}
+
+		ret = append(ret, compiledWaiver{Waiver: w, address: addressPattern(w.Address)})
+	}
+
+	return ret, nil
+}
+
+// addressPattern returns the regular expression matching the addresses
+// matched by a pattern where '*' matches any sequence of characters.
+func addressPattern(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// matches reports whether the waiver applies to the finding.
+func (w *compiledWaiver) matches(f *Finding) bool {
+	return f.RuleID == w.RuleID && w.address.MatchString(f.Address)
+}
+
+// splitWaivers splits the waivers between those that apply at t and those
+// that expired.
+func splitWaivers(waivers []compiledWaiver, t time.Time) (active []compiledWaiver, expired []Waiver) {
+	for i := range waivers {
+		if waivers[i].Expired(t) {
+			expired = append(expired, waivers[i].Waiver)
+		} else {
+			active = append(active, waivers[i])
+		}
+	}
+
+	return active, expired
+}
+
+// applyWaivers moves the findings of the result waived by one of the
+// waivers to its waived findings, and updates its status accordingly.
+func applyWaivers(result *RuleResult, waivers []compiledWaiver) {
+	if len(waivers) == 0 || len(result.Findings) == 0 {
+		return
+	}
+
+	findings := result.Findings[:0]
+
+	for _, f := range result.Findings {
+		waived := false
+
+		for i := range waivers {
+			if waivers[i].matches(&f) {
+				result.Waived = append(result.Waived, WaivedFinding{Finding: f, Waiver: waivers[i].Waiver})
+				waived = true
+
+				break
+			}
+		}
+
+		if !waived {
+			findings = append(findings, f)
+		}
+	}
+
+	result.Findings = findings
+
+	if result.Status == RuleStatusFail && !hasErrors(findings) {
+		result.Status = RuleStatusPass
+	}
+}
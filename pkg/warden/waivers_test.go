@@ -0,0 +1,255 @@
+// Copyright © 2021 Xavier Basty <xavier@hexbee.net>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warden
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
+)
+
+func testDate(t *testing.T, s string) time.Time {
+	t.Helper()
+
+	d, err := time.Parse(WaiverDateLayout, s)
+	require.NoError(t, err)
+
+	return d
+}
+
+func TestLoadWaivers(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    []Waiver
+		wantErr string
+	}{
+		{
+			name: "valid",
+			doc: `
+waivers:
+  - rule: s3/public
+    address: aws_s3_bucket.website*
+    justification: The website is served from the bucket.
+    owner: web-team
+    expires: 2021-12-31
+`,
+			want: []Waiver{{
+				RuleID:        "s3/public",
+				Address:       "aws_s3_bucket.website*",
+				Justification: "The website is served from the bucket.",
+				Owner:         "web-team",
+				Expires:       testDate(t, "2021-12-31"),
+			}},
+		},
+		{
+			name: "empty",
+			doc:  "",
+			want: []Waiver{},
+		},
+		{
+			name: "missing field",
+			doc: `
+waivers:
+  - rule: s3/public
+    address: "*"
+    justification: none
+    expires: 2021-12-31
+`,
+			wantErr: "invalid waiver #1: missing owner",
+		},
+		{
+			name: "invalid date",
+			doc: `
+waivers:
+  - rule: s3/public
+    address: "*"
+    justification: none
+    owner: me
+    expires: 31/12/2021
+`,
+			wantErr: "invalid expiry date '31/12/2021'",
+		},
+		{
+			name: "unknown field",
+			doc: `
+waivers:
+  - rule: s3/public
+    resource: "*"
+`,
+			wantErr: "failed to decode waivers",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadWaivers(strings.NewReader(tt.doc))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWaiver_Expired(t *testing.T) {
+	w := Waiver{Expires: testDate(t, "2021-12-31")}
+
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{at: time.Date(2021, 12, 30, 12, 0, 0, 0, time.UTC), want: false},
+		{at: time.Date(2021, 12, 31, 23, 59, 59, 0, time.UTC), want: false},
+		{at: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), want: true},
+		{at: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), want: true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, w.Expired(tt.at), tt.at)
+	}
+}
+
+func TestAddressPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		address string
+		want    bool
+	}{
+		{pattern: "aws_instance.web", address: "aws_instance.web", want: true},
+		{pattern: "aws_instance.web", address: "aws_instance.web2", want: false},
+		{pattern: "aws_instance.web[0]", address: "aws_instance.web[0]", want: true},
+		{pattern: "aws_instance.web[0]", address: "aws_instance.web0", want: false},
+		{pattern: "aws_instance.*", address: "aws_instance.web[\"a\"]", want: true},
+		{pattern: "module.legacy.*", address: "module.legacy.module.db.aws_db_instance.main", want: true},
+		{pattern: "module.legacy.*", address: "aws_instance.web", want: false},
+		{pattern: "*", address: "", want: true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, addressPattern(tt.pattern).MatchString(tt.address), "%s ~ %s", tt.pattern, tt.address)
+	}
+}
+
+func TestWarden_Waivers(t *testing.T) {
+	b := terraform.NewPlanBuilder()
+	for _, addr := range []string{"aws_instance.web", "aws_instance.legacy[0]", "aws_instance.legacy[1]"} {
+		b.ResourceChange(addr, plans.Create).After(cty.ObjectVal(map[string]cty.Value{
+			"instance_type": cty.StringVal("t3.large"),
+		}))
+	}
+
+	planFile, err := b.Build()
+	require.NoError(t, err)
+
+	rules := []Rule{
+		{
+			Name: "types",
+			Script: `
+local issues = {}
+for _, r in ipairs(require('tf').plan:findResource("aws_instance")) do
+	table.insert(issues, {resource = r, message = "unexpected instance type"})
+end
+return issues
+`,
+		},
+		{Name: "other", Script: `return {address = "aws_instance.legacy[0]", message = "not waived"}`},
+	}
+
+	active := Waiver{
+		RuleID:        "types",
+		Address:       "aws_instance.legacy*",
+		Justification: "Legacy instances are being decommissioned.",
+		Owner:         "ops",
+		Expires:       testDate(t, "2999-12-31"),
+	}
+	expired := active
+	expired.Address = "aws_instance.web"
+	expired.Expires = testDate(t, "2000-01-01")
+
+	t.Run("active", func(t *testing.T) {
+		w, err := New(&Options{Rules: rules[:1], Waivers: []Waiver{active}})
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		report, err := w.ValidatePlanFile(context.Background(), planFile)
+		assert.ErrorIs(t, err, ErrValidationFailed)
+		assert.Equal(t, RuleStatusFail, report.Results[0].Status)
+
+		findings := report.Findings()
+		require.Len(t, findings, 1)
+		assert.Equal(t, "aws_instance.web", findings[0].Address)
+
+		waived := report.Waived()
+		require.Len(t, waived, 2)
+		assert.Equal(t, "aws_instance.legacy[0]", waived[0].Address)
+		assert.Equal(t, "aws_instance.legacy[1]", waived[1].Address)
+		assert.Equal(t, active, waived[0].Waiver)
+		assert.Empty(t, report.ExpiredWaivers)
+	})
+
+	t.Run("all waived", func(t *testing.T) {
+		all := active
+		all.Address = "*"
+
+		w, err := New(&Options{Rules: rules, Waivers: []Waiver{all}})
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		report, err := w.ValidatePlanFile(context.Background(), planFile)
+		assert.ErrorIs(t, err, ErrValidationFailed)
+		assert.Equal(t, RuleStatusPass, report.Results[0].Status)
+		assert.Len(t, report.Results[0].Waived, 3)
+		assert.Equal(t, RuleStatusFail, report.Results[1].Status)
+		assert.Equal(t, []string{"not waived"}, findingMessages(report))
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		all := active
+		all.Address = "*"
+
+		w, err := New(&Options{Rules: rules[:1], Waivers: []Waiver{all, expired}})
+		require.NoError(t, err)
+
+		defer w.Close()
+
+		report, err := w.ValidatePlanFile(context.Background(), planFile)
+		assert.ErrorIs(t, err, ErrWaiverExpired)
+		assert.Equal(t, RuleStatusPass, report.Results[0].Status)
+		assert.Equal(t, []Waiver{expired}, report.ExpiredWaivers)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := New(&Options{Rules: rules, Waivers: []Waiver{{RuleID: "types"}}})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid waiver #1: missing address")
+	})
+}
@@ -1,21 +1,35 @@
 package warden
 
 import (
+	"context"
 	"fmt"
+	"runtime"
+	"time"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/imdario/mergo"
 	"github.com/spf13/afero"
 	"github.com/yuin/gopher-lua"
 	"golang.org/x/xerrors"
 
+	"github.com/hexbee-net/horus/pkg/terraform/tfdiags"
 	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
 	"github.com/hexbee-net/horus/pkg/warden/terraform"
 	tflua "github.com/hexbee-net/horus/pkg/warden/terraform/lua"
 )
 
+// Warden validates Terraform plans with a set of rules.
+//
+// The rules are compiled once when the Warden is created, and every
+// validation runs them in a fresh sandbox, so a Warden can be used to
+// validate any number of plans, including concurrently.
 type Warden struct {
-	options *Options
-	lState  *wlua.LState
+	options     *Options
+	userModules []wlua.CompiledModule
+	rules       []compiledRule
+	waivers     []compiledWaiver
+	overrides   map[string]Override
+	pool        *statePool
 }
 
 // New creates a new Warden instance.
@@ -32,85 +46,247 @@ func New(opts ...*Options) (*Warden, error) {
 		}
 	}
 
-	w := &Warden{
-		options: opt,
-		lState:  wlua.NewState(lua.Options{SkipOpenLibs: true}),
+	compile, compileUserModules := wlua.Compile, wlua.CompileUserModules
+	if opt.Coverage != nil {
+		compile, compileUserModules = opt.Coverage.Compile, opt.Coverage.CompileUserModules
 	}
 
-	w.lState.OpenModules(opt.Libs)
-	w.lState.PreloadModules(opt.Modules)
-
-	if err := w.lState.PreloadUserModule(opt.UserModules); err != nil {
+	userModules, err := compileUserModules(opt.UserModules)
+	if err != nil {
 		return nil, err //nolint:wrapcheck // this error actually comes from one of our own packages.
 	}
 
-	fn, err := w.lState.LoadString(w.options.Script)
+	rules, err := opt.rules()
+	if err != nil {
+		return nil, xerrors.Errorf("invalid rules: %w", err)
+	}
+
+	waivers, err := opt.waivers()
 	if err != nil {
-		return nil, xerrors.Errorf("invalid validation script: %w", err)
+		return nil, xerrors.Errorf("invalid waivers: %w", err)
+	}
+
+	params, err := opt.params(rules)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid parameters: %w", err)
+	}
+
+	overrides, err := opt.overrides(rules)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid overrides: %w", err)
+	}
+
+	w := &Warden{
+		options:     opt,
+		userModules: userModules,
+		rules:       make([]compiledRule, 0, len(rules)),
+		waivers:     waivers,
+		overrides:   overrides,
 	}
 
-	w.lState.Push(fn)
+	for _, r := range rules {
+		proto, err := compile(r.Name, r.Script)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid validation script for rule '%s': %w", r.Name, err)
+		}
+
+		w.rules = append(w.rules, compiledRule{Rule: r, proto: proto, params: params[r.Name]})
+	}
+
+	w.pool = newStatePool(runtime.GOMAXPROCS(0), w.newState)
 
 	return w, nil
 }
 
+// newState creates a new sandboxed Lua state with the configured libraries
+// and modules.
+func (w *Warden) newState() (*wlua.LState, error) {
+	ls := wlua.NewState(w.options.Limits.luaOptions())
+
+	ls.OpenModules(w.options.Libs)
+	ls.PreloadModules(w.options.Modules)
+
+	if w.options.Coverage != nil {
+		w.options.Coverage.Register(ls.LState)
+	}
+
+	if err := ls.PreloadCompiledModules(w.userModules); err != nil {
+		ls.Close()
+
+		return nil, err //nolint:wrapcheck // this error actually comes from one of our own packages.
+	}
+
+	return ls, nil
+}
+
 // ValidatePlan checks the validity of the specified plan with the configured
-// scripts.
-func (w *Warden) ValidatePlan(file afero.File) ([]string, error) {
+// rules.
+// The report is returned along with ErrLimitExceeded when at least one of
+// the rules exceeded the configured limits, ErrRuleErrored when at least one
+// of the rules could not be executed, ErrValidationFailed when at least
+// one of the mandatory rules reported a finding with the error severity and
+// was not overridden, or ErrWaiverExpired when at least one of the waivers
+// expired. Advisory rules only warn.
+// If the context is done before all the rules ran, the partial report is
+// returned along with the context error.
+// The values marked as sensitive are exposed to the rules as opaque values,
+// and all the known sensitive values of the plan are scrubbed from the
+// report, whether or not a rule unwrapped them. Values shorter than a few
+// characters are not scrubbed.
+//
+// ValidatePlan can be called concurrently.
+func (w *Warden) ValidatePlan(ctx context.Context, file afero.File) (*Report, error) {
 	planFile, err := terraform.LoadPlanFile(file)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to load plan file: %w", err)
 	}
 
-	w.lState.PreloadModule("tf", tflua.GetLoader(planFile))
+	return w.ValidatePlanFile(ctx, planFile)
+}
+
+// ValidatePlanFile checks the validity of a plan file already loaded, e.g.
+// built with terraform.PlanBuilder, with the configured rules.
+// The report and errors are the same as those of ValidatePlan.
+//
+// ValidatePlanFile can be called concurrently.
+func (w *Warden) ValidatePlanFile(ctx context.Context, planFile *terraform.PlanFile) (*Report, error) {
+	return w.validate(
+		ctx,
+		tflua.GetLoader(planFile, w.options.ProviderSchemas),
+		planFile,
+		planFile.SensitiveValues(w.options.ProviderSchemas),
+		diagnosticMessages(planFile.Diagnostics),
+	)
+}
 
-	if err := w.lState.PCall(0, lua.MultRet, nil); err != nil {
-		return nil, xerrors.Errorf("failed to load and parse validation script: %w", err)
+// ValidateState checks the validity of the specified state with the
+// configured rules, which access it through the 'tf.state' API. States of
+// any format written since Terraform 0.7 are supported.
+// The report and errors are the same as those of ValidatePlan.
+//
+// ValidateState can be called concurrently.
+func (w *Warden) ValidateState(ctx context.Context, file afero.File) (*Report, error) {
+	stateFile, err := terraform.LoadStateFile(file)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load state file: %w", err)
 	}
 
-	issues := checkResult(w.lState)
-	if len(issues) > 0 {
-		return issues, ErrValidationFailed
-	}
+	state := &terraform.State{State: stateFile.State, Schemas: w.options.ProviderSchemas}
 
-	return nil, nil
+	return w.validate(ctx, tflua.GetStateLoader(stateFile, w.options.ProviderSchemas), nil, state.SensitiveValues(), nil)
 }
 
-func (w *Warden) Close() {
-	if w.lState != nil {
-		w.lState.Close()
+// ValidateConfig checks the validity of the configuration of the root module
+// in dir with the configured rules, which access it through the 'tf.config'
+// API. No plan or state is needed, so no credentials either: the local
+// modules are loaded from their source directory, and the remote modules
+// from those installed by 'terraform init', if any.
+// The modules that could not be loaded are reported as warnings. The report
+// and errors are otherwise the same as those of ValidatePlan.
+//
+// ValidateConfig can be called concurrently.
+func (w *Warden) ValidateConfig(ctx context.Context, dir string) (*Report, error) {
+	config, diags := terraform.LoadConfigDir(dir)
+	if diags.HasErrors() {
+		return nil, xerrors.Errorf("failed to load configuration: %w", diags.Err())
 	}
+
+	root := terraform.NewModule(config)
+
+	return w.validate(ctx, tflua.GetConfigLoader(config, w.options.ProviderSchemas), root, root.SensitiveValues(), diagnosticMessages(diags))
 }
 
-func checkResult(ls *wlua.LState) (issues []string) {
-	ret := ls.Get(-1)
+// resourceLocator locates the declaration of resources in a configuration.
+type resourceLocator interface {
+	// ResourceRange returns the source range of the declaration of the
+	// resource with the given address, or nil if it is unknown.
+	ResourceRange(address string) *hcl.Range
+}
+
+// validate runs the rules with the given loader of the 'tf' module. The
+// locator, if any, is used to locate the findings in the configuration.
+// The sensitive values are scrubbed from the report.
+func (w *Warden) validate(
+	ctx context.Context,
+	loader lua.LGFunction,
+	locator resourceLocator,
+	sensitiveValues []string,
+	warnings []string,
+) (*Report, error) {
+	eval := func(ret lua.LValue) ([]Finding, error) {
+		return checkResult(ret, locator)
+	}
+
+	waivers, expired := splitWaivers(w.waivers, time.Now())
+
+	report := &Report{
+		Results:        make([]RuleResult, 0, len(w.rules)),
+		Warnings:       warnings,
+		ExpiredWaivers: expired,
+	}
+
+	// The report is redacted whatever the outcome, including when partial.
+	defer newRedactor(sensitiveValues).redactReport(report)
+
+	for i := range w.rules {
+		if err := ctx.Err(); err != nil {
+			return report, xerrors.Errorf("validation interrupted: %w", err)
+		}
+
+		result, err := w.runRule(ctx, &w.rules[i], loader, eval)
+		if err != nil {
+			return report, err
+		}
+
+		applyWaivers(&result, waivers)
+		enforce(&result, w.overrides)
 
-	if ret == lua.LNil || ret == lua.LTrue {
-		return nil
+		report.Results = append(report.Results, result)
 	}
 
-	if ret == lua.LFalse {
-		return []string{"validation failed"}
+	if err := ctx.Err(); err != nil {
+		return report, xerrors.Errorf("validation interrupted: %w", err)
 	}
 
-	// Check for single error
-	if str, ok := ret.(lua.LString); ok {
-		return []string{str.String()}
+	return report, report.err()
+}
+
+// runRule runs a rule in a fresh state from the pool, so that nothing a rule
+// changes in the globals, the standard libraries or the loaded modules is
+// visible to the next ones.
+func (w *Warden) runRule(
+	ctx context.Context,
+	rule *compiledRule,
+	loader lua.LGFunction,
+	eval func(lua.LValue) ([]Finding, error),
+) (RuleResult, error) {
+	ls, err := w.pool.get()
+	if err != nil {
+		return RuleResult{}, xerrors.Errorf("failed to create the script sandbox: %w", err)
 	}
 
-	// Check for multiple errors
-	if tbl, ok := ret.(*lua.LTable); ok {
-		errs := make([]string, 0, tbl.Len())
-		tbl.ForEach(func(_ lua.LValue, v lua.LValue) {
-			errs = append(errs, v.String())
-		})
+	defer w.pool.put(ls)
+
+	ls.PreloadModule("tf", loader)
 
-		return errs
+	return rule.run(ctx, ls.LState, ls.G.Global, w.options.Limits.RuleTimeout, eval), nil
+}
+
+// diagnosticMessages returns the messages of the diagnostics.
+func diagnosticMessages(diags tfdiags.Diagnostics) []string {
+	var ret []string
+
+	for _, diag := range diags {
+		desc := diag.Description()
+		ret = append(ret, fmt.Sprintf("%s: %s", desc.Summary, desc.Detail))
 	}
 
-	// The returned value was neither Nil, a boolean, a string or
-	// an array of string.
-	// Still, something was returned so assume the validation failed and
-	// return whatever we got back.
-	return []string{fmt.Sprintf("validation failed (%s)", ret.String())}
+	return ret
+}
+
+// Close releases the resources held by the Warden.
+func (w *Warden) Close() {
+	if w.pool != nil {
+		w.pool.close()
+	}
 }
@@ -1,14 +1,18 @@
 package warden
 
 import (
+	"context"
 	"path"
 	"testing"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
 
+	"github.com/hexbee-net/horus/pkg/terraform/plans"
 	wlua "github.com/hexbee-net/horus/pkg/warden/lua"
+	"github.com/hexbee-net/horus/pkg/warden/terraform"
 )
 
 func getTestDataPath(t *testing.T, localPath string) string {
@@ -16,6 +20,19 @@ func getTestDataPath(t *testing.T, localPath string) string {
 	return path.Clean(path.Join("../../testData/", localPath))
 }
 
+func findingMessages(report *Report) []string {
+	if report == nil {
+		return nil
+	}
+
+	var messages []string
+	for _, f := range report.Findings() {
+		messages = append(messages, f.Message)
+	}
+
+	return messages
+}
+
 func TestNew(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -99,7 +116,7 @@ func TestWarden_ValidatePlan_NilPlanFile(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.PanicsWithError(t, "runtime error: invalid memory address or nil pointer dereference", func() {
-		_, _ = w.ValidatePlan(nil)
+		_, _ = w.ValidatePlan(context.Background(), nil)
 	})
 }
 
@@ -111,7 +128,7 @@ func TestWarden_ValidatePlan_EmptyFile(t *testing.T) {
 	file, err := fs.Create("ts-planfile")
 	require.NoError(t, err)
 
-	_, err = w.ValidatePlan(file)
+	_, err = w.ValidatePlan(context.Background(), file)
 	assert.Error(t, err)
 }
 
@@ -123,7 +140,7 @@ func TestWarden_ValidatePlan_ValidFile(t *testing.T) {
 	planFile, err := fs.Open(getTestDataPath(t, "tf-planfile"))
 	require.NoError(t, err)
 
-	_, err = w.ValidatePlan(planFile)
+	_, err = w.ValidatePlan(context.Background(), planFile)
 	assert.NoError(t, err)
 }
 
@@ -205,7 +222,7 @@ func TestWarden_ValidatePlan_ReturnValues(t *testing.T) {
 			planFile, err := testFs.Open(getTestDataPath(t, tt.planFile))
 			require.NoError(t, err)
 
-			issues, err := w.ValidatePlan(planFile)
+			issues, err := w.ValidatePlan(context.Background(), planFile)
 			_ = planFile.Close()
 
 			if tt.wantErr {
@@ -214,7 +231,7 @@ func TestWarden_ValidatePlan_ReturnValues(t *testing.T) {
 				assert.NoError(t, err)
 			}
 
-			assert.ElementsMatch(t, tt.issues, issues)
+			assert.ElementsMatch(t, tt.issues, findingMessages(issues))
 		})
 	}
 }
@@ -292,7 +309,7 @@ return mod1.ok()
 			planFile, err := testFs.Open(getTestDataPath(t, tt.planFile))
 			require.NoError(t, err)
 
-			issues, err := w.ValidatePlan(planFile)
+			issues, err := w.ValidatePlan(context.Background(), planFile)
 			_ = planFile.Close()
 
 			if tt.wantErr {
@@ -301,7 +318,977 @@ return mod1.ok()
 				assert.NoError(t, err)
 			}
 
-			assert.ElementsMatch(t, tt.issues, issues)
+			assert.ElementsMatch(t, tt.issues, findingMessages(issues))
+		})
+	}
+}
+
+func TestWarden_ValidatePlan_FindResource(t *testing.T) {
+	testFs := afero.NewReadOnlyFs(afero.NewOsFs())
+
+	tests := []struct {
+		name    string
+		script  string
+		issues  []string
+		wantErr bool
+	}{
+		{
+			name: "type and name",
+			script: `
+local tf = require 'tf'
+local res = tf.plan:findResource("aws_instance", "simple_resource")
+return { #res, res[1]:address() }
+`,
+			issues:  []string{"1", "aws_instance.simple_resource"},
+			wantErr: true,
+		},
+		{
+			name: "type only",
+			script: `
+local tf = require 'tf'
+return tostring(#tf.plan:findResource("aws_instance"))
+`,
+			issues:  []string{"4"},
+			wantErr: true,
+		},
+		{
+			name: "name glob",
+			script: `
+local tf = require 'tf'
+local issues = {}
+for _, r in ipairs(tf.plan:findResource("aws_instance", "multiple_*")) do
+	table.insert(issues, r:address())
+end
+return issues
+`,
+			issues: []string{
+				"aws_instance.multiple_resource[0]",
+				"aws_instance.multiple_resource[1]",
+				"aws_instance.multiple_resource[2]",
+			},
+			wantErr: true,
+		},
+		{
+			name: "filter table",
+			script: `
+local tf = require 'tf'
+local issues = {}
+for _, r in ipairs(tf.plan:findResource{ action = "create", provider = "null", module = "root" }) do
+	table.insert(issues, r:address())
+end
+return issues
+`,
+			issues:  []string{"null_resource.foo"},
+			wantErr: true,
+		},
+		{
+			name: "no match",
+			script: `
+local tf = require 'tf'
+return #tf.plan:findResource{ type = "aws_instance", action = "delete" } == 0
+`,
+			issues:  nil,
+			wantErr: false,
+		},
+		{
+			name: "unknown filter field",
+			script: `
+local tf = require 'tf'
+return tf.plan:findResource{ kind = "aws_instance" }
+`,
+			issues:  nil,
+			wantErr: true,
+		},
+		{
+			name: "missing arguments",
+			script: `
+local tf = require 'tf'
+return tf.plan:findResource()
+`,
+			issues:  nil,
+			wantErr: true,
+		},
+	}
+	// The same policies run on binary and JSON plans.
+	for _, planFileName := range []string{"tf-planfile", "tf-plan.json"} {
+		for _, tt := range tests {
+			t.Run(planFileName+"/"+tt.name, func(t *testing.T) {
+				w, err := New(&Options{Script: tt.script})
+				require.NoError(t, err)
+
+				planFile, err := testFs.Open(getTestDataPath(t, planFileName))
+				require.NoError(t, err)
+
+				issues, err := w.ValidatePlan(context.Background(), planFile)
+				_ = planFile.Close()
+
+				if tt.wantErr {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+				}
+
+				assert.ElementsMatch(t, tt.issues, findingMessages(issues))
+			})
+		}
+	}
+}
+
+func TestWarden_ValidatePlan_ProviderSchemas(t *testing.T) {
+	testFs := afero.NewReadOnlyFs(afero.NewOsFs())
+
+	schemaFile, err := testFs.Open(getTestDataPath(t, "provider-schemas.json"))
+	require.NoError(t, err)
+
+	schemas, err := terraform.LoadProviderSchemas(schemaFile)
+	_ = schemaFile.Close()
+
+	require.NoError(t, err)
+
+	w, err := New(&Options{
+		ProviderSchemas: schemas,
+		Script: `
+local tf = require 'tf'
+local issues = {}
+for _, r in ipairs(tf.plan:findResource("aws_instance")) do
+	local c = r:change()
+	if c.after_unknown.id and c.after_sensitive.password_data then
+		table.insert(issues, r:address() .. " " .. c.after.instance_type)
+	end
+end
+return issues
+`,
+	})
+	require.NoError(t, err)
+
+	planFile, err := testFs.Open(getTestDataPath(t, "tf-planfile"))
+	require.NoError(t, err)
+
+	issues, err := w.ValidatePlan(context.Background(), planFile)
+	_ = planFile.Close()
+
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	assert.ElementsMatch(t, []string{
+		"aws_instance.simple_resource t2.micro",
+		"aws_instance.multiple_resource[0] t2.micro",
+		"aws_instance.multiple_resource[1] t2.micro",
+		"aws_instance.multiple_resource[2] t2.micro",
+	}, findingMessages(issues))
+}
+
+func TestWarden_ValidatePlan_Reuse(t *testing.T) {
+	w, err := New(&Options{
+		UserModules: []wlua.UserModule{{
+			Name:   "counter",
+			Script: `local M = { count = 0 } function M.inc() M.count = M.count + 1 return M.count end return M`,
+		}},
+		Script: `
+local counter = require 'counter'
+local tf = require 'tf'
+seen = (seen or 0) + 1
+string.leaked = true
+return { tostring(counter.inc()), tostring(seen), tostring(#tf.plan:findResource("aws_instance")) }
+`,
+	})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	testFs := afero.NewReadOnlyFs(afero.NewOsFs())
+
+	for i := 0; i < 3; i++ {
+		planFile, err := testFs.Open(getTestDataPath(t, "tf-planfile"))
+		require.NoError(t, err)
+
+		report, err := w.ValidatePlan(context.Background(), planFile)
+		_ = planFile.Close()
+
+		assert.ErrorIs(t, err, ErrValidationFailed)
+		assert.Equal(t, []string{"1", "1", "4"}, findingMessages(report), "run #%d", i+1)
+	}
+}
+
+func TestWarden_ValidatePlan_RuleIsolation(t *testing.T) {
+	w, err := New(&Options{Rules: []Rule{
+		{
+			Name: "tamper",
+			Script: `
+local tf = require 'tf'
+tf.plan = nil
+string.format = function() return "tampered" end
+_G.tampered = true
+return {}
+`,
+		},
+		{
+			Name: "check",
+			Script: `
+local tf = require 'tf'
+return {
+	string.format("%d", #tf.plan:findResource("aws_instance")),
+	tostring(tampered),
+}
+`,
+		},
+	}})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	planFile, err := afero.NewReadOnlyFs(afero.NewOsFs()).Open(getTestDataPath(t, "tf-planfile"))
+	require.NoError(t, err)
+
+	defer planFile.Close()
+
+	report, err := w.ValidatePlan(context.Background(), planFile)
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	assert.Equal(t, []string{"4", "nil"}, findingMessages(report))
+}
+
+func TestWarden_ValidatePlan_Concurrent(t *testing.T) {
+	w, err := New(&Options{
+		Script: `
+local tf = require 'tf'
+seen = (seen or 0) + 1
+local issues = {}
+for _, r in ipairs(tf.plan:findResource("aws_instance")) do
+	table.insert(issues, r:address() .. " " .. tostring(seen))
+end
+return issues
+`,
+	})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	const workers = 16
+
+	testFs := afero.NewReadOnlyFs(afero.NewOsFs())
+	errs := make(chan error, workers)
+	results := make(chan []string, workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			planFile, err := testFs.Open(getTestDataPath(t, "tf-planfile"))
+			if err != nil {
+				errs <- err
+
+				return
+			}
+
+			defer planFile.Close()
+
+			report, err := w.ValidatePlan(context.Background(), planFile)
+			errs <- err
+			results <- findingMessages(report)
+		}()
+	}
+
+	for i := 0; i < workers; i++ {
+		err := <-errs
+		if !assert.ErrorIs(t, err, ErrValidationFailed) {
+			continue
+		}
+
+		assert.ElementsMatch(t, []string{
+			"aws_instance.simple_resource 1",
+			"aws_instance.multiple_resource[0] 1",
+			"aws_instance.multiple_resource[1] 1",
+			"aws_instance.multiple_resource[2] 1",
+		}, <-results)
+	}
+}
+
+func TestWarden_ValidateState(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   string
+		script  string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:  "resources",
+			state: "tf-state.tfstate",
+			script: `
+local tf = require 'tf'
+local issues = {}
+for _, r in ipairs(tf.state:findResource({type = "aws_instance"})) do
+	if r:status() == "tainted" or r:deposed() then
+		table.insert(issues, r:address() .. " " .. r:status() .. " " .. tostring(r:deposed()) .. " " .. r:value().instance_type)
+	end
+end
+return issues
+`,
+			want: []string{
+				"aws_instance.multiple_resource[0] tainted nil t2.large",
+				"aws_instance.multiple_resource[0] ready 00000001 t2.micro",
+			},
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name:  "dependencies and sensitive values",
+			state: "tf-state.tfstate",
+			script: `
+local tf = require 'tf'
+local issues = {}
+for _, r in ipairs(tf.state:findResource("null_resource", "foo")) do
+	if r:sensitive().triggers then
+		table.insert(issues, r:address() .. " has sensitive triggers")
+	end
+end
+for _, r in ipairs(tf.state:findResource("aws_instance", "multiple_resource")) do
+	for _, dep in ipairs(r:dependencies()) do
+		table.insert(issues, r:address() .. " depends on " .. dep)
+	end
+end
+return issues
+`,
+			want: []string{
+				`module.net.null_resource.foo["b"] has sensitive triggers`,
+				"aws_instance.multiple_resource[0] depends on aws_instance.simple_resource",
+			},
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name:  "outputs",
+			state: "tf-state.tfstate",
+			script: `
+local tf = require 'tf'
+local issues = {}
+for name, o in pairs(tf.state:outputs()) do
+	if not o.sensitive then
+		table.insert(issues, name .. " = " .. o.value)
+	end
+end
+return issues
+`,
+			want:    []string{"instance_id = i-0a1b2c3d4e5f60718"},
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name:  "legacy state",
+			state: "tf-state-v3.tfstate",
+			script: `
+local tf = require 'tf'
+assert(tf.plan == nil)
+return #tf.state:findResource("null_resource") == 4
+`,
+		},
+		{
+			name:  "action filter",
+			state: "tf-state.tfstate",
+			script: `
+local tf = require 'tf'
+return #tf.state:findResource({action = "create"}) == 0
+`,
+			want:    []string{},
+			wantErr: ErrRuleErrored,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New(&Options{Script: tt.script})
+			require.NoError(t, err)
+
+			defer w.Close()
+
+			stateFile, err := afero.NewReadOnlyFs(afero.NewOsFs()).Open(getTestDataPath(t, tt.state))
+			require.NoError(t, err)
+
+			defer stateFile.Close()
+
+			report, err := w.ValidateState(context.Background(), stateFile)
+			require.NotNil(t, report)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tt.want != nil {
+				assert.ElementsMatch(t, tt.want, findingMessages(report))
+			}
+		})
+	}
+}
+
+func TestWarden_ValidateState_InvalidFile(t *testing.T) {
+	w, err := New(&Options{Script: `return true`})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	planFile, err := afero.NewReadOnlyFs(afero.NewOsFs()).Open(getTestDataPath(t, "tf-planfile"))
+	require.NoError(t, err)
+
+	defer planFile.Close()
+
+	_, err = w.ValidateState(context.Background(), planFile)
+	assert.Error(t, err)
+}
+
+func TestWarden_ValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "module tree",
+			script: `
+local tf = require 'tf'
+assert(tf.plan == nil and tf.state == nil)
+local issues = {}
+local function walk(m)
+	table.insert(issues, "module '" .. m:path() .. "' " .. tostring(m:source()) .. " " .. tostring(m:version()))
+	for _, child in ipairs(m:children()) do
+		walk(child)
+	end
+end
+walk(tf.config)
+for name, call in pairs(tf.config:moduleCalls()) do
+	if not call.version and not call.source:find("^%.") then
+		table.insert(issues, "unpinned module " .. name)
+	end
+end
+return issues
+`,
+			want: []string{
+				"module '' nil nil",
+				"module 'module.labels' cloudposse/label/null 0.25.0",
+				"module 'module.net' ./modules/net nil",
+				"unpinned module dns",
+			},
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name: "resources",
+			script: `
+local tf = require 'tf'
+local issues = {}
+for _, r in ipairs(tf.config:findResource({type = "aws_instance"})) do
+	local attrs = r:attributes()
+	table.insert(issues, r:address() .. " count=" .. r:count().value .. " ami=" .. attrs.ami.value)
+	table.insert(issues, "instance_type refs " .. table.concat(attrs.instance_type.references, ","))
+	table.insert(issues, "encrypted=" .. tostring(attrs.root_block_device[1].encrypted.value))
+	table.insert(issues, "tags constant=" .. tostring(attrs.tags.constant))
+end
+for _, r in ipairs(tf.config:findResource("null_resource")) do
+	table.insert(issues, r:address() .. " for_each=" .. tostring(r:forEach() ~= nil) .. " line=" .. r:range().start_line)
+end
+return issues
+`,
+			want: []string{
+				"aws_instance.web count=2 ami=ami-0c55b159cbfe1f0d0",
+				"instance_type refs var.instance_type",
+				"encrypted=true",
+				"tags constant=false",
+				"module.labels.null_resource.label for_each=false line=1",
+				"module.net.null_resource.marker for_each=true line=9",
+			},
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name: "variables, outputs and providers",
+			script: `
+local tf = require 'tf'
+local issues = {}
+for name, v in pairs(tf.config:variables()) do
+	if not v.description then
+		table.insert(issues, "undocumented variable " .. name .. " (" .. v.type .. ", sensitive=" .. tostring(v.sensitive) .. ")")
+	end
+end
+local region = tf.config:variables().region
+table.insert(issues, "region defaults to " .. region.default .. " " .. tostring(region.has_default))
+for name, o in pairs(tf.config:outputs()) do
+	if o.value.constant then
+		table.insert(issues, "constant output " .. name .. " = " .. o.value.value)
+	end
+end
+for name, p in pairs(tf.config:requiredProviders()) do
+	if not p.version then
+		table.insert(issues, "unconstrained provider " .. name .. " " .. p.source)
+	end
+end
+return issues
+`,
+			want: []string{
+				"undocumented variable db_password (string, sensitive=true)",
+				"undocumented variable instance_type (string, sensitive=false)",
+				"region defaults to eu-west-1 true",
+				"constant output environment = production",
+				"unconstrained provider null registry.terraform.io/hashicorp/null",
+			},
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name: "finding ranges",
+			script: `
+local tf = require 'tf'
+local issues = {}
+for _, r in ipairs(tf.config:findResource("aws_vpc")) do
+	table.insert(issues, { resource = r, message = "vpc" })
+end
+return issues
+`,
+			want:    []string{"vpc"},
+			wantErr: ErrValidationFailed,
+		},
+		{
+			name: "action filter",
+			script: `
+local tf = require 'tf'
+return #tf.config:findResource({action = "create"}) == 0
+`,
+			want:    []string{},
+			wantErr: ErrRuleErrored,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New(&Options{Script: tt.script})
+			require.NoError(t, err)
+
+			defer w.Close()
+
+			report, err := w.ValidateConfig(context.Background(), getTestDataPath(t, "config"))
+			require.NotNil(t, report)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tt.want != nil {
+				assert.ElementsMatch(t, tt.want, findingMessages(report))
+			}
+
+			require.Len(t, report.Warnings, 1)
+			assert.Contains(t, report.Warnings[0], "Module not installed")
+
+			for _, f := range report.Findings() {
+				if f.Address != "" {
+					require.NotNil(t, f.Range, f.Address)
+					assert.Equal(t, getTestDataPath(t, "config/modules/net/main.tf"), f.Range.Filename)
+				}
+			}
+		})
+	}
+}
+
+func TestWarden_ValidateConfig_InvalidDir(t *testing.T) {
+	w, err := New(&Options{Script: `return true`})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	_, err = w.ValidateConfig(context.Background(), getTestDataPath(t, "missing"))
+	assert.Error(t, err)
+}
+
+func TestWarden_ValidatePlan_Config(t *testing.T) {
+	testFs := afero.NewReadOnlyFs(afero.NewOsFs())
+
+	script := `
+local tf = require 'tf'
+local issues = {}
+for _, r in ipairs(tf.config:findResource("aws_instance")) do
+	local attrs = r:attributes()
+	local count = r:count() and r:count().value or 1
+	table.insert(issues, r:address() .. " x" .. count .. " " .. attrs.instance_type.value .. " " .. attrs.tags.value.Name)
+end
+local aws = tf.config:providers().aws
+table.insert(issues, "aws region " .. aws.attributes.region.value .. " " .. tostring(aws.alias))
+table.insert(issues, "aws version " .. tf.config:requiredProviders().aws.version)
+assert(tf.config:child("missing") == nil)
+assert(#tf.config:children() == 0)
+return issues
+`
+
+	// The configuration of binary and JSON plans has the same API.
+	for _, planFileName := range []string{"tf-planfile", "tf-plan.json"} {
+		t.Run(planFileName, func(t *testing.T) {
+			w, err := New(&Options{Script: script})
+			require.NoError(t, err)
+
+			defer w.Close()
+
+			planFile, err := testFs.Open(getTestDataPath(t, planFileName))
+			require.NoError(t, err)
+
+			defer planFile.Close()
+
+			report, err := w.ValidatePlan(context.Background(), planFile)
+			assert.ErrorIs(t, err, ErrValidationFailed)
+			assert.ElementsMatch(t, []string{
+				"aws_instance.multiple_resource x3 t2.micro ExampleAppServerInstance 2",
+				"aws_instance.simple_resource x1 t2.micro ExampleAppServerInstance 1",
+				"aws region eu-west-3 nil",
+				"aws version ~> 3.27",
+			}, findingMessages(report))
 		})
 	}
 }
+
+func TestWarden_ValidateConfig_Declarations(t *testing.T) {
+	w, err := New(&Options{Script: `
+local tf = require 'tf'
+local issues = {}
+for name, l in pairs(tf.config:locals()) do
+	table.insert(issues, "local " .. name .. " constant=" .. tostring(l.value.constant) .. " refs=" .. table.concat(l.value.references, ","))
+end
+for key, p in pairs(tf.config:providers()) do
+	table.insert(issues, "provider " .. key .. " alias=" .. tostring(p.alias) .. " region refs=" .. #p.attributes.region.references)
+end
+local net = tf.config:moduleCalls().net
+table.insert(issues, "net cidr=" .. net.inputs.cidr.value .. " count=" .. tostring(net.count) .. " deps=" .. #net.depends_on)
+for _, r in ipairs(tf.config:child("net"):resources()) do
+	local lc = r:lifecycle()
+	if lc.prevent_destroy then
+		table.insert(issues, r:address() .. " ignores " .. table.concat(lc.ignore_changes, ",") .. " depends on " .. table.concat(r:dependsOn(), ","))
+	end
+end
+assert(tf.config:findResource("aws_ami")[1]:lifecycle() == nil)
+return issues
+`})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	report, err := w.ValidateConfig(context.Background(), getTestDataPath(t, "config"))
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	assert.ElementsMatch(t, []string{
+		"local environment constant=true refs=",
+		"local name_prefix constant=false refs=local.environment",
+		"provider aws alias=nil region refs=1",
+		"provider aws.east alias=east region refs=0",
+		"net cidr=10.0.0.0/16 count=nil deps=0",
+		"module.net.aws_flow_log.main ignores tags depends on aws_vpc.main",
+	}, findingMessages(report))
+}
+
+const testOutputsPlan = `{
+  "format_version": "1.2",
+  "terraform_version": "1.5.7",
+  "prior_state": {
+    "format_version": "1.0",
+    "terraform_version": "1.5.7",
+    "values": {
+      "outputs": {
+        "api_token": { "sensitive": true, "value": "secret" },
+        "url": { "sensitive": false, "value": "https://old.example.com" }
+      },
+      "root_module": {}
+    }
+  },
+  "output_changes": {
+    "api_token": {
+      "actions": ["update"],
+      "before": "secret",
+      "after": "rotated",
+      "after_unknown": false,
+      "before_sensitive": true,
+      "after_sensitive": false
+    },
+    "db_password": {
+      "actions": ["create"],
+      "before": null,
+      "after": "hunter2",
+      "after_unknown": false,
+      "before_sensitive": false,
+      "after_sensitive": false
+    },
+    "url": {
+      "actions": ["update"],
+      "before": "https://old.example.com",
+      "after": null,
+      "after_unknown": true,
+      "before_sensitive": false,
+      "after_sensitive": false
+    }
+  }
+}`
+
+func TestWarden_ValidatePlan_Outputs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "plan.json", []byte(testOutputsPlan), 0o600))
+
+	w, err := New(&Options{Script: `
+local tf = require 'tf'
+local issues = {}
+for _, o in ipairs(tf.plan:outputs()) do
+	if o.name:find("_password$") and not o.sensitive then
+		table.insert(issues, o.address .. " must be sensitive")
+	end
+	if o.before_sensitive and not o.sensitive then
+		table.insert(issues, o.address .. " is no longer sensitive")
+	end
+	if o.after_unknown then
+		table.insert(issues, o.address .. " " .. o.action .. " from " .. o.before .. " to unknown")
+	end
+end
+return issues
+`})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	planFile, err := fs.Open("plan.json")
+	require.NoError(t, err)
+
+	defer planFile.Close()
+
+	report, err := w.ValidatePlan(context.Background(), planFile)
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	assert.Equal(t, []string{
+		"output.api_token is no longer sensitive",
+		"output.db_password must be sensitive",
+		"output.url update from https://old.example.com to unknown",
+	}, findingMessages(report))
+}
+
+const testDriftPlan = `{
+  "format_version": "0.2",
+  "terraform_version": "1.0.3",
+  "prior_state": {
+    "format_version": "0.2",
+    "terraform_version": "1.0.3",
+    "values": {
+      "root_module": {
+        "resources": [
+          {
+            "address": "aws_security_group.web",
+            "mode": "managed",
+            "type": "aws_security_group",
+            "name": "web",
+            "provider_name": "registry.terraform.io/hashicorp/aws",
+            "values": { "id": "sg-1", "ingress": ["0.0.0.0/0"], "tags": { "Name": "web" } }
+          },
+          {
+            "address": "aws_instance.web",
+            "mode": "managed",
+            "type": "aws_instance",
+            "name": "web",
+            "provider_name": "registry.terraform.io/hashicorp/aws",
+            "values": { "id": "i-1", "instance_type": "t3.micro" }
+          }
+        ]
+      }
+    }
+  },
+  "resource_drift": [
+    {
+      "address": "aws_security_group.web",
+      "mode": "managed",
+      "type": "aws_security_group",
+      "name": "web",
+      "provider_name": "registry.terraform.io/hashicorp/aws",
+      "change": {
+        "actions": ["update"],
+        "before": { "id": "sg-1", "ingress": ["10.0.0.0/8"], "tags": { "Name": "web" } },
+        "after": { "id": "sg-1", "ingress": ["0.0.0.0/0"], "tags": { "Name": "web" } }
+      }
+    },
+    {
+      "address": "aws_s3_bucket.logs",
+      "mode": "managed",
+      "type": "aws_s3_bucket",
+      "name": "logs",
+      "provider_name": "registry.terraform.io/hashicorp/aws",
+      "change": {
+        "actions": ["delete"],
+        "before": { "id": "logs" },
+        "after": null
+      }
+    }
+  ],
+  "resource_changes": []
+}`
+
+func TestWarden_ValidatePlan_Drift(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "plan.json", []byte(testDriftPlan), 0o600))
+
+	w, err := New(&Options{Script: `
+local tf = require 'tf'
+local issues = {}
+for _, d in ipairs(tf.drift:resources()) do
+	table.insert(issues, {
+		message = d:action() .. "d outside of Terraform: " .. table.concat(d:changedAttributes(), ", "),
+		resource = d,
+	})
+end
+for _, d in ipairs(tf.drift:findResource("aws_security_group")) do
+	if d:after().ingress[1] == "0.0.0.0/0" then
+		table.insert(issues, d:address() .. " was opened to the world by " .. d:before().ingress[1])
+	end
+end
+return issues
+`})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	planFile, err := fs.Open("plan.json")
+	require.NoError(t, err)
+
+	defer planFile.Close()
+
+	report, err := w.ValidatePlan(context.Background(), planFile)
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	assert.Equal(t, []string{
+		"deleted outside of Terraform: ",
+		"updated outside of Terraform: ingress[0]",
+		"aws_security_group.web was opened to the world by 10.0.0.0/8",
+	}, findingMessages(report))
+
+	var addresses []string
+	for _, f := range report.Findings() {
+		addresses = append(addresses, f.Address)
+	}
+
+	assert.Equal(t, []string{"aws_s3_bucket.logs", "aws_security_group.web", ""}, addresses)
+}
+
+func TestWarden_ValidateState_Sensitive(t *testing.T) {
+	w, err := New(&Options{Rules: []Rule{
+		{
+			Name: "leak",
+			Script: `
+local tf = require 'tf'
+local issues = {}
+local password = tf.state:outputs().db_password.value
+if tf.isSensitive(password) then
+	table.insert(issues, "db_password is " .. tf.unwrap(password))
+end
+for _, r in ipairs(tf.state:findResource("null_resource", "foo")) do
+	local triggers = r:value().triggers
+	if triggers then
+		table.insert(issues, {message = "token " .. triggers.token:unwrap() .. " leaked", resource = r})
+	end
+end
+table.insert(issues, "instance " .. tf.unwrap(tf.state:outputs().instance_id.value))
+return issues
+`,
+		},
+		{
+			Name:   "error",
+			Script: `error("the token is " .. require('tf').state:outputs().db_password.value:unwrap())`,
+		},
+		{
+			Name:   "concatenation",
+			Script: `return "the token is " .. require('tf').state:outputs().db_password.value`,
+		},
+	}})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	stateFile, err := afero.NewReadOnlyFs(afero.NewOsFs()).Open(getTestDataPath(t, "tf-state.tfstate"))
+	require.NoError(t, err)
+
+	defer stateFile.Close()
+
+	report, err := w.ValidateState(context.Background(), stateFile)
+	assert.ErrorIs(t, err, ErrRuleErrored)
+	require.NotNil(t, report)
+	require.Len(t, report.Results, 3)
+
+	assert.Equal(t, []string{
+		"db_password is (sensitive value)",
+		"token (sensitive value) leaked",
+		"instance i-0a1b2c3d4e5f60718",
+	}, findingMessages(report))
+
+	assert.Contains(t, report.Results[1].Err.Error(), "the token is (sensitive value)")
+	assert.NotContains(t, report.Results[1].Err.Error(), "hunter2")
+	assert.Contains(t, report.Results[2].Err.Error(), "sensitive values cannot be converted to strings")
+}
+
+func TestWarden_ValidatePlan_Unknowns(t *testing.T) {
+	b := terraform.NewPlanBuilder()
+	b.ResourceChange("aws_s3_bucket.known", plans.Create).After(cty.ObjectVal(map[string]cty.Value{
+		"acl":    cty.StringVal("private"),
+		"policy": cty.NullVal(cty.String),
+		"tags":   cty.MapValEmpty(cty.String),
+	}))
+	b.ResourceChange("aws_s3_bucket.unknown", plans.Create).After(cty.ObjectVal(map[string]cty.Value{
+		"acl":    cty.UnknownVal(cty.String),
+		"policy": cty.UnknownVal(cty.String),
+		"tags":   cty.MapVal(map[string]cty.Value{"Owner": cty.UnknownVal(cty.String)}),
+	}))
+
+	planFile, err := b.Build()
+	require.NoError(t, err)
+
+	w, err := New(&Options{Script: `
+local tf = require('tf')
+
+local issues = {}
+for _, r in ipairs(tf.plan:findResource("aws_s3_bucket")) do
+	local after = r:change().after
+	table.insert(issues, {
+		resource = r,
+		message = string.format("acl unknown: %s, policy null: %s, policy unknown: %s, tags known: %s, tags empty: %s",
+			tostring(after.acl == tf.unknown), tostring(tf.is_null(after.policy)), tostring(not tf.is_known(after.policy)),
+			tostring(tf.is_known(after.tags)), tostring(next(after.tags) == nil)),
+	})
+end
+return issues
+`})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	report, err := w.ValidatePlanFile(context.Background(), planFile)
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	assert.Equal(t, []string{
+		"acl unknown: false, policy null: true, policy unknown: false, tags known: true, tags empty: true",
+		"acl unknown: true, policy null: false, policy unknown: true, tags known: false, tags empty: false",
+	}, findingMessages(report))
+}
+
+func TestWarden_ValidatePlan_UnknownsAliases(t *testing.T) {
+	planFile, err := terraform.NewPlanBuilder().Build()
+	require.NoError(t, err)
+
+	w, err := New(&Options{Script: `
+local tf = require('tf')
+return {
+	tostring(tf.isKnown(tf.unknown)), tostring(tf.isKnown("x")),
+	tostring(tf.isNull(nil)), tostring(tf.isNull(tf.unknown)),
+}
+`})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	report, err := w.ValidatePlanFile(context.Background(), planFile)
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	assert.Equal(t, []string{"false", "true", "true", "false"}, findingMessages(report))
+}
+
+func TestWarden_ValidatePlan_BigNumbers(t *testing.T) {
+	planFile, err := terraform.NewPlanBuilder().Build()
+	require.NoError(t, err)
+
+	w, err := New(&Options{Script: `
+local tf = require('tf')
+
+local n = tf.bigNumber("12345678901234567890")
+return string.format("%s %s %s %s %s %s %s",
+	type(n), tostring(n + 1), tostring(tf.toNumber(n) > 1e19), tostring(n == tf.bigNumber("12345678901234567890")),
+	tostring(n < tf.bigNumber(1e20)), tostring(tf.toNumber("10") == 10), tostring(tf.toNumber("ten")))
+`})
+	require.NoError(t, err)
+
+	defer w.Close()
+
+	report, err := w.ValidatePlanFile(context.Background(), planFile)
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	assert.Equal(t, []string{"userdata 12345678901234567891 true true true true nil"}, findingMessages(report))
+}
@@ -0,0 +1,5 @@
+resource "null_resource" "label" {
+  triggers = {
+    name = "labels"
+  }
+}
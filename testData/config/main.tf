@@ -0,0 +1,60 @@
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 3.0"
+    }
+    null = {
+      source = "hashicorp/null"
+    }
+  }
+}
+
+provider "aws" {
+  region = var.region
+}
+
+resource "aws_instance" "web" {
+  count         = 2
+  ami           = "ami-0c55b159cbfe1f0d0"
+  instance_type = var.instance_type
+
+  tags = {
+    Name = "web-${count.index}"
+  }
+
+  root_block_device {
+    volume_size = 20
+    encrypted   = true
+  }
+}
+
+data "aws_ami" "ubuntu" {
+  most_recent = true
+  owners      = ["099720109477"]
+}
+
+module "net" {
+  source = "./modules/net"
+
+  cidr = "10.0.0.0/16"
+}
+
+module "labels" {
+  source  = "cloudposse/label/null"
+  version = "0.25.0"
+}
+
+module "dns" {
+  source = "example/dns/aws"
+}
+
+provider "aws" {
+  alias  = "east"
+  region = "us-east-1"
+}
+
+locals {
+  environment = "production"
+  name_prefix = "${local.environment}-web"
+}
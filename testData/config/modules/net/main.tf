@@ -0,0 +1,21 @@
+variable "cidr" {
+  type = string
+}
+
+resource "aws_vpc" "main" {
+  cidr_block = var.cidr
+}
+
+resource "null_resource" "marker" {
+  for_each = toset(["a", "b"])
+}
+
+resource "aws_flow_log" "main" {
+  vpc_id     = aws_vpc.main.id
+  depends_on = [aws_vpc.main]
+
+  lifecycle {
+    prevent_destroy = true
+    ignore_changes  = [tags]
+  }
+}
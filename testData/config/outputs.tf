@@ -0,0 +1,8 @@
+output "instance_ids" {
+  description = "IDs of the web instances"
+  value       = aws_instance.web[*].id
+}
+
+output "environment" {
+  value = "production"
+}
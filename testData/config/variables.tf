@@ -0,0 +1,14 @@
+variable "region" {
+  type        = string
+  description = "AWS region of the resources"
+  default     = "eu-west-1"
+}
+
+variable "instance_type" {
+  type = string
+}
+
+variable "db_password" {
+  type      = string
+  sensitive = true
+}